@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// againPrefix starts the custom IDs of the buttons offering a recent prompt
+// again, followed by which of the offer's prompts it is.
+const againPrefix = "again:"
+
+// recentOffered is how many recent prompts an empty command offers.
+const recentOffered = 3
+
+// againTTL is how long an offer's prompts are kept for its buttons.
+const againTTL = 24 * time.Hour
+
+// maxButtonLabel is the longest a button's label can be.
+const maxButtonLabel = 80
+
+// againOffer is the recent prompts offered to a user, and when.
+type againOffer struct {
+	authorID string
+	prompts  []string
+	at       time.Time
+}
+
+// againOffers are the prompts offered by each offer's message ID.
+var (
+	againOffersMu sync.Mutex
+	againOffers   = make(map[string]againOffer)
+)
+
+// pruneAgainOffers forgets the offers older than againTTL.
+func pruneAgainOffers() {
+	againOffersMu.Lock()
+	defer againOffersMu.Unlock()
+	t := now()
+	for id, o := range againOffers {
+		if t.Sub(o.at) >= againTTL {
+			delete(againOffers, id)
+		}
+	}
+}
+
+// promptsToOffer returns the last recentOffered different prompts userID made
+// images of, newest first.
+func promptsToOffer(ctx context.Context, userID string) []string {
+	records, err := history.ByAuthor(ctx, userID, suggestionHistory)
+	if err != nil {
+		logger(ctx).Error("Error getting recent prompts", "error", err)
+		return nil
+	}
+	seen := make(map[string]bool)
+	var prompts []string
+	for _, r := range records {
+		if !r.Success || r.Prompt == "" || seen[r.Prompt] {
+			continue
+		}
+		seen[r.Prompt] = true
+		prompts = append(prompts, r.Prompt)
+		if len(prompts) == recentOffered {
+			break
+		}
+	}
+	return prompts
+}
+
+// offerRecent answers an empty command with buttons to make one of the
+// requester's recent prompts again. It returns false when there's nothing
+// to offer, so the help is shown instead.
+func offerRecent(ctx context.Context, s session, imgReq *ImageRequest, reference *discordgo.MessageReference) bool {
+	if !cfg().SuggestRecentOnEmpty || history == nil {
+		return false
+	}
+	prompts := promptsToOffer(ctx, imgReq.AuthorID)
+	if len(prompts) == 0 {
+		return false
+	}
+
+	buttons := make([]discordgo.MessageComponent, 0, len(prompts))
+	for n, prompt := range prompts {
+		label := prompt
+		if utf8.RuneCountInString(label) > maxButtonLabel {
+			label = string([]rune(label)[:maxButtonLabel-1]) + "…"
+		}
+		buttons = append(buttons, discordgo.Button{
+			Label:    label,
+			Style:    discordgo.SecondaryButton,
+			CustomID: againPrefix + strconv.Itoa(n),
+			Emoji:    discordgo.ComponentEmoji{Name: "🔁"},
+		})
+	}
+	sent, err := s.ChannelMessageSendComplex(imgReq.ChannelID, &discordgo.MessageSend{
+		Content:    tr(imgReq.Locale, "again.offer"),
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}},
+		Reference:  reference,
+	})
+	if err != nil {
+		logger(ctx).Error("Error sending recent prompts", "error", err)
+		// the requester still gets an answer
+		return false
+	}
+
+	againOffersMu.Lock()
+	defer againOffersMu.Unlock()
+	againOffers[sent.ID] = againOffer{authorID: imgReq.AuthorID, prompts: prompts, at: now()}
+	return true
+}
+
+// makeAgain answers a click on an offered prompt by generating it again, as
+// a new response. Only the requester it was offered to can pick one.
+func makeAgain(s session, i *discordgo.InteractionCreate) {
+	locale := localeFor(i.Locale)
+	n, err := strconv.Atoi(strings.TrimPrefix(i.MessageComponentData().CustomID, againPrefix))
+	againOffersMu.Lock()
+	offer, ok := againOffers[i.Message.ID]
+	againOffersMu.Unlock()
+	if err != nil || !ok || n < 0 || n >= len(offer.prompts) {
+		respondPrivately(s, i, tr(locale, "again.expired"))
+		return
+	}
+	if offer.authorID != interactionAuthor(i).ID {
+		respondPrivately(s, i, tr(locale, "again.notYours"))
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		slog.Error("Error on acknowledging interaction", "interaction_id", i.ID, "error", err)
+		return
+	}
+	answerCommand(s, i, commandOptions{prompt: offer.prompts[n]})
+}
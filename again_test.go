@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// useAgainOffers gives a test its own offered prompts.
+func useAgainOffers(t *testing.T) {
+	againOffersMu.Lock()
+	old := againOffers
+	againOffers = make(map[string]againOffer)
+	againOffersMu.Unlock()
+	t.Cleanup(func() {
+		againOffersMu.Lock()
+		againOffers = old
+		againOffersMu.Unlock()
+	})
+}
+
+// buttonsOn returns the buttons the bot sent on m.
+func buttonsOn(m *sentMessage) []discordgo.Button {
+	var buttons []discordgo.Button
+	for _, c := range m.Components {
+		row, ok := c.(discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if b, ok := c.(discordgo.Button); ok {
+				buttons = append(buttons, b)
+			}
+		}
+	}
+	return buttons
+}
+
+func TestOfferRecentOnEmpty(t *testing.T) {
+	useHistory(t)
+	useAgainOffers(t)
+	d, api := setupBot(t, Config{SuggestRecentOnEmpty: true}, "again")
+	for _, prompt := range []string{"a red fox", "a blue whale", "a red fox", "a green frog", "a grey owl"} {
+		onMessageHandler(d, d.post("again", "user", "/dalle "+prompt))
+	}
+
+	onMessageHandler(d, d.post("again", "user", "/dalle"))
+
+	sent := d.sentMessages()
+	offer := sent[len(sent)-1]
+	if offer.Content != tr("en", "again.offer") {
+		t.Fatalf("replied %q, want the recent prompts offered", offer.Content)
+	}
+	var labels []string
+	for _, b := range buttonsOn(offer) {
+		labels = append(labels, b.Label)
+	}
+	if want := []string{"a grey owl", "a green frog", "a red fox"}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("offered %q, want %q", labels, want)
+	}
+
+	someone := d.click(offer.Message, "someone", againPrefix+"0")
+	onInteractionHandler(d, someone)
+	if got := d.response(someone); got == nil || got.Content != tr("en", "again.notYours") {
+		t.Errorf("answered someone else with %+v, want them refused", got)
+	}
+
+	click := d.click(offer.Message, "user", againPrefix+"2")
+	onInteractionHandler(d, click)
+	generated := api.generated()
+	if got := generated[len(generated)-1]; len(generated) != 6 || got != "a red fox" {
+		t.Errorf("generated %q, want the picked prompt made again", generated)
+	}
+	if got := d.response(click); got == nil || len(got.Files) != 1 {
+		t.Errorf("responded %+v, want the image", got)
+	}
+}
+
+func TestOfferRecentWithoutHistory(t *testing.T) {
+	useHistory(t)
+	useAgainOffers(t)
+	d, _ := setupBot(t, Config{SuggestRecentOnEmpty: true}, "newcomer")
+
+	onMessageHandler(d, d.post("newcomer", "user", "/dalle"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != helpText("en") {
+		t.Errorf("sent %d messages, want the help for someone without prompts", len(sent))
+	}
+}
+
+func TestOfferRecentExpired(t *testing.T) {
+	advance := fakeClock(t)
+	useHistory(t)
+	useAgainOffers(t)
+	d, _ := setupBot(t, Config{SuggestRecentOnEmpty: true}, "expired")
+	onMessageHandler(d, d.post("expired", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("expired", "user", "/dalle"))
+	offer := d.sentMessages()[1]
+
+	advance(againTTL)
+	pruneAgainOffers()
+	click := d.click(offer.Message, "user", againPrefix+"0")
+	onInteractionHandler(d, click)
+
+	if got := d.response(click); got == nil || got.Content != tr("en", "again.expired") {
+		t.Errorf("answered %+v, want the offer called too old", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// allowedHere reports whether the bot should respond in a channel. An empty
+// AllowedGuilds or AllowedChannels list doesn't restrict anything. Direct
+// messages, which have no guild, are only answered with AllowDMs.
+func allowedHere(guildID string, channelID string) bool {
+	if guildID == "" {
+		return cfg().AllowDMs
+	}
+	if len(cfg().AllowedGuilds) > 0 && !contains(cfg().AllowedGuilds, guildID) {
+		return false
+	}
+	if len(cfg().AllowedChannels) > 0 && !contains(cfg().AllowedChannels, channelID) {
+		return false
+	}
+	return true
+}
+
+// allowedChannel is allowedHere for a channel that may be a thread, which
+// is allowed when its parent channel is.
+func allowedChannel(s session, guildID string, channelID string) bool {
+	if allowedHere(guildID, channelID) {
+		return true
+	}
+	if guildID == "" || len(cfg().AllowedChannels) == 0 {
+		return false
+	}
+	parent := parentChannel(s, channelID)
+	return parent != channelID && allowedHere(guildID, parent)
+}
+
+// allowedMember reports whether userID may use the bot in a guild, which with
+// AllowedRoles set means holding one of those roles. member is the member
+// Discord sent along, if any, and is looked up otherwise. DMs carry no roles,
+// so with AllowedRoles set only admins are answered there.
+func allowedMember(s session, guildID string, member *discordgo.Member, userID string) bool {
+	if len(cfg().AllowedRoles) == 0 || isAdmin(userID) {
+		return true
+	}
+	if guildID == "" {
+		return false
+	}
+	if member == nil {
+		var err error
+		if member, err = s.cachedMember(guildID, userID); err != nil {
+			if member, err = s.GuildMember(guildID, userID); err != nil {
+				slog.Error("Error on getting member", "guild_id", guildID, "user_id", userID, "error", err)
+				return false
+			}
+		}
+	}
+	for _, role := range member.Roles {
+		if contains(cfg().AllowedRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDisallowedChannelIgnored(t *testing.T) {
+	d, api := setupBot(t, Config{AllowedChannels: []string{"art"}}, "art")
+	d.addChannel("general")
+	m := d.post("general", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing from a channel off the list", got)
+	}
+	if got := d.reactionsOn(m.ID); len(got) != 0 {
+		t.Errorf("request shows %q, want no reaction at all", got)
+	}
+	if sent := d.sentMessages(); len(sent) != 0 {
+		t.Errorf("sent %+v, want no reply", sent)
+	}
+
+	onMessageHandler(d, d.post("art", "user", "/dalle a red fox"))
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want the allowed channel answered", got)
+	}
+}
+
+func TestDisallowedGuildIgnored(t *testing.T) {
+	d, api := setupBot(t, Config{AllowedGuilds: []string{"another-guild"}}, "elsewhere")
+
+	onMessageHandler(d, d.post("elsewhere", "user", "/dalle a red fox"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing from a guild off the list", got)
+	}
+}
+
+func TestDisallowedChannelReactionIgnored(t *testing.T) {
+	d, api := setupBot(t, Config{}, "closed")
+	onMessageHandler(d, d.post("closed", "user", "/dalle a blue whale"))
+	result := d.sentMessages()[0]
+
+	useConfig(t, Config{BaseURL: api.URL, AllowedChannels: []string{"art"}})
+	onEmojiAddHandler(d, d.react("closed", result.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want no re-roll in a channel off the list", got)
+	}
+}
+
+func TestDMAllowed(t *testing.T) {
+	d, api := setupBot(t, Config{AllowDMs: true}, "guild-channel")
+	d.addDM("dm")
+	m := d.dm("dm", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 1 {
+		t.Fatalf("generated %q, want the DM answered", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].ChannelID != "dm" || len(sent[0].Files) != 1 {
+		t.Errorf("sent %+v, want the image in the DM", sent)
+	}
+}
+
+func TestDMIgnoredByDefault(t *testing.T) {
+	d, api := setupBot(t, Config{}, "guild-channel")
+	d.addDM("dm")
+	m := d.dm("dm", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want DMs ignored without allowDMs", got)
+	}
+	if len(d.sentMessages()) != 0 || len(d.reactionsOn(m.ID)) != 0 {
+		t.Error("answered a DM without allowDMs, want it ignored")
+	}
+}
+
+func TestAllowedRoles(t *testing.T) {
+	d, api := setupBot(t, Config{AllowedRoles: []string{"artist"}}, "roles")
+
+	artist := d.post("roles", "painter", "/dalle a red fox")
+	artist.Member = &discordgo.Member{Roles: []string{"member", "artist"}}
+	onMessageHandler(d, artist)
+	outsider := d.post("roles", "visitor", "/dalle a blue whale")
+	outsider.Member = &discordgo.Member{Roles: []string{"member"}}
+	onMessageHandler(d, outsider)
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox"}) {
+		t.Errorf("generated %q, want only the artist's prompt", got)
+	}
+	if got := d.reactionsOn(outsider.ID); !reflect.DeepEqual(got, []string{"🔒"}) {
+		t.Errorf("outsider's request shows %q, want 🔒", got)
+	}
+}
+
+func TestAllowedRolesLookedUp(t *testing.T) {
+	d, api := setupBot(t, Config{AllowedRoles: []string{"artist"}}, "roles")
+	d.addMember("painter", "artist")
+	d.addMember("visitor")
+	onMessageHandler(d, d.post("roles", "painter", "/dalle a red fox"))
+	result := d.sentMessages()[0]
+
+	// reactions don't carry the member, so their roles are fetched
+	onEmojiAddHandler(d, d.react("roles", result.ID, "visitor", "🔁"))
+	onEmojiAddHandler(d, d.react("roles", result.ID, "stranger", "🔁"))
+	if got := len(api.generated()); got != 1 {
+		t.Errorf("generated %d times, want no re-roll for members without the role", got)
+	}
+
+	onEmojiAddHandler(d, d.react("roles", result.ID, "painter", "🔁"))
+	if got := len(api.generated()); got != 2 {
+		t.Errorf("generated %d times, want the artist's re-roll", got)
+	}
+}
+
+func TestAllowedRolesInDMs(t *testing.T) {
+	d, api := setupBot(t, Config{AllowDMs: true, AllowedRoles: []string{"artist"}, Admins: []string{"admin"}}, "roles")
+	d.addDM("dm")
+
+	onMessageHandler(d, d.dm("dm", "painter", "/dalle a red fox"))
+	onMessageHandler(d, d.dm("dm", "admin", "/dalle a blue whale"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a blue whale"}) {
+		t.Errorf("generated %q, want only the admin answered without roles to check", got)
+	}
+}
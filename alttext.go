@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+// maxAltText is the longest attachment description Discord accepts.
+const maxAltText = 1024
+
+// withAltText returns copies of imgs described by the prompt each was drawn
+// from, the model's revised one when there is one, for screen readers.
+func withAltText(imgs []*imageFile, images []imagegen.Image, prompt string) []*imageFile {
+	if cfg().DisableAltText {
+		return imgs
+	}
+	described := make([]*imageFile, 0, len(imgs))
+	for i, img := range imgs {
+		copied := *img
+		copied.Description = prompt
+		if i < len(images) && images[i].RevisedPrompt != "" {
+			copied.Description = images[i].RevisedPrompt
+		}
+		if runes := []rune(copied.Description); len(runes) > maxAltText {
+			copied.Description = string(runes[:maxAltText-1]) + "…"
+		}
+		described = append(described, &copied)
+	}
+	return described
+}
+
+// attachmentMeta describes one uploaded file, by its index in the upload.
+type attachmentMeta struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	Description string `json:"description,omitempty"`
+}
+
+// describedMessage is a MessageSend with the attachment descriptions this
+// version of discordgo has no field for.
+type describedMessage struct {
+	*discordgo.MessageSend
+	Attachments []attachmentMeta `json:"attachments"`
+}
+
+// describedEdit is describedMessage for editing an interaction's response.
+type describedEdit struct {
+	*discordgo.WebhookEdit
+	Attachments []attachmentMeta `json:"attachments"`
+}
+
+// describedFiles returns imgs to upload, along with the attachments giving
+// each its Description as alt text.
+func describedFiles(imgs []*imageFile) ([]*discordgo.File, []attachmentMeta) {
+	files := make([]*discordgo.File, 0, len(imgs))
+	attachments := make([]attachmentMeta, 0, len(imgs))
+	for i, img := range imgs {
+		files = append(files, img.discordFile())
+		attachments = append(attachments, attachmentMeta{ID: i, Filename: img.Name, Description: img.Description})
+	}
+	return files, attachments
+}
+
+// sendDescribed sends send with imgs attached, each carrying its
+// Description as alt text.
+func sendDescribed(s session, channelID string, send *discordgo.MessageSend, imgs []*imageFile) (*discordgo.Message, error) {
+	imgs, thumbnails := withThumbnails(imgs)
+	if len(thumbnails) > 0 {
+		copied := *send
+		copied.Embeds = append(append([]*discordgo.MessageEmbed(nil), send.Embeds...), thumbnails...)
+		send = &copied
+	}
+	files, attachments := describedFiles(imgs)
+	return requestDescribed(s, "POST", discordgo.EndpointChannelMessages(channelID), describedMessage{send, attachments}, files)
+}
+
+// editDescribed edits an interaction's response to edit with imgs attached,
+// each carrying its Description as alt text.
+func editDescribed(s session, interaction *discordgo.Interaction, edit *discordgo.WebhookEdit, imgs []*imageFile) (*discordgo.Message, error) {
+	imgs, thumbnails := withThumbnails(imgs)
+	if len(thumbnails) > 0 {
+		copied := *edit
+		embeds := thumbnails
+		if edit.Embeds != nil {
+			embeds = append(append([]*discordgo.MessageEmbed(nil), *edit.Embeds...), thumbnails...)
+		}
+		copied.Embeds = &embeds
+		edit = &copied
+	}
+	files, attachments := describedFiles(imgs)
+	endpoint := discordgo.EndpointWebhookMessage(interaction.AppID, interaction.Token, "@original")
+	return requestDescribed(s, "PATCH", endpoint, describedEdit{edit, attachments}, files)
+}
+
+// requestDescribed sends data and files to endpoint as one multipart body,
+// returning the message Discord answers with.
+func requestDescribed(s session, method string, endpoint string, data interface{}, files []*discordgo.File) (*discordgo.Message, error) {
+	contentType, body, err := discordgo.MultipartBodyWithJSON(data, files)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.requestMultipart(method, endpoint, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	var msg *discordgo.Message
+	err = json.Unmarshal(response, &msg)
+	return msg, err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+func TestAltText(t *testing.T) {
+	useConfig(t, Config{})
+	imgs := []*imageFile{{Name: "0.png"}, {Name: "1.png"}}
+	images := []imagegen.Image{{}, {RevisedPrompt: "a small red fox curled up in fresh snow"}}
+
+	described := withAltText(imgs, images, "a red fox in the snow")
+
+	if got := described[0].Description; got != "a red fox in the snow" {
+		t.Errorf("first image described as %q, want the prompt", got)
+	}
+	if got := described[1].Description; got != images[1].RevisedPrompt {
+		t.Errorf("second image described as %q, want the revised prompt", got)
+	}
+	if imgs[0].Description != "" {
+		t.Errorf("original image was described as %q, want it left alone", imgs[0].Description)
+	}
+}
+
+func TestAltTextTruncated(t *testing.T) {
+	useConfig(t, Config{})
+	prompt := strings.Repeat("é", maxAltText+10)
+
+	got := withAltText([]*imageFile{{Name: "0.png"}}, nil, prompt)[0].Description
+
+	if n := utf8.RuneCountInString(got); n != maxAltText || !strings.HasSuffix(got, "…") {
+		t.Errorf("alt text is %d characters ending %q, want %d ending …", n, got[len(got)-3:], maxAltText)
+	}
+}
+
+func TestAltTextDisabled(t *testing.T) {
+	useConfig(t, Config{DisableAltText: true})
+
+	if got := withAltText([]*imageFile{{Name: "0.png"}}, nil, "a red fox")[0].Description; got != "" {
+		t.Errorf("alt text is %q, want none when disabled", got)
+	}
+}
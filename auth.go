@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Supported values for Config.AuthScheme.
+const (
+	authBearer = "bearer" // Authorization: Bearer <key>, what OpenAI expects
+	authNone   = "none"   // no credentials, for local servers without auth
+	authHeader = "header" // the key as-is in the AuthHeader header
+)
+
+func authScheme() string {
+	return cfg().authScheme()
+}
+
+func (c *Config) authScheme() string {
+	if c.AuthScheme == "" {
+		return authBearer
+	}
+	return strings.ToLower(c.AuthScheme)
+}
+
+// validateAuthScheme checks the auth scheme of c.
+func validateAuthScheme(c *Config) error {
+	switch c.authScheme() {
+	case authBearer, authNone:
+		return nil
+	case authHeader:
+		if c.AuthHeader == "" {
+			return fmt.Errorf("authScheme %q needs authHeader to be set", authHeader)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown authScheme %q, use %q, %q or %q", c.AuthScheme, authBearer, authNone, authHeader)
+}
+
+// hasCredentials reports whether requests for the guild can be authorized.
+func hasCredentials(guildID string) bool {
+	return authScheme() == authNone || guildKey(guildID) != "" || len(globalKeys()) > 0
+}
+
+// authorize adds the guild's credentials to req using the configured scheme.
+func authorize(req *http.Request, guildID string) {
+	if authScheme() == authNone {
+		return
+	}
+	key := openAIKeyFor(guildID)
+	recordKey(req.Context(), key)
+	switch authScheme() {
+	case authBearer:
+		req.Header.Set("Authorization", "Bearer "+key)
+	case authHeader:
+		req.Header.Set(cfg().AuthHeader, key)
+	}
+}
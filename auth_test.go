@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthorizeHeaders(t *testing.T) {
+	for _, c := range []struct {
+		config  Config
+		headers map[string]string
+	}{
+		{Config{}, map[string]string{"Authorization": "Bearer sk-test"}},
+		{Config{AuthScheme: "Bearer"}, map[string]string{"Authorization": "Bearer sk-test"}},
+		{Config{AuthScheme: "none"}, map[string]string{"Authorization": ""}},
+		{Config{AuthScheme: "header", AuthHeader: "api-key"}, map[string]string{"Api-Key": "sk-test", "Authorization": ""}},
+	} {
+		useConfig(t, c.config)
+		req, err := http.NewRequest("POST", "http://localhost/images/generations", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authorize(req, testGuildID)
+
+		for name, want := range c.headers {
+			if got := req.Header.Get(name); got != want {
+				t.Errorf("with scheme %q, %s is %q, want %q", c.config.AuthScheme, name, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateAuthScheme(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		ok     bool
+	}{
+		{Config{}, true},
+		{Config{AuthScheme: "none"}, true},
+		{Config{AuthScheme: "header", AuthHeader: "api-key"}, true},
+		{Config{AuthScheme: "header"}, false},
+		{Config{AuthScheme: "basic"}, false},
+	} {
+		if err := validateAuthScheme(&c.config); (err == nil) != c.ok {
+			t.Errorf("validateAuthScheme(%+v) = %v, want ok %v", c.config, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxSuggestions is the most choices Discord shows for an autocomplete.
+const maxSuggestions = 25
+
+// maxChoiceLength is the longest a choice's name and value can be, so longer
+// prompts aren't suggested.
+const maxChoiceLength = 100
+
+// suggestionHistory is how many of a user's recent requests are looked at for
+// suggestions.
+const suggestionHistory = 50
+
+// popularPrompts are suggested to everyone after their own prompts, unless
+// SuggestedPrompts replaces them.
+var popularPrompts = []string{
+	"a cat wearing a space suit, digital art",
+	"a cozy cabin in a snowy forest at night",
+	"a watercolor painting of a lighthouse at sunset",
+	"a cyberpunk city street in the rain, neon lights",
+	"a corgi astronaut floating above the earth",
+	"a bowl of ramen in the style of a ukiyo-e print",
+	"an isometric pixel art island with a castle",
+	"a dragon made of autumn leaves",
+	"a steampunk owl with brass gears, highly detailed",
+	"a still life of fruit in the style of cezanne",
+}
+
+// suggestedPrompts returns the prompts to suggest after a user's own.
+func suggestedPrompts() []string {
+	if len(cfg().SuggestedPrompts) > 0 {
+		return cfg().SuggestedPrompts
+	}
+	return popularPrompts
+}
+
+// promptSuggestions returns up to maxSuggestions prompts containing typed,
+// userID's recent ones first, newest first, then suggestedPrompts. Without a
+// history there are only the suggested ones.
+func promptSuggestions(ctx context.Context, userID string, typed string) []string {
+	var candidates []string
+	if history != nil {
+		records, err := history.ByAuthor(ctx, userID, suggestionHistory)
+		if err != nil {
+			logger(ctx).Error("Error getting recent prompts", "error", err)
+		}
+		for _, r := range records {
+			candidates = append(candidates, r.Prompt)
+		}
+	}
+	candidates = append(candidates, suggestedPrompts()...)
+
+	typed = strings.ToLower(strings.TrimSpace(typed))
+	seen := make(map[string]bool)
+	var suggestions []string
+	for _, prompt := range candidates {
+		key := strings.ToLower(prompt)
+		if seen[key] || prompt == "" || utf8.RuneCountInString(prompt) > maxChoiceLength || !strings.Contains(key, typed) {
+			continue
+		}
+		seen[key] = true
+		suggestions = append(suggestions, prompt)
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+	}
+	return suggestions
+}
+
+// answerAutocomplete suggests prompts for what's been typed so far in the
+// /dalle command's prompt option.
+func answerAutocomplete(s session, i *discordgo.InteractionCreate) {
+	var typed string
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "prompt" && opt.Focused {
+			typed = opt.StringValue()
+		}
+	}
+
+	prompts := promptSuggestions(context.Background(), interactionAuthor(i).ID, typed)
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(prompts))
+	for _, prompt := range prompts {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: prompt, Value: prompt})
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		slog.Error("Error on answering autocomplete", "interaction_id", i.ID, "error", err)
+	}
+}
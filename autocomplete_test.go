@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdesson/disc-e/store"
+)
+
+func TestPromptSuggestions(t *testing.T) {
+	s := useHistory(t)
+	useConfig(t, Config{SuggestedPrompts: []string{"a red fox in the snow", "a blue whale", "A Red Fox"}})
+	ctx := context.Background()
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a red fox"})
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a lighthouse"})
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a red fox, oil painting"})
+	s.Add(ctx, &store.Record{AuthorID: "someone", Prompt: "a red fox at night"})
+
+	got := promptSuggestions(ctx, "user", "Red Fox")
+
+	// the user's own come first, newest first, without repeats
+	want := []string{"a red fox, oil painting", "a red fox", "a red fox in the snow"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("promptSuggestions() = %q, want %q", got, want)
+	}
+}
+
+func TestPromptSuggestionsLimited(t *testing.T) {
+	var many []string
+	for n := 0; n < 40; n++ {
+		many = append(many, fmt.Sprintf("a castle number %d", n))
+	}
+	long := "a castle " + strings.Repeat("very ", maxChoiceLength/5)
+	useConfig(t, Config{SuggestedPrompts: append([]string{long}, many...)})
+
+	got := promptSuggestions(context.Background(), "user", "castle")
+
+	if len(got) != maxSuggestions || got[0] != many[0] {
+		t.Errorf("got %d suggestions starting with %q, want %d without the one too long to be a choice", len(got), got[0], maxSuggestions)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "autocomplete")
+
+	i := d.autocomplete("autocomplete", "user", "lighthouse")
+	onInteractionHandler(d, i)
+
+	if got, want := d.suggested(i), []string{"a watercolor painting of a lighthouse at sunset"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("suggested %q, want %q", got, want)
+	}
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q while typing, want nothing", got)
+	}
+}
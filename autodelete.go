@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pendingDeletes holds the timers of scheduled deletions so shutdown can
+// stop them. Each is removed once it fires.
+var (
+	pendingDeletesMu sync.Mutex
+	pendingDeletes   = make(map[*time.Timer]bool)
+)
+
+// scheduleDelete deletes a message once AutoDeleteFailedAfterSeconds have
+// passed. It does nothing when that's 0.
+func scheduleDelete(s session, channelID string, messageID string) {
+	if cfg().AutoDeleteFailedAfterSeconds <= 0 {
+		return
+	}
+	delay := time.Duration(cfg().AutoDeleteFailedAfterSeconds) * time.Second
+
+	pendingDeletesMu.Lock()
+	defer pendingDeletesMu.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		pendingDeletesMu.Lock()
+		delete(pendingDeletes, timer)
+		pendingDeletesMu.Unlock()
+
+		if err := s.ChannelMessageDelete(channelID, messageID); err != nil {
+			slog.Error("Error on deleting failed request", "message_id", messageID, "channel_id", channelID, "error", err)
+		}
+	})
+	pendingDeletes[timer] = true
+}
+
+// stopPendingDeletes calls off the deletions that haven't happened yet.
+func stopPendingDeletes() {
+	pendingDeletesMu.Lock()
+	defer pendingDeletesMu.Unlock()
+	for timer := range pendingDeletes {
+		timer.Stop()
+	}
+	pendingDeletes = make(map[*time.Timer]bool)
+}
+
+// failRequest marks imgReq failed and schedules its command message for
+// deletion, unless its requester cancelled it or it's one line of a batch
+// whose other results reply to the message.
+func failRequest(ctx context.Context, s session, imgReq *ImageRequest) {
+	failStatus(ctx, s, imgReq.ChannelID, imgReq.ID)
+	if ctx.Err() == nil && !imgReq.Batch {
+		scheduleDelete(s, imgReq.ChannelID, imgReq.ID)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// waitForDeletes polls until n messages have been deleted or a couple of
+// seconds pass, returning what was deleted.
+func waitForDeletes(d *fakeDiscord, n int) []string {
+	deadline := time.Now().Add(3 * time.Second)
+	for len(d.deletedMessages()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return d.deletedMessages()
+}
+
+func TestFailedRequestDeleted(t *testing.T) {
+	d, api := setupBot(t, Config{AutoDeleteFailedAfterSeconds: 1}, "autodelete")
+	t.Cleanup(stopPendingDeletes)
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	m := d.post("autodelete", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := d.deletedMessages(); len(got) != 0 {
+		t.Fatalf("deleted %q straight away, want a wait first", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want the error reply", len(sent))
+	}
+	got := waitForDeletes(d, 2)
+	// both timers come due together, so either may fire first
+	sort.Strings(got)
+	if want := []string{m.ID, sent[0].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deleted %q, want the request and its error reply %q", got, want)
+	}
+}
+
+func TestPendingDeletesStopped(t *testing.T) {
+	d, api := setupBot(t, Config{AutoDeleteFailedAfterSeconds: 1}, "stopped")
+	t.Cleanup(stopPendingDeletes)
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	first := d.post("stopped", "user", "/dalle a red fox")
+	onMessageHandler(d, first)
+
+	stopPendingDeletes()
+	second := d.post("stopped", "user", "/dalle a blue fox")
+	onMessageHandler(d, second)
+
+	// the first deletion would have come due before the second
+	got := waitForDeletes(d, 2)
+	deleted := make(map[string]bool)
+	for _, id := range got {
+		deleted[id] = true
+	}
+	if deleted[first.ID] {
+		t.Errorf("deleted %q, want the stopped deletion called off", got)
+	}
+	if !deleted[second.ID] {
+		t.Errorf("deleted %q, want the later request still deleted", got)
+	}
+}
+
+func TestAutoDeleteOff(t *testing.T) {
+	d, api := setupBot(t, Config{}, "kept")
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	m := d.post("kept", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	pendingDeletesMu.Lock()
+	pending := len(pendingDeletes)
+	pendingDeletesMu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d deletions scheduled, want none when the delay is 0", pending)
+	}
+	if got := d.deletedMessages(); len(got) != 0 {
+		t.Errorf("deleted %q, want failed requests kept", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// modelToken picks the model for one prompt, like "model:dall-e-3".
+var modelToken = regexp.MustCompile(`(?:^|\s)model:(\S+)(?:\s|$)`)
+
+// The models AutoModelSelect picks between.
+const (
+	simpleModel   = "dall-e-2"
+	detailedModel = "dall-e-3"
+)
+
+// detailWords are words a prompt asking for more than dall-e-2 does well
+// tends to have.
+var detailWords = map[string]bool{
+	"detailed":       true,
+	"intricate":      true,
+	"photorealistic": true,
+	"hyperrealistic": true,
+	"realistic":      true,
+	"cinematic":      true,
+	"lighting":       true,
+	"text":           true,
+	"sign":           true,
+	"lettering":      true,
+	"reading":        true,
+	"portrait":       true,
+	"4k":             true,
+	"8k":             true,
+}
+
+// detailedScore is the score from which chooseModel picks detailedModel.
+const detailedScore = 5
+
+// chooseModel picks detailedModel for long or detailed prompts and
+// simpleModel, which is cheaper, for the rest. A prompt scores a point for
+// every five words, one for every comma, since each adds a detail, and two
+// for each of detailWords.
+func chooseModel(prompt string) string {
+	words := strings.Fields(prompt)
+	score := len(words)/5 + strings.Count(prompt, ",")
+	for _, w := range words {
+		if detailWords[strings.Trim(w, ",.;:!?\"'()")] {
+			score += 2
+		}
+	}
+	if score >= detailedScore {
+		return detailedModel
+	}
+	return simpleModel
+}
+
+// parseModel takes a "model:<name>" token naming a supported model out of
+// anywhere in a prompt. Prompts without one get an empty model, and tokens
+// naming a model that isn't supported are left in the prompt.
+func parseModel(prompt string) (model string, rest string) {
+	loc := modelToken.FindStringSubmatchIndex(prompt)
+	if loc == nil {
+		return "", prompt
+	}
+	model = prompt[loc[2]:loc[3]]
+	if _, ok := modelSizes[model]; !ok {
+		return "", prompt
+	}
+	return model, strings.Join(strings.Fields(prompt[:loc[0]]+" "+prompt[loc[1]:]), " ")
+}
+
+// requestModel returns the model for prompt in guildID: the one a model:
+// token names, else chooseModel's pick when AutoModelSelect is set, else the
+// guild's. auto is set when the model was picked for the prompt, and rest is
+// the prompt without the token.
+func requestModel(guildID string, prompt string) (model string, auto bool, rest string) {
+	if model, rest := parseModel(prompt); model != "" {
+		return model, false, rest
+	}
+	if cfg().AutoModelSelect {
+		return chooseModel(prompt), true, prompt
+	}
+	return modelFor(guildID), false, prompt
+}
+
+// autoModelNote tells the requester which model was picked for their prompt.
+func autoModelNote(imgReq *ImageRequest) string {
+	if !imgReq.AutoModel || imgReq.FallbackFrom != "" {
+		return ""
+	}
+	return "\n*" + tr(imgReq.Locale, "reply.autoModel", imgReq.Model) + "*"
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChooseModel(t *testing.T) {
+	for _, c := range []struct {
+		prompt string
+		want   string
+	}{
+		{"a red fox", simpleModel},
+		{"a cat in a hat", simpleModel},
+		{"a photorealistic portrait of an old sailor", detailedModel},
+		{"a market at dusk, lanterns, crowds, rain, reflections, steam, awnings", detailedModel},
+		{"a shop sign with the text open late", detailedModel},
+	} {
+		if got := chooseModel(c.prompt); got != c.want {
+			t.Errorf("chooseModel(%q) = %s, want %s", c.prompt, got, c.want)
+		}
+	}
+}
+
+func TestParseModel(t *testing.T) {
+	for _, c := range []struct {
+		prompt string
+		model  string
+		rest   string
+	}{
+		{"a red fox", "", "a red fox"},
+		{"model:dall-e-3 a red fox", "dall-e-3", "a red fox"},
+		{"a red fox model:dall-e-2", "dall-e-2", "a red fox"},
+		{"a red fox model:midjourney", "", "a red fox model:midjourney"},
+	} {
+		model, rest := parseModel(c.prompt)
+		if model != c.model || rest != c.rest {
+			t.Errorf("parseModel(%q) = %q, %q, want %q, %q", c.prompt, model, rest, c.model, c.rest)
+		}
+	}
+}
+
+func TestAutoModelSelect(t *testing.T) {
+	d, api := setupBot(t, Config{AutoModelSelect: true}, "automodel")
+
+	onMessageHandler(d, d.post("automodel", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("automodel", "user", "/dalle a photorealistic portrait of an old sailor"))
+	onMessageHandler(d, d.post("automodel", "user", "/dalle model:dall-e-2 a photorealistic portrait of an old sailor"))
+
+	gens := api.generations()
+	if len(gens) != 3 {
+		t.Fatalf("made %d generations, want 3", len(gens))
+	}
+	for n, want := range []string{"dall-e-2", "dall-e-3", "dall-e-2"} {
+		if gens[n].Model != want {
+			t.Errorf("generation %d used %s, want %s", n+1, gens[n].Model, want)
+		}
+	}
+	if gens[2].Prompt != "a photorealistic portrait of an old sailor" {
+		t.Errorf("sent %q, want the model token taken out", gens[2].Prompt)
+	}
+
+	sent := d.sentMessages()
+	for n, want := range []string{tr("en", "reply.autoModel", "dall-e-2"), tr("en", "reply.autoModel", "dall-e-3")} {
+		if !strings.Contains(sent[n].Content, want) {
+			t.Errorf("reply %d is %q, want it to say %q", n+1, sent[n].Content, want)
+		}
+	}
+	if strings.Contains(sent[2].Content, "picked for this prompt") {
+		t.Errorf("reply to the chosen model is %q, want no note", sent[2].Content)
+	}
+}
+
+func TestModelTokenWithoutAutoSelect(t *testing.T) {
+	d, api := setupBot(t, Config{}, "modeltoken")
+
+	onMessageHandler(d, d.post("modeltoken", "user", "/dalle model:dall-e-3 a red fox"))
+
+	if gens := api.generations(); len(gens) != 1 || gens[0].Model != "dall-e-3" || gens[0].Prompt != "a red fox" {
+		t.Errorf("generations %+v, want dall-e-3 for the prompt without the token", gens)
+	}
+}
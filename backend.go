@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mdesson/disc-e/imagegen"
+	"golang.org/x/time/rate"
+)
+
+// apiBaseURL returns the root of the OpenAI-compatible API, without a
+// trailing slash.
+func apiBaseURL() string {
+	if cfg().BaseURL == "" {
+		return imagegen.DefaultBaseURL
+	}
+	return strings.TrimSuffix(cfg().BaseURL, "/")
+}
+
+// validateBaseURL checks the base URL of c.
+func validateBaseURL(c *Config) error {
+	if c.BaseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("baseURL %q must be an http or https URL", c.BaseURL)
+	}
+	return nil
+}
+
+// apiLimiter paces calls to the images API at OpenAIRequestsPerMinute, or
+// returns nil when there's no cap.
+func apiLimiter() *rate.Limiter {
+	rpm := cfg().OpenAIRequestsPerMinute
+	if rpm <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(rpm)), 1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchImageAtBaseURL(t *testing.T) {
+	for _, c := range []struct {
+		config  Config
+		headers map[string]string
+	}{
+		{Config{}, map[string]string{"Authorization": "Bearer sk-test"}},
+		{Config{AuthScheme: "header", AuthHeader: "api-key"}, map[string]string{"Api-Key": "sk-test", "Authorization": ""}},
+	} {
+		var path string
+		var headers http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, headers = r.URL.Path, r.Header.Clone()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"created": 1,
+				"data":    []map[string]string{{"url": "https://example.com/fox.png"}},
+			})
+		}))
+		t.Cleanup(server.Close)
+		c.config.BaseURL = server.URL + "/v1/"
+		useConfig(t, c.config)
+
+		images, err := fetchImage(context.Background(), &ImageRequest{Prompt: "a red fox", GuildID: testGuildID})
+		if err != nil {
+			t.Fatalf("with scheme %q: %v", c.config.AuthScheme, err)
+		}
+		if len(images) != 1 {
+			t.Errorf("with scheme %q, got %d images, want 1", c.config.AuthScheme, len(images))
+		}
+		if path != "/v1/images/generations" {
+			t.Errorf("with scheme %q, asked %s, want /v1/images/generations", c.config.AuthScheme, path)
+		}
+		for name, want := range c.headers {
+			if got := headers.Get(name); got != want {
+				t.Errorf("with scheme %q, %s is %q, want %q", c.config.AuthScheme, name, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateBaseURL(t *testing.T) {
+	for _, c := range []struct {
+		baseURL string
+		ok      bool
+	}{
+		{"", true},
+		{"https://api.openai.com/v1", true},
+		{"http://localhost:7860/v1", true},
+		{"localhost:7860", false},
+		{"ftp://example.com", false},
+		{"https://", false},
+	} {
+		if err := validateBaseURL(&Config{BaseURL: c.baseURL}); (err == nil) != c.ok {
+			t.Errorf("validateBaseURL(%q) = %v, want ok %v", c.baseURL, err, c.ok)
+		}
+	}
+}
+
+func TestAPILimiter(t *testing.T) {
+	useConfig(t, Config{})
+	if l := apiLimiter(); l != nil {
+		t.Errorf("apiLimiter() = %v with no cap, want nil", l)
+	}
+
+	useConfig(t, Config{OpenAIRequestsPerMinute: 30})
+	l := apiLimiter()
+	if l == nil || l.Limit() != 0.5 || l.Burst() != 1 {
+		t.Errorf("apiLimiter() = %+v for 30 a minute, want one every 2s", l)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultMaxBatch is how many prompts one message may hold when
+// MaxPromptsPerMessage isn't set.
+const defaultMaxBatch = 4
+
+// maxBatch returns how many prompts one message may hold.
+func maxBatch() int {
+	if cfg().MaxPromptsPerMessage > 0 {
+		return cfg().MaxPromptsPerMessage
+	}
+	return defaultMaxBatch
+}
+
+// batchCommands returns the text after the prefix of each line of content
+// that is a command of its own.
+func batchCommands(content string) []string {
+	var commands []string
+	for _, line := range strings.Split(content, "\n") {
+		if command, ok := parseCommand(line); ok {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// handleBatch answers a message with a command on each of several lines.
+// With AllowBatch they run one after another as separate requests, each
+// through the usual limits, and otherwise the requester is told to split
+// them up.
+func handleBatch(s session, m *discordgo.MessageCreate, commands []string) {
+	if !cfg().AllowBatch {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "refuse.batch", commandPrefix()), m.Reference())
+		return
+	}
+	if len(commands) > maxBatch() {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "refuse.batchSize", maxBatch()), m.Reference())
+		return
+	}
+	for i, command := range commands {
+		handlePrompt(s, m, normalizePrompt(strings.ToLower(command)), i+1)
+	}
+}
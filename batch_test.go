@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchCommands(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		want    []string
+	}{
+		{"/dalle a red fox", []string{"a red fox"}},
+		{"/dalle a red fox\nin the snow", []string{"a red fox"}},
+		{"/dalle a red fox\n/dalle a blue whale\nnot a command\n  /DALLE a green frog", []string{"a red fox", "a blue whale", "a green frog"}},
+		{"hello\n/dalle2 nope", nil},
+	} {
+		if got := batchCommands(c.content); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("batchCommands(%q) = %q, want %q", c.content, got, c.want)
+		}
+	}
+}
+
+func TestSingleLineNotBatch(t *testing.T) {
+	d, api := setupBot(t, Config{}, "single")
+
+	onMessageHandler(d, d.post("single", "user", "/dalle a red fox\nin the snow"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox in the snow"}) {
+		t.Errorf("generated %q, want the whole message as one prompt", got)
+	}
+}
+
+func TestBatchRunsEachPrompt(t *testing.T) {
+	useLimiter(t)
+	d, api := setupBot(t, Config{AllowBatch: true, MaxRequestsPerMinute: 2}, "batch")
+
+	onMessageHandler(d, d.post("batch", "user", "/dalle a red fox\n/dalle a blue whale\n/dalle a green frog"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox", "a blue whale"}) {
+		t.Errorf("generated %q, want each prompt until the rate limit", got)
+	}
+	images := 0
+	for _, sent := range d.sentMessages() {
+		images += len(sent.Files)
+	}
+	if images != 2 {
+		t.Errorf("sent %d images, want one for each prompt within the limit", images)
+	}
+}
+
+func TestBatchRefused(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		want   string
+	}{
+		{Config{}, "Send one `/dalle` prompt per message, please."},
+		{Config{AllowBatch: true, MaxPromptsPerMessage: 2}, "I can only take 2 prompts in one message."},
+	} {
+		d, api := setupBot(t, c.config, "batch")
+
+		onMessageHandler(d, d.post("batch", "user", "/dalle a red fox\n/dalle a blue whale\n/dalle a green frog"))
+
+		if got := api.generated(); len(got) != 0 {
+			t.Errorf("with %+v generated %q, want the batch refused", c.config, got)
+		}
+		if sent := d.sentMessages(); len(sent) != 1 || sent[0].Content != c.want {
+			t.Errorf("with %+v sent %+v, want %q", c.config, sent, c.want)
+		}
+	}
+}
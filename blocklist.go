@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// blockedWord returns the first of BlockedWords in prompt, or "" when there
+// is none. Words match case-insensitively and only as whole words, unless
+// BlockedWordsSubstring is set.
+func blockedWord(prompt string) string {
+	prompt = strings.ToLower(prompt)
+	for _, word := range cfg().BlockedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		if cfg().BlockedWordsSubstring {
+			if strings.Contains(prompt, word) {
+				return word
+			}
+		} else if containsWord(prompt, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// containsWord reports whether word appears in s with no letter or digit
+// directly on either side of it.
+func containsWord(s string, word string) bool {
+	for start := 0; ; {
+		i := strings.Index(s[start:], word)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(word)
+
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		start = i + size
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBlockedWord(t *testing.T) {
+	for _, tt := range []struct {
+		prompt    string
+		substring bool
+		want      string
+	}{
+		{"a red fox", false, ""},
+		{"a gory scene", false, "gory"},
+		{"a GORY scene", false, "gory"},
+		{"gory, isn't it", false, "gory"},
+		{"the story of gregory", false, ""},
+		{"a gorypalooza", false, ""},
+		{"allegory", true, "gory"},
+		{"very gore", false, "very gore"},
+		{"very  gore", false, ""},
+	} {
+		useConfig(t, Config{BlockedWords: []string{"  Gory ", "", "very gore"}, BlockedWordsSubstring: tt.substring})
+		if got := blockedWord(tt.prompt); got != tt.want {
+			t.Errorf("blockedWord(%q) with substring %v = %q, want %q", tt.prompt, tt.substring, got, tt.want)
+		}
+	}
+}
+
+func TestBlockedPromptRefused(t *testing.T) {
+	d, api := setupBot(t, Config{BlockedWords: []string{"gory"}}, "blocked")
+	m := d.post("blocked", "user", "/dalle a gory scene")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want a blocked prompt never sent", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"🚫"}) {
+		t.Errorf("request shows %q, want 🚫", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || strings.Contains(sent[0].Content, "gory") {
+		t.Errorf("sent %+v, want a refusal that doesn't repeat the word", sent)
+	}
+}
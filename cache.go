@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+const defaultCacheSize = 100
+
+// imageCache keeps recently generated images so identical requests made
+// shortly after each other are only paid for once. It holds the downloaded
+// images rather than OpenAI's URLs, which expire.
+type imageCache struct {
+	mu      sync.Mutex
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	images  []imagegen.Image
+	imgs    []*imageFile
+	expires time.Time
+}
+
+var resultCache = &imageCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+// cacheKey identifies the requests that would get the same images, or is
+// empty for requests that can't be cached. It has the prompt as sent, so
+// reloading a new PromptPrefix or PromptSuffix doesn't reuse images made
+// with the old one and guilds with their own GuildPromptSuffixes never get
+// each other's, and the guild, whose key and model may be its own.
+func cacheKey(imgReq *ImageRequest) string {
+	if imgReq.Source != nil || imgReq.Redo {
+		return ""
+	}
+	opts := imgReq.options()
+	seed := ""
+	if opts.Seed != nil {
+		seed = strconv.FormatInt(*opts.Seed, 10)
+	}
+	return imgReq.GuildID + "|" + opts.Model + "|" + opts.Size + "|" + strconv.Itoa(opts.N) + "|" + opts.Quality + "|" + opts.Style + "|" + seed + "|" + styledPrompt(imgReq.GuildID, imgReq.Prompt)
+}
+
+// get returns the unexpired images cached under key.
+func (c *imageCache) get(key string) ([]imagegen.Image, []*imageFile, bool) {
+	if cfg().CacheTTLSeconds <= 0 || key == "" {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.images, entry.imgs, true
+}
+
+// put caches images for CacheTTLSeconds, evicting the least recently used
+// entry once there are CacheSize of them.
+func (c *imageCache) put(key string, images []imagegen.Image, imgs []*imageFile) {
+	if cfg().CacheTTLSeconds <= 0 || key == "" {
+		return
+	}
+	size := cfg().CacheSize
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{
+		key:     key,
+		images:  images,
+		imgs:    imgs,
+		expires: now().Add(time.Duration(cfg().CacheTTLSeconds) * time.Second),
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCacheReusesIdenticalRequests(t *testing.T) {
+	d, api := setupBot(t, Config{CacheTTLSeconds: 60}, "cached")
+
+	onMessageHandler(d, d.post("cached", "user", "/dalle a cached lighthouse"))
+	onMessageHandler(d, d.post("cached", "someone", "/dalle a cached lighthouse"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a cached lighthouse"}) {
+		t.Errorf("generated %q, want the second request answered from the cache", got)
+	}
+	if sent := d.sentMessages(); len(sent) != 2 || len(sent[1].Files) != 1 {
+		t.Errorf("sent %+v, want both requests answered with the image", sent)
+	}
+}
+
+func TestCacheMissesAfterPrefixChange(t *testing.T) {
+	d, api := setupBot(t, Config{CacheTTLSeconds: 60, PromptPrefix: "a watercolor of "}, "restyled")
+	onMessageHandler(d, d.post("restyled", "user", "/dalle a restyled harbour"))
+
+	// as after a reload with a new prefix
+	useConfig(t, Config{BaseURL: api.URL, CacheTTLSeconds: 60, PromptPrefix: "a charcoal sketch of "})
+	onMessageHandler(d, d.post("restyled", "user", "/dalle a restyled harbour"))
+
+	want := []string{"a watercolor of a restyled harbour", "a charcoal sketch of a restyled harbour"}
+	if got := api.generated(); !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q", got, want)
+	}
+}
+
+func TestCacheKeptApartByGuildSuffix(t *testing.T) {
+	d, api := setupBot(t, Config{CacheTTLSeconds: 60, GuildPromptSuffixes: map[string]string{
+		testGuildID: ", safe for work",
+		"other":     ", in black and white",
+	}}, "guarded")
+
+	onMessageHandler(d, d.post("guarded", "user", "/dalle a guarded castle"))
+	other := d.post("guarded", "someone", "/dalle a guarded castle")
+	other.GuildID = "other"
+	onMessageHandler(d, other)
+	onMessageHandler(d, d.post("guarded", "someone", "/dalle a guarded castle"))
+
+	want := []string{"a guarded castle, safe for work", "a guarded castle, in black and white"}
+	if got := api.generated(); !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q with only the same guild's request cached", got, want)
+	}
+	if sent := d.sentMessages(); len(sent) != 3 || len(sent[2].Files) != 1 {
+		t.Errorf("sent %+v, want all three answered with an image", sent)
+	}
+}
+
+func TestCacheKeyHasEffectivePrompt(t *testing.T) {
+	useConfig(t, Config{PromptSuffix: ", oil painting", GuildPromptSuffixes: map[string]string{"a": ", safe for work"}})
+
+	inA := &ImageRequest{GuildID: "a", Prompt: "a castle", Model: "dall-e-2", Size: "512x512"}
+	inB := &ImageRequest{GuildID: "b", Prompt: "a castle", Model: "dall-e-2", Size: "512x512"}
+	if key := cacheKey(inA); !strings.HasSuffix(key, "|a castle, oil painting, safe for work") {
+		t.Errorf("cacheKey() = %q, want it to end with the prompt as sent", key)
+	}
+	if cacheKey(inA) == cacheKey(inB) {
+		t.Error("requests in guilds with different suffixes share a cache key")
+	}
+	bigger := *inA
+	bigger.Size = "1024x1024"
+	if cacheKey(inA) == cacheKey(&bigger) {
+		t.Error("requests for different sizes share a cache key")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// cancelEntry is a running request that its requester can cancel.
+type cancelEntry struct {
+	userID string
+	cancel context.CancelFunc
+}
+
+// cancelRegistry keeps the cancel functions of running requests by the
+// message showing their status.
+type cancelRegistry struct {
+	mu        sync.Mutex
+	byMessage map[string][]*cancelEntry
+}
+
+var runningRequests = &cancelRegistry{byMessage: make(map[string][]*cancelEntry)}
+
+// add records a request shown on messageID that userID may cancel. The
+// returned function removes it again and must be called when it finishes.
+func (c *cancelRegistry) add(messageID string, userID string, cancel context.CancelFunc) (remove func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cancelEntry{userID, cancel}
+	c.byMessage[messageID] = append(c.byMessage[messageID], entry)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		entries := c.byMessage[messageID]
+		for i, e := range entries {
+			if e == entry {
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.byMessage, messageID)
+		} else {
+			c.byMessage[messageID] = entries
+		}
+	}
+}
+
+// cancel cancels userID's requests shown on messageID, reporting whether
+// there were any.
+func (c *cancelRegistry) cancel(messageID string, userID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cancelled := false
+	for _, e := range c.byMessage[messageID] {
+		if e.userID == userID {
+			e.cancel()
+			cancelled = true
+		}
+	}
+	return cancelled
+}
+
+// cancelAll cancels every request shown on messageID, whoever asked for it,
+// reporting whether there were any.
+func (c *cancelRegistry) cancelAll(messageID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.byMessage[messageID] {
+		e.cancel()
+	}
+	return len(c.byMessage[messageID]) > 0
+}
+
+// isCancelEmoji reports whether a reaction is ⏹️, which clients send with or
+// without the variation selector.
+func isCancelEmoji(name string) bool {
+	return strings.TrimSuffix(name, "\ufe0f") == "⏹"
+}
+
+// failStatus swaps a request's 🤖 for ❌, or for 🚫 when its requester
+// cancelled it. Requests the janitor already marked overdue are left alone.
+func failStatus(ctx context.Context, s session, channelID string, messageID string) error {
+	if activeRequests.isOverdue(channelID, messageID) {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logf(ctx, "Request cancelled")
+		return swapStatus(s, channelID, messageID, statusEmojis().Working, "🚫")
+	}
+	return swapStatus(s, channelID, messageID, statusEmojis().Working, statusEmojis().Error)
+}
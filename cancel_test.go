@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCancelRegistry(t *testing.T) {
+	registry := &cancelRegistry{byMessage: make(map[string][]*cancelEntry)}
+	ctx, cancel := context.WithCancel(context.Background())
+	remove := registry.add("m1", "user", cancel)
+
+	if registry.cancel("m1", "someone") || ctx.Err() != nil {
+		t.Fatal("someone else cancelled the request")
+	}
+	if !registry.cancel("m1", "user") || ctx.Err() != context.Canceled {
+		t.Fatalf("requester's cancel left the context with %v, want it cancelled", ctx.Err())
+	}
+
+	remove()
+	if _, ok := registry.byMessage["m1"]; ok {
+		t.Error("finished request is still registered")
+	}
+	if registry.cancel("m1", "user") {
+		t.Error("cancelled a request that had finished")
+	}
+}
+
+func TestCancelInFlight(t *testing.T) {
+	d, api := setupBot(t, Config{}, "inflight")
+	release := api.hang()
+	t.Cleanup(release)
+
+	m := d.post("inflight", "user", "/dalle a long wait")
+	done := make(chan struct{})
+	go func() {
+		onMessageHandler(d, m)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); len(api.generated()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("generation never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	onEmojiAddHandler(d, d.react("inflight", m.ID, "someone", "⏹️"))
+	onEmojiAddHandler(d, d.react("inflight", m.ID, "user", "⏹"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request is still generating")
+	}
+	// the server hears of the dropped connection in its own time
+	for deadline := time.Now().Add(time.Second); api.abandonedCount() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("OpenAI saw %d generations given up on, want the cancelled one", api.abandonedCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"🚫"}) {
+		t.Errorf("cancelled request shows %q, want 🚫", got)
+	}
+	if len(d.sentMessages()) != 0 {
+		t.Errorf("sent %+v, want nothing for a cancelled request", d.sentMessages())
+	}
+	runningRequests.mu.Lock()
+	defer runningRequests.mu.Unlock()
+	if _, ok := runningRequests.byMessage[m.ID]; ok {
+		t.Error("cancelled request is still registered")
+	}
+}
+
+func TestWithdrawnRerollCancelled(t *testing.T) {
+	d, api := setupBot(t, Config{}, "withdrawn")
+	onMessageHandler(d, d.post("withdrawn", "user", "/dalle a blue whale"))
+	first := d.sentMessages()[0]
+	release := api.hang()
+	t.Cleanup(release)
+
+	done := make(chan struct{})
+	go func() {
+		onEmojiAddHandler(d, d.react("withdrawn", first.ID, "user", "🔁"))
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); len(api.generated()) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("re-roll never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// neither the bot clearing its own 🔁 nor someone else's withdrawal
+	// stops it
+	onEmojiRemoveHandler(d, d.unreact("withdrawn", first.ID, testBotID, "🔁"))
+	onEmojiRemoveHandler(d, d.unreact("withdrawn", first.ID, "someone", "🔁"))
+	select {
+	case <-done:
+		t.Fatal("re-roll stopped without its requester taking back the 🔁")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	onEmojiRemoveHandler(d, d.unreact("withdrawn", first.ID, "user", "🔁"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("withdrawn re-roll is still generating")
+	}
+	if sent := d.sentMessages(); len(sent) != 1 {
+		t.Errorf("sent %d messages, want no variation after the 🔁 was taken back", len(sent))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+
+	"github.com/mdesson/disc-e/store"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// chartDays is how many days the stats chart goes back.
+const chartDays = 14
+
+const (
+	chartWidth  = 640
+	chartHeight = 320
+	// chartMargin leaves room around the bars for the labels
+	chartMargin = 24
+)
+
+var (
+	chartBackground = color.RGBA{0x2b, 0x2d, 0x31, 0xff}
+	chartBar        = color.RGBA{0x58, 0x65, 0xf2, 0xff}
+	chartText       = color.RGBA{0xdb, 0xde, 0xe1, 0xff}
+)
+
+// drawChart draws counts as a bar a day, labelled with the date under it
+// and the count over it, as a PNG.
+func drawChart(counts []store.DayCount) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+
+	most := 1
+	for _, c := range counts {
+		most = max(most, c.Count)
+	}
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+	// the bottom of the bars, with the dates under it and a count over the
+	// tallest
+	bottom := chartHeight - chartMargin - lineHeight
+	tallest := bottom - chartMargin - lineHeight
+	slot := (chartWidth - 2*chartMargin) / max(1, len(counts))
+
+	label := func(text string, centre int, baseline int) {
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(chartText), Face: face}
+		width := d.MeasureString(text).Ceil()
+		d.Dot = fixed.P(centre-width/2, baseline)
+		d.DrawString(text)
+	}
+	for i, c := range counts {
+		left := chartMargin + i*slot
+		centre := left + slot/2
+		height := tallest * c.Count / most
+		bar := image.Rect(left+slot/6, bottom-height, left+slot-slot/6, bottom)
+		draw.Draw(img, bar, image.NewUniform(chartBar), image.Point{}, draw.Src)
+
+		if c.Count > 0 {
+			label(strconv.Itoa(c.Count), centre, bar.Min.Y-4)
+		}
+		label(c.Day.Format("Jan 2"), centre, bottom+lineHeight+2)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// statsChart charts userID's requests a day for the stats command. When
+// there's nothing to chart, chart is nil and reply says why.
+func statsChart(ctx context.Context, userID string) (chart *imageFile, reply string) {
+	locale := localeFrom(ctx)
+	if history == nil {
+		return nil, tr(locale, "stats.off")
+	}
+	counts, err := history.Daily(ctx, userID, chartDays, now())
+	if err != nil {
+		logger(ctx).Error("Error getting daily stats", "error", err)
+		return nil, tr(locale, "stats.error")
+	}
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	if total == 0 {
+		return nil, tr(locale, "stats.noData")
+	}
+
+	data, err := drawChart(counts)
+	if err != nil {
+		logger(ctx).Error("Error drawing stats chart", "error", err)
+		return nil, tr(locale, "stats.error")
+	}
+	chart = &imageFile{
+		Name:        "stats.png",
+		ContentType: "image/png",
+		Data:        data,
+		Description: tr(locale, "stats.chartAlt", chartDays),
+	}
+	return chart, tr(locale, "stats.chart", total, chartDays)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mdesson/disc-e/store"
+)
+
+func TestDrawChart(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	counts := []store.DayCount{{Day: day, Count: 3}, {Day: day.AddDate(0, 0, 1)}, {Day: day.AddDate(0, 0, 2), Count: 1}}
+
+	data, err := drawChart(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("chart isn't a PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != chartWidth || b.Dy() != chartHeight {
+		t.Errorf("chart is %dx%d, want %dx%d", b.Dx(), b.Dy(), chartWidth, chartHeight)
+	}
+	// the first day's bar rises from the bottom of the chart
+	slot := (chartWidth - 2*chartMargin) / len(counts)
+	if got := img.At(chartMargin+slot/2, chartHeight/2); got != chartBar {
+		t.Errorf("colour in the middle of the tallest bar is %v, want %v", got, chartBar)
+	}
+	if got := img.At(chartMargin+slot+slot/2, chartHeight/2); got != chartBackground {
+		t.Errorf("colour over the empty day is %v, want the background", got)
+	}
+}
+
+func TestStatsChart(t *testing.T) {
+	useHistory(t)
+	d, _ := setupBot(t, Config{}, "chart")
+	onMessageHandler(d, d.post("chart", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("chart", "user", "/dalle a blue whale"))
+
+	onMessageHandler(d, d.post("chart", "user", "/dalle stats --chart"))
+
+	sent := d.sentMessages()
+	last := sent[len(sent)-1]
+	if want := tr("en", "stats.chart", 2, chartDays); last.Content != want {
+		t.Errorf("replied %q, want %q", last.Content, want)
+	}
+	if !reflect.DeepEqual(last.FileNames, []string{"stats.png"}) {
+		t.Fatalf("attached %q, want the chart", last.FileNames)
+	}
+	if _, err := png.Decode(bytes.NewReader(last.Files[0])); err != nil {
+		t.Errorf("chart isn't a PNG: %v", err)
+	}
+}
+
+func TestStatsChartNoData(t *testing.T) {
+	useHistory(t)
+	d, _ := setupBot(t, Config{}, "emptychart")
+
+	onMessageHandler(d, d.post("emptychart", "newcomer", "/dalle stats --chart"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != tr("en", "stats.noData") || len(sent[0].Files) != 0 {
+		t.Errorf("sent %+v, want only the no data reply", sent)
+	}
+}
+
+func TestSlashCommandStatsChart(t *testing.T) {
+	s := useHistory(t)
+	d, _ := setupBot(t, Config{EnableSlashCommands: true}, "slashchart")
+	s.Add(context.Background(), &store.Record{AuthorID: "user", Prompt: "a red fox", Success: true})
+
+	i := d.command("slashchart", "user", "stats --chart")
+	onInteractionHandler(d, i)
+
+	response := d.response(i)
+	if response == nil || !reflect.DeepEqual(response.FileNames, []string{"stats.png"}) {
+		t.Fatalf("response %+v, want the chart attached", response)
+	}
+	if want := tr("en", "stats.chart", 1, chartDays); response.Content != want {
+		t.Errorf("responded %q, want %q", response.Content, want)
+	}
+}
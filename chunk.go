@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxMessageLength is the most characters Discord allows in a message.
+const maxMessageLength = 2000
+
+// splitMessage breaks content into pieces of at most limit characters,
+// cutting at the last line break that fits, else the last space, and only
+// mid-word when a single word is longer than limit.
+func splitMessage(content string, limit int) []string {
+	var chunks []string
+	for utf8.RuneCountInString(content) > limit {
+		// byte offset just past the first limit runes
+		end, n := len(content), 0
+		for i := range content {
+			if n == limit {
+				end = i
+				break
+			}
+			n++
+		}
+
+		cut, next := end, end
+		if i := strings.LastIndex(content[:end], "\n"); i > 0 {
+			cut, next = i, i+1
+		} else if i := strings.LastIndex(content[:end], " "); i > 0 {
+			cut, next = i, i+1
+		}
+		chunks = append(chunks, strings.TrimRight(content[:cut], " "))
+		content = content[next:]
+	}
+	if content != "" || len(chunks) == 0 {
+		chunks = append(chunks, content)
+	}
+	return chunks
+}
+
+// sendReplyChunked sends content as a reply to reference (or as a plain
+// message when reference is nil), split over as many messages as Discord's
+// length limit needs. The images and the reply go on the first message, which
+// is the one returned.
+func sendReplyChunked(s session, channelID string, content string, imgs []*imageFile, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	return sendChunks(s, channelID, content, imgs, reference, nil)
+}
+
+// sendChunks is sendReplyChunked asking retry, when it's set, whether to send
+// a piece that failed once more. It's only asked about that one piece, so
+// those already sent aren't sent twice.
+func sendChunks(s session, channelID string, content string, imgs []*imageFile, reference *discordgo.MessageReference, retry func(err error) bool) (*discordgo.Message, error) {
+	var first *discordgo.Message
+	for i, chunk := range splitMessage(content, maxMessageLength) {
+		send := func() (*discordgo.Message, error) {
+			msg := &discordgo.MessageSend{Content: chunk}
+			if i == 0 {
+				msg.Reference = reference
+			}
+			if i == 0 && len(imgs) > 0 {
+				return sendDescribed(s, channelID, msg, imgs)
+			}
+			return s.ChannelMessageSendComplex(channelID, msg)
+		}
+		msg, err := send()
+		if err != nil && retry != nil && retry(err) {
+			msg, err = send()
+		}
+		if err != nil {
+			return first, err
+		}
+		if first == nil {
+			first = msg
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSplitMessage(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		limit   int
+		want    []string
+	}{
+		{"short", "a red fox", 20, []string{"a red fox"}},
+		{"empty", "", 20, []string{""}},
+		{"at a line break", "first line\nsecond line", 15, []string{"first line", "second line"}},
+		{"at a space", "one two three four", 10, []string{"one two", "three four"}},
+		{"mid-word", "abcdefghijkl", 5, []string{"abcde", "fghij", "kl"}},
+		{"multibyte", "ééééé ééééé", 6, []string{"ééééé", "ééééé"}},
+	} {
+		got := splitMessage(tt.content, tt.limit)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: splitMessage(%q, %d) = %q, want %q", tt.name, tt.content, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSendReplyChunked(t *testing.T) {
+	useConfig(t, Config{})
+	d := newFakeDiscord()
+	d.addChannel("long")
+	img, err := newImageFile(testPNG(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sendReplyChunked(d, "long", "a short reply", []*imageFile{img}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sent := d.sentMessages(); len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %+v, want one message with the image", sent)
+	}
+
+	long := strings.Repeat("word ", 900)
+	first, err := sendReplyChunked(d, "long", long, []*imageFile{img}, &discordgo.MessageReference{MessageID: "request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := d.sentMessages()[1:]
+	if len(sent) != 3 {
+		t.Fatalf("sent %d messages for %d characters, want 3", len(sent), len(long))
+	}
+	if first.ID != sent[0].ID || len(sent[0].Files) != 1 || sent[0].MessageReference == nil {
+		t.Errorf("first chunk is %+v, want it returned with the image and the reply", sent[0])
+	}
+	for i, m := range sent {
+		if n := utf8.RuneCountInString(m.Content); n > maxMessageLength {
+			t.Errorf("chunk %d has %d characters", i, n)
+		}
+		if i > 0 && (len(m.Files) != 0 || m.MessageReference != nil) {
+			t.Errorf("chunk %d is %+v, want only text", i, m)
+		}
+	}
+}
+
+func TestSlowModeRetriesOnlyRejectedChunk(t *testing.T) {
+	useConfig(t, Config{HonorSlowMode: true})
+	d := newFakeDiscord()
+	d.addChannel("slowmode")
+	slowMode := &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeThisActionCannotBePerformedDueToSlowmodeRateLimit}}
+	d.sendErrors = []error{nil, slowMode}
+	content := strings.Repeat("a", maxMessageLength) + "\n" + "the rest"
+
+	if _, err := sendReply(context.Background(), d, "slowmode", content, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := d.sentMessages()
+	if len(sent) != 2 || !strings.HasSuffix(sent[1].Content, "the rest") {
+		t.Errorf("sent %d messages, want the first chunk once and the rejected one again", len(sent))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// generateOnce runs a single generation without connecting to Discord,
+// printing the image URL or, when out is set, saving the image to that file.
+func generateOnce(prompt string, out string) error {
+	size, prompt := parseSize(normalizePrompt(prompt))
+	quality, style, prompt := parseStyle(prompt, currentModel())
+	imgReq := ImageRequest{
+		RequestID:      uuid.NewString(),
+		ID:             "cli",
+		Prompt:         prompt,
+		Model:          currentModel(),
+		Size:           resolveSize(currentModel(), size),
+		Quality:        quality,
+		Style:          style,
+		IdempotencyKey: fmt.Sprintf("cli-%d", time.Now().UnixNano()),
+	}
+
+	if imgReq.Prompt == "" {
+		return fmt.Errorf("prompt is empty")
+	}
+	if !hasCredentials("") {
+		return fmt.Errorf("no openAIKey configured")
+	}
+
+	images, err := generateImage(newRequestContext(context.Background(), &imgReq), &imgReq)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		if images[0].URL == "" {
+			return fmt.Errorf("the image came back as base64, use -out to save it")
+		}
+		fmt.Println(images[0].URL)
+		return nil
+	}
+
+	imgs, err := loadImages(context.Background(), images[:1])
+	if err != nil {
+		return err
+	}
+	img := imgs[0]
+
+	if err := ioutil.WriteFile(out, img.Data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved image to %s\n", out)
+	return nil
+}
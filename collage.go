@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mdesson/disc-e/store"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// defaultCollageCount is how many images a collage holds when the
+	// command doesn't say.
+	defaultCollageCount = 9
+	// maxCollageCount keeps a collage to a grid that's still worth looking
+	// at, and its downloads few.
+	maxCollageCount = 16
+	// maxCollageBytes caps how much a collage downloads altogether.
+	maxCollageBytes = 50 << 20
+	// collageLookback is how many of a user's requests are looked through
+	// for results, since failed ones have none.
+	collageLookback = 100
+	// collageCell is the width and height each image is scaled to fit.
+	collageCell = 256
+	// collageGap is the space between and around the images.
+	collageGap = 4
+)
+
+// parseCollage reads the collage command: "collage" or "collage <count>",
+// with count held to maxCollageCount. ok is false for any other prompt, so
+// "collage of cats" is still drawn.
+func parseCollage(prompt string) (count int, ok bool) {
+	fields := strings.Fields(prompt)
+	if len(fields) == 0 || fields[0] != "collage" || len(fields) > 2 {
+		return 0, false
+	}
+	if len(fields) == 1 {
+		return defaultCollageCount, true
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil || count < 1 {
+		return 0, false
+	}
+	return min(count, maxCollageCount), true
+}
+
+// makeContactSheet lays imgs out in a grid as near square as it gets, each
+// scaled to fit a cell of collageCell pixels and centred in it, as a PNG.
+func makeContactSheet(imgs []image.Image) ([]byte, error) {
+	if len(imgs) == 0 {
+		return nil, errors.New("no images for a contact sheet")
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(len(imgs)))))
+	rows := (len(imgs) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		columns*(collageCell+collageGap)+collageGap,
+		rows*(collageCell+collageGap)+collageGap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+
+	for i, img := range imgs {
+		bounds := img.Bounds()
+		w, h := bounds.Dx(), bounds.Dy()
+		if w >= h {
+			w, h = collageCell, max(1, h*collageCell/w)
+		} else {
+			w, h = max(1, w*collageCell/h), collageCell
+		}
+		left := collageGap + (i%columns)*(collageCell+collageGap) + (collageCell-w)/2
+		top := collageGap + (i/columns)*(collageCell+collageGap) + (collageCell-h)/2
+		draw.CatmullRom.Scale(sheet, image.Rect(left, top, left+w, top+h), img, bounds, draw.Src, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// collageURLs returns up to count of the result images in records, newest
+// first.
+func collageURLs(records []store.Record, count int) []string {
+	var urls []string
+	for _, r := range records {
+		if !r.Success {
+			continue
+		}
+		for _, u := range r.URLs {
+			if len(urls) == count {
+				return urls
+			}
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// collage makes a contact sheet of userID's last count result images for
+// the collage command. Images that are gone, or no longer decode, are left
+// out, and downloading stops at maxCollageBytes. When there's nothing to
+// show, sheet is nil and reply says why.
+func collage(ctx context.Context, userID string, count int) (sheet *imageFile, reply string) {
+	locale := localeFrom(ctx)
+	if history == nil {
+		return nil, tr(locale, "collage.off")
+	}
+	records, err := history.ByAuthor(ctx, userID, collageLookback)
+	if err != nil {
+		logger(ctx).Error("Error getting history", "error", err)
+		return nil, tr(locale, "collage.error")
+	}
+	urls := collageURLs(records, count)
+	if len(urls) == 0 {
+		return nil, tr(locale, "collage.none")
+	}
+
+	var imgs []image.Image
+	budget := maxCollageBytes
+	for _, u := range urls {
+		if budget <= 0 {
+			break
+		}
+		dctx, cancel := context.WithTimeout(ctx, downloadTimeout())
+		file, err := downloadLimited(dctx, u, min(budget, maxDownloadBytes()))
+		cancel()
+		if err != nil {
+			// Discord's links expire too, and deleted replies take theirs along
+			logger(ctx).Warn("Skipping image for collage", "url", u, "error", err)
+			continue
+		}
+		budget -= len(file.Data)
+		img, _, err := image.Decode(bytes.NewReader(file.Data))
+		if err != nil {
+			logger(ctx).Warn("Skipping image for collage", "url", u, "error", err)
+			continue
+		}
+		imgs = append(imgs, img)
+	}
+	if len(imgs) == 0 {
+		return nil, tr(locale, "collage.gone")
+	}
+
+	data, err := makeContactSheet(imgs)
+	if err != nil {
+		logger(ctx).Error("Error drawing collage", "error", err)
+		return nil, tr(locale, "collage.error")
+	}
+	sheet = &imageFile{
+		Name:        "collage.png",
+		ContentType: "image/png",
+		Data:        data,
+		Description: tr(locale, "collage.alt", len(imgs)),
+	}
+	reply = tr(locale, "collage.made", len(imgs))
+	if skipped := len(urls) - len(imgs); skipped > 0 {
+		reply += " " + tr(locale, "collage.skipped", skipped)
+	}
+	return sheet, reply
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/mdesson/disc-e/store"
+)
+
+func TestParseCollage(t *testing.T) {
+	for _, c := range []struct {
+		prompt string
+		count  int
+		ok     bool
+	}{
+		{"collage", defaultCollageCount, true},
+		{"collage 4", 4, true},
+		{"collage 100", maxCollageCount, true},
+		{"collage 0", 0, false},
+		{"collage of cats", 0, false},
+		{"a collage", 0, false},
+		{"collage 4 cats", 0, false},
+	} {
+		if count, ok := parseCollage(c.prompt); count != c.count || ok != c.ok {
+			t.Errorf("parseCollage(%q) = %d, %v, want %d, %v", c.prompt, count, ok, c.count, c.ok)
+		}
+	}
+}
+
+func TestMakeContactSheet(t *testing.T) {
+	var imgs []image.Image
+	for _, size := range [][2]int{{16, 16}, {32, 8}, {8, 32}, {16, 16}, {16, 16}} {
+		imgs = append(imgs, image.NewRGBA(image.Rect(0, 0, size[0], size[1])))
+	}
+
+	data, err := makeContactSheet(imgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("contact sheet isn't a PNG: %v", err)
+	}
+	// five images go three to a row
+	want := image.Rect(0, 0, 3*(collageCell+collageGap)+collageGap, 2*(collageCell+collageGap)+collageGap)
+	if sheet.Bounds() != want {
+		t.Errorf("contact sheet is %v, want %v", sheet.Bounds(), want)
+	}
+	// the empty sixth cell is left as the background
+	if got := color.RGBAModel.Convert(sheet.At(want.Max.X-collageGap-collageCell/2, want.Max.Y-collageGap-collageCell/2)); got != chartBackground {
+		t.Errorf("colour of the empty cell is %v, want the background", got)
+	}
+
+	if _, err := makeContactSheet(nil); err == nil {
+		t.Error("makeContactSheet(nil) succeeded, want an error")
+	}
+}
+
+func TestCollage(t *testing.T) {
+	s := useHistory(t)
+	d, _ := setupBot(t, Config{}, "collage")
+	ctx := context.Background()
+	served := serving(t, "image/png", pngOfSize(t, 8, 8))
+	gone := serving(t, "text/plain", []byte("not found"))
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a red fox", URLs: []string{served + "/1", served + "/2"}, Success: true})
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a blue whale", Success: false})
+	s.Add(ctx, &store.Record{AuthorID: "user", Prompt: "a green frog", URLs: []string{gone + "/3"}, Success: true})
+	s.Add(ctx, &store.Record{AuthorID: "someone", Prompt: "a cat", URLs: []string{served + "/4"}, Success: true})
+
+	onMessageHandler(d, d.post("collage", "user", "/dalle collage 2"))
+	onMessageHandler(d, d.post("collage", "user", "/dalle collage"))
+
+	sent := d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want two collages", len(sent))
+	}
+	// the newest result is gone, so the first collage only has one image
+	want := []string{
+		tr("en", "collage.made", 1) + " " + tr("en", "collage.skipped", 1),
+		tr("en", "collage.made", 2) + " " + tr("en", "collage.skipped", 1),
+	}
+	for i, m := range sent {
+		if m.Content != want[i] {
+			t.Errorf("collage %d replied %q, want %q", i, m.Content, want[i])
+		}
+		if !reflect.DeepEqual(m.FileNames, []string{"collage.png"}) {
+			t.Fatalf("collage %d attached %q, want the contact sheet", i, m.FileNames)
+		}
+		if _, err := png.Decode(bytes.NewReader(m.Files[0])); err != nil {
+			t.Errorf("collage %d isn't a PNG: %v", i, err)
+		}
+	}
+}
+
+func TestCollageNothingLeft(t *testing.T) {
+	s := useHistory(t)
+	d, api := setupBot(t, Config{}, "nocollage")
+	s.Add(context.Background(), &store.Record{AuthorID: "user", Prompt: "a red fox", URLs: []string{serving(t, "text/plain", nil)}, Success: true})
+
+	onMessageHandler(d, d.post("nocollage", "newcomer", "/dalle collage"))
+	onMessageHandler(d, d.post("nocollage", "user", "/dalle collage"))
+
+	var replies []string
+	for _, m := range d.sentMessages() {
+		if len(m.Files) != 0 {
+			t.Errorf("attached %q, want nothing to show", m.FileNames)
+		}
+		replies = append(replies, m.Content)
+	}
+	if want := []string{tr("en", "collage.none"), tr("en", "collage.gone")}; !reflect.DeepEqual(replies, want) {
+		t.Errorf("replied %q, want %q", replies, want)
+	}
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want the command answered without OpenAI", got)
+	}
+}
+
+func TestCollageOff(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "collageoff")
+
+	onMessageHandler(d, d.post("collageoff", "user", "/dalle collage"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != tr("en", "collage.off") {
+		t.Errorf("sent %+v, want collages refused without a history", sent)
+	}
+}
+
+func TestSlashCommandCollage(t *testing.T) {
+	s := useHistory(t)
+	d, _ := setupBot(t, Config{EnableSlashCommands: true}, "slashcollage")
+	s.Add(context.Background(), &store.Record{AuthorID: "user", Prompt: "a red fox", URLs: []string{serving(t, "image/png", pngOfSize(t, 8, 8))}, Success: true})
+
+	i := d.command("slashcollage", "user", "collage")
+	onInteractionHandler(d, i)
+
+	response := d.response(i)
+	if response == nil || !reflect.DeepEqual(response.FileNames, []string{"collage.png"}) {
+		t.Fatalf("response %+v, want the contact sheet attached", response)
+	}
+	if want := tr("en", "collage.made", 1); response.Content != want {
+		t.Errorf("responded %q, want %q", response.Content, want)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// compositionEmoji on a result redraws it keeping its composition.
+const compositionEmoji = "🎯"
+
+// defaultCompositionStrength redraws enough to change the details while
+// keeping the layout.
+const defaultCompositionStrength = 0.4
+
+// compositionStrength is how far a 🎯 redraw strays from the result, from 0
+// keeping it as it is to 1 ignoring it.
+func compositionStrength() float64 {
+	if cfg().CompositionStrength > 0 {
+		return cfg().CompositionStrength
+	}
+	return defaultCompositionStrength
+}
+
+// validateCompositionStrength checks the 🎯 redraw strength.
+func validateCompositionStrength(c *Config) error {
+	if s := c.CompositionStrength; s < 0 || s > 1 {
+		return fmt.Errorf("compositionStrength %g must be between 0 and 1, with 0 meaning %g", s, defaultCompositionStrength)
+	}
+	return nil
+}
+
+// keepComposition sets imgReq up to redraw the image in result: as an edit
+// at compositionStrength for backends that take one, else as a variation,
+// which OpenAI keeps closest to the original.
+func keepComposition(ctx context.Context, imgReq *ImageRequest, result *discordgo.Message, size string) error {
+	redraw := cfg().SupportsStrength && imgReq.Prompt != ""
+	// variations only take square images
+	source, err := compositionSource(ctx, result, !redraw)
+	if err != nil {
+		return err
+	}
+	imgReq.Source, imgReq.Mask = source, nil
+	if redraw {
+		strength := compositionStrength()
+		imgReq.Strength = &strength
+		return nil
+	}
+	imgReq.Model, imgReq.AutoModel = variationModel, false
+	imgReq.Size = resolveSize(variationModel, size)
+	return nil
+}
+
+// compositionSource downloads the first image of result as a PNG, cropped
+// to a centred square when square is set.
+func compositionSource(ctx context.Context, result *discordgo.Message, square bool) ([]byte, error) {
+	var imgURL string
+	switch {
+	case len(result.Attachments) > 0:
+		imgURL = result.Attachments[0].URL
+	case len(result.Embeds) > 0 && result.Embeds[0].Image != nil:
+		imgURL = result.Embeds[0].Image.URL
+	default:
+		return nil, errors.New("no image on the result")
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, attachmentTimeout())
+	defer cancel()
+	img, err := downloadLimited(downloadCtx, imgURL, maxDownloadBytes())
+	if err != nil {
+		return nil, err
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	if square {
+		side := min(bounds.Dx(), bounds.Dy())
+		x := bounds.Min.X + (bounds.Dx()-side)/2
+		y := bounds.Min.Y + (bounds.Dy()-side)/2
+		bounds = image.Rect(x, y, x+side, y+side)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), decoded, bounds.Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxVariationBytes {
+		return nil, fmt.Errorf("result image is %d bytes as a PNG: %w", buf.Len(), errImageTooLarge)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// withResultImage attaches a PNG of the given size to the bot's first reply.
+func withResultImage(t *testing.T, d *fakeDiscord, width, height int) *sentMessage {
+	t.Helper()
+	result := d.sentMessages()[0]
+	d.mu.Lock()
+	result.Attachments = []*discordgo.MessageAttachment{attached(t, "image/png", pngOfSize(t, width, height))}
+	d.mu.Unlock()
+	return result
+}
+
+// formImageSize is the size of the image sent with form.
+func formImageSize(t *testing.T, form fakeForm) image.Point {
+	t.Helper()
+	config, _, err := image.DecodeConfig(bytes.NewReader(form.Files["image"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image.Pt(config.Width, config.Height)
+}
+
+func TestKeepCompositionVaries(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-3"}, "composition")
+	onMessageHandler(d, d.post("composition", "user", "/dalle a red fox"))
+	result := withResultImage(t, d, 32, 16)
+
+	onEmojiAddHandler(d, d.react("composition", result.ID, "user", compositionEmoji))
+
+	forms := api.varied()
+	if len(forms) != 1 || forms[0].Path != "/images/variations" {
+		t.Fatalf("sent %+v, want one variation of the result", forms)
+	}
+	if got := forms[0].Fields["model"]; got != variationModel {
+		t.Errorf("varied with %s, want %s", got, variationModel)
+	}
+	if got := formImageSize(t, forms[0]); got != image.Pt(16, 16) {
+		t.Errorf("varied a %v image, want the result cropped square", got)
+	}
+	if got := len(d.sentMessages()); got != 2 {
+		t.Errorf("sent %d messages, want the redraw after the result", got)
+	}
+	if got := len(api.generations()); got != 1 {
+		t.Errorf("made %d generations, want the redraw to vary the result instead", got)
+	}
+}
+
+func TestKeepCompositionWithStrength(t *testing.T) {
+	d, api := setupBot(t, Config{SupportsStrength: true, CompositionStrength: 0.25}, "strength")
+	onMessageHandler(d, d.post("strength", "user", "/dalle a red fox"))
+	result := withResultImage(t, d, 32, 16)
+
+	onEmojiAddHandler(d, d.react("strength", result.ID, "user", compositionEmoji))
+
+	forms := api.varied()
+	if len(forms) != 1 || forms[0].Path != "/images/edits" {
+		t.Fatalf("sent %+v, want one edit of the result", forms)
+	}
+	want := map[string]string{"prompt": "a red fox", "model": "dall-e-2", "n": "1", "size": "512x512", "strength": "0.25"}
+	if !reflect.DeepEqual(forms[0].Fields, want) {
+		t.Errorf("fields are %v, want %v", forms[0].Fields, want)
+	}
+	if _, ok := forms[0].Files["mask"]; ok {
+		t.Error("sent a mask, want the whole result redrawn")
+	}
+	if got := formImageSize(t, forms[0]); got != image.Pt(32, 16) {
+		t.Errorf("redrew a %v image, want the result as it was", got)
+	}
+}
+
+func TestKeepCompositionWithoutImage(t *testing.T) {
+	d, api := setupBot(t, Config{}, "noimage")
+	onMessageHandler(d, d.post("noimage", "user", "/dalle a red fox"))
+	result := d.sentMessages()[0]
+
+	onEmojiAddHandler(d, d.react("noimage", result.ID, "user", compositionEmoji))
+
+	if forms := api.varied(); len(forms) != 0 {
+		t.Errorf("sent %+v, want nothing without an image to redraw", forms)
+	}
+	if got := d.reactionsOn(result.ID); !reflect.DeepEqual(got, []string{"🔁", "⚠️"}) {
+		t.Errorf("result shows %q, want a warning added", got)
+	}
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	DiscordToken string `json:"discordToken"`
+	OpenAIKey    string `json:"openAIKey"`
+	// OpenAIKeys are more keys taken in turn with OpenAIKey, skipping any
+	// that run out of quota for KeyCooldownSeconds (default 600).
+	OpenAIKeys         []string `json:"openAIKeys"`
+	KeyCooldownSeconds int      `json:"keyCooldownSeconds"`
+	SpecialUser        string   `json:"specialUser"`
+	SpecialReply       string   `json:"specialReply"`
+	// SpecialReplies maps user IDs to a reply sent before each of their
+	// images. SpecialUser and SpecialReply still work as a single entry.
+	SpecialReplies map[string]string `json:"specialReplies"`
+
+	// GuildKeys maps a guild ID to the OpenAI key used for that guild's
+	// requests, so each server pays for its own images.
+	GuildKeys map[string]string `json:"guildKeys"`
+	// Admins are user IDs allowed to run admin commands such as setkey.
+	Admins []string `json:"admins"`
+	// ShowSettingsFooter appends the settings a request was generated with
+	// to its reply.
+	ShowSettingsFooter bool `json:"showSettingsFooter"`
+	// HonorSlowMode waits out a channel's slow-mode interval and retries
+	// when a reply is rejected by slow-mode.
+	HonorSlowMode bool `json:"honorSlowMode"`
+	// ResultWebhookURL receives a JSON POST for every completed generation,
+	// signed with ResultWebhookSecret when it's set.
+	ResultWebhookURL    string `json:"resultWebhookURL"`
+	ResultWebhookSecret string `json:"resultWebhookSecret"`
+	// DownvoteThreshold is the number of distinct users reacting with
+	// DownvoteEmoji (default 👎) that gets an image deleted. 0 disables it.
+	DownvoteThreshold        int    `json:"downvoteThreshold"`
+	DownvoteEmoji            string `json:"downvoteEmoji"`
+	IgnoreRequesterDownvotes bool   `json:"ignoreRequesterDownvotes"`
+	// AutoPinThreshold is the number of reactions that gets a bot image in
+	// AutoPinChannelID pinned. 0 disables auto-pinning.
+	AutoPinThreshold int    `json:"autoPinThreshold"`
+	AutoPinChannelID string `json:"autoPinChannelID"`
+	// ActiveHours limits generation in a guild, keyed by guild ID, to a
+	// daily window. Guilds without an entry can generate at any time.
+	ActiveHours map[string]ActiveHours `json:"activeHours"`
+	// ShowCostPerImage appends the estimated cost to each reply, converted
+	// from USD with CostExchangeRate and shown with CostCurrency.
+	ShowCostPerImage bool    `json:"showCostPerImage"`
+	CostCurrency     string  `json:"costCurrency"`
+	CostExchangeRate float64 `json:"costExchangeRate"`
+	// RetryOnEmptyResult retries once when OpenAI returns no image or a
+	// blank placeholder.
+	RetryOnEmptyResult bool `json:"retryOnEmptyResult"`
+	// ReactionDebounceMillis waits this long for a reaction to settle
+	// before acting on it, so quickly toggling 🔁 only counts once.
+	ReactionDebounceMillis int `json:"reactionDebounceMillis"`
+	// MaxInFlightPerUser caps how many generations one user can have
+	// running at once. 0 means no limit.
+	MaxInFlightPerUser int `json:"maxInFlightPerUser"`
+	// MaintenanceFile is where maintenance mode is saved between runs,
+	// defaulting to maintenance.json.
+	MaintenanceFile string `json:"maintenanceFile"`
+	// EnablePromptCopy DMs the prompt behind an image to anyone who reacts
+	// to it with 📋.
+	EnablePromptCopy bool `json:"enablePromptCopy"`
+	// EnableFavorites DMs a copy of an image to anyone who reacts to it
+	// with ⭐.
+	EnableFavorites bool `json:"enableFavorites"`
+	// AuthScheme is how the key is sent: "bearer" (default), "none" for
+	// servers without auth, or "header" to send it in the AuthHeader header.
+	AuthScheme string `json:"authScheme"`
+	AuthHeader string `json:"authHeader"`
+	// BaseURL points the bot at an OpenAI-compatible API instead of
+	// OpenAI's, such as a self-hosted server or an Azure deployment.
+	BaseURL string `json:"baseURL"`
+	// ClearStatusOnComplete removes the 🤖 status once an image is sent
+	// instead of replacing it with ✅. Failures still get ❌.
+	ClearStatusOnComplete bool `json:"clearStatusOnComplete"`
+	// CostDowngradeThreshold is the daily USD spend after which a guild's
+	// requests drop to the cheapest size instead of being refused.
+	CostDowngradeThreshold float64 `json:"costDowngradeThreshold"`
+	// HTTPRecordDir records every HTTP exchange with OpenAI to this
+	// directory; HTTPReplayDir answers requests from such recordings
+	// instead of the network. Both are meant for debugging and tests.
+	HTTPRecordDir string `json:"httpRecordDir"`
+	HTTPReplayDir string `json:"httpReplayDir"`
+	// RecordDir records a whole session to this directory for debugging:
+	// every Discord event the bot handles along with every HTTP exchange,
+	// with OpenAI and to download images. Run the bot with -replay on the
+	// directory to go through it again offline.
+	RecordDir string `json:"recordDir"`
+	// RequestTimeoutSeconds bounds each call to OpenAI, defaulting to 60.
+	RequestTimeoutSeconds int `json:"requestTimeoutSeconds"`
+	// Model is the OpenAI image model, "dall-e-2" (default) or "dall-e-3".
+	Model string `json:"model"`
+	// MaxRequestsPerMinute is how many images each user can ask for per
+	// minute, with bursts up to the same number. 0 means no limit.
+	MaxRequestsPerMinute int `json:"maxRequestsPerMinute"`
+	// QuietRateLimit only shows ⏳ on requests over the limit after the
+	// first reply saying how long to wait, instead of updating that reply.
+	QuietRateLimit bool `json:"quietRateLimit"`
+	// CommandPrefix starts every bot command, defaulting to "/dalle".
+	CommandPrefix string `json:"commandPrefix"`
+	// ShutdownGraceSeconds is how long to wait for running requests on
+	// exit, defaulting to 30.
+	ShutdownGraceSeconds int `json:"shutdownGraceSeconds"`
+	// MaxProcessingSeconds is how long a request may run before its 🤖 is
+	// swapped for the error status, defaulting to 600.
+	MaxProcessingSeconds int `json:"maxProcessingSeconds"`
+	// ModerationEnabled runs every prompt through OpenAI's moderation
+	// endpoint first and refuses the flagged ones.
+	ModerationEnabled bool `json:"moderationEnabled"`
+	// BlurSensitiveResults scores each result with OpenAI's moderation
+	// endpoint too and sends the ones scoring SensitiveThreshold (default
+	// 0.5) or over in any category blurred, as spoilers with a warning.
+	BlurSensitiveResults bool    `json:"blurSensitiveResults"`
+	SensitiveThreshold   float64 `json:"sensitiveThreshold"`
+	// MaxImages is how many images a single request can ask for with a
+	// leading count, defaulting to 1.
+	MaxImages int `json:"maxImages"`
+	// SeparateMessagesPerImage sends each image of a request for several
+	// as a reply of its own, which 🔁 re-rolls on its own.
+	SeparateMessagesPerImage bool `json:"separateMessagesPerImage"`
+	// AllowedGuilds and AllowedChannels limit where the bot responds.
+	// Empty lists allow everywhere.
+	AllowedGuilds   []string `json:"allowedGuilds"`
+	AllowedChannels []string `json:"allowedChannels"`
+	// AllowedRoles, when set, limits the bot to members holding one of these
+	// role IDs, and to admins.
+	AllowedRoles []string `json:"allowedRoles"`
+	// MaxRetries is how many more times a generation is tried after a 429,
+	// 5xx or timeout, backing off exponentially. 0 disables retries.
+	MaxRetries int `json:"maxRetries"`
+	// HistoryDB is the SQLite database every request is recorded in. Empty
+	// disables the history.
+	HistoryDB string `json:"historyDB"`
+	// MaxConcurrent is how many generations run at once across all users,
+	// the rest queue behind them. 0 means no limit.
+	MaxConcurrent int `json:"maxConcurrent"`
+	// QueuePolicy is how the queued requests get their turn: "fifo"
+	// (default) in the order they arrived, or "fair", with the servers that
+	// have requests queued taking turns. GuildQueueWeights gives a server
+	// more than one generation in a row on its turn.
+	QueuePolicy       string         `json:"queuePolicy"`
+	GuildQueueWeights map[string]int `json:"guildQueueWeights"`
+	// ShowQueuePosition tells a queued request where it is in line, and
+	// keeps it up to date as the line moves.
+	ShowQueuePosition bool `json:"showQueuePosition"`
+	// MediaConcurrency is how many requests download and upload their
+	// images at once, apart from the generation slots so the two don't hold
+	// each other up. 0 means no limit.
+	MediaConcurrency int `json:"mediaConcurrency"`
+	// LogLevel is the lowest level logged, "debug", "info" (default), "warn"
+	// or "error". LogFormat is "text" (default) or "json".
+	LogLevel  string `json:"logLevel"`
+	LogFormat string `json:"logFormat"`
+	// HealthPort serves /healthz and /readyz for container health checks,
+	// and Prometheus metrics on /metrics. 0 disables the server.
+	HealthPort int `json:"healthPort"`
+	// PushgatewayURL, when set, pushes the same metrics to a Prometheus
+	// Pushgateway every PushIntervalSeconds (default 15) and once more on
+	// shutdown, for deployments that can't be scraped.
+	PushgatewayURL      string `json:"pushgatewayURL"`
+	PushIntervalSeconds int    `json:"pushIntervalSeconds"`
+	// DailyQuota is how many images each user can ask for per UTC day. 0
+	// means no quota.
+	DailyQuota int `json:"dailyQuota"`
+	// EnableSlashCommands registers the /dalle application command.
+	// DisableTextCommands stops answering text messages, for servers
+	// that only want the application command.
+	EnableSlashCommands bool `json:"enableSlashCommands"`
+	DisableTextCommands bool `json:"disableTextCommands"`
+	// DisableAltText stops describing uploaded images with their prompt.
+	DisableAltText bool `json:"disableAltText"`
+	// CacheTTLSeconds reuses the images of an identical request made this
+	// recently instead of paying for new ones. 0 disables the cache, which
+	// holds up to CacheSize requests (default 100).
+	CacheTTLSeconds int `json:"cacheTTLSeconds"`
+	CacheSize       int `json:"cacheSize"`
+	// ChannelCooldownSeconds is how long a channel waits after a request
+	// before the next one is taken. 0 means no cooldown.
+	ChannelCooldownSeconds int `json:"channelCooldownSeconds"`
+	// StatusEmojis replaces the 🤖 ✅ ❌ 🔁 status reactions, all four at
+	// once.
+	StatusEmojis *StatusEmojis `json:"statusEmojis"`
+	// AllowDMs answers requests sent to the bot in direct messages.
+	AllowDMs bool `json:"allowDMs"`
+	// BlockedWords refuses prompts containing any of these words, matched
+	// case-insensitively as whole words, or anywhere in the prompt with
+	// BlockedWordsSubstring.
+	BlockedWords          []string `json:"blockedWords"`
+	BlockedWordsSubstring bool     `json:"blockedWordsSubstring"`
+	// MaxReplyDepth is how many replies up from an image the bot looks for
+	// the request behind it, defaulting to 10.
+	MaxReplyDepth int `json:"maxReplyDepth"`
+	// DefaultQuality ("standard" or "hd") and DefaultStyle ("vivid" or
+	// "natural") are used for dall-e-3 when a prompt doesn't pick one.
+	// Empty leaves it to OpenAI.
+	DefaultQuality string `json:"defaultQuality"`
+	DefaultStyle   string `json:"defaultStyle"`
+	// OpenAIRequestsPerMinute caps the calls made to the images API across
+	// all users, to stay under the account's rate limit. 0 means no cap.
+	OpenAIRequestsPerMinute int `json:"openAIRequestsPerMinute"`
+	// SupportsSeed sends the seed a prompt sets with seed:12345, for
+	// backends that take one. Without it the seed is dropped.
+	SupportsSeed bool `json:"supportsSeed"`
+	// SuggestRecentOnEmpty answers the prefix on its own with buttons to
+	// make one of the requester's last three prompts again, when there's a
+	// historyDB. People without any still get the help.
+	SuggestRecentOnEmpty bool `json:"suggestRecentOnEmpty"`
+	// Providers are other OpenAI-compatible image APIs, by name, for
+	// ProviderFallbackOrder to fall back on.
+	Providers map[string]ProviderConfig `json:"providers"`
+	// ProviderFallbackOrder names the providers to try in turn until one
+	// makes the images, "openai" being the API set by baseURL, e.g.
+	// ["openai", "backup"]. Empty uses only that one.
+	ProviderFallbackOrder []string `json:"providerFallbackOrder"`
+	// SupportsStrength redraws a result reacted to with 🎯 as an edit at
+	// CompositionStrength, for backends that redraw a whole image from one.
+	// Without it the result is varied instead.
+	SupportsStrength bool `json:"supportsStrength"`
+	// CompositionStrength is how far a 🎯 redraw strays from the result,
+	// from 0 to 1, defaulting to 0.4.
+	CompositionStrength float64 `json:"compositionStrength"`
+	// ShowRevisedPrompt puts the prompt dall-e-3 rewrote the request into
+	// above its image.
+	ShowRevisedPrompt bool `json:"showRevisedPrompt"`
+	// FallbackModels are tried in order when the model rejects a prompt or
+	// keeps failing, e.g. ["dall-e-2"] behind dall-e-3.
+	FallbackModels []string `json:"fallbackModels"`
+	// CreateThreadPerPrompt starts a thread on each prompt and posts its
+	// results and re-rolls there.
+	CreateThreadPerPrompt bool `json:"createThreadPerPrompt"`
+	// ErrorsToDM sends the reason a server request failed to the requester's
+	// DMs instead of replying in the channel.
+	ErrorsToDM bool `json:"errorsToDM"`
+	// AutoDeleteFailedAfterSeconds, when set, deletes a command that failed
+	// with ❌ and the error reply to it after that many seconds.
+	AutoDeleteFailedAfterSeconds int `json:"autoDeleteFailedAfterSeconds"`
+	// AutoSpoilerModerated generates prompts moderation flags behind a
+	// spoiler instead of refusing them.
+	AutoSpoilerModerated bool `json:"autoSpoilerModerated"`
+	// SpendFile keeps the running spend estimate across restarts. Without it
+	// the totals start over each run.
+	SpendFile string `json:"spendFile"`
+	// WebhookID and WebhookToken post results through a webhook in its
+	// channel, under WebhookUsername and WebhookAvatarURL when they're set,
+	// instead of as the bot's replies. Those results can't be re-rolled.
+	WebhookID        string `json:"webhookID"`
+	WebhookToken     string `json:"webhookToken"`
+	WebhookUsername  string `json:"webhookUsername"`
+	WebhookAvatarURL string `json:"webhookAvatarURL"`
+	// PromptPrefix and PromptSuffix are added around every prompt, e.g. "in
+	// the style of a watercolor painting, " to give a server one look.
+	PromptPrefix string `json:"promptPrefix"`
+	PromptSuffix string `json:"promptSuffix"`
+	// GuildPromptSuffixes maps a guild ID to more to add to the end of its
+	// prompts after PromptSuffix, e.g. ", safe for work" to guard one
+	// server's images.
+	GuildPromptSuffixes map[string]string `json:"guildPromptSuffixes"`
+	// AutoModelSelect picks dall-e-3 for long or detailed prompts and the
+	// cheaper dall-e-2 for simple ones, instead of the guild's model. A
+	// model:<name> token in a prompt picks its model either way.
+	AutoModelSelect bool `json:"autoModelSelect"`
+	// SuggestedPrompts replace the built-in prompts the /dalle command
+	// suggests as it's typed, after the user's own recent ones.
+	SuggestedPrompts []string `json:"suggestedPrompts"`
+	// OutputFormat is "png" (the default) to upload images as OpenAI made
+	// them, "jpeg" to convert them at OutputQuality (1-100, 0 for the
+	// default of 85) or "webp" to convert them losslessly, so they take up
+	// less of Discord's upload limit.
+	OutputFormat  string `json:"outputFormat"`
+	OutputQuality int    `json:"outputQuality"`
+	// ThumbnailSize, when set, also uploads each image scaled down to fit
+	// in that many pixels and shows it inline as an embed, for anyone on a
+	// slow connection. The full image is still attached.
+	ThumbnailSize int `json:"thumbnailSize"`
+	// PostProcess lists, in order, the stages each image goes through
+	// before it's uploaded: "transcode" to OutputFormat, "retro" to
+	// posterize it and "watermark" to write WatermarkText in a corner. It
+	// defaults to only transcoding, and an empty list turns them all off.
+	// GuildPostProcess replaces it for the guild IDs it maps.
+	PostProcess      []string            `json:"postProcess"`
+	GuildPostProcess map[string][]string `json:"guildPostProcess"`
+	WatermarkText    string              `json:"watermarkText"`
+	// ResponseFormat is "url" (the default) to download each image from the
+	// link OpenAI returns, or "b64_json" to get the image in the response.
+	ResponseFormat string `json:"responseFormat"`
+	// MaxDownloadBytes (default 20MB) and DownloadTimeoutSeconds (default
+	// 60) bound downloading each generated image. Anything bigger, slower,
+	// or not an image fails the request.
+	MaxDownloadBytes       int `json:"maxDownloadBytes"`
+	DownloadTimeoutSeconds int `json:"downloadTimeoutSeconds"`
+	// MaxAttachmentBytes lowers the 4MB limit on images attached for
+	// variations and edits, which must download within
+	// AttachmentTimeoutSeconds (default 15).
+	MaxAttachmentBytes       int `json:"maxAttachmentBytes"`
+	AttachmentTimeoutSeconds int `json:"attachmentTimeoutSeconds"`
+	// Locale is the language replies are written in, "en" (the default) or
+	// "fr". Slash commands answer in the requester's Discord language when
+	// there's a catalog for it.
+	Locale string `json:"locale"`
+	// AutoLocaleFromPrompt answers each prompt in the language it's written
+	// in when that's clear, over Locale and the requester's Discord language.
+	AutoLocaleFromPrompt bool `json:"autoLocaleFromPrompt"`
+	// AllowBatch runs each line of a message that starts with the prefix as
+	// its own request, up to MaxPromptsPerMessage (default 4). Without it
+	// such messages are refused.
+	AllowBatch           bool `json:"allowBatch"`
+	MaxPromptsPerMessage int  `json:"maxPromptsPerMessage"`
+}
+
+// UnmarshalJSON decodes a config, also accepting the misspelled
+// "speicalReply" key older configs use for specialReply.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	// plain has Config's fields without this method, avoiding recursion
+	type plain Config
+	aux := struct {
+		*plain
+		LegacySpecialReply *string `json:"speicalReply"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.LegacySpecialReply != nil {
+		slog.Warn("Config key speicalReply is deprecated, rename it to specialReply")
+		if c.SpecialReply == "" {
+			c.SpecialReply = *aux.LegacySpecialReply
+		}
+	}
+	return nil
+}
+
+// configFiles are the config locations tried in order; the first that exists
+// is loaded.
+var configFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// overlayEnv names the config files, separated like PATH, merged over the
+// base config in order, so secrets can live apart from the defaults.
+const overlayEnv = "DISCE_CONFIG"
+
+// configPath is the config file given with -config, searched for in
+// configFiles when empty.
+var configPath string
+
+// loadConfig reads configPath or else the first config file found, merges
+// any overlays named by DISCE_CONFIG over it, then applies any of the
+// environment variables in envVars and envInts on top. Running from the environment
+// alone works too, as long as the required values are set.
+func loadConfig(config *Config) error {
+	path := configPath
+	if path == "" {
+		for _, name := range configFiles {
+			if _, err := os.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+				path = name
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := readConfigFile(path, config); err != nil {
+			return err
+		}
+	}
+	if err := applyOverlays(config, os.Getenv(overlayEnv)); err != nil {
+		return err
+	}
+
+	if err := applyEnv(config); err != nil {
+		return err
+	}
+
+	if missing := missingConfig(config); len(missing) > 0 {
+		if path == "" {
+			return fmt.Errorf("no config file found, looked for %s (see README for the format), and missing %s", strings.Join(configFiles, ", "), strings.Join(missing, ", "))
+		}
+		return fmt.Errorf("%s: missing %s", path, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// applyOverlays merges each file in the PATH-style list paths over config.
+// An overlay only sets the values it gives that aren't zero, and naming one
+// that doesn't exist is an error.
+func applyOverlays(config *Config, paths string) error {
+	for _, path := range filepath.SplitList(paths) {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s has overlay %s, which doesn't exist", overlayEnv, path)
+		}
+
+		var overlay Config
+		if err := readConfigFile(path, &overlay); err != nil {
+			return err
+		}
+		mergeConfig(config, &overlay)
+	}
+	return nil
+}
+
+// mergeConfig copies every field of src that isn't zero to dst. Maps and
+// lists replace what dst had rather than being combined with it.
+func mergeConfig(dst *Config, src *Config) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if field := s.Field(i); !field.IsZero() {
+			d.Field(i).Set(field)
+		}
+	}
+}
+
+func readConfigFile(path string, config *Config) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s is not readable: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, not a config file", path)
+	}
+
+	configFile, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("%s is not readable: permission denied", path)
+		}
+		return fmt.Errorf("%s is not readable: %w", path, err)
+	}
+	defer configFile.Close()
+
+	configBytes, err := ioutil.ReadAll(configFile)
+	if err != nil {
+		return fmt.Errorf("%s is not readable: %w", path, err)
+	}
+
+	return decodeConfig(path, configBytes, config)
+}
+
+// envVars maps the environment variables that can set a config value to the
+// value they set.
+func envVars(config *Config) map[string]*string {
+	return map[string]*string{
+		"DISCORD_TOKEN":         &config.DiscordToken,
+		"OPENAI_KEY":            &config.OpenAIKey,
+		"SPECIAL_USER":          &config.SpecialUser,
+		"SPECIAL_REPLY":         &config.SpecialReply,
+		"RESULT_WEBHOOK_URL":    &config.ResultWebhookURL,
+		"RESULT_WEBHOOK_SECRET": &config.ResultWebhookSecret,
+		"WEBHOOK_TOKEN":         &config.WebhookToken,
+		"AUTH_SCHEME":           &config.AuthScheme,
+		"AUTH_HEADER":           &config.AuthHeader,
+		"BASE_URL":              &config.BaseURL,
+		"MODEL":                 &config.Model,
+		"COMMAND_PREFIX":        &config.CommandPrefix,
+		"MAINTENANCE_FILE":      &config.MaintenanceFile,
+	}
+}
+
+// envInts maps the environment variables that can set a numeric config
+// value to the value they set.
+func envInts(config *Config) map[string]*int {
+	return map[string]*int{
+		"HEALTH_PORT":    &config.HealthPort,
+		"MAX_CONCURRENT": &config.MaxConcurrent,
+	}
+}
+
+// applyEnv overrides config with the environment variables that are set.
+// ADMINS is a comma separated list of user IDs. A numeric variable that
+// isn't a number is an error rather than being skipped.
+func applyEnv(config *Config) error {
+	for name, value := range envVars(config) {
+		if v, ok := os.LookupEnv(name); ok {
+			*value = v
+		}
+	}
+	for name, value := range envInts(config) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("%s is %q, want a whole number", name, v)
+			}
+			*value = n
+		}
+	}
+	if v, ok := os.LookupEnv("ADMINS"); ok {
+		config.Admins = nil
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				config.Admins = append(config.Admins, id)
+			}
+		}
+	}
+	return nil
+}
+
+// missingConfig lists the required values that are still empty, by config
+// key and environment variable.
+func missingConfig(config *Config) []string {
+	var missing []string
+	if config.DiscordToken == "" {
+		missing = append(missing, "discordToken (DISCORD_TOKEN)")
+	}
+	return missing
+}
+
+// limits are the numeric settings that can't be negative, by config key.
+func (c *Config) limits() map[string]float64 {
+	return map[string]float64{
+		"downvoteThreshold":            float64(c.DownvoteThreshold),
+		"autoPinThreshold":             float64(c.AutoPinThreshold),
+		"costExchangeRate":             c.CostExchangeRate,
+		"reactionDebounceMillis":       float64(c.ReactionDebounceMillis),
+		"maxInFlightPerUser":           float64(c.MaxInFlightPerUser),
+		"costDowngradeThreshold":       c.CostDowngradeThreshold,
+		"requestTimeoutSeconds":        float64(c.RequestTimeoutSeconds),
+		"maxRequestsPerMinute":         float64(c.MaxRequestsPerMinute),
+		"shutdownGraceSeconds":         float64(c.ShutdownGraceSeconds),
+		"maxProcessingSeconds":         float64(c.MaxProcessingSeconds),
+		"keyCooldownSeconds":           float64(c.KeyCooldownSeconds),
+		"maxAttachmentBytes":           float64(c.MaxAttachmentBytes),
+		"maxDownloadBytes":             float64(c.MaxDownloadBytes),
+		"downloadTimeoutSeconds":       float64(c.DownloadTimeoutSeconds),
+		"attachmentTimeoutSeconds":     float64(c.AttachmentTimeoutSeconds),
+		"autoDeleteFailedAfterSeconds": float64(c.AutoDeleteFailedAfterSeconds),
+		"maxImages":                    float64(c.MaxImages),
+		"maxRetries":                   float64(c.MaxRetries),
+		"maxConcurrent":                float64(c.MaxConcurrent),
+		"mediaConcurrency":             float64(c.MediaConcurrency),
+		"healthPort":                   float64(c.HealthPort),
+		"pushIntervalSeconds":          float64(c.PushIntervalSeconds),
+		"dailyQuota":                   float64(c.DailyQuota),
+		"cacheTTLSeconds":              float64(c.CacheTTLSeconds),
+		"cacheSize":                    float64(c.CacheSize),
+		"channelCooldownSeconds":       float64(c.ChannelCooldownSeconds),
+		"maxReplyDepth":                float64(c.MaxReplyDepth),
+		"maxPromptsPerMessage":         float64(c.MaxPromptsPerMessage),
+		"openAIRequestsPerMinute":      float64(c.OpenAIRequestsPerMinute),
+	}
+}
+
+// Validate checks c for everything that would otherwise fail later at
+// runtime, returning one error that lists every problem found.
+func (c *Config) Validate() error {
+	var problems []error
+	for _, missing := range missingConfig(c) {
+		problems = append(problems, fmt.Errorf("missing %s", missing))
+	}
+	for _, validate := range []func(*Config) error{validateAuthScheme, validateModel, validateBaseURL, validateStatusEmojis, validateOutputFormat, validatePostProcess, validateCompositionStrength, validateSensitiveThreshold, validateProviders, validatePushgateway, validateQueuePolicy, validateResponseFormat, validateLocale} {
+		if err := validate(c); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	limits := c.limits()
+	keys := make([]string, 0, len(limits))
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if limits[key] < 0 {
+			problems = append(problems, fmt.Errorf("%s can't be negative", key))
+		}
+	}
+
+	if (c.SpecialUser == "") != (c.SpecialReply == "") {
+		problems = append(problems, errors.New("specialUser and specialReply must be set together"))
+	}
+	for userID, reply := range c.SpecialReplies {
+		if userID == "" || reply == "" {
+			problems = append(problems, errors.New("specialReplies can't have an empty user ID or reply"))
+			break
+		}
+	}
+	if c.HealthPort > 65535 {
+		problems = append(problems, fmt.Errorf("healthPort %d is not a valid port", c.HealthPort))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(problems...))
+	}
+	return nil
+}
+
+// decodeConfig parses a config file in the format given by its extension.
+// YAML and TOML are converted to JSON first so the json tags on Config are
+// the only field names to maintain.
+func decodeConfig(path string, data []byte, config *Config) error {
+	var fields map[string]interface{}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		return json.Unmarshal(data, config)
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("%s: unrecognized config format %q, use .json, .yaml, .yml or .toml", path, ext)
+	}
+
+	jsonBytes, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return json.Unmarshal(jsonBytes, config)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpecialReplies(t *testing.T) {
+	useConfig(t, Config{
+		SpecialUser:    "zack",
+		SpecialReply:   "Oh, it's you.",
+		SpecialReplies: map[string]string{"amy": "Hi Amy!", "zack": "Welcome back."},
+	})
+
+	for userID, want := range map[string]string{"amy": "Hi Amy!", "zack": "Welcome back."} {
+		if reply, ok := specialReply(userID); !ok || reply != want {
+			t.Errorf("specialReply(%q) = %q, %v, want %q", userID, reply, ok, want)
+		}
+	}
+	if reply, ok := specialReply("someone"); ok {
+		t.Errorf("specialReply for an unknown user = %q, want none", reply)
+	}
+
+	useConfig(t, Config{SpecialUser: "zack", SpecialReply: "Oh, it's you."})
+	if reply, ok := specialReply("zack"); !ok || reply != "Oh, it's you." {
+		t.Errorf("specialReply from the single pair = %q, %v", reply, ok)
+	}
+}
+
+func TestLegacySpecialReplyKey(t *testing.T) {
+	var c Config
+	if err := json.Unmarshal([]byte(`{"specialUser": "zack", "speicalReply": "Oh, it's you."}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.SpecialReply != "Oh, it's you." {
+		t.Errorf("SpecialReply is %q, want it read from speicalReply", c.SpecialReply)
+	}
+
+	c = Config{}
+	if err := json.Unmarshal([]byte(`{"specialReply": "new", "speicalReply": "old"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.SpecialReply != "new" {
+		t.Errorf("SpecialReply is %q, want specialReply to win", c.SpecialReply)
+	}
+}
+
+func TestValidateDownloadLimits(t *testing.T) {
+	config := Config{DiscordToken: "token", MaxDownloadBytes: -1, DownloadTimeoutSeconds: -5}
+
+	err := config.Validate()
+
+	for _, key := range []string{"maxDownloadBytes", "downloadTimeoutSeconds"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Validate() = %v, want it to reject a negative %s", err, key)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		want   []string
+	}{
+		{Config{DiscordToken: "token"}, nil},
+		{Config{DiscordToken: "token", Model: "dall-e-3", SpecialUser: "friend", SpecialReply: "hi"}, nil},
+		{Config{}, []string{"discordToken"}},
+		{Config{DiscordToken: "token", Model: "dall-e-9"}, []string{"dall-e-9"}},
+		{Config{DiscordToken: "token", DailyQuota: -1, CacheSize: -2}, []string{"dailyQuota", "cacheSize"}},
+		{Config{DiscordToken: "token", SpecialUser: "friend"}, []string{"specialReply"}},
+		{Config{DiscordToken: "token", SpecialReplies: map[string]string{"friend": ""}}, []string{"specialReplies"}},
+		{Config{MaxReplyDepth: -1, HealthPort: 70000}, []string{"discordToken", "maxReplyDepth", "healthPort"}},
+		{Config{DiscordToken: "token", CompositionStrength: 1.5}, []string{"compositionStrength"}},
+		{Config{DiscordToken: "token", SensitiveThreshold: -0.1}, []string{"sensitiveThreshold"}},
+		{Config{DiscordToken: "token", PushgatewayURL: "pushgateway:9091", PushIntervalSeconds: -1}, []string{"pushgatewayURL", "pushIntervalSeconds"}},
+		{Config{DiscordToken: "token", QueuePolicy: "lottery"}, []string{"queuePolicy"}},
+	} {
+		err := c.config.Validate()
+		if len(c.want) == 0 {
+			if err != nil {
+				t.Errorf("Validate(%+v) = %v, want it valid", c.config, err)
+			}
+			continue
+		}
+		for _, want := range c.want {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Validate(%+v) = %v, want it to mention %s", c.config, err, want)
+			}
+		}
+	}
+}
+
+// clearEnv unsets every variable loadConfig reads for the rest of the test,
+// so whatever the machine running it has set doesn't leak in.
+func clearEnv(t *testing.T) {
+	var c Config
+	names := []string{"ADMINS", overlayEnv}
+	for name := range envVars(&c) {
+		names = append(names, name)
+	}
+	for name := range envInts(&c) {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// useConfigFile points loadConfig at a config file holding contents, or at
+// none when contents is empty.
+func useConfigFile(t *testing.T, contents string) {
+	oldPath := configPath
+	t.Cleanup(func() { configPath = oldPath })
+	configPath = ""
+	if contents == "" {
+		// nothing to find in an empty working directory
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Chdir(wd) })
+		return
+	}
+	configPath = filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		value string
+		got   func(*Config) interface{}
+		want  interface{}
+	}{
+		{"DISCORD_TOKEN", "env-token", func(c *Config) interface{} { return c.DiscordToken }, "env-token"},
+		{"OPENAI_KEY", "sk-env", func(c *Config) interface{} { return c.OpenAIKey }, "sk-env"},
+		{"SPECIAL_USER", "zack", func(c *Config) interface{} { return c.SpecialUser }, "zack"},
+		{"SPECIAL_REPLY", "Oh, it's you.", func(c *Config) interface{} { return c.SpecialReply }, "Oh, it's you."},
+		{"RESULT_WEBHOOK_URL", "https://hooks.example.com", func(c *Config) interface{} { return c.ResultWebhookURL }, "https://hooks.example.com"},
+		{"RESULT_WEBHOOK_SECRET", "hush", func(c *Config) interface{} { return c.ResultWebhookSecret }, "hush"},
+		{"WEBHOOK_TOKEN", "let-me-in", func(c *Config) interface{} { return c.WebhookToken }, "let-me-in"},
+		{"AUTH_SCHEME", "api-key", func(c *Config) interface{} { return c.AuthScheme }, "api-key"},
+		{"AUTH_HEADER", "X-Key", func(c *Config) interface{} { return c.AuthHeader }, "X-Key"},
+		{"BASE_URL", "https://proxy.example.com/v1", func(c *Config) interface{} { return c.BaseURL }, "https://proxy.example.com/v1"},
+		{"MODEL", "dall-e-2", func(c *Config) interface{} { return c.Model }, "dall-e-2"},
+		{"COMMAND_PREFIX", "!img", func(c *Config) interface{} { return c.CommandPrefix }, "!img"},
+		{"MAINTENANCE_FILE", "/tmp/down", func(c *Config) interface{} { return c.MaintenanceFile }, "/tmp/down"},
+		{"HEALTH_PORT", " 9090", func(c *Config) interface{} { return c.HealthPort }, 9090},
+		{"MAX_CONCURRENT", "4", func(c *Config) interface{} { return c.MaxConcurrent }, 4},
+		{"ADMINS", "amy, zack,", func(c *Config) interface{} { return c.Admins }, []string{"amy", "zack"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clearEnv(t)
+			useConfigFile(t, `{"discordToken": "file-token"}`)
+			t.Setenv(c.name, c.value)
+
+			var config Config
+			if err := loadConfig(&config); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.got(&config); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s=%q set %#v, want %#v", c.name, c.value, got, c.want)
+			}
+		})
+	}
+}
+
+func TestEnvBadNumber(t *testing.T) {
+	clearEnv(t)
+	useConfigFile(t, `{"discordToken": "file-token", "healthPort": 8080}`)
+	t.Setenv("HEALTH_PORT", "eighty")
+
+	var config Config
+	err := loadConfig(&config)
+	if err == nil || !strings.Contains(err.Error(), `HEALTH_PORT is "eighty"`) {
+		t.Errorf("loadConfig returned %v, want it to reject the port", err)
+	}
+}
+
+func TestEnvOverFile(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		file  string
+		env   map[string]string
+		want  Config
+		fails bool
+	}{
+		{
+			name: "file only",
+			file: `{"discordToken": "file-token", "model": "dall-e-3", "healthPort": 8080}`,
+			want: Config{DiscordToken: "file-token", Model: "dall-e-3", HealthPort: 8080},
+		},
+		{
+			name: "env only",
+			env:  map[string]string{"DISCORD_TOKEN": "env-token", "MODEL": "dall-e-2", "HEALTH_PORT": "9090"},
+			want: Config{DiscordToken: "env-token", Model: "dall-e-2", HealthPort: 9090},
+		},
+		{
+			name: "env wins",
+			file: `{"discordToken": "file-token", "model": "dall-e-3", "healthPort": 8080, "openAIKey": "sk-file"}`,
+			env:  map[string]string{"MODEL": "dall-e-2", "HEALTH_PORT": "9090"},
+			want: Config{DiscordToken: "file-token", Model: "dall-e-2", HealthPort: 9090, OpenAIKey: "sk-file"},
+		},
+		{
+			name:  "neither",
+			env:   map[string]string{"MODEL": "dall-e-2"},
+			fails: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clearEnv(t)
+			useConfigFile(t, c.file)
+			for name, value := range c.env {
+				t.Setenv(name, value)
+			}
+
+			var config Config
+			err := loadConfig(&config)
+			if c.fails {
+				if err == nil || !strings.Contains(err.Error(), "discordToken (DISCORD_TOKEN)") {
+					t.Errorf("loadConfig returned %v, want it to say the token is missing", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, c.want) {
+				t.Errorf("loaded %+v, want %+v", config, c.want)
+			}
+		})
+	}
+}
+
+func TestConfigOverlays(t *testing.T) {
+	overlay := filepath.Join(t.TempDir(), "secrets.json")
+	if err := os.WriteFile(overlay, []byte(`{"discordToken": "secret-token", "openAIKey": "sk-secret", "cacheSize": 0}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	base := `{"discordToken": "base-token", "model": "dall-e-3", "cacheSize": 50}`
+	for _, c := range []struct {
+		name    string
+		overlay string
+		want    Config
+		fails   bool
+	}{
+		{
+			name: "base only",
+			want: Config{DiscordToken: "base-token", Model: "dall-e-3", CacheSize: 50},
+		},
+		{
+			name:    "merged",
+			overlay: overlay,
+			want:    Config{DiscordToken: "secret-token", OpenAIKey: "sk-secret", Model: "dall-e-3", CacheSize: 50},
+		},
+		{
+			name:    "missing overlay",
+			overlay: overlay + string(filepath.ListSeparator) + filepath.Join(t.TempDir(), "missing.json"),
+			fails:   true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clearEnv(t)
+			useConfigFile(t, base)
+			t.Setenv(overlayEnv, c.overlay)
+
+			var config Config
+			err := loadConfig(&config)
+			if c.fails {
+				if err == nil || !strings.Contains(err.Error(), "missing.json") {
+					t.Errorf("loadConfig returned %v, want it to name the missing overlay", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, c.want) {
+				t.Errorf("loaded %+v, want %+v", config, c.want)
+			}
+		})
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	clearEnv(t)
+	// a config in the working directory is passed over for the chosen one
+	useConfigFile(t, "")
+	if err := os.WriteFile("config.json", []byte(`{"discordToken": "cwd-token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	chosen := filepath.Join(t.TempDir(), "bot.yaml")
+	if err := os.WriteFile(chosen, []byte("discordToken: chosen-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath = chosen
+	var config Config
+	if err := loadConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.DiscordToken != "chosen-token" {
+		t.Errorf("loaded token %q, want the one from %s", config.DiscordToken, chosen)
+	}
+
+	// a missing file is an error even when the environment has the rest
+	t.Setenv("DISCORD_TOKEN", "env-token")
+	configPath = filepath.Join(t.TempDir(), "missing.json")
+	err := loadConfig(&Config{})
+	if err == nil || !strings.Contains(err.Error(), configPath+" is not readable") {
+		t.Errorf("loadConfig returned %v, want it to say %s is not readable", err, configPath)
+	}
+}
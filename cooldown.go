@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// cooldown remembers when each channel last started a generation.
+type cooldown struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+var channelCooldown = &cooldown{last: make(map[string]time.Time)}
+
+// allow reports whether channelID is out of its cooldown, starting a new one
+// when it is. A wait of 0 means no cooldown.
+func (c *cooldown) allow(channelID string, wait time.Duration) bool {
+	if wait <= 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := now()
+	if last, ok := c.last[channelID]; ok && t.Sub(last) < wait {
+		return false
+	}
+	c.last[channelID] = t
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCooldownExpires(t *testing.T) {
+	advance := fakeClock(t)
+	c := &cooldown{last: make(map[string]time.Time)}
+
+	if !c.allow("channel", time.Minute) {
+		t.Fatal("first request refused")
+	}
+	advance(59 * time.Second)
+	if c.allow("channel", time.Minute) {
+		t.Error("request allowed 59s into a minute's cooldown")
+	}
+	advance(time.Second)
+	if !c.allow("channel", time.Minute) {
+		t.Error("request refused once the cooldown was over")
+	}
+}
+
+func TestCooldownConcurrent(t *testing.T) {
+	fakeClock(t)
+	c := &cooldown{last: make(map[string]time.Time)}
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.allow("channel", time.Minute) {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := allowed.Load(); n != 1 {
+		t.Errorf("%d of 20 simultaneous requests allowed, want 1", n)
+	}
+}
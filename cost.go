@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// imagePrices is the USD price of one image, by model and size.
+var imagePrices = map[string]map[string]float64{
+	"dall-e-2": {
+		"256x256":   0.016,
+		"512x512":   0.018,
+		"1024x1024": 0.020,
+	},
+	"dall-e-3": {
+		"1024x1024": 0.040,
+		"1792x1024": 0.080,
+		"1024x1792": 0.080,
+	},
+}
+
+// hdImagePrices is the USD price of one hd quality image, by model and size.
+var hdImagePrices = map[string]map[string]float64{
+	"dall-e-3": {
+		"1024x1024": 0.080,
+		"1792x1024": 0.120,
+		"1024x1792": 0.120,
+	},
+}
+
+// estimateCost returns the estimated USD cost of a request, and false when the
+// model/size pair isn't in the pricing table.
+func estimateCost(model string, size string, quality string, n int) (float64, bool) {
+	prices := imagePrices
+	if quality == "hd" {
+		prices = hdImagePrices
+	}
+	price, ok := prices[model][size]
+	return price * float64(n), ok
+}
+
+// formatCost renders a USD amount in the configured currency, keeping a third
+// decimal for amounts that would otherwise round to zero cents.
+func formatCost(usd float64) string {
+	rate := cfg().CostExchangeRate
+	if rate == 0 {
+		rate = 1
+	}
+	symbol := cfg().CostCurrency
+	if symbol == "" {
+		symbol = "$"
+	}
+
+	amount := usd * rate
+	if math.Round(amount*100) == 0 {
+		return fmt.Sprintf("~%s%.3f", symbol, amount)
+	}
+	return fmt.Sprintf("~%s%.2f", symbol, amount)
+}
+
+// costNote describes the estimated cost of a request for its reply, or
+// returns an empty string when ShowCostPerImage is off.
+func costNote(imgReq *ImageRequest) string {
+	if !cfg().ShowCostPerImage {
+		return ""
+	}
+	cost, ok := estimateCost(imgReq.Model, imgReq.Size, imgReq.options().Quality, imgReq.count())
+	if !ok {
+		return ""
+	}
+	return "\n" + formatCost(cost)
+}
+
+// spendTracker keeps each guild's estimated spend for the current UTC day.
+type spendTracker struct {
+	mu      sync.Mutex
+	day     string
+	byGuild map[string]float64
+}
+
+var guildSpend = &spendTracker{byGuild: make(map[string]float64)}
+
+// rollover starts a new day's totals once the UTC date changes. The caller
+// must hold t.mu.
+func (t *spendTracker) rollover() {
+	day := now().UTC().Format("2006-01-02")
+	if day != t.day {
+		t.day = day
+		t.byGuild = make(map[string]float64)
+	}
+}
+
+func (t *spendTracker) add(guildID string, usd float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	t.byGuild[guildID] += usd
+}
+
+func (t *spendTracker) today(guildID string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.byGuild[guildID]
+}
+
+// recordSpend adds the estimated cost of a completed request to its guild's
+// total for the day and to the bot's running totals.
+func recordSpend(imgReq *ImageRequest) {
+	if cost, ok := estimateCost(imgReq.Model, imgReq.Size, imgReq.options().Quality, imgReq.count()); ok {
+		guildSpend.add(imgReq.GuildID, cost)
+		addSpend(cost, imgReq.count())
+	}
+}
+
+// cheapestSize returns the lowest priced size for a model.
+func cheapestSize(model string) string {
+	cheapest := ""
+	for size, price := range imagePrices[model] {
+		if cheapest == "" || price < imagePrices[model][cheapest] {
+			cheapest = size
+		}
+	}
+	return cheapest
+}
+
+// applyCostDowngrade switches a request to the cheapest size once its guild
+// has spent CostDowngradeThreshold (USD) today, returning a note for the
+// reply when it does.
+func applyCostDowngrade(imgReq *ImageRequest) string {
+	if cfg().CostDowngradeThreshold <= 0 || guildSpend.today(imgReq.GuildID) < cfg().CostDowngradeThreshold {
+		return ""
+	}
+
+	size := cheapestSize(imgReq.Model)
+	if size == "" || size == imgReq.Size {
+		return ""
+	}
+	imgReq.Size = size
+	return "\n*" + tr(imgReq.Locale, "reply.budget", size) + "*"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	for _, tt := range []struct {
+		model, size, quality string
+		n                    int
+		cost                 float64
+		ok                   bool
+	}{
+		{"dall-e-2", "256x256", "", 1, 0.016, true},
+		{"dall-e-2", "1024x1024", "", 4, 0.080, true},
+		{"dall-e-3", "1024x1024", "standard", 1, 0.040, true},
+		{"dall-e-3", "1792x1024", "", 1, 0.080, true},
+		{"dall-e-3", "1024x1024", "hd", 1, 0.080, true},
+		{"dall-e-3", "1024x1792", "hd", 1, 0.120, true},
+		{"dall-e-2", "1792x1024", "", 1, 0, false},
+		{"dall-e-2", "512x512", "hd", 1, 0, false},
+		{"dall-e-9", "1024x1024", "", 1, 0, false},
+	} {
+		cost, ok := estimateCost(tt.model, tt.size, tt.quality, tt.n)
+		if ok != tt.ok || math.Abs(cost-tt.cost) > 1e-9 {
+			t.Errorf("estimateCost(%q, %q, %q, %d) = %v, %v, want %v, %v", tt.model, tt.size, tt.quality, tt.n, cost, ok, tt.cost, tt.ok)
+		}
+	}
+}
+
+func TestFormatCost(t *testing.T) {
+	for _, tt := range []struct {
+		config Config
+		usd    float64
+		want   string
+	}{
+		{Config{}, 0.08, "~$0.08"},
+		{Config{}, 0.004, "~$0.004"},
+		{Config{CostCurrency: "€", CostExchangeRate: 0.5}, 0.08, "~€0.04"},
+	} {
+		useConfig(t, tt.config)
+		if got := formatCost(tt.usd); got != tt.want {
+			t.Errorf("formatCost(%v) with %+v = %q, want %q", tt.usd, tt.config, got, tt.want)
+		}
+	}
+}
+
+// useSpend starts the running and per guild totals from nothing for the
+// rest of the test.
+func useSpend(t *testing.T) {
+	spendMu.Lock()
+	old := spend
+	spend = spendTotals{}
+	spendMu.Unlock()
+	oldGuilds := guildSpend
+	guildSpend = &spendTracker{byGuild: make(map[string]float64)}
+	t.Cleanup(func() {
+		spendMu.Lock()
+		spend = old
+		spendMu.Unlock()
+		guildSpend = oldGuilds
+	})
+}
+
+func TestSpendTotals(t *testing.T) {
+	advance := fakeClock(t)
+	useSpend(t)
+	useConfig(t, Config{Admins: []string{"admin"}, SpendFile: filepath.Join(t.TempDir(), "spend.json")})
+
+	recordSpend(&ImageRequest{GuildID: testGuildID, Model: "dall-e-2", Size: "1024x1024", N: 2})
+	recordSpend(&ImageRequest{GuildID: "other", Model: "dall-e-3", Size: "1024x1024", Quality: "hd"})
+	recordSpend(&ImageRequest{GuildID: testGuildID, Model: "dall-e-9", Size: "1024x1024"})
+	if got := costReply("en", "admin"); got != "Estimated spend: ~$0.12 today, ~$0.12 all-time over 3 images." {
+		t.Errorf("cost reply is %q, want both priced requests counted", got)
+	}
+	if got := costReply("en", "user"); got != "Only bot admins can see the spend." {
+		t.Errorf("cost reply to a non-admin is %q, want it refused", got)
+	}
+
+	advance(24 * time.Hour)
+	recordSpend(&ImageRequest{GuildID: testGuildID, Model: "dall-e-2", Size: "256x256"})
+	totals := spendSoFar()
+	if math.Abs(totals.Today-0.016) > 1e-9 || math.Abs(totals.AllTime-0.136) > 1e-9 || totals.Images != 4 {
+		t.Errorf("next day's totals are %+v, want today started over", totals)
+	}
+
+	// a restart picks up where the file left off
+	spendMu.Lock()
+	spend = spendTotals{}
+	spendMu.Unlock()
+	if err := loadSpend(); err != nil {
+		t.Fatal(err)
+	}
+	if got := spendSoFar(); got != totals {
+		t.Errorf("loaded totals %+v, want the saved %+v", got, totals)
+	}
+}
+
+func TestGuildSpendByDay(t *testing.T) {
+	advance := fakeClock(t)
+	tracker := &spendTracker{byGuild: make(map[string]float64)}
+
+	tracker.add(testGuildID, 0.04)
+	tracker.add(testGuildID, 0.02)
+	tracker.add("other", 0.08)
+	if got := tracker.today(testGuildID); math.Abs(got-0.06) > 1e-9 {
+		t.Errorf("guild spent %v today, want 0.06", got)
+	}
+
+	advance(24 * time.Hour)
+	if got := tracker.today(testGuildID); got != 0 {
+		t.Errorf("guild spent %v on a new day, want nothing yet", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// debouncer delays actions so that only the last of a burst of calls with the
+// same key runs, and pending actions can be called off.
+type debouncer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+var reactionDebouncer = &debouncer{timers: make(map[string]*time.Timer)}
+
+// schedule runs fn after wait unless schedule or cancel is called again for
+// the same key first.
+func (d *debouncer) schedule(key string, wait time.Duration, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[key]; ok {
+		t.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		// a newer call may have replaced this timer
+		if d.timers[key] != t {
+			d.mu.Unlock()
+			return
+		}
+		delete(d.timers, key)
+		d.mu.Unlock()
+		fn()
+	})
+	d.timers[key] = t
+}
+
+// cancel calls off the pending action for key, if any.
+func (d *debouncer) cancel(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[key]; ok {
+		t.Stop()
+		delete(d.timers, key)
+	}
+}
+
+func reactionKey(r *discordgo.MessageReaction) string {
+	return r.MessageID + "/" + r.UserID + "/" + r.Emoji.APIName()
+}
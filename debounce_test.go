@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// settled waits for want generations, then long enough past the debounce
+// window for an unwanted one to show up too.
+func settled(api *fakeOpenAI, want int) {
+	for deadline := time.Now().Add(2 * time.Second); len(api.generated()) < want && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+}
+
+func TestFidgetyRerollGeneratesOnce(t *testing.T) {
+	d, api := setupBot(t, Config{ReactionDebounceMillis: 50}, "fidgety")
+	onMessageHandler(d, d.post("fidgety", "user", "/dalle a blue whale"))
+	result := d.sentMessages()[0]
+
+	add := d.react("fidgety", result.ID, "user", "🔁")
+	onEmojiAddHandler(d, add)
+	onEmojiRemoveHandler(d, &discordgo.MessageReactionRemove{MessageReaction: add.MessageReaction})
+	onEmojiAddHandler(d, d.react("fidgety", result.ID, "user", "🔁"))
+	onEmojiAddHandler(d, d.react("fidgety", result.ID, "user", "🔁"))
+	settled(api, 2)
+
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %q, want the prompt and a single re-roll", got)
+	}
+}
+
+func TestRerollTakenBackInTime(t *testing.T) {
+	d, api := setupBot(t, Config{ReactionDebounceMillis: 50}, "changedmind")
+	onMessageHandler(d, d.post("changedmind", "user", "/dalle a blue whale"))
+	result := d.sentMessages()[0]
+
+	add := d.react("changedmind", result.ID, "user", "🔁")
+	onEmojiAddHandler(d, add)
+	onEmojiRemoveHandler(d, &discordgo.MessageReactionRemove{MessageReaction: add.MessageReaction})
+	settled(api, 1)
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want no re-roll once the 🔁 was taken back", got)
+	}
+}
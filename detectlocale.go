@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// localeWords are short words common in prompts in each catalog locale and
+// rare in the others, so counting them is enough to tell the languages
+// apart without a model.
+var localeWords = map[string][]string{
+	"en": {"the", "a", "an", "of", "in", "on", "with", "and", "is", "at", "by", "wearing", "under", "over", "from", "his", "her", "its", "their", "made", "style"},
+	"fr": {"le", "la", "les", "un", "une", "des", "du", "de", "dans", "sur", "avec", "et", "est", "au", "aux", "sous", "qui", "son", "sa", "ses", "leur", "fait", "très"},
+}
+
+// minLocaleHits is how many of a locale's words a prompt needs before it's
+// taken to be in that locale. A word or two could be in either.
+const minLocaleHits = 2
+
+// detectLocale guesses which catalog locale prompt is written in. ok is
+// false when too few words are known, or the best guess doesn't have at
+// least twice the hits of the next one.
+func detectLocale(prompt string) (locale string, ok bool) {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	hits := make(map[string]int, len(localeWords))
+	for _, word := range words {
+		// l'arbre and d'or count as their article
+		if article, _, found := strings.Cut(word, "'"); found && len(article) == 1 {
+			hits["fr"]++
+			continue
+		}
+		for name, known := range localeWords {
+			for _, w := range known {
+				if w == word {
+					hits[name]++
+				}
+			}
+		}
+	}
+
+	most, next := 0, 0
+	for name, n := range hits {
+		switch {
+		case n > most:
+			locale, most, next = name, n, most
+		case n > next:
+			next = n
+		}
+	}
+	if most < minLocaleHits || most < 2*next {
+		return "", false
+	}
+	return locale, true
+}
+
+// promptLocale is the locale to answer prompt in: the one it's written in
+// with AutoLocaleFromPrompt, when that's clear, and fallback otherwise.
+func promptLocale(prompt string, fallback string) string {
+	if !cfg().AutoLocaleFromPrompt {
+		return fallback
+	}
+	if detected, ok := detectLocale(prompt); ok {
+		return detected
+	}
+	return fallback
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDetectLocale(t *testing.T) {
+	for _, c := range []struct {
+		prompt string
+		locale string
+		ok     bool
+	}{
+		{"a red fox in the snow", "en", true},
+		{"An astronaut riding a horse on the moon, in the style of Monet", "en", true},
+		{"un renard roux dans la neige", "fr", true},
+		{"Une tasse de café sur la table, au soleil", "fr", true},
+		{"l'arbre d'or sous la lune", "fr", true},
+		// too few words to go on
+		{"a red fox", "", false},
+		{"renard roux", "", false},
+		// as much of one as the other
+		{"a croissant with café au lait et du sucre", "", false},
+		// not a language in the catalog
+		{"ein roter Fuchs im Schnee", "", false},
+	} {
+		if locale, ok := detectLocale(c.prompt); locale != c.locale || ok != c.ok {
+			t.Errorf("detectLocale(%q) = %q, %v, want %q, %v", c.prompt, locale, ok, c.locale, c.ok)
+		}
+	}
+}
+
+func TestPromptLocale(t *testing.T) {
+	useConfig(t, Config{AutoLocaleFromPrompt: true})
+	if got := promptLocale("un renard roux dans la neige", "en"); got != "fr" {
+		t.Errorf("promptLocale of a French prompt = %q, want fr", got)
+	}
+	if got := promptLocale("renard roux", "en"); got != "en" {
+		t.Errorf("promptLocale of an unclear prompt = %q, want the fallback", got)
+	}
+
+	useConfig(t, Config{})
+	if got := promptLocale("un renard roux dans la neige", "en"); got != "en" {
+		t.Errorf("promptLocale with detection off = %q, want the fallback", got)
+	}
+}
+
+func TestRepliesInPromptLanguage(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config Config
+		prompt string
+		locale string
+	}{
+		{"french prompt", Config{AutoLocaleFromPrompt: true}, "un renard roux dans la neige", "fr"},
+		{"english prompt over french", Config{AutoLocaleFromPrompt: true, Locale: "fr"}, "a red fox in the snow", "en"},
+		{"unclear prompt", Config{AutoLocaleFromPrompt: true, Locale: "fr"}, "red fox", "fr"},
+		{"off", Config{}, "un renard roux dans la neige", "en"},
+	} {
+		d, api := setupBot(t, c.config, "languages")
+		api.fail(http.StatusBadRequest, rejectedPrompt)
+
+		onMessageHandler(d, d.post("languages", "user", "/dalle "+c.prompt))
+
+		want := tr(c.locale, "error.rejected", "Your request was rejected by our safety system.")
+		sent := d.sentMessages()
+		if len(sent) != 1 || !strings.Contains(sent[0].Content, want) {
+			t.Errorf("%s: sent %+v, want the error in %s", c.name, sent, c.locale)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+const defaultOutputQuality = 85
+
+func outputFormat() string {
+	if cfg().OutputFormat != "" {
+		return cfg().OutputFormat
+	}
+	return "png"
+}
+
+func outputQuality() int {
+	if cfg().OutputQuality > 0 {
+		return cfg().OutputQuality
+	}
+	return defaultOutputQuality
+}
+
+// outputTypes maps each OutputFormat to the content type it uploads as.
+var outputTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// validateOutputFormat checks the configured upload format.
+func validateOutputFormat(c *Config) error {
+	if _, ok := outputTypes[c.OutputFormat]; c.OutputFormat != "" && !ok {
+		return fmt.Errorf("unsupported outputFormat %q, use png, jpeg or webp", c.OutputFormat)
+	}
+	if q := c.OutputQuality; q < 0 || q > 100 {
+		return fmt.Errorf("outputQuality %d must be between 0 and 100, with 0 meaning %d", q, defaultOutputQuality)
+	}
+	if c.ThumbnailSize < 0 {
+		return fmt.Errorf("thumbnailSize %d can't be negative, use 0 for no thumbnails", c.ThumbnailSize)
+	}
+	return nil
+}
+
+// validateResponseFormat checks how images are asked to come back.
+func validateResponseFormat(c *Config) error {
+	if f := c.ResponseFormat; f != "" && f != "url" && f != "b64_json" {
+		return fmt.Errorf("unsupported responseFormat %q, use url or b64_json", f)
+	}
+	return nil
+}
+
+// reencode converts a downloaded image to OutputFormat so big PNGs fit
+// Discord's upload limit. PNG output passes images through untouched, and so
+// does a conversion that wouldn't be any smaller.
+func reencode(img *imageFile) (*imageFile, error) {
+	contentType := outputTypes[outputFormat()]
+	if outputFormat() == "png" || img.ContentType == contentType {
+		return img, nil
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	switch outputFormat() {
+	case "jpeg":
+		err = jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: outputQuality()})
+	case "webp":
+		// the webp encoder is lossless, so OutputQuality doesn't apply
+		err = nativewebp.Encode(&buf, decoded, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// flat images can come out bigger, and then there's nothing to gain
+	if buf.Len() >= len(img.Data) {
+		return img, nil
+	}
+	return &imageFile{
+		Name:        "disc-e." + imageExtensions[contentType],
+		ContentType: contentType,
+		Data:        buf.Bytes(),
+	}, nil
+}
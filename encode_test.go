@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// photoPNG is a noisy image big enough that converting it pays off.
+func photoPNG(t *testing.T) *imageFile {
+	t.Helper()
+	r := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(r.Intn(64)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &imageFile{Name: "disc-e.png", ContentType: "image/png", Data: buf.Bytes()}
+}
+
+func TestReencodeConverts(t *testing.T) {
+	for _, format := range []string{"jpeg", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			useConfig(t, Config{OutputFormat: format})
+			src := photoPNG(t)
+
+			got, err := reencode(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "image/" + format; got.ContentType != want || got.Name != "disc-e."+imageExtensions[want] {
+				t.Errorf("converted to %s named %s, want %s", got.ContentType, got.Name, want)
+			}
+			if len(got.Data) >= len(src.Data) {
+				t.Errorf("converted image is %d bytes, want it smaller than the %d byte PNG", len(got.Data), len(src.Data))
+			}
+			decoded, decodedFormat, err := image.Decode(bytes.NewReader(got.Data))
+			if err != nil {
+				t.Fatalf("converted image doesn't decode: %v", err)
+			}
+			if decodedFormat != format || decoded.Bounds() != image.Rect(0, 0, 256, 256) {
+				t.Errorf("decoded a %s of %v, want a 256x256 %s", decodedFormat, decoded.Bounds(), format)
+			}
+		})
+	}
+}
+
+func TestReencodePNGPassesThrough(t *testing.T) {
+	useConfig(t, Config{})
+	src := photoPNG(t)
+
+	got, err := reencode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != src {
+		t.Errorf("got %s of %d bytes, want the PNG passed through untouched", got.ContentType, len(got.Data))
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		ok     bool
+	}{
+		{Config{}, true},
+		{Config{OutputFormat: "webp"}, true},
+		{Config{OutputFormat: "jpeg", OutputQuality: 100}, true},
+		{Config{OutputFormat: "gif"}, false},
+		{Config{OutputQuality: 101}, false},
+		{Config{OutputQuality: -1}, false},
+		{Config{ThumbnailSize: 256}, true},
+		{Config{ThumbnailSize: -1}, false},
+	} {
+		if err := validateOutputFormat(&c.config); (err == nil) != c.ok {
+			t.Errorf("validateOutputFormat(%+v) = %v, want ok %v", c.config, err, c.ok)
+		}
+	}
+}
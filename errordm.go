@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sendErrorReply tells the requester of m why it failed. With ErrorsToDM the
+// reason goes to their DMs so the channel only sees the status, falling back
+// to a reply when their DMs are closed. It returns the reply in the channel,
+// if it made one.
+func sendErrorReply(ctx context.Context, s session, m *discordgo.Message, reason string) *discordgo.Message {
+	if cfg().ErrorsToDM && m.GuildID != "" {
+		dm, err := s.UserChannelCreate(m.Author.ID)
+		if err == nil {
+			_, err = sendReplyChunked(s, dm.ID, tr(localeFrom(ctx), "reply.failedFor", messageLink(m.GuildID, m.ChannelID, m.ID), reason), nil, nil)
+		}
+		if err == nil {
+			return nil
+		}
+		logger(ctx).Error("Error on sending error by DM", "error", err)
+	}
+	reply, err := sendReplyChunked(s, m.ChannelID, reason, nil, m.Reference())
+	if err != nil {
+		logger(ctx).Error("Error on sending error reply", "error", err)
+	}
+	return reply
+}
+
+// respondError replaces the public placeholder of an acknowledged slash
+// command with an ephemeral message only its user sees. It edits the
+// placeholder instead when it can't be deleted.
+func respondError(ctx context.Context, s session, i *discordgo.InteractionCreate, reason string) {
+	if err := s.InteractionResponseDelete(i.Interaction); err == nil {
+		_, err = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: reason,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+		if err != nil {
+			logger(ctx).Error("Error on sending ephemeral error", "error", err)
+		}
+		return
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &reason}); err != nil {
+		logger(ctx).Error("Error on editing interaction response", "error", err)
+	}
+}
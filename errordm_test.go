@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDalleCommandErrorEphemeral(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "slash")
+	ok := d.command("slash", "user", "a red fox")
+	onInteractionHandler(d, ok)
+
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	failed := d.command("slash", "user", "something forbidden")
+	onInteractionHandler(d, failed)
+
+	if response := d.response(ok); response == nil || response.Flags&discordgo.MessageFlagsEphemeral != 0 || len(response.Files) != 1 {
+		t.Errorf("success responded %+v, want the image in public", response)
+	}
+	response := d.response(failed)
+	if response == nil || response.Flags&discordgo.MessageFlagsEphemeral == 0 {
+		t.Fatalf("failure responded %+v, want an ephemeral message", response)
+	}
+	if !strings.Contains(response.Content, rejectedReason) {
+		t.Errorf("ephemeral error says %q, want it to contain %q", response.Content, rejectedReason)
+	}
+}
+
+func TestErrorsToDM(t *testing.T) {
+	d, api := setupBot(t, Config{ErrorsToDM: true}, "dmerrors")
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	m := d.post("dmerrors", "user", "/dalle something forbidden")
+
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].ChannelID != "dm-user" {
+		t.Fatalf("sent %+v, want only a DM to the requester", sent)
+	}
+	if !strings.Contains(sent[0].Content, rejectedReason) || !strings.Contains(sent[0].Content, m.ID) {
+		t.Errorf("DM says %q, want the reason and a link to the request", sent[0].Content)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+}
+
+func TestErrorsToClosedDMs(t *testing.T) {
+	d, api := setupBot(t, Config{ErrorsToDM: true}, "dmerrors")
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	d.closeDMs("user")
+	m := d.post("dmerrors", "user", "/dalle something forbidden")
+
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].ChannelID != "dmerrors" || !strings.Contains(sent[0].Content, rejectedReason) {
+		t.Fatalf("sent %+v, want the error replied in the channel", sent)
+	}
+	if ref := sent[0].MessageReference; ref == nil || ref.MessageID != m.ID {
+		t.Errorf("error replies to %+v, want the request", ref)
+	}
+}
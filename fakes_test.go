@@ -0,0 +1,1031 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testBotID   = "bot"
+	testGuildID = "guild"
+)
+
+// fakeDiscord stands in for Discord, keeping the messages and reactions the
+// bot sends so tests can look at them. Calls it doesn't fake panic on the
+// nil session it embeds.
+type fakeDiscord struct {
+	session
+
+	mu       sync.Mutex
+	nextID   int
+	channels map[string]*discordgo.Channel
+	messages map[string]*discordgo.Message
+	sent     []*sentMessage
+	// reactions are the bot's current reactions on each message
+	reactions map[string][]string
+	// responses are the bot's responses to interactions, by token
+	responses map[string]*sentMessage
+	// modals are the modals the bot opened in answer to interactions, by
+	// token
+	modals map[string]*discordgo.InteractionResponseData
+	// suggestions are the autocomplete choices the bot gave, by token
+	suggestions map[string][]*discordgo.ApplicationCommandOptionChoice
+	// failSends, when set, is the error every message the bot sends gets
+	failSends error
+	// sendErrors are what the bot's next sends get in turn, nil letting one
+	// through
+	sendErrors []error
+	// deleted are the messages the bot deleted, in order
+	deleted []string
+	// permissions are users' channel permissions, everyone else having all
+	permissions map[string]int64
+	// typing counts the typing indicators shown in each channel
+	typing map[string]int
+	// edits are the message edits the bot made, in order
+	edits []*discordgo.MessageEdit
+	// closedDMs are the users who don't take DMs from the bot
+	closedDMs map[string]bool
+	// webhooks are the channels of the webhooks that exist, by ID, and
+	// failWebhooks, when set, the error every webhook post gets
+	webhooks     map[string]string
+	failWebhooks error
+	// members are the guild's members Discord can look up, by user ID
+	members map[string]*discordgo.Member
+	// failReactions, when set, is the error every reaction the bot adds gets
+	failReactions error
+}
+
+// sentMessage is a message the bot sent, with the images it attached and
+// their names and alt text.
+type sentMessage struct {
+	*discordgo.Message
+	Files     [][]byte
+	FileNames []string
+	AltText   []string
+}
+
+func newFakeDiscord() *fakeDiscord {
+	return &fakeDiscord{
+		channels:    make(map[string]*discordgo.Channel),
+		messages:    make(map[string]*discordgo.Message),
+		reactions:   make(map[string][]string),
+		responses:   make(map[string]*sentMessage),
+		modals:      make(map[string]*discordgo.InteractionResponseData),
+		suggestions: make(map[string][]*discordgo.ApplicationCommandOptionChoice),
+
+		permissions: make(map[string]int64),
+		typing:      make(map[string]int),
+		closedDMs:   make(map[string]bool),
+		webhooks:    make(map[string]string),
+		members:     make(map[string]*discordgo.Member),
+	}
+}
+
+func (d *fakeDiscord) id() string {
+	d.nextID++
+	return fmt.Sprintf("m%d", d.nextID)
+}
+
+// addChannel makes a guild text channel.
+func (d *fakeDiscord) addChannel(channelID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[channelID] = &discordgo.Channel{ID: channelID, GuildID: testGuildID, Type: discordgo.ChannelTypeGuildText}
+}
+
+// addDM makes a direct message channel.
+func (d *fakeDiscord) addDM(channelID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[channelID] = &discordgo.Channel{ID: channelID, Type: discordgo.ChannelTypeDM}
+}
+
+// dm is userID sending content to the bot in the DM channel channelID.
+func (d *fakeDiscord) dm(channelID string, userID string, content string) *discordgo.MessageCreate {
+	m := d.post(channelID, userID, content)
+	m.GuildID = ""
+	return m
+}
+
+// post is userID sending content in channelID, returning the event Discord
+// would deliver.
+func (d *fakeDiscord) post(channelID string, userID string, content string) *discordgo.MessageCreate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := &discordgo.Message{
+		ID:        d.id(),
+		ChannelID: channelID,
+		GuildID:   testGuildID,
+		Author:    &discordgo.User{ID: userID},
+		Content:   content,
+	}
+	d.messages[m.ID] = m
+	return &discordgo.MessageCreate{Message: m}
+}
+
+// react is userID reacting to messageID with emoji.
+func (d *fakeDiscord) react(channelID string, messageID string, userID string, emoji string) *discordgo.MessageReactionAdd {
+	return &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID:    userID,
+		MessageID: messageID,
+		ChannelID: channelID,
+		GuildID:   testGuildID,
+		Emoji:     discordgo.Emoji{Name: emoji},
+	}}
+}
+
+// unreact is userID taking back their emoji reaction to messageID.
+func (d *fakeDiscord) unreact(channelID string, messageID string, userID string, emoji string) *discordgo.MessageReactionRemove {
+	return &discordgo.MessageReactionRemove{MessageReaction: d.react(channelID, messageID, userID, emoji).MessageReaction}
+}
+
+// command is userID using /dalle with prompt in channelID.
+func (d *fakeDiscord) command(channelID string, userID string, prompt string) *discordgo.InteractionCreate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.id()
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        id,
+		AppID:     testBotID,
+		Token:     "token-" + id,
+		Type:      discordgo.InteractionApplicationCommand,
+		ChannelID: channelID,
+		GuildID:   testGuildID,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: dalleCommand.Name,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "prompt", Type: discordgo.ApplicationCommandOptionString, Value: prompt},
+			},
+		},
+	}}
+}
+
+// click is userID clicking the button with customID on message.
+func (d *fakeDiscord) click(message *discordgo.Message, userID string, customID string) *discordgo.InteractionCreate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.id()
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        id,
+		AppID:     testBotID,
+		Token:     "token-" + id,
+		Type:      discordgo.InteractionMessageComponent,
+		ChannelID: message.ChannelID,
+		GuildID:   testGuildID,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID}},
+		Message:   message,
+		Data:      discordgo.MessageComponentInteractionData{CustomID: customID, ComponentType: discordgo.ButtonComponent},
+	}}
+}
+
+// submit is userID submitting the modal with customID, opened from message,
+// with its text inputs set to values by custom ID.
+func (d *fakeDiscord) submit(message *discordgo.Message, userID string, customID string, values map[string]string) *discordgo.InteractionCreate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.id()
+	var rows []discordgo.MessageComponent
+	for inputID, value := range values {
+		rows = append(rows, &discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			&discordgo.TextInput{CustomID: inputID, Value: value},
+		}})
+	}
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        id,
+		AppID:     testBotID,
+		Token:     "token-" + id,
+		Type:      discordgo.InteractionModalSubmit,
+		ChannelID: message.ChannelID,
+		GuildID:   testGuildID,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID}},
+		Message:   message,
+		Data:      discordgo.ModalSubmitInteractionData{CustomID: customID, Components: rows},
+	}}
+}
+
+// autocomplete is userID having typed prompt into the /dalle command so
+// far, asking for suggestions.
+func (d *fakeDiscord) autocomplete(channelID string, userID string, prompt string) *discordgo.InteractionCreate {
+	i := d.command(channelID, userID, prompt)
+	i.Type = discordgo.InteractionApplicationCommandAutocomplete
+	i.ApplicationCommandData().Options[0].Focused = true
+	return i
+}
+
+// suggested returns the prompts the bot suggested in answer to an
+// autocomplete, in order.
+func (d *fakeDiscord) suggested(i *discordgo.InteractionCreate) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var prompts []string
+	for _, c := range d.suggestions[i.Token] {
+		prompts = append(prompts, c.Value.(string))
+	}
+	return prompts
+}
+
+// modal returns the modal the bot opened in answer to an interaction, or
+// nil.
+func (d *fakeDiscord) modal(i *discordgo.InteractionCreate) *discordgo.InteractionResponseData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.modals[i.Token]
+}
+
+// response returns the bot's response to an interaction, or nil.
+func (d *fakeDiscord) response(i *discordgo.InteractionCreate) *sentMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.responses[i.Token]
+}
+
+// sentMessages returns what the bot has sent so far.
+func (d *fakeDiscord) sentMessages() []*sentMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*sentMessage(nil), d.sent...)
+}
+
+// deletedMessages returns the IDs of the messages the bot deleted.
+func (d *fakeDiscord) deletedMessages() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.deleted...)
+}
+
+// reactionsOn returns the bot's reactions on messageID, oldest first.
+func (d *fakeDiscord) reactionsOn(messageID string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.reactions[messageID]...)
+}
+
+// send records a message from the bot.
+func (d *fakeDiscord) send(channelID string, content string, ref *discordgo.MessageReference) (*sentMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failSends != nil {
+		return nil, d.failSends
+	}
+	if len(d.sendErrors) > 0 {
+		err := d.sendErrors[0]
+		d.sendErrors = d.sendErrors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %s", channelID)
+	}
+	if len(ch.Recipients) > 0 && d.closedDMs[ch.Recipients[0].ID] {
+		return nil, &discordgo.RESTError{Response: &http.Response{Status: "403 Forbidden", StatusCode: http.StatusForbidden}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeCannotSendMessagesToThisUser}}
+	}
+	m := &discordgo.Message{
+		ID:               d.id(),
+		ChannelID:        channelID,
+		GuildID:          testGuildID,
+		Author:           &discordgo.User{ID: testBotID, Bot: true},
+		Content:          content,
+		MessageReference: ref,
+	}
+	d.messages[m.ID] = m
+	sent := &sentMessage{Message: m}
+	d.sent = append(d.sent, sent)
+	return sent, nil
+}
+
+func (d *fakeDiscord) botID() string {
+	return testBotID
+}
+
+func (d *fakeDiscord) cachedChannel(channelID string) (*discordgo.Channel, error) {
+	return d.Channel(channelID)
+}
+
+func (d *fakeDiscord) cachedMember(guildID string, userID string) (*discordgo.Member, error) {
+	return nil, discordgo.ErrStateNotFound
+}
+
+// addMember makes userID a member of the test guild holding roles.
+func (d *fakeDiscord) addMember(userID string, roles ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.members[userID] = &discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: userID}, Roles: roles}
+}
+
+func (d *fakeDiscord) GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if member, ok := d.members[userID]; ok && guildID == testGuildID {
+		return member, nil
+	}
+	return nil, &discordgo.RESTError{Response: &http.Response{Status: "404 Not Found", StatusCode: http.StatusNotFound}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownMember}}
+}
+
+func (d *fakeDiscord) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ch, ok := d.channels[channelID]; ok {
+		return ch, nil
+	}
+	return nil, fmt.Errorf("unknown channel %s", channelID)
+}
+
+// UserChannelCreate opens the DM channel "dm-" + recipientID.
+func (d *fakeDiscord) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch := &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM, Recipients: []*discordgo.User{{ID: recipientID}}}
+	d.channels[ch.ID] = ch
+	return ch, nil
+}
+
+// closeDMs stops userID taking DMs from the bot.
+func (d *fakeDiscord) closeDMs(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closedDMs[userID] = true
+}
+
+func (d *fakeDiscord) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, ok := d.messages[messageID]; ok && m.ChannelID == channelID {
+		// Discord leaves out the replied-to message as often as not
+		copied := *m
+		return &copied, nil
+	}
+	return nil, fmt.Errorf("unknown message %s", messageID)
+}
+
+func (d *fakeDiscord) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	sent, err := d.send(channelID, content, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sent.Message, nil
+}
+
+func (d *fakeDiscord) ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	sent, err := d.send(channelID, content, reference)
+	if err != nil {
+		return nil, err
+	}
+	return sent.Message, nil
+}
+
+func (d *fakeDiscord) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	sent, err := d.send(channelID, data.Content, data.Reference)
+	if err != nil {
+		return nil, err
+	}
+	sent.Components = data.Components
+	for _, f := range data.Files {
+		b, _ := io.ReadAll(f.Reader)
+		sent.Files = append(sent.Files, b)
+		sent.FileNames = append(sent.FileNames, f.Name)
+	}
+	return sent.Message, nil
+}
+
+// requestMultipart handles the uploads the bot builds itself, which carry
+// alt text.
+func (d *fakeDiscord) requestMultipart(method string, endpoint string, contentType string, body []byte) ([]byte, error) {
+	if webhook, ok := strings.CutPrefix(endpoint, discordgo.EndpointWebhooks); ok && method == "PATCH" {
+		return d.editResponse(strings.Split(webhook, "/")[1], contentType, body)
+	}
+	channelID, ok := strings.CutPrefix(endpoint, discordgo.EndpointChannels)
+	if !ok || method != "POST" {
+		return nil, fmt.Errorf("unexpected request %s %s", method, endpoint)
+	}
+	channelID = strings.TrimSuffix(channelID, "/messages")
+
+	var payload struct {
+		Content     string                      `json:"content"`
+		Reference   *discordgo.MessageReference `json:"message_reference"`
+		Embeds      []*discordgo.MessageEmbed   `json:"embeds"`
+		Attachments []attachmentMeta            `json:"attachments"`
+	}
+	files, err := readMultipart(contentType, body, &payload)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := d.send(channelID, payload.Content, payload.Reference)
+	if err != nil {
+		return nil, err
+	}
+	sent.Files, sent.Embeds = files, payload.Embeds
+	for _, a := range payload.Attachments {
+		sent.FileNames = append(sent.FileNames, a.Filename)
+		sent.AltText = append(sent.AltText, a.Description)
+	}
+	return json.Marshal(sent.Message)
+}
+
+// editResponse is the bot editing its response to an interaction with images.
+func (d *fakeDiscord) editResponse(token string, contentType string, body []byte) ([]byte, error) {
+	var payload struct {
+		Content     string                    `json:"content"`
+		Embeds      []*discordgo.MessageEmbed `json:"embeds"`
+		Components  json.RawMessage           `json:"components"`
+		Attachments []attachmentMeta          `json:"attachments"`
+	}
+	files, err := readMultipart(contentType, body, &payload)
+	if err != nil {
+		return nil, err
+	}
+	// discordgo only knows how to read components as part of a message
+	var components discordgo.Message
+	if len(payload.Components) > 0 {
+		if err := json.Unmarshal([]byte(`{"components":`+string(payload.Components)+`}`), &components); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	response, ok := d.responses[token]
+	if !ok {
+		return nil, fmt.Errorf("no response to edit for %s", token)
+	}
+	response.Content, response.Files, response.FileNames, response.AltText = payload.Content, files, nil, nil
+	response.Embeds, response.Components = payload.Embeds, components.Components
+	for _, a := range payload.Attachments {
+		response.FileNames = append(response.FileNames, a.Filename)
+		response.AltText = append(response.AltText, a.Description)
+	}
+	return json.Marshal(response.Message)
+}
+
+func (d *fakeDiscord) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failReactions != nil {
+		return d.failReactions
+	}
+	d.reactions[messageID] = append(d.reactions[messageID], emojiID)
+	return nil
+}
+
+func (d *fakeDiscord) MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reactions := d.reactions[messageID]
+	for i, r := range reactions {
+		if r == emojiID {
+			d.reactions[messageID] = append(reactions[:i:i], reactions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (d *fakeDiscord) ChannelTyping(channelID string, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.typing[channelID]++
+	return nil
+}
+
+// typingIn returns how many times the bot showed itself typing in channelID.
+func (d *fakeDiscord) typingIn(channelID string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.typing[channelID]
+}
+
+func (d *fakeDiscord) ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	msg, ok := d.messages[m.ID]
+	if !ok {
+		return nil, fmt.Errorf("unknown message %s", m.ID)
+	}
+	d.edits = append(d.edits, m)
+	if m.Content != nil {
+		msg.Content = *m.Content
+	}
+	return msg, nil
+}
+
+// editedMessages returns the edits the bot made so far.
+func (d *fakeDiscord) editedMessages() []*discordgo.MessageEdit {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*discordgo.MessageEdit(nil), d.edits...)
+}
+
+func (d *fakeDiscord) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.messages[messageID]; !ok {
+		return &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusNotFound}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownMessage}}
+	}
+	delete(d.messages, messageID)
+	d.deleted = append(d.deleted, messageID)
+	return nil
+}
+
+func (d *fakeDiscord) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if perms, ok := d.permissions[userID]; ok {
+		return perms, nil
+	}
+	return discordgo.PermissionAll, nil
+}
+
+// addWebhook makes a webhook webhookID posting in channelID.
+func (d *fakeDiscord) addWebhook(webhookID string, channelID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.webhooks[webhookID] = channelID
+}
+
+func (d *fakeDiscord) WebhookWithToken(webhookID, token string, options ...discordgo.RequestOption) (*discordgo.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	channelID, ok := d.webhooks[webhookID]
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook %s", webhookID)
+	}
+	return &discordgo.Webhook{ID: webhookID, ChannelID: channelID, Token: token}, nil
+}
+
+func (d *fakeDiscord) WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return d.WebhookThreadExecute(webhookID, token, wait, "", data)
+}
+
+// WebhookThreadExecute posts as the webhook, under the username it's given,
+// in threadID or the webhook's own channel when that's empty.
+func (d *fakeDiscord) WebhookThreadExecute(webhookID, token string, wait bool, threadID string, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	channelID, ok := d.webhooks[webhookID]
+	failure := d.failWebhooks
+	d.mu.Unlock()
+	if failure != nil {
+		return nil, failure
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook %s", webhookID)
+	}
+	if threadID != "" {
+		channelID = threadID
+	}
+
+	sent, err := d.send(channelID, data.Content, nil)
+	if err != nil {
+		return nil, err
+	}
+	sent.WebhookID = webhookID
+	sent.Author = &discordgo.User{ID: webhookID, Username: data.Username, Bot: true}
+	for _, f := range data.Files {
+		b, _ := io.ReadAll(f.Reader)
+		sent.Files = append(sent.Files, b)
+		sent.FileNames = append(sent.FileNames, f.Name)
+	}
+	return sent.Message, nil
+}
+
+func (d *fakeDiscord) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// a thread started from a message shares its ID
+	thread := &discordgo.Channel{ID: messageID, GuildID: testGuildID, ParentID: channelID, Name: name, Type: discordgo.ChannelTypeGuildPublicThread}
+	d.channels[thread.ID] = thread
+	return thread, nil
+}
+
+func (d *fakeDiscord) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch resp.Type {
+	case discordgo.InteractionResponseModal:
+		d.modals[interaction.Token] = resp.Data
+		return nil
+	case discordgo.InteractionApplicationCommandAutocompleteResult:
+		d.suggestions[interaction.Token] = resp.Data.Choices
+		return nil
+	}
+	m := &discordgo.Message{ID: d.id(), ChannelID: interaction.ChannelID, Author: &discordgo.User{ID: testBotID, Bot: true}}
+	if resp.Data != nil {
+		m.Content, m.Flags = resp.Data.Content, resp.Data.Flags
+	}
+	d.responses[interaction.Token] = &sentMessage{Message: m}
+	return nil
+}
+
+func (d *fakeDiscord) InteractionResponse(interaction *discordgo.Interaction, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	response, ok := d.responses[interaction.Token]
+	if !ok {
+		return nil, fmt.Errorf("no response for %s", interaction.Token)
+	}
+	return response.Message, nil
+}
+
+func (d *fakeDiscord) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	response, ok := d.responses[interaction.Token]
+	if !ok {
+		return nil, fmt.Errorf("no response to edit for %s", interaction.Token)
+	}
+	if newresp.Content != nil {
+		response.Content = *newresp.Content
+	}
+	return response.Message, nil
+}
+
+func (d *fakeDiscord) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.responses, interaction.Token)
+	return nil
+}
+
+func (d *fakeDiscord) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// followups stand in for the response once it's gone
+	m := &discordgo.Message{ID: d.id(), ChannelID: interaction.ChannelID, Author: &discordgo.User{ID: testBotID, Bot: true}, Content: data.Content, Flags: data.Flags}
+	d.responses[interaction.Token] = &sentMessage{Message: m}
+	return m, nil
+}
+
+// rejectedPrompt is OpenAI refusing a prompt, and rejectedReason what the
+// requester is told.
+const (
+	rejectedPrompt = `{"error": {"message": "Your request was rejected by our safety system.", "type": "invalid_request_error", "code": "content_policy_violation"}}`
+	rejectedReason = "Your prompt was rejected: Your request was rejected by our safety system."
+)
+
+// fakeOpenAI is an images API answering every generation with generated
+// PNGs it serves itself, or with the error it's been told to fail with.
+type fakeOpenAI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	prompts []string
+	// requests are the generations asked for, prompts their prompts
+	requests []fakeGeneration
+	// forms are the variations and edits asked for
+	forms []fakeForm
+	// failStatus and failBody, when set, answer every generation, or only
+	// those for failModels when it has any
+	failStatus int
+	failBody   string
+	failModels map[string]bool
+	// hold, when set, keeps generations waiting until it's closed, and
+	// abandoned counts those the bot gave up on while they waited
+	hold      chan struct{}
+	abandoned int
+	// moderated are the prompts sent for moderation, and flagged the
+	// categories every one of them is flagged for
+	moderated []string
+	flagged   []string
+	// imagesScored are the images sent for moderation, each scored
+	// imageScore unless failScoring
+	imagesScored []string
+	imageScore   float64
+	failScoring  bool
+	// revised, when set, is the prompt every generation says it drew
+	revised string
+	// running is how many generations are being answered right now, and
+	// mostRunning the most there have been at once
+	running, mostRunning int
+}
+
+func newFakeOpenAI(t *testing.T) *fakeOpenAI {
+	api := &fakeOpenAI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/generations", api.generate)
+	mux.HandleFunc("/images/variations", api.vary)
+	mux.HandleFunc("/images/edits", api.vary)
+	mux.HandleFunc("/moderations", api.moderate)
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(testPNG())
+	})
+	api.Server = httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+	return api
+}
+
+// fakeGeneration is the body of a request to the generations endpoint.
+type fakeGeneration struct {
+	Model   string  `json:"model"`
+	Prompt  string  `json:"prompt"`
+	N       int     `json:"n"`
+	Size    string  `json:"size"`
+	Quality *string `json:"quality"`
+	Style   *string `json:"style"`
+	Seed    *int64  `json:"seed"`
+	// ResponseFormat of b64_json gets the images in the response
+	ResponseFormat string `json:"response_format"`
+}
+
+func (api *fakeOpenAI) generate(w http.ResponseWriter, r *http.Request) {
+	var body fakeGeneration
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.mu.Lock()
+	api.prompts = append(api.prompts, body.Prompt)
+	api.requests = append(api.requests, body)
+	status, failBody, hold, revised := api.failStatus, api.failBody, api.hold, api.revised
+	if len(api.failModels) > 0 && !api.failModels[body.Model] {
+		status = 0
+	}
+	api.running++
+	api.mostRunning = max(api.mostRunning, api.running)
+	api.mu.Unlock()
+	defer func() {
+		api.mu.Lock()
+		api.running--
+		api.mu.Unlock()
+	}()
+
+	if hold != nil {
+		select {
+		case <-hold:
+		case <-r.Context().Done():
+			api.mu.Lock()
+			api.abandoned++
+			api.mu.Unlock()
+			return
+		}
+	}
+
+	if status != 0 {
+		w.WriteHeader(status)
+		io.WriteString(w, failBody)
+		return
+	}
+	data := make([]map[string]string, 0, body.N)
+	for i := 0; i < body.N; i++ {
+		image := map[string]string{"url": fmt.Sprintf("%s/files/%d.png", api.URL, i)}
+		if body.ResponseFormat == "b64_json" {
+			image = map[string]string{"b64_json": base64.StdEncoding.EncodeToString(testPNG())}
+		}
+		if revised != "" {
+			image["revised_prompt"] = revised
+		}
+		data = append(data, image)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"created": 1, "data": data})
+}
+
+// fakeForm is a request to the variations or edits endpoint, with its text
+// fields and the PNGs it sent by field name.
+type fakeForm struct {
+	Path   string
+	Fields map[string]string
+	Files  map[string][]byte
+}
+
+func (api *fakeOpenAI) vary(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxVariationBytes * 2); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := fakeForm{Path: r.URL.Path, Fields: make(map[string]string), Files: make(map[string][]byte)}
+	for name, values := range r.MultipartForm.Value {
+		form.Fields[name] = values[0]
+	}
+	for name, files := range r.MultipartForm.File {
+		f, err := files[0].Open()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form.Files[name], _ = io.ReadAll(f)
+		f.Close()
+	}
+	api.mu.Lock()
+	api.forms = append(api.forms, form)
+	api.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"created": 1, "data": []map[string]string{{"url": api.URL + "/files/0.png"}}})
+}
+
+// varied returns the variations and edits asked for so far.
+func (api *fakeOpenAI) varied() []fakeForm {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]fakeForm(nil), api.forms...)
+}
+
+func (api *fakeOpenAI) moderate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var prompt string
+	if err := json.Unmarshal(body.Input, &prompt); err != nil {
+		api.moderateImage(w, body.Model, body.Input)
+		return
+	}
+	api.mu.Lock()
+	api.moderated = append(api.moderated, prompt)
+	flagged := api.flagged
+	api.mu.Unlock()
+
+	categories := make(map[string]bool)
+	for _, category := range flagged {
+		categories[category] = true
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": []map[string]interface{}{{"flagged": len(flagged) > 0, "categories": categories}},
+	})
+}
+
+// moderateImage scores an image sent for moderation with the score set by
+// scoreImages in every category, or fails the way it was told to.
+func (api *fakeOpenAI) moderateImage(w http.ResponseWriter, model string, input json.RawMessage) {
+	var parts []struct {
+		Type     string `json:"type"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(input, &parts); err != nil || len(parts) != 1 || parts[0].Type != "image_url" || model != imageModerationModel {
+		http.Error(w, "unexpected image moderation", http.StatusBadRequest)
+		return
+	}
+	api.mu.Lock()
+	api.imagesScored = append(api.imagesScored, parts[0].ImageURL.URL)
+	score, fail := api.imageScore, api.failScoring
+	api.mu.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": {"message": "moderation is down"}}`))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": []map[string]interface{}{{
+			"flagged":         score >= 0.5,
+			"category_scores": map[string]float64{"sexual": score, "violence": score / 2},
+		}},
+	})
+}
+
+// scoreImages makes moderation score every image from now on at score, or
+// fail when fail is set.
+func (api *fakeOpenAI) scoreImages(score float64, fail bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.imageScore, api.failScoring = score, fail
+}
+
+// scoredImages returns the data URLs of the images sent for moderation.
+func (api *fakeOpenAI) scoredImages() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string(nil), api.imagesScored...)
+}
+
+// revise makes every generation from now on say it drew prompt instead.
+func (api *fakeOpenAI) revise(prompt string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.revised = prompt
+}
+
+// flag makes moderation flag every prompt from now on for categories.
+func (api *fakeOpenAI) flag(categories ...string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.flagged = categories
+}
+
+// moderations returns the prompts sent for moderation so far.
+func (api *fakeOpenAI) moderations() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string(nil), api.moderated...)
+}
+
+// fail makes every generation from now on answer with status and body.
+func (api *fakeOpenAI) fail(status int, body string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.failStatus, api.failBody = status, body
+}
+
+// failModel makes every generation from now on with model answer with
+// status and body, and the others succeed.
+func (api *fakeOpenAI) failModel(model string, status int, body string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.failStatus, api.failBody = status, body
+	if api.failModels == nil {
+		api.failModels = make(map[string]bool)
+	}
+	api.failModels[model] = true
+}
+
+// hang keeps generations from now on waiting, until release is called or
+// the bot gives up on them.
+func (api *fakeOpenAI) hang() (release func()) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	hold := make(chan struct{})
+	api.hold = hold
+	var once sync.Once
+	return func() { once.Do(func() { close(hold) }) }
+}
+
+// abandonedCount returns how many held generations the bot gave up on.
+func (api *fakeOpenAI) abandonedCount() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.abandoned
+}
+
+// mostConcurrent returns the most generations that were answered at once.
+func (api *fakeOpenAI) mostConcurrent() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.mostRunning
+}
+
+// generations returns the generations asked for so far.
+func (api *fakeOpenAI) generations() []fakeGeneration {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]fakeGeneration(nil), api.requests...)
+}
+
+// generated returns the prompts sent for generation so far.
+func (api *fakeOpenAI) generated() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string(nil), api.prompts...)
+}
+
+// testPNG is a small PNG that isn't a single flat colour.
+func testPNG() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// setupBot runs the bot with config against a fresh fake Discord with
+// channelID in it and a fake OpenAI.
+func setupBot(t *testing.T, config Config, channelID string) (*fakeDiscord, *fakeOpenAI) {
+	t.Helper()
+	api := newFakeOpenAI(t)
+	config.BaseURL = api.URL
+	useConfig(t, config)
+
+	d := newFakeDiscord()
+	d.addChannel(channelID)
+	return d, api
+}
+
+// useConfig runs the bot with config, and a test key unless it has one, for
+// the rest of the test.
+func useConfig(t *testing.T, config Config) {
+	t.Helper()
+	if config.OpenAIKey == "" {
+		config.OpenAIKey = "sk-test"
+	}
+
+	old := currentConfig.Swap(&config)
+	oldClients := openAI.Load()
+	t.Cleanup(func() {
+		currentConfig.Store(old)
+		openAI.Store(oldClients)
+	})
+	if err := setupOpenAI(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mdesson/disc-e/imagegen"
+	_ "golang.org/x/image/webp"
+)
+
+// errorReason turns a generation or download error into a short explanation
+// for the requester, or an empty string when there's nothing useful to tell
+// them.
+func errorReason(locale string, err error) string {
+	var apiErr *imagegen.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Rejected():
+		return tr(locale, "error.rejected", apiErr.Message)
+	case errors.As(err, &apiErr):
+		return tr(locale, "error.openai", apiErr.Message)
+	case errors.Is(err, imagegen.ErrEmptyResult):
+		return tr(locale, "error.empty")
+	case errors.Is(err, errImageTooLarge):
+		return tr(locale, "error.imageTooLarge")
+	case errors.Is(err, errNotAnImage):
+		return tr(locale, "error.notAnImage")
+	}
+	return ""
+}
+
+// blankVariance is the luminance variance below which an image is considered
+// a uniform placeholder rather than a real result.
+const blankVariance = 4.0
+
+// generateImage fetches the images for imgReq. With RetryOnEmptyResult set,
+// blank placeholder images are dropped and a response left empty is retried
+// once.
+func generateImage(ctx context.Context, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	images, err := fetchWithFallback(ctx, imgReq)
+	if !cfg().RetryOnEmptyResult {
+		return images, err
+	}
+
+	switch {
+	case errors.Is(err, imagegen.ErrEmptyResult):
+		logf(ctx, "Empty result, retrying once")
+	case err != nil:
+		return nil, err
+	default:
+		if images = dropBlankImages(ctx, images); len(images) > 0 {
+			return images, nil
+		}
+		logf(ctx, "Blank image result, retrying once")
+	}
+
+	// the retry must not be answered with the same result
+	retry := *imgReq
+	retry.IdempotencyKey += "-retry"
+	images, err = fetchWithFallback(ctx, &retry)
+	if err != nil {
+		return nil, err
+	}
+	imgReq.Model, imgReq.Size, imgReq.FallbackFrom, imgReq.Provider = retry.Model, retry.Size, retry.FallbackFrom, retry.Provider
+	if images = dropBlankImages(ctx, images); len(images) == 0 {
+		return nil, imagegen.ErrEmptyResult
+	}
+	return images, nil
+}
+
+// fetchWithFallback fetches the images for imgReq, trying each of
+// FallbackModels in turn when the model fails in a way another model might
+// not. imgReq is left set to the model that made the images.
+func fetchWithFallback(ctx context.Context, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	images, err := fetchImage(ctx, imgReq)
+	if err == nil || imgReq.Source != nil || !fallbackWorthy(err) {
+		return images, err
+	}
+
+	requested, key := imgReq.Model, imgReq.IdempotencyKey
+	for _, model := range cfg().FallbackModels {
+		if model == requested || model == imgReq.FallbackFrom {
+			continue
+		}
+		logger(ctx).Warn("Model failed, falling back", "model", imgReq.Model, "fallback", model, "error", err)
+		imgReq.Model = model
+		imgReq.Size = resolveSize(model, imgReq.Size)
+		if imgReq.FallbackFrom == "" {
+			imgReq.FallbackFrom = requested
+		}
+		imgReq.IdempotencyKey = key + "-" + model
+		images, err = fetchImage(ctx, imgReq)
+		if err == nil || !fallbackWorthy(err) {
+			return images, err
+		}
+	}
+	return nil, err
+}
+
+// fallbackWorthy reports whether err could go differently with another
+// model: a rejected prompt, or an API failure that outlasted its retries.
+func fallbackWorthy(err error) bool {
+	var apiErr *imagegen.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Rejected() || apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+}
+
+// fallbackNote tells the requester their image came from a fallback model
+// or provider.
+func fallbackNote(imgReq *ImageRequest) string {
+	var note string
+	if imgReq.FallbackFrom != "" {
+		note += "\n*" + tr(imgReq.Locale, "reply.fallback", imgReq.FallbackFrom, imgReq.Model) + "*"
+	}
+	if imgReq.Provider != "" {
+		note += "\n*" + tr(imgReq.Locale, "reply.provider", imgReq.Provider) + "*"
+	}
+	return note
+}
+
+// dropBlankImages returns images without the blank placeholder ones. Images
+// that came back as URLs are downloaded here, within MaxDownloadBytes, and keep
+// their bytes so loadImages doesn't fetch them again.
+func dropBlankImages(ctx context.Context, images []imagegen.Image) []imagegen.Image {
+	var kept []imagegen.Image
+	for _, img := range images {
+		if len(img.Data) == 0 {
+			if downloaded, err := downloadImage(ctx, img.URL); err == nil {
+				img.Data = downloaded.Data
+			}
+		}
+		if !isBlankImage(img) {
+			kept = append(kept, img)
+		}
+	}
+	return kept
+}
+
+// urlsOf returns the URLs of images.
+func urlsOf(images []imagegen.Image) []string {
+	urls := make([]string, 0, len(images))
+	for _, img := range images {
+		urls = append(urls, img.URL)
+	}
+	return urls
+}
+
+// withRevisedPrompt puts the prompt the model actually drew in front of a
+// reply's caption when ShowRevisedPrompt is on, cut short if the whole reply
+// would go over Discord's length limit.
+func withRevisedPrompt(caption string, images []imagegen.Image) string {
+	if !cfg().ShowRevisedPrompt || len(images) == 0 || images[0].RevisedPrompt == "" {
+		return caption
+	}
+	revised := strings.ReplaceAll(images[0].RevisedPrompt, "*", "")
+	room := maxMessageLength - utf8.RuneCountInString(caption) - len("**\n")
+	if room <= 0 {
+		return caption
+	}
+	if runes := []rune(revised); len(runes) > room {
+		revised = string(runes[:room-1]) + "…"
+	}
+	return "*" + revised + "*\n" + caption
+}
+
+// isBlankImage reports whether a generated image is a single flat colour.
+// Images without their bytes, or that can't be decoded, are assumed to be
+// fine.
+func isBlankImage(generated imagegen.Image) bool {
+	img, _, err := image.Decode(bytes.NewReader(generated.Data))
+	if err != nil {
+		return false
+	}
+
+	// sample a grid of pixels rather than every one
+	bounds := img.Bounds()
+	step := bounds.Dx() / 64
+	if step < 1 {
+		step = 1
+	}
+
+	var sum, sumSq, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+			lum := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			sum += lum
+			sumSq += lum * lum
+			n++
+		}
+	}
+	if n == 0 {
+		return false
+	}
+
+	mean := sum / n
+	return sumSq/n-mean*mean < blankVariance
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+func TestRevisedPromptTruncated(t *testing.T) {
+	useConfig(t, Config{ShowRevisedPrompt: true})
+	caption := strings.Repeat("c", maxMessageLength-100)
+	images := []imagegen.Image{{RevisedPrompt: strings.Repeat("r", 500)}}
+
+	got := withRevisedPrompt(caption, images)
+
+	if n := utf8.RuneCountInString(got); n > maxMessageLength {
+		t.Errorf("reply is %d characters, want at most %d", n, maxMessageLength)
+	}
+	if !strings.HasPrefix(got, "*rrr") || !strings.HasSuffix(got, "…*\n"+caption) {
+		t.Errorf("reply %q, want the revised prompt cut short before the caption", got)
+	}
+}
+
+func TestRevisedPromptOff(t *testing.T) {
+	useConfig(t, Config{})
+	images := []imagegen.Image{{RevisedPrompt: "a small red fox"}}
+
+	if got := withRevisedPrompt("a red fox", images); got != "a red fox" {
+		t.Errorf("reply %q with ShowRevisedPrompt off, want just the caption", got)
+	}
+}
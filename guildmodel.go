@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildModels are the models admins picked for their guild with the model
+// command, kept until the bot restarts. guildModelsMu guards it.
+var (
+	guildModels   = make(map[string]string)
+	guildModelsMu sync.RWMutex
+)
+
+// modelFor returns the model a guild's requests use: its own when an admin
+// set one, and the configured model otherwise.
+func modelFor(guildID string) string {
+	guildModelsMu.RLock()
+	model := guildModels[guildID]
+	guildModelsMu.RUnlock()
+	if model != "" {
+		return model
+	}
+	return currentModel()
+}
+
+// supportedModels lists the models that can be picked, sorted.
+func supportedModels() []string {
+	models := make([]string, 0, len(modelSizes))
+	for model := range modelSizes {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// guildModelReply handles the model command sent in a guild. With no
+// argument it shows the guild's model, "model <name>" switches to name and
+// "model default" goes back to the configured one.
+func guildModelReply(guildID string, args []string) string {
+	if guildID == "" {
+		return tr(locale(), "admin.modelGuild")
+	}
+	models := strings.Join(supportedModels(), ", ")
+	if len(args) == 0 {
+		return tr(locale(), "admin.model", modelFor(guildID), models)
+	}
+
+	name := args[0]
+	guildModelsMu.Lock()
+	defer guildModelsMu.Unlock()
+	if name == "default" {
+		delete(guildModels, guildID)
+		return tr(locale(), "admin.modelSet", currentModel())
+	}
+	if _, ok := modelSizes[name]; !ok {
+		return tr(locale(), "admin.modelUnknown", name, models)
+	}
+	guildModels[guildID] = name
+	return tr(locale(), "admin.modelSet", name)
+}
+
+// isModelCommand reports whether prompt is the model command. Only admins
+// can switch models, so from anyone else "model" followed by words is an
+// ordinary prompt.
+func isModelCommand(prompt string, userID string) bool {
+	return prompt == "model" || (strings.HasPrefix(prompt, "model ") && isAdmin(userID))
+}
+
+// setGuildModel answers the model command.
+func setGuildModel(s session, m *discordgo.MessageCreate, prompt string) {
+	args := strings.Fields(prompt)[1:]
+	s.ChannelMessageSendReply(m.ChannelID, guildModelReply(m.GuildID, args), m.Reference())
+}
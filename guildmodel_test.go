@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useGuildModels starts every guild on the configured model for the rest of
+// the test.
+func useGuildModels(t *testing.T) {
+	guildModelsMu.Lock()
+	old := guildModels
+	guildModels = make(map[string]string)
+	guildModelsMu.Unlock()
+	t.Cleanup(func() {
+		guildModelsMu.Lock()
+		guildModels = old
+		guildModelsMu.Unlock()
+	})
+}
+
+func TestSetGuildModel(t *testing.T) {
+	useGuildModels(t)
+	d, api := setupBot(t, Config{Admins: []string{"admin"}}, "models")
+
+	onMessageHandler(d, d.post("models", "admin", "/dalle model dall-e-3"))
+	onMessageHandler(d, d.post("models", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 2 || sent[0].Content != "This server now uses dall-e-3 until the next restart." {
+		t.Fatalf("sent %+v, want the switch confirmed", sent)
+	}
+	if got := api.generations(); len(got) != 1 || got[0].Model != "dall-e-3" {
+		t.Errorf("asked for %+v, want the guild's model used", got)
+	}
+	if got := modelFor("another-guild"); got != "dall-e-2" {
+		t.Errorf("another guild uses %s, want the configured model", got)
+	}
+
+	onMessageHandler(d, d.post("models", "admin", "/dalle model default"))
+	if got := modelFor(testGuildID); got != "dall-e-2" {
+		t.Errorf("guild uses %s after going back to the default, want dall-e-2", got)
+	}
+}
+
+func TestSetUnknownGuildModel(t *testing.T) {
+	useGuildModels(t)
+	d, _ := setupBot(t, Config{Admins: []string{"admin"}, Model: "dall-e-3"}, "models")
+
+	onMessageHandler(d, d.post("models", "admin", "/dalle model dall-e-9"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != `I don't know the model "dall-e-9", use one of dall-e-2, dall-e-3.` {
+		t.Errorf("sent %+v, want the model refused with the choices", sent)
+	}
+	if got := modelFor(testGuildID); got != "dall-e-3" {
+		t.Errorf("guild uses %s, want the configured model kept", got)
+	}
+}
+
+func TestShowGuildModel(t *testing.T) {
+	useGuildModels(t)
+	d, api := setupBot(t, Config{Admins: []string{"admin"}}, "models")
+
+	onMessageHandler(d, d.post("models", "user", "/dalle model"))
+	// from anyone but an admin, it's a prompt
+	onMessageHandler(d, d.post("models", "user", "/dalle model dall-e-3"))
+
+	sent := d.sentMessages()
+	if len(sent) != 2 || sent[0].Content != "This server uses dall-e-2. Admins can switch to one of dall-e-2, dall-e-3, or back with `default`." {
+		t.Errorf("sent %+v, want the guild's model shown", sent)
+	}
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"model dall-e-3"}) {
+		t.Errorf("generated %q, want a non-admin's words taken as a prompt", got)
+	}
+	if got := modelFor(testGuildID); got != "dall-e-2" {
+		t.Errorf("guild uses %s, want the configured model kept", got)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ready is set once the Discord session has been opened.
+var ready atomic.Bool
+
+// healthHandler serves /healthz, which is OK while the Discord connection is
+// up, /readyz, which is OK once the bot has started, and the Prometheus
+// metrics on /metrics.
+func healthHandler(s *discordgo.Session) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.RLock()
+		up := s.DataReady
+		s.RUnlock()
+		writeHealth(w, up)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, ready.Load())
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// startHealthServer serves the health checks and metrics on HealthPort, returning nil
+// when no port is configured.
+func startHealthServer(s *discordgo.Session) *http.Server {
+	if cfg().HealthPort == 0 {
+		return nil
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg().HealthPort),
+		Handler:           healthHandler(s),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+	return srv
+}
+
+// stopHealthServer shuts down a server started by startHealthServer.
+func stopHealthServer(srv *http.Server) {
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Error on stopping health server", "error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func get(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHealthz(t *testing.T) {
+	s := &discordgo.Session{}
+	server := httptest.NewServer(healthHandler(s))
+	defer server.Close()
+
+	if status := get(t, server.URL+"/healthz"); status != http.StatusServiceUnavailable {
+		t.Errorf("closed session is %d, want 503", status)
+	}
+
+	s.Lock()
+	s.DataReady = true
+	s.Unlock()
+	if status := get(t, server.URL+"/healthz"); status != http.StatusOK {
+		t.Errorf("open session is %d, want 200", status)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	server := httptest.NewServer(healthHandler(&discordgo.Session{}))
+	defer server.Close()
+	t.Cleanup(func() { ready.Store(false) })
+
+	if status := get(t, server.URL+"/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("before starting is %d, want 503", status)
+	}
+	ready.Store(true)
+	if status := get(t, server.URL+"/readyz"); status != http.StatusOK {
+		t.Errorf("once started is %d, want 200", status)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// sizeOrder is the order sizes are listed in the help.
+var sizeOrder = []string{"256", "512", "1024", "wide", "tall"}
+
+// availableSizes returns the size tokens model can generate.
+func availableSizes(model string) []string {
+	var sizes []string
+	for _, token := range sizeOrder {
+		for _, size := range modelSizes[model] {
+			if sizeTokens[token] == size {
+				sizes = append(sizes, token)
+			}
+		}
+	}
+	return sizes
+}
+
+// helpLines builds the help in locale from what the config has turned on,
+// one line per feature.
+func helpLines(locale string) []string {
+	p := commandPrefix()
+	model := currentModel()
+	emojis := statusEmojis()
+
+	lines := []string{tr(locale, "help.intro", p)}
+	sizes := availableSizes(model)
+	if len(sizes) > 1 {
+		lines = append(lines, tr(locale, "help.size", strings.Join(sizes, ", "), p, sizes[len(sizes)-1]))
+	}
+	if max := maxImages(model); max > 1 {
+		lines = append(lines, tr(locale, "help.count", max, p, sizes[len(sizes)-1]))
+	}
+	if takesStyle(model) {
+		lines = append(lines, tr(locale, "help.style", p))
+	}
+	if cfg().SupportsSeed {
+		lines = append(lines, tr(locale, "help.seed", p))
+	}
+	lines = append(lines, tr(locale, "help.negatives", p))
+	if cfg().HistoryDB != "" {
+		lines = append(lines, tr(locale, "help.styles", p), tr(locale, "help.collage", p, maxCollageCount))
+	}
+	lines = append(lines,
+		tr(locale, "help.spoiler", p),
+		tr(locale, "help.variation", p),
+	)
+	if cfg().EnableSlashCommands {
+		lines = append(lines, tr(locale, "help.slash"))
+	}
+	if cfg().AllowDMs {
+		lines = append(lines, tr(locale, "help.dms"))
+	}
+	if cfg().AllowBatch {
+		lines = append(lines, tr(locale, "help.batch", maxBatch(), p))
+	}
+
+	lines = append(lines,
+		tr(locale, "help.retry", emojiText(emojis.Retry)),
+		tr(locale, "help.queued"),
+		tr(locale, "help.working", emojiText(emojis.Working)),
+		tr(locale, "help.done", emojiText(emojis.Done)),
+		tr(locale, "help.upscale", upscaleEmoji),
+		tr(locale, "help.composition", compositionEmoji),
+		tr(locale, "help.delete"),
+	)
+	if cfg().EnablePromptCopy {
+		lines = append(lines, tr(locale, "help.copy"))
+	}
+	if cfg().EnableFavorites {
+		lines = append(lines, tr(locale, "help.favorite"))
+	}
+	lines = append(lines, tr(locale, "help.error", emojiText(emojis.Error)))
+	if cfg().ModerationEnabled || len(cfg().BlockedWords) > 0 || cfg().DailyQuota > 0 {
+		lines = append(lines, tr(locale, "help.blocked"))
+	}
+	if len(cfg().ActiveHours) > 0 {
+		lines = append(lines, tr(locale, "help.closed"))
+	}
+	return lines
+}
+
+// helpText is the help message in locale, which sendReplyChunked splits if
+// it grows past Discord's length limit.
+func helpText(locale string) string {
+	return strings.Join(helpLines(locale), "\n")
+}
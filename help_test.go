@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelpFollowsConfig(t *testing.T) {
+	useConfig(t, Config{Model: "dall-e-2"})
+	plain := helpText("en")
+	for _, key := range []string{"help.slash", "help.dms", "help.copy", "help.favorite", "help.blocked"} {
+		if line := tr("en", key); strings.Contains(plain, line) {
+			t.Errorf("default help has %q, want it only once turned on", line)
+		}
+	}
+
+	useConfig(t, Config{Model: "dall-e-2", EnableSlashCommands: true, AllowDMs: true, EnablePromptCopy: true, EnableFavorites: true, BlockedWords: []string{"gory"}})
+	full := helpText("en")
+	for _, key := range []string{"help.slash", "help.dms", "help.copy", "help.favorite", "help.blocked"} {
+		if line := tr("en", key); !strings.Contains(full, line) {
+			t.Errorf("help is missing %q with the feature turned on", line)
+		}
+	}
+}
+
+func TestHelpFollowsModel(t *testing.T) {
+	useConfig(t, Config{Model: "dall-e-3", CommandPrefix: "!img"})
+	help := helpText("en")
+	if !strings.Contains(help, tr("en", "help.style", "!img")) {
+		t.Error("help for dall-e-3 doesn't explain quality and style")
+	}
+	if strings.Contains(help, "256") {
+		t.Error("help for dall-e-3 offers 256, a size it can't make")
+	}
+	if strings.Contains(help, "/dalle") {
+		t.Error("help still says /dalle with the prefix changed")
+	}
+}
+
+func TestHelpUsesCustomEmojis(t *testing.T) {
+	useConfig(t, Config{StatusEmojis: &StatusEmojis{Working: "⚙️", Done: "done:456", Error: "👎", Retry: "🔄"}})
+	help := helpText("en")
+	for _, want := range []string{"⚙️", "<:done:456>", "👎", "🔄"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help doesn't mention %s", want)
+		}
+	}
+}
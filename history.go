@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/mdesson/disc-e/store"
+)
+
+// history keeps every request when HistoryDB is set, and is nil otherwise.
+var history *store.Store
+
+// openHistory opens the history database if one is configured.
+func openHistory() error {
+	if cfg().HistoryDB == "" {
+		return nil
+	}
+	s, err := store.Open(cfg().HistoryDB)
+	if err != nil {
+		return err
+	}
+	history = s
+	return nil
+}
+
+// recordHistory saves how a request turned out. Failures to save are only
+// logged. A cancelled request is still saved, so ctx being done doesn't stop
+// the insert.
+func recordHistory(ctx context.Context, imgReq *ImageRequest, urls []string, success bool) {
+	if history == nil {
+		return
+	}
+	err := history.Add(context.WithoutCancel(ctx), &store.Record{
+		AuthorID:  imgReq.AuthorID,
+		GuildID:   imgReq.GuildID,
+		ChannelID: imgReq.ChannelID,
+		Prompt:    imgReq.Prompt,
+		URLs:      urls,
+		Success:   success,
+	})
+	if err != nil {
+		logger(ctx).Error("Error saving history", "error", err)
+	}
+}
+
+// statsReply describes userID's usage for the stats command.
+func statsReply(ctx context.Context, userID string) string {
+	if history == nil {
+		return tr(localeFrom(ctx), "stats.off")
+	}
+	stats, err := history.Stats(ctx, userID)
+	if err != nil {
+		logger(ctx).Error("Error getting stats", "error", err)
+		return tr(localeFrom(ctx), "stats.error")
+	}
+	if stats.Total == 0 {
+		return tr(localeFrom(ctx), "stats.none")
+	}
+	rate := 100 * stats.Succeeded / stats.Total
+	return tr(localeFrom(ctx), "stats.summary", stats.Total, rate, stats.LastPrompt)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdesson/disc-e/store"
+)
+
+// useHistory keeps history in a fresh database for the rest of the test.
+func useHistory(t *testing.T) *store.Store {
+	s, err := store.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history = s
+	t.Cleanup(func() {
+		history = nil
+		s.Close()
+	})
+	return s
+}
+
+func TestCancelledRequestRecorded(t *testing.T) {
+	s := useHistory(t)
+
+	imgReq := &ImageRequest{AuthorID: "user", GuildID: testGuildID, ChannelID: "channel", Prompt: "a long wait"}
+	ctx, cancel := context.WithCancel(newRequestContext(context.Background(), imgReq))
+	cancel()
+	recordHistory(ctx, imgReq, nil, false)
+
+	records, err := s.ByAuthor(context.Background(), "user", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Prompt != "a long wait" || records[0].Success {
+		t.Errorf("history is %+v, want the cancelled request as a failure", records)
+	}
+}
+
+func TestStatsForUserWithHistory(t *testing.T) {
+	useHistory(t)
+	d, api := setupBot(t, Config{}, "stats")
+	onMessageHandler(d, d.post("stats", "user", "/dalle a red fox"))
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+	onMessageHandler(d, d.post("stats", "user", "/dalle a blue whale"))
+
+	onMessageHandler(d, d.post("stats", "user", "/dalle stats"))
+
+	sent := d.sentMessages()
+	if want := tr("en", "stats.summary", 2, 50, "a blue whale"); sent[len(sent)-1].Content != want {
+		t.Errorf("stats reply is %q, want %q", sent[len(sent)-1].Content, want)
+	}
+}
+
+func TestStatsForUserWithoutHistory(t *testing.T) {
+	useHistory(t)
+	d, _ := setupBot(t, Config{}, "nostats")
+
+	onMessageHandler(d, d.post("nostats", "newcomer", "/dalle stats"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != tr("en", "stats.none") {
+		t.Errorf("sent %+v, want the no-history reply", sent)
+	}
+}
+
+func TestStatsWithoutDatabase(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "statsoff")
+
+	onMessageHandler(d, d.post("statsoff", "user", "/dalle stats"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != tr("en", "stats.off") {
+		t.Errorf("sent %+v, want the history-off reply", sent)
+	}
+}
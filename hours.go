@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+	_ "time/tzdata" // the alpine image ships without zoneinfo
+)
+
+// ActiveHours is a daily window, in a guild's timezone, during which images
+// can be generated. End may be earlier than Start for windows that cross
+// midnight.
+type ActiveHours struct {
+	Start    string `json:"start"`    // "HH:MM"
+	End      string `json:"end"`      // "HH:MM"
+	Timezone string `json:"timezone"` // IANA name, defaults to UTC
+}
+
+// now is the clock used for time-based limits, swappable for tests.
+var now = time.Now
+
+// isOpen reports whether t falls inside the window.
+func (h ActiveHours) isOpen(t time.Time) (bool, error) {
+	loc, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		return false, err
+	}
+	start, err := time.Parse("15:04", h.Start)
+	if err != nil {
+		return false, fmt.Errorf("invalid start %q: %w", h.Start, err)
+	}
+	end, err := time.Parse("15:04", h.End)
+	if err != nil {
+		return false, fmt.Errorf("invalid end %q: %w", h.End, err)
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute, nil
+	}
+	// the window crosses midnight
+	return minute >= startMinute || minute < endMinute, nil
+}
+
+func (h ActiveHours) String() string {
+	tz := h.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	return fmt.Sprintf("%s to %s (%s)", h.Start, h.End, tz)
+}
+
+// outsideActiveHours returns the guild's active window when generation is
+// currently closed for userID in that guild. Admins are never restricted.
+func outsideActiveHours(guildID string, userID string) (ActiveHours, bool) {
+	hours, ok := cfg().ActiveHours[guildID]
+	if !ok || isAdmin(userID) {
+		return hours, false
+	}
+
+	open, err := hours.isOpen(now())
+	if err != nil {
+		slog.Warn("Ignoring active hours", "guild_id", guildID, "error", err)
+		return hours, false
+	}
+	return hours, !open
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// recordedExchange is one request/response pair as stored on disk. Request
+// headers aren't kept so credentials never end up in a recording.
+type recordedExchange struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"requestBody"`
+	StatusCode   int         `json:"statusCode"`
+	Header       http.Header `json:"header"`
+	ResponseBody string      `json:"responseBody"`
+}
+
+// exchangeFile names the recording for a request after its method, URL and
+// body, so replaying the same request finds the same file.
+func exchangeFile(dir string, req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	h.Write(body)
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))[:16]+".json")
+}
+
+// readRequestBody reads req's body and replaces it so it can still be sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// RecordingTransport passes requests through to Next and writes every
+// request/response pair to Dir for later replay.
+type RecordingTransport struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	exchange := recordedExchange{
+		Method:       req.Method,
+		URL:          req.URL.String(),
+		RequestBody:  string(body),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		ResponseBody: string(respBody),
+	}
+	b, err := json.MarshalIndent(exchange, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(exchangeFile(t.Dir, req, body), b, 0644)
+	}
+	if err != nil {
+		slog.Error("Error recording HTTP exchange", "method", req.Method, "url", req.URL.String(), "error", err)
+	}
+
+	return resp, nil
+}
+
+// ReplayTransport answers requests from the recordings in Dir without
+// touching the network, failing any request that wasn't recorded.
+type ReplayTransport struct {
+	Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(exchangeFile(t.Dir, req, body))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var exchange recordedExchange
+	if err := json.Unmarshal(b, &exchange); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(exchange.ResponseBody))),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}, nil
+}
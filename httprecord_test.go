@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestReplayedPrompt runs a whole request against the recording of a real
+// generation in testdata/replay.
+func TestReplayedPrompt(t *testing.T) {
+	useConfig(t, Config{HTTPReplayDir: "testdata/replay", ResponseFormat: "b64_json"})
+	d := newFakeDiscord()
+	d.addChannel("golden")
+	m := d.post("golden", "user", "/dalle a red fox in the snow")
+
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %+v, want the recorded image", sent)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+		t.Errorf("request shows %q, want ✅", got)
+	}
+}
+
+func TestReplayWithoutRecording(t *testing.T) {
+	useConfig(t, Config{HTTPReplayDir: "testdata/replay", ResponseFormat: "b64_json"})
+	d := newFakeDiscord()
+	d.addChannel("golden")
+	m := d.post("golden", "user", "/dalle something never recorded")
+
+	onMessageHandler(d, m)
+
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append([]byte(`{"echo": `), append(body, '}')...))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	send := func(transport http.RoundTripper) []byte {
+		t.Helper()
+		req, err := http.NewRequest("POST", server.URL+"/images/generations", bytes.NewBufferString(`"a cat"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return b
+	}
+
+	recorded := send(&RecordingTransport{Dir: dir})
+	server.Close()
+	replayed := send(&ReplayTransport{Dir: dir})
+	if !bytes.Equal(recorded, replayed) || string(replayed) != `{"echo": "a cat"}` {
+		t.Errorf("replayed %q after recording %q", replayed, recorded)
+	}
+}
@@ -0,0 +1,75 @@
+// Package imagegen generates images from text prompts. Generator is what the
+// bot depends on; OpenAI is the implementation that talks to the OpenAI API.
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Generator turns a prompt, an image to vary, or an image and mask to edit
+// into generated images.
+type Generator interface {
+	Generate(ctx context.Context, prompt string, opts Options) ([]Image, error)
+	Vary(ctx context.Context, png []byte, opts Options) ([]Image, error)
+	Edit(ctx context.Context, png []byte, mask []byte, prompt string, opts Options) ([]Image, error)
+}
+
+// Image is one generated image, linked by URL or, with the b64_json
+// ResponseFormat, carried in Data.
+type Image struct {
+	URL  string
+	Data []byte
+	// RevisedPrompt is the prompt the model actually drew, for models such
+	// as dall-e-3 that rewrite prompts. It's empty otherwise.
+	RevisedPrompt string
+}
+
+// Options are the settings for one generation.
+type Options struct {
+	Model string
+	Size  string
+	// N is how many images to generate, 0 meaning 1.
+	N int
+	// Quality and Style are only sent by Generate when set, for models
+	// that take them.
+	Quality string
+	Style   string
+	// Seed, when set, is sent for backends that take one to make results
+	// repeatable. OpenAI's own API doesn't.
+	Seed *int64
+	// Strength, when set, is sent with edits for backends that redraw a
+	// whole image from it, from 0 keeping the image as it is to 1 ignoring
+	// it. OpenAI's own API doesn't take it.
+	Strength *float64
+	// ResponseFormat is "url" or "b64_json", sent only when set. OpenAI
+	// returns URLs by default.
+	ResponseFormat string
+	// IdempotencyKey is sent with the request so a retried call isn't
+	// generated and billed twice. Each generation needs its own.
+	IdempotencyKey string
+}
+
+// ErrEmptyResult is returned when the API responds successfully but without
+// an image.
+var ErrEmptyResult = errors.New("no image in the response")
+
+// APIError is the error envelope OpenAI returns with failed requests.
+type APIError struct {
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("openai: %s (status %d, type %s, code %s)", e.Message, e.StatusCode, e.Type, e.Code)
+}
+
+// Rejected reports whether the prompt itself was refused by OpenAI's safety
+// system, as opposed to the request failing for some other reason. Other
+// invalid requests, like a bad size, aren't a verdict on the prompt.
+func (e *APIError) Rejected() bool {
+	return e.Code == "content_policy_violation" || e.Code == "moderation_blocked"
+}
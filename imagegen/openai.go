@@ -0,0 +1,277 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultBaseURL is the OpenAI API root used when OpenAI.BaseURL is empty.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// OpenAI generates images with the OpenAI images API.
+type OpenAI struct {
+	// BaseURL is the API root, defaulting to DefaultBaseURL.
+	BaseURL string
+	// Client sends every request, defaulting to http.DefaultClient.
+	Client *http.Client
+	// Authorize adds credentials to each request. The request's context is
+	// the one passed to Generate.
+	Authorize func(req *http.Request)
+	// Timeout bounds each attempt, 0 meaning no limit beyond the context.
+	Timeout time.Duration
+	// MaxRetries is how many more attempts are made after a 429, 5xx or
+	// timeout.
+	MaxRetries int
+	// Limiter, when set, paces every attempt, retries included, so the bot
+	// stays under the account's rate limit however many users it has.
+	Limiter *rate.Limiter
+	// Logf, when set, receives progress messages such as retries.
+	Logf func(ctx context.Context, format string, args ...interface{})
+}
+
+// generationRequest is the body sent to the generations endpoint.
+type generationRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	N      int    `json:"n"`
+	Size   string `json:"size"`
+
+	Quality string `json:"quality,omitempty"`
+	Style   string `json:"style,omitempty"`
+	Seed    *int64 `json:"seed,omitempty"`
+
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+type generationResponse struct {
+	Created int                 `json:"created"`
+	Data    []map[string]string `json:"data"`
+	Error   *APIError           `json:"error"`
+}
+
+// GenerationBody returns the JSON body Generate sends for prompt and opts.
+func GenerationBody(prompt string, opts Options) ([]byte, error) {
+	n := opts.N
+	if n < 1 {
+		n = 1
+	}
+	return json.Marshal(generationRequest{Model: opts.Model, Prompt: prompt, N: n, Size: opts.Size, Quality: opts.Quality, Style: opts.Style, Seed: opts.Seed, ResponseFormat: opts.ResponseFormat})
+}
+
+// Generate asks the generations endpoint for opts.N images of prompt.
+func (o *OpenAI) Generate(ctx context.Context, prompt string, opts Options) ([]Image, error) {
+	jsonBytes, err := GenerationBody(prompt, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// retry transient failures, the idempotency key keeps a retry from
+	// generating a second image
+	url := o.baseURL() + "/images/generations"
+	status, b, err := o.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if o.Authorize != nil {
+			o.Authorize(req)
+		}
+		if opts.IdempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", opts.IdempotencyKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return o.parseResponse(ctx, status, b)
+}
+
+// Vary asks the variations endpoint for opts.N variations of a PNG image.
+func (o *OpenAI) Vary(ctx context.Context, png []byte, opts Options) ([]Image, error) {
+	files := map[string][]byte{"image": png}
+	return o.postForm(ctx, "/images/variations", files, o.formFields("", opts), opts)
+}
+
+// Edit asks the edits endpoint to redraw the transparent areas of mask in a
+// PNG image following prompt. Both must be PNGs of the same size. Without a
+// mask the image's own transparent areas are redrawn, or with
+// Options.Strength all of it.
+func (o *OpenAI) Edit(ctx context.Context, png []byte, mask []byte, prompt string, opts Options) ([]Image, error) {
+	files := map[string][]byte{"image": png}
+	if mask != nil {
+		files["mask"] = mask
+	}
+	return o.postForm(ctx, "/images/edits", files, o.formFields(prompt, opts), opts)
+}
+
+// formFields are the text fields of a variations or edits form.
+func (o *OpenAI) formFields(prompt string, opts Options) [][2]string {
+	n := opts.N
+	if n < 1 {
+		n = 1
+	}
+	fields := [][2]string{{"prompt", prompt}, {"model", opts.Model}, {"n", strconv.Itoa(n)}, {"size", opts.Size}}
+	if opts.Seed != nil {
+		fields = append(fields, [2]string{"seed", strconv.FormatInt(*opts.Seed, 10)})
+	}
+	if opts.Strength != nil {
+		fields = append(fields, [2]string{"strength", strconv.FormatFloat(*opts.Strength, 'f', -1, 64)})
+	}
+	return append(fields, [2]string{"response_format", opts.ResponseFormat})
+}
+
+// postForm sends files and fields as a multipart form to path.
+func (o *OpenAI) postForm(ctx context.Context, path string, files map[string][]byte, fields [][2]string, opts Options) ([]Image, error) {
+	body, contentType, err := formBody(files, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	url := o.baseURL() + path
+	status, b, err := o.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", contentType)
+		if o.Authorize != nil {
+			o.Authorize(req)
+		}
+		if opts.IdempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", opts.IdempotencyKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return o.parseResponse(ctx, status, b)
+}
+
+// formBody builds a multipart form of PNG files and text fields, skipping
+// empty fields, and returns it with its Content-Type.
+func formBody(files map[string][]byte, fields [][2]string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	// map order is random, keep the parts in a fixed order
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		part, err := w.CreateFormFile(name, name+".png")
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(files[name]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// parseResponse extracts the images from an images API response.
+func (o *OpenAI) parseResponse(ctx context.Context, status int, b []byte) ([]Image, error) {
+	var r generationResponse
+	err := json.Unmarshal(b, &r)
+	if r.Error != nil {
+		r.Error.StatusCode = status
+		return nil, r.Error
+	}
+	// a failure without OpenAI's error body, such as a proxy's 401 page,
+	// still has to come back as an API error
+	if status < 200 || status > 299 {
+		return nil, &APIError{StatusCode: status, Message: bodySnippet(b)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	for _, d := range r.Data {
+		img := Image{URL: d["url"], RevisedPrompt: d["revised_prompt"]}
+		if d["b64_json"] != "" {
+			if img.Data, err = base64.StdEncoding.DecodeString(d["b64_json"]); err != nil {
+				return nil, fmt.Errorf("decoding b64_json image: %w", err)
+			}
+		}
+		if img.URL == "" && len(img.Data) == 0 {
+			continue
+		}
+		images = append(images, img)
+		if revised := d["revised_prompt"]; revised != "" {
+			o.logf(ctx, "Revised prompt: %s", revised)
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrEmptyResult
+	}
+
+	return images, nil
+}
+
+// maxSnippet is how much of an unexpected response body goes in an error.
+const maxSnippet = 200
+
+// bodySnippet returns the start of a response body for an error message.
+func bodySnippet(b []byte) string {
+	snippet := strings.TrimSpace(string(b))
+	if len(snippet) > maxSnippet {
+		snippet = strings.ToValidUTF8(snippet[:maxSnippet], "") + "…"
+	}
+	if snippet == "" {
+		return "empty response"
+	}
+	return snippet
+}
+
+func (o *OpenAI) baseURL() string {
+	if o.BaseURL != "" {
+		return o.BaseURL
+	}
+	return DefaultBaseURL
+}
+
+func (o *OpenAI) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+func (o *OpenAI) logf(ctx context.Context, format string, args ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(ctx, format, args...)
+	}
+}
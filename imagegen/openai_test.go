@@ -0,0 +1,322 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// answering returns an OpenAI whose every call gets status and body.
+func answering(t *testing.T, status int, body string) *OpenAI {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return &OpenAI{BaseURL: server.URL}
+}
+
+func TestGenerationBodyEscapesPrompt(t *testing.T) {
+	prompt := "a cat saying \"hello\"\n\\ and goodbye"
+	b, err := GenerationBody(prompt, Options{Model: "dall-e-2", Size: "512x512"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(b) {
+		t.Fatalf("body %s isn't valid JSON", b)
+	}
+	var body generationRequest
+	if err := json.Unmarshal(b, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Prompt != prompt || body.N != 1 || body.Size != "512x512" {
+		t.Errorf("body decodes to %+v, want the prompt back with n 1 and size 512x512", body)
+	}
+}
+
+func TestGenerateModerationError(t *testing.T) {
+	o := answering(t, http.StatusBadRequest, `{"error": {"message": "Your request was rejected as a result of our safety system.", "type": "invalid_request_error", "code": "content_policy_violation"}}`)
+
+	_, err := o.Generate(context.Background(), "something forbidden", Options{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want an APIError", err)
+	}
+	if !apiErr.Rejected() || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %+v, want a rejected prompt with status 400", apiErr)
+	}
+	if !strings.Contains(err.Error(), "rejected as a result of our safety system") {
+		t.Errorf("error %q doesn't say why", err)
+	}
+}
+
+func TestGenerateInvalidRequestNotRejected(t *testing.T) {
+	o := answering(t, http.StatusBadRequest, `{"error": {"message": "'1000x1000' is not one of ['256x256', '512x512', '1024x1024'] - 'size'", "type": "invalid_request_error", "code": null}}`)
+
+	_, err := o.Generate(context.Background(), "a fox", Options{Size: "1000x1000"})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want an APIError", err)
+	}
+	if apiErr.Rejected() {
+		t.Errorf("a bad size counts as a rejected prompt: %+v", apiErr)
+	}
+}
+
+func TestGenerateEmptyData(t *testing.T) {
+	o := answering(t, http.StatusOK, `{"created": 1, "data": []}`)
+
+	if _, err := o.Generate(context.Background(), "a fox", Options{}); !errors.Is(err, ErrEmptyResult) {
+		t.Errorf("got %v, want ErrEmptyResult", err)
+	}
+}
+
+func TestGenerateErrorWithoutEnvelope(t *testing.T) {
+	o := answering(t, http.StatusUnauthorized, "<html>401 Authorization Required</html>")
+
+	_, err := o.Generate(context.Background(), "a fox", Options{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "<html>401 Authorization Required</html>" {
+		t.Errorf("got %v, want an APIError with the status and body", err)
+	}
+}
+
+func TestGenerateStatuses(t *testing.T) {
+	for _, c := range []struct {
+		status  int
+		body    string
+		code    string
+		message string
+	}{
+		{http.StatusUnauthorized, `{"error": {"message": "Incorrect API key provided.", "type": "invalid_request_error", "code": "invalid_api_key"}}`, "invalid_api_key", "Incorrect API key provided."},
+		{http.StatusTooManyRequests, `{"error": {"message": "Rate limit reached for images per minute.", "type": "requests", "code": "rate_limit_exceeded"}}`, "rate_limit_exceeded", "Rate limit reached for images per minute."},
+		{http.StatusOK, `{"created": 1, "data": [{"url": "https://images.example.com/fox.png"}]}`, "", ""},
+	} {
+		o := answering(t, c.status, c.body)
+
+		images, err := o.Generate(context.Background(), "a fox", Options{})
+
+		if c.status == http.StatusOK {
+			if err != nil || len(images) != 1 || images[0].URL != "https://images.example.com/fox.png" {
+				t.Errorf("status 200 got %+v, %v, want the image", images, err)
+			}
+			continue
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != c.status || apiErr.Code != c.code || apiErr.Message != c.message {
+			t.Errorf("status %d got %v, want an APIError with the status, code and message", c.status, err)
+		}
+		if images != nil {
+			t.Errorf("status %d returned images %+v alongside the error", c.status, images)
+		}
+	}
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	o := &OpenAI{BaseURL: server.URL, Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := o.Generate(context.Background(), "a fox", Options{})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v, want about the timeout", elapsed)
+	}
+}
+
+func TestGenerateReturnsEveryImage(t *testing.T) {
+	o := answering(t, http.StatusOK, `{"created": 1, "data": [{"url": "https://images.example.com/1.png"}, {"url": "https://images.example.com/2.png"}, {"url": "https://images.example.com/3.png"}]}`)
+
+	images, err := o.Generate(context.Background(), "a sunset", Options{N: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 {
+		t.Fatalf("got %d images, want 3", len(images))
+	}
+	for i, img := range images {
+		if want := fmt.Sprintf("https://images.example.com/%d.png", i+1); img.URL != want {
+			t.Errorf("image %d is %q, want %q", i, img.URL, want)
+		}
+	}
+}
+
+// onePixelPNG is a 1x1 PNG, base64 encoded as OpenAI sends it.
+const onePixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg=="
+
+func TestGenerateBase64(t *testing.T) {
+	o := answering(t, http.StatusOK, `{"created": 1, "data": [{"b64_json": "`+onePixelPNG+`"}]}`)
+
+	images, err := o.Generate(context.Background(), "a single pixel", Options{ResponseFormat: "b64_json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].URL != "" {
+		t.Fatalf("got %+v, want one image carried in the response", images)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(images[0].Data))
+	if err != nil {
+		t.Fatalf("decoded data isn't a PNG: %v", err)
+	}
+	if config.Width != 1 || config.Height != 1 {
+		t.Errorf("decoded a %dx%d image, want 1x1", config.Width, config.Height)
+	}
+}
+
+func TestGenerateBadBase64(t *testing.T) {
+	o := answering(t, http.StatusOK, `{"created": 1, "data": [{"b64_json": "not base64!"}]}`)
+
+	if _, err := o.Generate(context.Background(), "a fox", Options{ResponseFormat: "b64_json"}); err == nil || !strings.Contains(err.Error(), "b64_json") {
+		t.Errorf("got %v, want a decoding error", err)
+	}
+}
+
+func TestGenerationBodyResponseFormat(t *testing.T) {
+	for format, want := range map[string]bool{"": false, "url": true, "b64_json": true} {
+		b, err := GenerationBody("a fox", Options{ResponseFormat: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Fatal(err)
+		}
+		if got, sent := body["response_format"]; sent != want || (want && got != format) {
+			t.Errorf("body for %q has response_format %v, want it sent %v", format, got, want)
+		}
+	}
+}
+
+// receivingForm returns an OpenAI whose calls are answered with one image
+// and the multipart forms they sent.
+func receivingForm(t *testing.T) (*OpenAI, func() (string, *multipart.Form)) {
+	var mu sync.Mutex
+	var path string
+	var form *multipart.Form
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		path, form = r.URL.Path, r.MultipartForm
+		mu.Unlock()
+		io.WriteString(w, `{"created": 1, "data": [{"url": "https://images.example.com/1.png"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return &OpenAI{BaseURL: server.URL}, func() (string, *multipart.Form) {
+		mu.Lock()
+		defer mu.Unlock()
+		return path, form
+	}
+}
+
+// formFile reads the file sent as name in form.
+func formFile(t *testing.T, form *multipart.Form, name string) (filename string, data string) {
+	t.Helper()
+	if len(form.File[name]) != 1 {
+		t.Fatalf("form has %d %s files, want 1", len(form.File[name]), name)
+	}
+	fh := form.File[name][0]
+	f, err := fh.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fh.Filename, string(b)
+}
+
+func TestVaryForm(t *testing.T) {
+	o, sent := receivingForm(t)
+
+	seed := int64(42)
+	if _, err := o.Vary(context.Background(), []byte("png bytes"), Options{Model: "dall-e-2", N: 2, Size: "512x512", Seed: &seed}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, form := sent()
+	if path != "/images/variations" {
+		t.Errorf("posted to %s, want /images/variations", path)
+	}
+	if name, data := formFile(t, form, "image"); name != "image.png" || data != "png bytes" {
+		t.Errorf("image part is %s holding %q, want image.png with the PNG", name, data)
+	}
+	if len(form.File["mask"]) != 0 {
+		t.Error("variation sent a mask, want just the image")
+	}
+	want := map[string][]string{"model": {"dall-e-2"}, "n": {"2"}, "size": {"512x512"}, "seed": {"42"}}
+	if !reflect.DeepEqual(form.Value, want) {
+		t.Errorf("fields are %v, want %v with empty ones left out", form.Value, want)
+	}
+}
+
+func TestEditForm(t *testing.T) {
+	o, sent := receivingForm(t)
+
+	if _, err := o.Edit(context.Background(), []byte("image bytes"), []byte("mask bytes"), "add a hat", Options{Model: "dall-e-2", Size: "1024x1024"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, form := sent()
+	if path != "/images/edits" {
+		t.Errorf("posted to %s, want /images/edits", path)
+	}
+	if name, data := formFile(t, form, "image"); name != "image.png" || data != "image bytes" {
+		t.Errorf("image part is %s holding %q, want image.png with the image", name, data)
+	}
+	if name, data := formFile(t, form, "mask"); name != "mask.png" || data != "mask bytes" {
+		t.Errorf("mask part is %s holding %q, want mask.png with the mask", name, data)
+	}
+	want := map[string][]string{"prompt": {"add a hat"}, "model": {"dall-e-2"}, "n": {"1"}, "size": {"1024x1024"}}
+	if !reflect.DeepEqual(form.Value, want) {
+		t.Errorf("fields are %v, want %v", form.Value, want)
+	}
+}
+
+func TestEditWithStrength(t *testing.T) {
+	o, sent := receivingForm(t)
+
+	strength := 0.35
+	if _, err := o.Edit(context.Background(), []byte("image bytes"), nil, "a red fox", Options{Model: "sdxl", Size: "1024x1024", Strength: &strength}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, form := sent()
+	if len(form.File["mask"]) != 0 {
+		t.Error("edit sent a mask, want just the image")
+	}
+	want := map[string][]string{"prompt": {"a red fox"}, "model": {"sdxl"}, "n": {"1"}, "size": {"1024x1024"}, "strength": {"0.35"}}
+	if !reflect.DeepEqual(form.Value, want) {
+		t.Errorf("fields are %v, want %v", form.Value, want)
+	}
+}
@@ -0,0 +1,115 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryableStatus reports whether a response status is a transient failure
+// worth retrying. Anything else, such as a 400 for a rejected prompt, fails
+// straight away.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// retryDelay is how long to wait before the retry following attempt, which
+// counts from 0. A Retry-After header in seconds or as a date wins over the
+// exponential backoff, which is jittered between half and all of its delay
+// so clients that failed together don't retry together.
+func retryDelay(attempt int, header http.Header) time.Duration {
+	delay := retryBaseDelay << attempt
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if after := header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+			delay = time.Duration(secs) * time.Second
+		} else if at, err := http.ParseTime(after); err == nil {
+			delay = time.Until(at)
+		}
+	}
+
+	switch {
+	case delay < 0:
+		return 0
+	case delay > retryMaxDelay:
+		return retryMaxDelay
+	}
+	return delay
+}
+
+// doWithRetry sends the request built by newRequest, making up to MaxRetries
+// more attempts on 429s, 5xx errors and timeouts. Each attempt gets its own
+// Timeout. It returns the last response's status and body.
+func (o *OpenAI) doWithRetry(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		status, header, body, err := o.doAttempt(ctx, newRequest)
+
+		retry := false
+		switch {
+		case err != nil:
+			var netErr net.Error
+			retry = errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil
+		default:
+			retry = retryableStatus(status)
+		}
+		if !retry || attempt >= o.MaxRetries {
+			return status, body, err
+		}
+
+		wait := retryDelay(attempt, header)
+		if err != nil {
+			o.logf(ctx, "Attempt %d failed with %v, retrying in %v", attempt+1, err, wait)
+		} else {
+			o.logf(ctx, "Attempt %d failed with status %d, retrying in %v", attempt+1, status, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+func (o *OpenAI) doAttempt(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (int, http.Header, []byte, error) {
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	if o.Limiter != nil {
+		if err := o.Limiter.Wait(ctx); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
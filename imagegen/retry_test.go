@@ -0,0 +1,134 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// answeringInTurn returns an OpenAI whose calls get statuses in order, each
+// failure saying to retry straight away, and the keys each call carried.
+func answeringInTurn(t *testing.T, statuses ...int) (*OpenAI, func() []string) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := statuses[len(keys)]
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+
+		if status != http.StatusOK {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			io.WriteString(w, `{"error": {"message": "The server is overloaded.", "type": "server_error"}}`)
+			return
+		}
+		io.WriteString(w, `{"created": 1, "data": [{"url": "https://images.example.com/fox.png"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return &OpenAI{BaseURL: server.URL}, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), keys...)
+	}
+}
+
+func TestGenerateRetriesUnavailable(t *testing.T) {
+	o, calls := answeringInTurn(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+	o.MaxRetries = 2
+
+	images, err := o.Generate(context.Background(), "a fox", Options{IdempotencyKey: "req-1"})
+
+	if err != nil {
+		t.Fatalf("got %v, want the third attempt to succeed", err)
+	}
+	if len(images) != 1 || images[0].URL != "https://images.example.com/fox.png" {
+		t.Errorf("got %+v, want the image from the third attempt", images)
+	}
+	keys := calls()
+	if len(keys) != 3 {
+		t.Fatalf("made %d attempts, want 3", len(keys))
+	}
+	for i, key := range keys {
+		if key != "req-1" {
+			t.Errorf("attempt %d sent idempotency key %q, want every retry to send req-1", i+1, key)
+		}
+	}
+}
+
+func TestGenerateGivesUpAfterMaxRetries(t *testing.T) {
+	o, calls := answeringInTurn(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+	o.MaxRetries = 1
+
+	_, err := o.Generate(context.Background(), "a fox", Options{})
+
+	if err == nil {
+		t.Fatal("got no error, want the second 503 returned")
+	}
+	if n := len(calls()); n != 2 {
+		t.Errorf("made %d attempts, want 2", n)
+	}
+}
+
+func TestLimiterPacesBurst(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		io.WriteString(w, `{"created": 1, "data": [{"url": "https://images.example.com/fox.png"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	interval := 20 * time.Millisecond
+	o := &OpenAI{BaseURL: server.URL, Limiter: rate.NewLimiter(rate.Every(interval), 1)}
+
+	const burst = 5
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := o.Generate(context.Background(), "a fox", Options{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(arrivals) != burst {
+		t.Fatalf("server saw %d calls, want %d", len(arrivals), burst)
+	}
+	first, last := arrivals[0], arrivals[0]
+	for _, at := range arrivals {
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	// allow for the clock's granularity, but not for a second call at once
+	if spread, want := last.Sub(first), (burst-1)*interval*9/10; spread < want {
+		t.Errorf("%d calls came within %v, want them paced over at least %v", burst, spread, want)
+	}
+}
+
+func TestLimiterGivesUpWithContext(t *testing.T) {
+	o := answering(t, http.StatusOK, `{"created": 1, "data": [{"url": "https://images.example.com/fox.png"}]}`)
+	o.Limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	o.Limiter.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := o.Generate(ctx, "a fox", Options{}); err == nil || errors.Is(err, ErrEmptyResult) {
+		t.Errorf("Generate waiting on an exhausted limiter returned %v, want it to give up", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+// inFlight counts the generations each user currently has running.
+type inFlight struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var userInFlight = &inFlight{counts: make(map[string]int)}
+
+// rerollsInFlight is keyed by the message re-rolled, so reactions that land
+// together on one message start a single generation.
+var rerollsInFlight = &inFlight{counts: make(map[string]int)}
+
+// acquire reserves a slot for userID, returning false when they already
+// have max requests running. A max of 0 means no limit.
+func (f *inFlight) acquire(userID string, max int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if max > 0 && f.counts[userID] >= max {
+		return false
+	}
+	f.counts[userID]++
+	return true
+}
+
+// release frees a slot taken by acquire.
+func (f *inFlight) release(userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.counts[userID]--
+	if f.counts[userID] <= 0 {
+		delete(f.counts, userID)
+	}
+}
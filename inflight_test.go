@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInFlightConcurrentAcquire(t *testing.T) {
+	f := &inFlight{counts: make(map[string]int)}
+
+	var wg sync.WaitGroup
+	var granted atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f.acquire("user", 3) {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := granted.Load(); n != 3 {
+		t.Fatalf("%d of 50 concurrent requests got a slot, want 3", n)
+	}
+	f.release("user")
+	if !f.acquire("user", 3) {
+		t.Error("no slot after one was released")
+	}
+	if !f.acquire("other", 3) {
+		t.Error("another user was refused, want each user counted on their own")
+	}
+}
+
+func TestInFlightRefusesSecondPrompt(t *testing.T) {
+	d, api := setupBot(t, Config{MaxInFlightPerUser: 1}, "busy")
+	release := api.hang()
+	defer release()
+
+	done := make(chan struct{})
+	go func() {
+		onMessageHandler(d, d.post("busy", "user", "/dalle a slow fox"))
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); len(api.generated()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("first request never reached OpenAI")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	m := d.post("busy", "user", "/dalle another fox")
+	onMessageHandler(d, m)
+
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"⏳"}) {
+		t.Errorf("second request shows %q, want ⏳", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, "You already have 1 requests in progress") {
+		t.Errorf("sent %+v, want a reply saying the user is busy", sent)
+	}
+
+	release()
+	<-done
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want only the first prompt", got)
+	}
+	onMessageHandler(d, d.post("busy", "user", "/dalle a third fox"))
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %q, want the user's slot back once the first finished", got)
+	}
+}
+
+func TestConcurrentRerollsCollapse(t *testing.T) {
+	d, api := setupBot(t, Config{}, "doubletap")
+	onMessageHandler(d, d.post("doubletap", "user", "/dalle a blue whale"))
+	first := d.sentMessages()[0]
+	release := api.hang()
+	t.Cleanup(release)
+
+	var wg sync.WaitGroup
+	for _, userID := range []string{"user", "someone"} {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			onEmojiAddHandler(d, d.react("doubletap", first.ID, userID, "🔁"))
+		}(userID)
+	}
+	for deadline := time.Now().Add(time.Second); len(api.generated()) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("re-roll never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// give the other reaction time to start a second one if it would
+	time.Sleep(20 * time.Millisecond)
+	release()
+	wg.Wait()
+
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %d times, want the two 🔁 collapsed into one re-roll", len(got)-1)
+	}
+
+	// once it's done the message can be re-rolled again
+	onEmojiAddHandler(d, d.react("doubletap", first.ID, "user", "🔁"))
+	if got := api.generated(); len(got) != 3 {
+		t.Errorf("generated %d times, want another re-roll after the first finished", len(got)-1)
+	}
+}
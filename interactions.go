@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+)
+
+// dalleCommand is the /dalle application command, which gets Discord's own
+// argument UI rather than parsing a text message.
+var dalleCommand = &discordgo.ApplicationCommand{
+	Name:        "dalle",
+	Description: "Generate an image from a prompt",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "prompt",
+			Description:  "What to draw",
+			Required:     true,
+			Autocomplete: true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "size",
+			Description: "Image size",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "256", Value: "256"},
+				{Name: "512", Value: "512"},
+				{Name: "1024", Value: "1024"},
+				{Name: "wide (dall-e-3)", Value: "wide"},
+				{Name: "tall (dall-e-3)", Value: "tall"},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "quality",
+			Description: "Image quality (dall-e-3)",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "standard", Value: "standard"},
+				{Name: "hd", Value: "hd"},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "style",
+			Description: "Image style (dall-e-3)",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "vivid", Value: "vivid"},
+				{Name: "natural", Value: "natural"},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "spoiler",
+			Description: "Hide the image behind a spoiler",
+		},
+	},
+}
+
+// registerCommands registers the application commands globally. The session
+// must be open.
+func registerCommands(s session) error {
+	allowDMs := cfg().AllowDMs
+	dalleCommand.DMPermission = &allowDMs
+	_, err := s.ApplicationCommandBulkOverwrite(s.botID(), "", []*discordgo.ApplicationCommand{dalleCommand})
+	return err
+}
+
+func onInteractionHandler(s session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		switch customID := i.MessageComponentData().CustomID; {
+		case strings.HasPrefix(customID, refinePrefix):
+			openRefine(s, i)
+		case strings.HasPrefix(customID, againPrefix):
+			makeAgain(s, i)
+		}
+		return
+	case discordgo.InteractionModalSubmit:
+		if strings.HasPrefix(i.ModalSubmitData().CustomID, refinePrefix) {
+			submitRefine(s, i)
+		}
+		return
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		if i.ApplicationCommandData().Name == dalleCommand.Name {
+			answerAutocomplete(s, i)
+		}
+		return
+	}
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != dalleCommand.Name {
+		return
+	}
+
+	// generation takes longer than the three seconds Discord waits for an
+	// answer, so acknowledge now and edit in the result later
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		slog.Error("Error on acknowledging interaction", "interaction_id", i.ID, "error", err)
+		return
+	}
+	handleDalleCommand(s, i)
+}
+
+// handleDalleCommand generates the image for an acknowledged /dalle command
+// and edits it into the response.
+func handleDalleCommand(s session, i *discordgo.InteractionCreate) {
+	var opts commandOptions
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "prompt":
+			opts.prompt = opt.StringValue()
+		case "size":
+			opts.size = opt.StringValue()
+		case "quality":
+			opts.quality = opt.StringValue()
+		case "style":
+			opts.style = opt.StringValue()
+		case "spoiler":
+			opts.spoiler = opt.BoolValue()
+		}
+	}
+	answerCommand(s, i, opts)
+}
+
+// answerCommand generates the image for the acknowledged interaction i
+// with opts, be it a /dalle command or a refined one, and edits it into the
+// response.
+func answerCommand(s session, i *discordgo.InteractionCreate, opts commandOptions) {
+	prompt := sanitizePrompt(normalizePrompt(strings.ToLower(opts.prompt)), nil)
+	model, autoModel, prompt := requestModel(i.GuildID, prompt)
+	size := sizeTokens[opts.size]
+	quality := qualityTokens[opts.quality]
+	if quality == "" {
+		quality = cfg().DefaultQuality
+	}
+	style := styleTokens[opts.style]
+	if style == "" {
+		style = cfg().DefaultStyle
+	}
+	spoiler := opts.spoiler
+
+	author := interactionAuthor(i)
+
+	imgReq := ImageRequest{
+		RequestID: uuid.NewString(),
+		ID:        i.ID,
+		Prompt:    prompt,
+		AuthorID:  author.ID,
+		Model:     model,
+		AutoModel: autoModel,
+		Size:      resolveSize(model, size),
+		Quality:   quality,
+		Style:     style,
+		Spoiler:   spoiler,
+		Locale:    promptLocale(prompt, localeFor(i.Locale)),
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		// an interaction is delivered once, so its ID names the generation
+		IdempotencyKey: i.ID,
+	}
+	ctx := newRequestContext(context.Background(), &imgReq)
+
+	respond := func(content string) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			logger(ctx).Error("Error on editing interaction response", "error", err)
+		}
+	}
+
+	if reply, ok := styleCommand(ctx, imgReq.Locale, imgReq.AuthorID, prompt); ok {
+		respond(reply)
+		return
+	}
+	expanded, err := expandStyles(ctx, imgReq.Locale, imgReq.AuthorID, prompt)
+	if err != nil {
+		respond(err.Error())
+		return
+	}
+	// what to leave out is split off once the styles are filled in
+	prompt = applyNegatives(expanded)
+	imgReq.Prompt = prompt
+
+	if prompt == "stats --chart" {
+		chart, reply := statsChart(ctx, imgReq.AuthorID)
+		if chart == nil {
+			respond(reply)
+			return
+		}
+		if _, err := editDescribed(s, i.Interaction, &discordgo.WebhookEdit{Content: &reply}, []*imageFile{chart}); err != nil {
+			logger(ctx).Error("Error sending stats chart", "error", err)
+		}
+		return
+	}
+	if count, ok := parseCollage(prompt); ok {
+		sheet, reply := collage(ctx, imgReq.AuthorID, count)
+		if sheet == nil {
+			respond(reply)
+			return
+		}
+		if _, err := editDescribed(s, i.Interaction, &discordgo.WebhookEdit{Content: &reply}, []*imageFile{sheet}); err != nil {
+			logger(ctx).Error("Error sending collage", "error", err)
+		}
+		return
+	}
+	if reply, ok := promptReply(ctx, &imgReq); ok {
+		respond(reply)
+		return
+	}
+	if rest, ok := strings.CutPrefix(prompt, "preview "); ok {
+		imgReq.Prompt = rest
+		respond(previewReply(&imgReq))
+		return
+	}
+	if prompt == "redo" {
+		previous, ok := recentRequests.last(imgReq.AuthorID)
+		if !ok {
+			respond(tr(imgReq.Locale, "refuse.noRedo"))
+			return
+		}
+		imgReq.redo(previous)
+		// refining it starts from what was redone
+		opts.prompt = imgReq.Prompt
+	}
+	if !allowedMember(s, imgReq.GuildID, i.Member, imgReq.AuthorID) {
+		respond(tr(imgReq.Locale, "refuse.role"))
+		return
+	}
+	if reason, ok := commandRefusal(ctx, s, &imgReq); !ok {
+		respond(reason)
+		return
+	}
+	if !userInFlight.acquire(imgReq.AuthorID, cfg().MaxInFlightPerUser) {
+		respond(tr(imgReq.Locale, "refuse.inFlight", cfg().MaxInFlightPerUser))
+		return
+	}
+	defer userInFlight.release(imgReq.AuthorID)
+	if !dailyQuota.take(imgReq.AuthorID, imgReq.count(), cfg().DailyQuota) {
+		respond(tr(imgReq.Locale, "refuse.quota", cfg().DailyQuota))
+		return
+	}
+
+	if !activeRequests.start(imgReq.ChannelID, imgReq.ID) {
+		respond(tr(imgReq.Locale, "refuse.shutdown"))
+		return
+	}
+	defer activeRequests.done(imgReq.ChannelID, imgReq.ID)
+
+	// the requester can stop it with ⏹️ on the response while it waits
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if response, err := s.InteractionResponse(i.Interaction); err == nil {
+		defer runningRequests.add(response.ID, imgReq.AuthorID, cancel)()
+	}
+	// the response says where the request is in line until it's made
+	var report func(int)
+	if cfg().ShowQueuePosition {
+		report = func(position int) { respond(tr(imgReq.Locale, "reply.queuePos", position)) }
+	}
+	if !queueSlot(ctx, report) {
+		if activeRequests.closingDown() {
+			respond(tr(imgReq.Locale, "refuse.shutdown"))
+		} else {
+			respond(tr(imgReq.Locale, "reply.cancelled"))
+		}
+		return
+	}
+	releaseSlot := generationSlots.releaser()
+	defer releaseSlot()
+	activeRequests.running(imgReq.ChannelID, imgReq.ID)
+
+	var results []string
+	succeeded := false
+	requestsTotal.Inc()
+	defer func() {
+		recordHistory(ctx, &imgReq, results, succeeded)
+		recordOutcome(succeeded)
+	}()
+
+	downgrade := applyCostDowngrade(&imgReq)
+	key := cacheKey(&imgReq)
+	images, imgs, cached := resultCache.get(key)
+	if !cached {
+		var err error
+		images, err = generateImage(ctx, &imgReq)
+		if err != nil {
+			logger(ctx).Error("Error on getting image", "error", err)
+			reason := errorReason(imgReq.Locale, err)
+			if reason == "" {
+				reason = tr(imgReq.Locale, "error.generic")
+			}
+			respondError(ctx, s, i, withRequestID(ctx, reason))
+			return
+		}
+		recordSpend(&imgReq)
+	}
+
+	if !enterMediaStage(ctx, releaseSlot) {
+		respond(tr(imgReq.Locale, "reply.cancelled"))
+		return
+	}
+	defer mediaSlots.release()
+	if !cached {
+		var err error
+		imgs, err = loadImages(ctx, images)
+		if err != nil {
+			logger(ctx).Error("Error on downloading image", "error", err)
+			reason := errorReason(imgReq.Locale, err)
+			if reason == "" {
+				reason = tr(imgReq.Locale, "error.download")
+			}
+			respondError(ctx, s, i, withRequestID(ctx, reason))
+			return
+		}
+		resultCache.put(key, images, imgs)
+	}
+	imgURLs := urlsOf(images)
+	if imgReq.Spoiler {
+		imgs = spoilered(imgs)
+	}
+	imgs, sensitive := blurSensitive(ctx, imgs)
+	imgs = withAltText(imgs, images, imgReq.Prompt)
+
+	caption := sensitiveNote(imgReq.Locale, sensitive) + downgrade + fallbackNote(&imgReq) + autoModelNote(&imgReq) + settingsFooter(&imgReq)
+	if !cached {
+		caption += costNote(&imgReq)
+	}
+	caption = strings.TrimSpace(withRevisedPrompt(caption, images))
+	// there's only the one response, so special users' reply leads it
+	if special, ok := specialReply(imgReq.AuthorID); ok {
+		caption = strings.TrimSpace(special + "\n" + caption)
+	}
+	refine := refineButton(imgReq.Locale, opts)
+	reply, err := editDescribed(s, i.Interaction, &discordgo.WebhookEdit{Content: &caption, Components: &refine}, imgs)
+	if err != nil {
+		logSendError(ctx, err)
+		respondError(ctx, s, i, withRequestID(ctx, tr(imgReq.Locale, "error.generic")))
+		return
+	}
+	rememberRefinable(reply.ID, imgReq.AuthorID, opts.prompt)
+
+	results, succeeded = resultURLs(reply, imgURLs), true
+	recentRequests.add(imgReq.AuthorID, &imgReq)
+	postResult(&imgReq, resultURL(reply, imgURLs[0]))
+	logf(ctx, "Successfully answered slash command")
+}
+
+// commandRefusal runs the checks a /dalle command must pass before anything
+// is generated, returning the reason to give the user when one fails.
+func commandRefusal(ctx context.Context, s session, imgReq *ImageRequest) (string, bool) {
+	if !allowedChannel(s, imgReq.GuildID, imgReq.ChannelID) {
+		return tr(imgReq.Locale, "refuse.here"), false
+	}
+	if message, ok := inMaintenance(imgReq.AuthorID); ok {
+		return message, false
+	}
+	if imgReq.Prompt == "" {
+		return tr(imgReq.Locale, "refuse.empty"), false
+	}
+	if refused := generationRefusal(ctx, imgReq); refused != nil {
+		return refused.reason, false
+	}
+	if cfg().ModerationEnabled {
+		flagged, err := moderatePrompt(ctx, imgReq.Prompt)
+		if err != nil {
+			logger(ctx).Error("Error on moderating prompt", "error", err)
+		} else if flagged != nil && cfg().AutoSpoilerModerated {
+			logger(ctx).Info("Prompt flagged, hiding it behind a spoiler", "categories", flagged)
+			imgReq.Spoiler = true
+		} else if flagged != nil {
+			return tr(imgReq.Locale, "refuse.flagged", strings.Join(flagged, ", ")), false
+		}
+	}
+	return "", true
+}
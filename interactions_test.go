@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDalleCommandSendsImage(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "slash")
+	i := d.command("slash", "user", "a red fox")
+
+	onInteractionHandler(d, i)
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox"}) {
+		t.Fatalf("generated %q, want just the prompt", got)
+	}
+	response := d.response(i)
+	if response == nil || len(response.Files) != 1 {
+		t.Fatalf("response is %+v, want it edited to the image", response)
+	}
+	if !reflect.DeepEqual(response.AltText, []string{"a red fox"}) {
+		t.Errorf("alt text is %q, want the prompt", response.AltText)
+	}
+}
+
+func TestDalleCommandInfoPrompts(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "info")
+
+	for prompt, want := range map[string]string{
+		"help":  helpText("en"),
+		"stats": tr("en", "stats.off"),
+	} {
+		i := d.command("info", "user", prompt)
+		onInteractionHandler(d, i)
+		if response := d.response(i); response == nil || response.Content != want {
+			t.Errorf("/dalle %s answered %+v, want %q", prompt, response, want)
+		}
+	}
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing", got)
+	}
+}
+
+func TestDalleCommandChannelCooldown(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true, ChannelCooldownSeconds: 60}, "slowslash")
+	d.addChannel("otherslash")
+
+	onInteractionHandler(d, d.command("slowslash", "user", "a first cat"))
+	second := d.command("slowslash", "someone", "a second cat")
+	onInteractionHandler(d, second)
+	onInteractionHandler(d, d.command("otherslash", "someone", "a third cat"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a first cat", "a third cat"}) {
+		t.Errorf("generated %q, want the second command in the channel held back", got)
+	}
+	if response := d.response(second); response == nil || response.Content != tr("en", "refuse.cooldown") {
+		t.Errorf("second command answered %+v, want the cooldown explained", response)
+	}
+}
+
+func TestDalleCommandSpecialReply(t *testing.T) {
+	d, _ := setupBot(t, Config{EnableSlashCommands: true, SpecialReplies: map[string]string{"zack": "Oh, it's you."}}, "special")
+	i := d.command("special", "zack", "a sunflower")
+
+	onInteractionHandler(d, i)
+
+	if response := d.response(i); response == nil || !strings.HasPrefix(response.Content, "Oh, it's you.") || len(response.Files) != 1 {
+		t.Errorf("response is %+v, want the image led by the special reply", response)
+	}
+}
+
+// holdSlots leaves the bot a single generation slot and takes it for the
+// rest of the test, so requests queue.
+func holdSlots(t *testing.T) {
+	oldSlots := generationSlots.size()
+	generationSlots.resize(1)
+	if !generationSlots.tryAcquire() {
+		t.Fatal("no free slot to hold")
+	}
+	t.Cleanup(func() {
+		generationSlots.release()
+		generationSlots.resize(oldSlots)
+	})
+}
+
+// waitInLine waits for userID to be queued for a slot.
+func waitInLine(t *testing.T, userID string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, mine := generationLine.positions(userID); len(mine) > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never joined the line", userID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDalleCommandCancelledWhileQueued(t *testing.T) {
+	d, api := setupBot(t, Config{EnableSlashCommands: true, MaxConcurrent: 1}, "slashqueue")
+	holdSlots(t)
+	i := d.command("slashqueue", "patient", "a long queue")
+
+	done := make(chan struct{})
+	go func() {
+		onInteractionHandler(d, i)
+		close(done)
+	}()
+	waitInLine(t, "patient")
+	onEmojiAddHandler(d, d.react("slashqueue", d.response(i).ID, "patient", "⏹️"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled command is still waiting for a slot")
+	}
+	if response := d.response(i); response == nil || response.Content != tr("en", "reply.cancelled") {
+		t.Errorf("response is %+v, want it to say the command was cancelled", response)
+	}
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing", got)
+	}
+}
+
+func TestDalleCommandQueuedAtShutdown(t *testing.T) {
+	d, _ := setupBot(t, Config{EnableSlashCommands: true, MaxConcurrent: 1}, "slashdrain")
+	holdSlots(t)
+	oldTracker := activeRequests
+	activeRequests = newRequestTracker()
+	t.Cleanup(func() { activeRequests = oldTracker })
+	i := d.command("slashdrain", "late", "a last request")
+
+	done := make(chan struct{})
+	go func() {
+		onInteractionHandler(d, i)
+		close(done)
+	}()
+	waitInLine(t, "late")
+	if remaining := activeRequests.drain(time.Second); len(remaining) != 0 {
+		t.Errorf("shutdown left %v running, want the queued command given up", remaining)
+	}
+
+	<-done
+	if response := d.response(i); response == nil || response.Content != tr("en", "refuse.shutdown") {
+		t.Errorf("response is %+v, want it to say the bot is shutting down", response)
+	}
+}
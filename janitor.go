@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxProcessing = 10 * time.Minute
+	// janitorInterval is how often running requests are checked.
+	janitorInterval = time.Minute
+)
+
+func maxProcessing() time.Duration {
+	if cfg().MaxProcessingSeconds > 0 {
+		return time.Duration(cfg().MaxProcessingSeconds) * time.Second
+	}
+	return defaultMaxProcessing
+}
+
+// startJanitor checks running requests every janitorInterval until the
+// returned function is called, so one that hangs isn't left showing 🤖. It
+// also drops rate limit buckets nobody has used for a while, images sent
+// apart that are too old to re-roll on their own and prompts too old to
+// refine or offer again.
+func startJanitor(s session) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweepOverdue(s)
+				userLimiter.prune(cfg().MaxRequestsPerMinute)
+				pruneSingleImages()
+				pruneRefinable()
+				pruneAgainOffers()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// sweepOverdue marks requests running longer than MaxProcessingSeconds as
+// failed and cancels them, so one that comes back late doesn't reply after
+// its requester was told it failed.
+func sweepOverdue(s session) {
+	for _, msg := range activeRequests.takeOverdue(maxProcessing()) {
+		slog.Warn("Request overdue, marking it failed", "channel_id", msg.ChannelID, "message_id", msg.MessageID)
+		if err := swapStatus(s, msg.ChannelID, msg.MessageID, statusEmojis().Working, statusEmojis().Error); err != nil {
+			slog.Error("Error on marking request as failed", "message_id", msg.MessageID, "error", err)
+		}
+		runningRequests.cancelAll(msg.MessageID)
+	}
+}
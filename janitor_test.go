@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock makes now return a time tests move forward by hand.
+func fakeClock(t *testing.T) (advance func(time.Duration)) {
+	var mu sync.Mutex
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	oldNow := now
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	t.Cleanup(func() { now = oldNow })
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(d)
+	}
+}
+
+func TestOverdueRequestFinishingLate(t *testing.T) {
+	advance := fakeClock(t)
+	d, api := setupBot(t, Config{MaxProcessingSeconds: 60}, "slowapi")
+	release := api.hang()
+	defer release()
+	m := d.post("slowapi", "user", "/dalle a very slow painting")
+
+	done := make(chan struct{})
+	go func() {
+		onMessageHandler(d, m)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); len(api.generated()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("request never reached OpenAI")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	advance(2 * time.Minute)
+	sweepOverdue(d)
+	// OpenAI answering now is too late
+	release()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("overdue request is still running")
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want only the janitor's ❌", got)
+	}
+	if sent := d.sentMessages(); len(sent) != 0 {
+		t.Errorf("sent %+v, want no reply after the request was marked failed", sent)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+const defaultKeyCooldown = 10 * time.Minute
+
+func keyCooldown() time.Duration {
+	if cfg().KeyCooldownSeconds > 0 {
+		return time.Duration(cfg().KeyCooldownSeconds) * time.Second
+	}
+	return defaultKeyCooldown
+}
+
+// globalKeys returns the keys shared by servers without their own: OpenAIKey
+// followed by OpenAIKeys, without repeats.
+func globalKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range append([]string{cfg().OpenAIKey}, cfg().OpenAIKeys...) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keyRotation hands out the global keys in turn, skipping any that recently
+// ran out of quota.
+type keyRotation struct {
+	mu       sync.Mutex
+	next     int
+	disabled map[string]time.Time
+}
+
+var apiKeys = &keyRotation{disabled: make(map[string]time.Time)}
+
+// pick returns the next global key that isn't cooling down. When they all
+// are, it returns the next one anyway so the request fails with OpenAI's own
+// error.
+func (r *keyRotation) pick() string {
+	keys := globalKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := 0; i < len(keys); i++ {
+		key := keys[(r.next+i)%len(keys)]
+		if now().Before(r.disabled[key]) {
+			continue
+		}
+		r.next = (r.next + i + 1) % len(keys)
+		return key
+	}
+	key := keys[r.next%len(keys)]
+	r.next = (r.next + 1) % len(keys)
+	return key
+}
+
+// disable takes key out of the rotation for the cooldown.
+func (r *keyRotation) disable(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabled[key] = now().Add(keyCooldown())
+}
+
+// keyExhausted reports whether err means the key it was sent with can't be
+// used for now: it was revoked or is out of quota.
+func keyExhausted(err error) bool {
+	var apiErr *imagegen.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusUnauthorized || apiErr.Code == "insufficient_quota" || apiErr.Code == "billing_hard_limit_reached"
+}
+
+// usedKey records the last key a request was authorized with, so a quota
+// error can be blamed on it.
+type usedKey struct {
+	mu  sync.Mutex
+	key string
+}
+
+// withUsedKey returns a context that records the key authorize picks for it.
+func withUsedKey(ctx context.Context) (context.Context, *usedKey) {
+	used := &usedKey{}
+	return context.WithValue(ctx, usedKeyKey, used), used
+}
+
+// recordKey notes key as the one ctx's request was sent with.
+func recordKey(ctx context.Context, key string) {
+	if used, ok := ctx.Value(usedKeyKey).(*usedKey); ok {
+		used.mu.Lock()
+		used.key = key
+		used.mu.Unlock()
+	}
+}
+
+// retireIfExhausted takes the key behind err out of the rotation when err
+// says it's out of quota. Servers' own keys are left alone.
+func (used *usedKey) retireIfExhausted(ctx context.Context, err error) {
+	if !keyExhausted(err) {
+		return
+	}
+	used.mu.Lock()
+	key := used.key
+	used.mu.Unlock()
+	for _, global := range globalKeys() {
+		if key == global {
+			logger(ctx).Warn("OpenAI key out of quota, skipping it for a while", "cooldown", keyCooldown())
+			apiKeys.disable(key)
+			return
+		}
+	}
+}
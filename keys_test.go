@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useKeys rotates through keys afresh, on a fake clock, for the rest of the
+// test.
+func useKeys(t *testing.T, config Config) (advance func(time.Duration)) {
+	advance = fakeClock(t)
+	old := apiKeys
+	apiKeys = &keyRotation{disabled: make(map[string]time.Time)}
+	t.Cleanup(func() { apiKeys = old })
+	useConfig(t, config)
+	return advance
+}
+
+// picks returns the next n keys the rotation hands out.
+func picks(n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		keys = append(keys, apiKeys.pick())
+	}
+	return keys
+}
+
+func TestKeyRotationRoundRobin(t *testing.T) {
+	useKeys(t, Config{OpenAIKey: "sk-a", OpenAIKeys: []string{"sk-a", "sk-b", "", "sk-c"}})
+
+	if got, want := picks(4), []string{"sk-a", "sk-b", "sk-c", "sk-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("picked %q, want each key in turn", got)
+	}
+}
+
+func TestKeyRotationSkipsDisabled(t *testing.T) {
+	advance := useKeys(t, Config{OpenAIKey: "sk-a", OpenAIKeys: []string{"sk-b", "sk-c"}, KeyCooldownSeconds: 60})
+
+	apiKeys.disable("sk-b")
+	if got, want := picks(3), []string{"sk-a", "sk-c", "sk-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("picked %q while sk-b cools down, want it skipped", got)
+	}
+
+	advance(time.Minute)
+	if got, want := picks(3), []string{"sk-b", "sk-c", "sk-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("picked %q after the cooldown, want sk-b back", got)
+	}
+
+	// with every key out, requests still go out and get OpenAI's error
+	for _, key := range []string{"sk-a", "sk-b", "sk-c"} {
+		apiKeys.disable(key)
+	}
+	if got := picks(2); got[0] == "" || got[0] == got[1] {
+		t.Errorf("picked %q with every key cooling down, want them still taken in turn", got)
+	}
+}
+
+func TestQuotaErrorDisablesKey(t *testing.T) {
+	var mu sync.Mutex
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		used = append(used, key)
+		mu.Unlock()
+		if key == "sk-b" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error": {"message": "You exceeded your current quota.", "type": "insufficient_quota", "code": "insufficient_quota"}}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"created": 1,
+			"data":    []map[string]string{{"url": "https://example.com/fox.png"}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	useKeys(t, Config{BaseURL: server.URL, OpenAIKey: "sk-a", OpenAIKeys: []string{"sk-b", "sk-c"}})
+
+	var failures int
+	for i := 0; i < 5; i++ {
+		if _, err := fetchImage(context.Background(), &ImageRequest{Prompt: "a red fox", GuildID: testGuildID}); err != nil {
+			failures++
+		}
+	}
+
+	if want := []string{"sk-a", "sk-b", "sk-c", "sk-a", "sk-c"}; !reflect.DeepEqual(used, want) {
+		t.Errorf("sent with %q, want sk-b dropped after its quota error", used)
+	}
+	if failures != 1 {
+		t.Errorf("%d requests failed, want only the one sent with sk-b", failures)
+	}
+}
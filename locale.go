@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultLocale = "en"
+
+// catalog holds the user-facing strings of each locale by key, as fmt
+// formats. English has every key, other locales fall back to it for any they
+// don't have.
+var catalog = map[string]map[string]string{
+	"en": {
+		// help
+		"help.intro":       "Type `%[1]s` with some words to get an image! (`%[1]s help` to display this message, `%[1]s stats` for your usage, `%[1]s redo` to run your last prompt again, `%[1]s queue` to see how busy I am)",
+		"help.size":        "Start with a size (%s) to pick it, like `%s %s a red fox`",
+		"help.count":       "Then add a number up to %d for several images, like `%s %s 4 a red fox`",
+		"help.style":       "Add hd for more detail and natural for a less dramatic look, like `%s hd natural a cabin`",
+		"help.seed":        "Add a seed to get the same picture again, like `%s a red fox seed:1234`",
+		"help.negatives":   "Put what to leave out after a |, like `%s a forest | no people, no buildings`",
+		"help.collage":     "Send `%s collage` for a contact sheet of your last images, or add a number up to %d",
+		"help.styles":      "Save a style with `%[1]s savestyle noir black and white, film noir` and add it to a prompt with @noir, like `%[1]s @noir a city street` (`%[1]s styles` lists yours)",
+		"help.spoiler":     "Start with spoiler to hide the image until it's clicked, like `%s spoiler a haunted house`",
+		"help.variation":   "Attach a PNG with no prompt to get variations of it, or a PNG and a mask with `%s edit a red hat` to redraw the transparent part of the mask",
+		"help.slash":       "You can also use the `/dalle` command",
+		"help.dms":         "You can DM me too",
+		"help.batch":       "Put up to %d prompts on their own lines, each starting with `%s`, to get them all at once",
+		"help.retry":       "%s = Click this to try again for a better picture, click it again to call it off",
+		"help.queued":      "⌛ = Waiting for other images to finish first",
+		"help.working":     "%s = AI is working on it, react ⏹️ to cancel it",
+		"help.done":        "%s = Done! I've sent your nightmare fuel",
+		"help.upscale":     "%s = Try the same prompt again at a bigger size",
+		"help.composition": "%s = Redraw the same picture with different details",
+		"help.delete":      "🗑️ = Delete an image you asked for",
+		"help.copy":        "📋 = Get the prompt behind an image in a DM",
+		"help.favorite":    "⭐ = Save an image to your DMs",
+		"help.error":       "%s = It didn't work for some reason",
+		"help.blocked":     "🚫 = Your prompt isn't allowed, or you're out of images for today",
+		"help.closed":      "🌙 = Image generation is closed at this hour",
+
+		// refusals
+		"refuse.here":          "I'm not allowed to make images here.",
+		"refuse.role":          "You need one of this server's image roles to use me.",
+		"refuse.empty":         "Give me something to draw!",
+		"reroll.severalImages": "I can't tell which of these images to keep, react %s to a reply with just one.",
+		"reply.sensitive":      "⚠️ This might be sensitive, so it's blurred. Click to reveal.",
+		"refuse.count":         "Ask for between 1 and %d images.",
+		"reply.countClamped":   "You asked for %d images, but %d is the most %s can make at once.",
+		"refuse.length":        "Prompt too long: %d/%d chars",
+		"refuse.blocked":       "That prompt uses a word that isn't allowed here.",
+		"refuse.nokey":         "This server hasn't configured image generation yet.",
+		"refuse.hours":         "Image generation is available from %s.",
+		"refuse.tooFast":       "You're making images too fast, try again in %d seconds.",
+		"refuse.cooldown":      "This channel just had an image, give it a moment.",
+		"refuse.inFlight":      "You already have %d requests in progress, please wait for them to finish.",
+		"refuse.quota":         "You've used your %d images for today, the quota resets at midnight UTC.",
+		"refuse.quotaIn":       "You've used your %d images for today, the quota resets at midnight UTC (in %dh %dm).",
+		"refuse.shutdown":      "The bot is shutting down, try again in a moment.",
+		"refuse.flagged":       "Your prompt was flagged for: %s",
+		"refuse.noRedo":        "You haven't asked me for anything yet, so there's nothing to redo.",
+		"refuse.batch":         "Send one `%s` prompt per message, please.",
+		"refuse.batchSize":     "I can only take %d prompts in one message.",
+
+		// errors
+		"error.rejected":       "Your prompt was rejected: %s",
+		"error.openai":         "OpenAI couldn't make that image: %s",
+		"error.empty":          "Sorry, I couldn't generate an image for that prompt.",
+		"error.generic":        "Sorry, something went wrong making that image.",
+		"error.download":       "Sorry, I couldn't download the image.",
+		"error.imageTooLarge":  "Sorry, the image came back too big to download.",
+		"error.notAnImage":     "Sorry, what came back wasn't an image.",
+		"error.noReactions":    "I can't react to messages here, so I won't show how requests are going. A server admin can give me the Add Reactions and Read Message History permissions.",
+		"error.tooBig":         "That image is too big, I need a PNG under %g MB.",
+		"error.slowAttachment": "Your image took too long to download, try again in a bit.",
+		"error.noPNG":          "I couldn't download that image, I need a PNG.",
+		"error.onlyPNG":        "That only works with PNG images.",
+		"error.needMask":       "Attach the image and then its mask to edit it.",
+		"error.badImage":       "I couldn't read the image.",
+		"error.badMask":        "I couldn't read the mask.",
+		"error.maskSize":       "The mask is %dx%d but the image is %dx%d, they need to be the same size.",
+		"error.toGuild":        "I can only send images to channels in this server.",
+		"error.toPerms":        "I can't send images to <#%s> for you.",
+		"error.request":        "%s (request `%s`)",
+
+		// replies
+		"reply.budget":    "This server has hit today's budget, so images are %s until tomorrow.",
+		"reply.fallback":  "%s couldn't make this one, so %s did.",
+		"reply.provider":  "Made by %s, as the usual image service was unavailable.",
+		"reply.autoModel": "Made with %s, picked for this prompt.",
+		"reply.settings":  "Settings: %s",
+		"reply.askedIn":   "<@%s> asked for this in %s",
+		"reply.madeFor":   "Made for <@%s>",
+		"reply.prompt":    "Here's the prompt for %s\n```\n%s\n```\nSettings: model %s, size %s",
+		"reply.promptDM":  "<@%s> I couldn't DM you the prompt, here it is: `%s`",
+		"reply.saved":     "Saved from %s",
+		"reply.failedFor": "Your request %s didn't work: %s",
+		"reply.queue":     "In progress: %d, waiting: %d.",
+		"reply.queuePos":  "You're number %d in line.",
+		"reply.cancelled": "Cancelled, nothing was generated.",
+		// refine
+		"refine.button":   "Refine",
+		"refine.title":    "Refine your prompt",
+		"refine.label":    "Prompt",
+		"refine.expired":  "This image is too old to refine, use the command again.",
+		"refine.notYours": "Only whoever asked for this image can refine it.",
+		"again.offer":     "Want to make one of these again?",
+		"again.expired":   "These prompts are too old, use the command again.",
+		"again.notYours":  "Only whoever these prompts were offered to can pick one.",
+
+		// stats
+		"stats.off":       "Stats aren't available, the history isn't turned on.",
+		"stats.error":     "Sorry, I couldn't get your stats.",
+		"stats.none":      "You haven't made any images yet, give it a try!",
+		"stats.summary":   "You've made %d requests and %d%% of them worked.\nYour last prompt was: %s",
+		"stats.noData":    "No data yet, make some images first!",
+		"stats.chart":     "Your %d requests over the last %d days:",
+		"stats.chartAlt":  "Bar chart of your requests a day over the last %d days",
+		"collage.off":     "Collages aren't available, the history isn't turned on.",
+		"collage.error":   "Sorry, I couldn't make your collage.",
+		"collage.none":    "You don't have any images to make a collage of yet, give it a try!",
+		"collage.gone":    "I couldn't find any of your images anymore.",
+		"collage.made":    "Your last %d images:",
+		"collage.skipped": "(%d more couldn't be found anymore)",
+		"collage.alt":     "Contact sheet of your last %d images",
+
+		// saved styles
+		"styles.off":     "Saved styles aren't available, the history isn't turned on.",
+		"styles.error":   "Sorry, I couldn't get to your saved styles.",
+		"styles.usage":   "Usage: `%[1]s savestyle <name> <text>`, `%[1]s styles` or `%[1]s delstyle <name>`. Names can have letters, numbers, - and _.",
+		"styles.saved":   "Saved @%[1]s, put @%[1]s in a prompt to add it.",
+		"styles.deleted": "Deleted @%s.",
+		"styles.unknown": "You don't have a style called @%s.",
+		"styles.none":    "You haven't saved any styles yet, try `%s savestyle noir black and white, film noir`.",
+		"styles.list":    "Your styles:\n%s",
+
+		// admin
+		"admin.setkey":       "Only bot admins can set server keys.",
+		"admin.setkeyUsage":  "Usage: `%s setkey <server ID> <OpenAI key>`",
+		"admin.keySet":       "OpenAI key set for server %s until the next restart. Add it to `guildKeys` in config.json to keep it.",
+		"admin.maintenance":  "Only bot admins can change maintenance mode.",
+		"admin.maintUsage":   "Usage: `%[1]s maintenance on [message]` or `%[1]s maintenance off`",
+		"admin.maintOn":      "Maintenance mode is on.",
+		"admin.maintOff":     "Maintenance mode is off.",
+		"admin.maintMessage": "DISC-E is down for maintenance, try again later!",
+		"admin.reload":       "Only bot admins can reload the config.",
+		"admin.reloadFailed": "Couldn't reload the config, still using the old one: %s",
+		"admin.reloaded":     "Config reloaded. The Discord token, slash commands, logging, health port, history database, maintenance and spend files only change on a restart.",
+		"admin.cost":         "Only bot admins can see the spend.",
+		"admin.spend":        "Estimated spend: %s today, %s all-time over %d images.",
+		"admin.preview":      "Only bot admins can preview requests.",
+		"admin.model":        "This server uses %s. Admins can switch to one of %s, or back with `default`.",
+		"admin.modelSet":     "This server now uses %s until the next restart.",
+		"admin.modelUnknown": "I don't know the model %q, use one of %s.",
+		"admin.modelGuild":   "Models are picked per server, send this in one.",
+	},
+	"fr": {
+		"help.intro":       "Tape `%[1]s` suivi de quelques mots pour obtenir une image ! (`%[1]s help` pour afficher ce message, `%[1]s stats` pour ton utilisation, `%[1]s redo` pour relancer ta dernière demande, `%[1]s queue` pour voir si je suis occupé)",
+		"help.size":        "Commence par une taille (%s) pour la choisir, comme `%s %s un renard roux`",
+		"help.count":       "Puis ajoute un nombre jusqu'à %d pour plusieurs images, comme `%s %s 4 un renard roux`",
+		"help.style":       "Ajoute hd pour plus de détails et natural pour un rendu plus sobre, comme `%s hd natural un chalet`",
+		"help.seed":        "Ajoute une graine pour retrouver la même image, comme `%s un renard roux seed:1234`",
+		"help.negatives":   "Mets ce qu'il faut éviter après un |, comme `%s une forêt | pas de gens, pas de bâtiments`",
+		"help.collage":     "Envoie `%s collage` pour une planche contact de tes dernières images, ou ajoute un nombre jusqu'à %d",
+		"help.styles":      "Enregistre un style avec `%[1]s savestyle noir noir et blanc, film noir` et ajoute-le à une demande avec @noir, comme `%[1]s @noir une rue de la ville` (`%[1]s styles` liste les tiens)",
+		"help.spoiler":     "Commence par spoiler pour cacher l'image jusqu'au clic, comme `%s spoiler une maison hantée`",
+		"help.variation":   "Joins un PNG sans texte pour en obtenir des variantes, ou un PNG et un masque avec `%s edit un chapeau rouge` pour redessiner la partie transparente du masque",
+		"help.slash":       "Tu peux aussi utiliser la commande `/dalle`",
+		"help.dms":         "Tu peux aussi m'écrire en message privé",
+		"help.batch":       "Mets jusqu'à %d demandes sur des lignes séparées, chacune commençant par `%s`, pour les obtenir d'un coup",
+		"help.retry":       "%s = Clique pour réessayer et obtenir une meilleure image, clique à nouveau pour annuler",
+		"help.queued":      "⌛ = En attente que d'autres images se terminent",
+		"help.working":     "%s = L'IA y travaille, réagis avec ⏹️ pour annuler",
+		"help.done":        "%s = Terminé ! Voici ton cauchemar",
+		"help.upscale":     "%s = Relance la même demande en plus grand",
+		"help.composition": "%s = Redessine la même image avec d'autres détails",
+		"help.delete":      "🗑️ = Supprime une image que tu as demandée",
+		"help.copy":        "📋 = Reçois en privé le texte derrière une image",
+		"help.favorite":    "⭐ = Enregistre une image dans tes messages privés",
+		"help.error":       "%s = Ça n'a pas marché pour une raison quelconque",
+		"help.blocked":     "🚫 = Ta demande n'est pas autorisée, ou tu n'as plus d'images pour aujourd'hui",
+		"help.closed":      "🌙 = La génération d'images est fermée à cette heure",
+
+		"refuse.here":          "Je n'ai pas le droit de faire des images ici.",
+		"refuse.role":          "Il te faut un des rôles d'images de ce serveur pour m'utiliser.",
+		"refuse.empty":         "Donne-moi quelque chose à dessiner !",
+		"reroll.severalImages": "Je ne sais pas laquelle de ces images garder, réagis avec %s à une réponse qui n'en a qu'une.",
+		"reply.sensitive":      "⚠️ Ceci pourrait être sensible, donc c'est flouté. Clique pour afficher.",
+		"refuse.count":         "Demande entre 1 et %d images.",
+		"reply.countClamped":   "Tu as demandé %d images, mais %d est le maximum que %s peut faire à la fois.",
+		"refuse.length":        "Texte trop long : %d/%d caractères",
+		"refuse.blocked":       "Ce texte contient un mot qui n'est pas autorisé ici.",
+		"refuse.nokey":         "Ce serveur n'a pas encore configuré la génération d'images.",
+		"refuse.hours":         "La génération d'images est disponible de %s.",
+		"refuse.tooFast":       "Tu fais des images trop vite, réessaie dans %d secondes.",
+		"refuse.cooldown":      "Ce salon vient d'avoir une image, attends un instant.",
+		"refuse.inFlight":      "Tu as déjà %d demandes en cours, attends qu'elles se terminent.",
+		"refuse.quota":         "Tu as utilisé tes %d images du jour, le quota repart à minuit UTC.",
+		"refuse.quotaIn":       "Tu as utilisé tes %d images du jour, le quota repart à minuit UTC (dans %dh %dm).",
+		"refuse.shutdown":      "Le bot s'arrête, réessaie dans un instant.",
+		"refuse.flagged":       "Ta demande a été signalée pour : %s",
+		"refuse.noRedo":        "Tu ne m'as encore rien demandé, il n'y a rien à relancer.",
+		"refuse.batch":         "Envoie une seule demande `%s` par message, s'il te plaît.",
+		"refuse.batchSize":     "Je ne peux prendre que %d demandes dans un message.",
+
+		"error.rejected":       "Ta demande a été refusée : %s",
+		"error.openai":         "OpenAI n'a pas pu faire cette image : %s",
+		"error.empty":          "Désolé, je n'ai pas pu générer d'image pour cette demande.",
+		"error.generic":        "Désolé, quelque chose s'est mal passé pendant la création de l'image.",
+		"error.download":       "Désolé, je n'ai pas pu télécharger l'image.",
+		"error.imageTooLarge":  "Désolé, l'image reçue est trop grande pour être téléchargée.",
+		"error.notAnImage":     "Désolé, ce que j'ai reçu n'était pas une image.",
+		"error.noReactions":    "Je ne peux pas réagir aux messages ici, je ne montrerai donc pas où en sont les demandes. Un admin du serveur peut me donner les permissions Ajouter des réactions et Voir les anciens messages.",
+		"error.tooBig":         "Cette image est trop grande, il me faut un PNG de moins de %g Mo.",
+		"error.slowAttachment": "Ton image a mis trop de temps à se télécharger, réessaie dans un moment.",
+		"error.noPNG":          "Je n'ai pas pu télécharger cette image, il me faut un PNG.",
+		"error.onlyPNG":        "Ça ne marche qu'avec des images PNG.",
+		"error.needMask":       "Joins l'image puis son masque pour la modifier.",
+		"error.badImage":       "Je n'ai pas pu lire l'image.",
+		"error.badMask":        "Je n'ai pas pu lire le masque.",
+		"error.maskSize":       "Le masque fait %dx%d mais l'image fait %dx%d, ils doivent avoir la même taille.",
+		"error.toGuild":        "Je ne peux envoyer des images que dans les salons de ce serveur.",
+		"error.toPerms":        "Je ne peux pas envoyer d'images dans <#%s> pour toi.",
+		"error.request":        "%s (demande `%s`)",
+
+		"reply.budget":    "Ce serveur a atteint son budget du jour, les images sont donc en %s jusqu'à demain.",
+		"reply.fallback":  "%s n'a pas pu faire celle-ci, c'est donc %s qui l'a faite.",
+		"reply.provider":  "Faite par %s, le service d'images habituel n'étant pas disponible.",
+		"reply.autoModel": "Faite avec %s, choisi pour cette demande.",
+		"reply.settings":  "Réglages : %s",
+		"reply.askedIn":   "<@%s> a demandé ceci dans %s",
+		"reply.madeFor":   "Faite pour <@%s>",
+		"reply.prompt":    "Voici le texte de %s\n```\n%s\n```\nRéglages : modèle %s, taille %s",
+		"reply.promptDM":  "<@%s> je n'ai pas pu t'envoyer le texte en privé, le voici : `%s`",
+		"reply.saved":     "Enregistrée depuis %s",
+		"reply.failedFor": "Ta demande %s n'a pas marché : %s",
+		"reply.queue":     "En cours : %d, en attente : %d.",
+		"reply.queuePos":  "Tu es numéro %d dans la file.",
+		"reply.cancelled": "Annulé, rien n'a été généré.",
+		// refine
+		"refine.button":   "Affiner",
+		"refine.title":    "Affine ta demande",
+		"refine.label":    "Demande",
+		"refine.expired":  "Cette image est trop ancienne pour être affinée, relance la commande.",
+		"refine.notYours": "Seule la personne qui a demandé cette image peut l'affiner.",
+		"again.offer":     "Envie de refaire l'une de celles-ci ?",
+		"again.expired":   "Ces demandes sont trop anciennes, relance la commande.",
+		"again.notYours":  "Seule la personne à qui ces demandes ont été proposées peut en choisir une.",
+
+		"stats.off":       "Les statistiques ne sont pas disponibles, l'historique n'est pas activé.",
+		"stats.error":     "Désolé, je n'ai pas pu récupérer tes statistiques.",
+		"stats.none":      "Tu n'as encore fait aucune image, essaie !",
+		"stats.summary":   "Tu as fait %d demandes et %d%% ont marché.\nTa dernière demande était : %s",
+		"stats.noData":    "Pas encore de données, fais d'abord quelques images !",
+		"stats.chart":     "Tes %d demandes sur les %d derniers jours :",
+		"stats.chartAlt":  "Graphique en barres de tes demandes par jour sur les %d derniers jours",
+		"collage.off":     "Les collages ne sont pas disponibles, l'historique n'est pas activé.",
+		"collage.error":   "Désolé, je n'ai pas pu faire ton collage.",
+		"collage.none":    "Tu n'as encore aucune image pour un collage, essaie !",
+		"collage.gone":    "Je n'ai plus retrouvé aucune de tes images.",
+		"collage.made":    "Tes %d dernières images :",
+		"collage.skipped": "(%d autres sont introuvables)",
+		"collage.alt":     "Planche contact de tes %d dernières images",
+
+		"styles.off":     "Les styles enregistrés ne sont pas disponibles, l'historique n'est pas activé.",
+		"styles.error":   "Désolé, je n'ai pas pu accéder à tes styles enregistrés.",
+		"styles.usage":   "Utilisation : `%[1]s savestyle <nom> <texte>`, `%[1]s styles` ou `%[1]s delstyle <nom>`. Les noms peuvent contenir des lettres, des chiffres, - et _.",
+		"styles.saved":   "@%[1]s est enregistré, mets @%[1]s dans une demande pour l'ajouter.",
+		"styles.deleted": "@%s est supprimé.",
+		"styles.unknown": "Tu n'as pas de style appelé @%s.",
+		"styles.none":    "Tu n'as encore enregistré aucun style, essaie `%s savestyle noir noir et blanc, film noir`.",
+		"styles.list":    "Tes styles :\n%s",
+	},
+}
+
+// locale returns the configured locale.
+func locale() string {
+	if cfg().Locale != "" {
+		return cfg().Locale
+	}
+	return defaultLocale
+}
+
+// localeFor picks the catalog locale for a Discord locale such as "fr" or
+// "pt-BR", trying its language alone when there's no exact match and using
+// the configured locale when neither is in the catalog.
+func localeFor(discordLocale discordgo.Locale) string {
+	name := string(discordLocale)
+	if _, ok := catalog[name]; ok {
+		return name
+	}
+	if language, _, _ := strings.Cut(name, "-"); catalog[language] != nil {
+		return language
+	}
+	return locale()
+}
+
+// tr returns the string for key in locale formatted with args, falling back
+// to English when the locale doesn't have it. An unknown or empty locale
+// means the configured one.
+func tr(locale string, key string, args ...interface{}) string {
+	if catalog[locale] == nil {
+		locale = localeFor(discordgo.Locale(locale))
+	}
+	format, ok := catalog[locale][key]
+	if !ok {
+		format = catalog[defaultLocale][key]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// validateLocale checks the configured locale is in the catalog.
+func validateLocale(c *Config) error {
+	if c.Locale != "" && catalog[c.Locale] == nil {
+		return fmt.Errorf("unsupported locale %q", c.Locale)
+	}
+	return nil
+}
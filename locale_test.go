@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	noRedoEnglish = "You haven't asked me for anything yet, so there's nothing to redo."
+	noRedoFrench  = "Tu ne m'as encore rien demandé, il n'y a rien à relancer."
+)
+
+func TestTranslate(t *testing.T) {
+	useConfig(t, Config{})
+	catalog[defaultLocale]["test.englishOnly"] = "Only in %s"
+	t.Cleanup(func() { delete(catalog[defaultLocale], "test.englishOnly") })
+
+	for _, c := range []struct {
+		locale, key string
+		args        []interface{}
+		want        string
+	}{
+		{"en", "refuse.noRedo", nil, noRedoEnglish},
+		{"fr", "refuse.noRedo", nil, noRedoFrench},
+		{"fr", "refuse.length", []interface{}{1001, 1000}, catalogFormat("fr", "refuse.length", 1001, 1000)},
+		{"fr", "test.englishOnly", []interface{}{"English"}, "Only in English"},
+		{"", "refuse.noRedo", nil, noRedoEnglish},
+		{"de", "refuse.noRedo", nil, noRedoEnglish},
+	} {
+		if got := tr(c.locale, c.key, c.args...); got != c.want {
+			t.Errorf("tr(%q, %q) = %q, want %q", c.locale, c.key, got, c.want)
+		}
+	}
+}
+
+// catalogFormat formats key straight from locale's catalog.
+func catalogFormat(locale string, key string, args ...interface{}) string {
+	return fmt.Sprintf(catalog[locale][key], args...)
+}
+
+func TestLocaleFor(t *testing.T) {
+	useConfig(t, Config{Locale: "fr"})
+	for _, c := range []struct {
+		discord discordgo.Locale
+		want    string
+	}{
+		{discordgo.French, "fr"},
+		{discordgo.EnglishUS, "en"},
+		{discordgo.EnglishGB, "en"},
+		{discordgo.German, "fr"},
+		{"", "fr"},
+	} {
+		if got := localeFor(c.discord); got != c.want {
+			t.Errorf("localeFor(%q) = %q, want %q", c.discord, got, c.want)
+		}
+	}
+}
+
+func TestConfiguredLocaleReplies(t *testing.T) {
+	useRecent(t)
+	d, _ := setupBot(t, Config{Locale: "fr"}, "fr")
+
+	onMessageHandler(d, d.post("fr", "user", "/dalle redo"))
+
+	if sent := d.sentMessages(); len(sent) != 1 || sent[0].Content != noRedoFrench {
+		t.Errorf("sent %+v, want the French refusal", sent)
+	}
+}
+
+func TestInteractionLocaleReplies(t *testing.T) {
+	useRecent(t)
+	d, _ := setupBot(t, Config{EnableSlashCommands: true}, "slash")
+	for _, c := range []struct {
+		locale discordgo.Locale
+		want   string
+	}{
+		{discordgo.French, noRedoFrench},
+		{discordgo.EnglishUS, noRedoEnglish},
+		{discordgo.German, noRedoEnglish},
+	} {
+		i := d.command("slash", "user", "redo")
+		i.Locale = c.locale
+
+		onInteractionHandler(d, i)
+
+		if got := d.response(i); got == nil || got.Content != c.want {
+			t.Errorf("with locale %q responded %+v, want %q", c.locale, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging makes the default slog logger use the configured LogLevel
+// (default info) and LogFormat, "text" (default) or "json".
+func setupLogging() error {
+	var level slog.Level
+	if cfg().LogLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg().LogLevel)); err != nil {
+			return fmt.Errorf("unsupported logLevel %q, use debug, info, warn or error", cfg().LogLevel)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg().LogFormat) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("unsupported logFormat %q, use text or json", cfg().LogFormat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logRecord is one captured log line's message and fields.
+type logRecord struct {
+	msg    string
+	fields map[string]string
+}
+
+// recordHandler keeps every record logged through it.
+type recordHandler struct {
+	mu      *sync.Mutex
+	records *[]logRecord
+	attrs   []slog.Attr
+}
+
+func (h recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h recordHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string)
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value.String()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, logRecord{msg: r.Message, fields: fields})
+	return nil
+}
+
+func (h recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return h
+}
+
+func (h recordHandler) WithGroup(string) slog.Handler { return h }
+
+// captureLogs sends the default logger's records to a list for the rest of
+// the test, returning a function that finds those with msg.
+func captureLogs(t *testing.T) (logged func(msg string) []logRecord) {
+	var mu sync.Mutex
+	var records []logRecord
+	old := slog.Default()
+	slog.SetDefault(slog.New(recordHandler{mu: &mu, records: &records}))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return func(msg string) []logRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		var found []logRecord
+		for _, r := range records {
+			if r.msg == msg {
+				found = append(found, r)
+			}
+		}
+		return found
+	}
+}
+
+func TestRequestLogsCarryFields(t *testing.T) {
+	logged := captureLogs(t)
+	d, _ := setupBot(t, Config{}, "logged")
+	m := d.post("logged", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	records := logged("Fetching images")
+	if len(records) != 1 {
+		t.Fatalf("logged fetching images %d times, want once", len(records))
+	}
+	r := records[0]
+	for key, want := range map[string]string{"author_id": "user", "channel_id": "logged", "message_id": m.ID, "prompt": "a red fox"} {
+		if got := r.fields[key]; got != want {
+			t.Errorf("%s is %q, want %q", key, got, want)
+		}
+	}
+	if r.fields["request_id"] == "" {
+		t.Error("no request_id logged")
+	}
+}
+
+func TestFailureLogsError(t *testing.T) {
+	logged := captureLogs(t)
+	d, api := setupBot(t, Config{}, "loggedfail")
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+
+	onMessageHandler(d, d.post("loggedfail", "user", "/dalle a lighthouse"))
+
+	records := logged("Error on getting image")
+	if len(records) != 1 {
+		t.Fatalf("logged the failure %d times, want once", len(records))
+	}
+	r := records[0]
+	if r.fields["error"] == "" || r.fields["request_id"] == "" {
+		t.Errorf("logged %v, want the error and request_id", r.fields)
+	}
+}
+
+func TestSetupLoggingRejectsUnknown(t *testing.T) {
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	for _, c := range []Config{{LogLevel: "loud"}, {LogFormat: "xml"}} {
+		useConfig(t, c)
+		if err := setupLogging(); err == nil {
+			t.Errorf("setupLogging with %+v succeeded, want an error", c)
+		}
+	}
+	useConfig(t, Config{LogLevel: "debug", LogFormat: "json"})
+	if err := setupLogging(); err != nil {
+		t.Errorf("setupLogging with debug json: %v", err)
+	}
+}
+
+func TestEachGenerationHasItsOwnRequestID(t *testing.T) {
+	logged := captureLogs(t)
+	d, api := setupBot(t, Config{}, "ids")
+	onMessageHandler(d, d.post("ids", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("ids", "user", "/dalle a red fox"))
+	onEmojiAddHandler(d, d.react("ids", d.sentMessages()[0].ID, "user", "🔁"))
+
+	ids := make(map[string]bool)
+	for _, r := range append(logged("Fetching images"), logged("Sending variation")...) {
+		ids[r.fields["request_id"]] = true
+	}
+	if len(ids) != 3 || ids[""] {
+		t.Errorf("3 generations logged request IDs %v, want 3 different ones", ids)
+	}
+
+	// a failure's reply gives the ID its logs carry
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+	onMessageHandler(d, d.post("ids", "user", "/dalle a lighthouse"))
+	failures := logged("Error on getting image")
+	if len(failures) != 1 {
+		t.Fatalf("logged the failure %d times, want once", len(failures))
+	}
+	sent := d.sentMessages()
+	if id := failures[0].fields["request_id"]; !strings.Contains(sent[len(sent)-1].Content, id) {
+		t.Errorf("error reply %q doesn't give request ID %s", sent[len(sent)-1].Content, id)
+	}
+}
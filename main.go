@@ -1,65 +1,256 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	"github.com/mdesson/disc-e/imagegen"
 )
 
 const (
 	ZackID = "144628264583954433"
+
+	defaultRequestTimeout = 60 * time.Second
 )
 
-type Config struct {
-	DiscordToken string `json:"discordToken"`
-	OpenAIKey    string `json:"openAIKey"`
-	SpecialUser  string `json:"specialUser"`
-	SpeicalReply string `json:"specialReply"`
+type ImageRequest struct {
+	// RequestID names this one generation in logs and error replies. ID is
+	// the Discord message it was asked for in, which re-rolls share.
+	RequestID string
+	ID        string
+	Prompt    string
+	AuthorID  string
+	Model     string
+	Size      string
+	// Quality and Style are only set for models that take them.
+	Quality string
+	Style   string
+	// Seed is the seed the prompt asked for, or nil.
+	Seed *int64
+	// Spoiler uploads the images as spoilers.
+	Spoiler bool
+	// Batch is set for one of several prompts sent in one message.
+	Batch bool
+	// Redo is set when the request runs a previous one again, which mustn't
+	// be answered from the cache.
+	Redo bool
+	// Locale is the language to answer in.
+	Locale string
+	// FallbackFrom is the model that failed when Model is a fallback.
+	FallbackFrom string
+	// AutoModel is set when Model was picked for the prompt by
+	// AutoModelSelect.
+	AutoModel bool
+	// N is how many images to generate, 0 meaning 1.
+	N int
+	// CountAsked is how many images were asked for when N had to be lowered
+	// to what the model and providers make at once.
+	CountAsked int
+	// Source is a PNG to make variations of instead of generating from
+	// Prompt. With Mask, the transparent areas of Mask are redrawn in Source
+	// following Prompt instead.
+	Source []byte
+	Mask   []byte
+	// Provider names the provider in ProviderFallbackOrder that made the
+	// images when the first couldn't.
+	Provider string
+	// Strength, when set, redraws all of Source following Prompt, straying
+	// that far from it.
+	Strength *float64
+	// GuildID and ChannelID come straight from the triggering message rather
+	// than a guild/channel lookup, which can fail and leave nothing to reply to.
+	GuildID   string
+	ChannelID string
+	// IdempotencyKey is sent with the request so a retried call isn't
+	// generated and billed twice. Each generation needs its own.
+	IdempotencyKey string
 }
 
-type ImageRequest struct {
-	ID       string
-	Prompt   string
-	AuthorID string
-	Guild    *discordgo.Guild
-	Channel  *discordgo.Channel
+// currentConfig is the config in use. Reloading swaps it while handlers are
+// reading it, so it's only accessed through cfg.
+var currentConfig atomic.Pointer[Config]
+
+// cfg returns the config in use, which must be treated as read-only.
+func cfg() *Config {
+	if c := currentConfig.Load(); c != nil {
+		return c
+	}
+	return &Config{}
+}
+
+// openAIClients are what calls to OpenAI go through: the HTTP client, with
+// the configured timeout, and the generator that makes the images. When a
+// session is recorded or replayed, downloads is what images are downloaded
+// with instead of downloadClient.
+type openAIClients struct {
+	http      *http.Client
+	generator imagegen.Generator
+	downloads *http.Client
+	// providers are those in ProviderFallbackOrder, by name
+	providers map[string]*provider
+}
+
+// openAI is built from the config by setupOpenAI, and built again when the
+// config is reloaded.
+var openAI atomic.Pointer[openAIClients]
+
+var defaultHTTPClient = &http.Client{Timeout: defaultRequestTimeout}
+
+// httpClient returns the client for calls to OpenAI, or one with the default
+// timeout before setupOpenAI has run.
+func httpClient() *http.Client {
+	if c := openAI.Load(); c != nil {
+		return c.http
+	}
+	return defaultHTTPClient
 }
 
-type ImageResponse struct {
-	Created int                 `json:"created"`
-	Data    []map[string]string `json:"data"`
+// generator returns what makes the images.
+func generator() imagegen.Generator {
+	return openAI.Load().generator
 }
 
-var config Config
+// setupOpenAI builds the client and generator from the config in use and
+// swaps them in. Requests already sent finish with the old ones.
+func setupOpenAI() error {
+	client := &http.Client{Timeout: requestTimeout()}
+	switch {
+	case cfg().HTTPReplayDir != "":
+		client.Transport = &ReplayTransport{Dir: cfg().HTTPReplayDir}
+	case cfg().HTTPRecordDir != "":
+		if err := os.MkdirAll(cfg().HTTPRecordDir, 0755); err != nil {
+			return err
+		}
+		client.Transport = &RecordingTransport{Dir: cfg().HTTPRecordDir}
+	case cfg().RecordDir != "":
+		dir := filepath.Join(cfg().RecordDir, recordedHTTPDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		client.Transport = &RecordingTransport{Dir: dir}
+	}
+	clients := &openAIClients{http: client, generator: newGenerator(client), providers: newProviders(client)}
+	// a session's images are recorded and replayed along with it
+	switch {
+	case replayDir != "":
+		clients.downloads = &http.Client{Timeout: downloadClient.Timeout, Transport: &ReplayTransport{Dir: filepath.Join(replayDir, recordedHTTPDir)}}
+	case cfg().RecordDir != "":
+		clients.downloads = &http.Client{Timeout: downloadClient.Timeout, Transport: &RecordingTransport{Dir: filepath.Join(cfg().RecordDir, recordedHTTPDir), Next: downloadClient.Transport}}
+	}
+	openAI.Store(clients)
+	return nil
+}
+
+// downloads returns the client images are downloaded with.
+func downloads() *http.Client {
+	if clients := openAI.Load(); clients != nil && clients.downloads != nil {
+		return clients.downloads
+	}
+	return downloadClient
+}
+
+// runtimeGuildKeys are the keys admins set with setkey, which take precedence
+// over GuildKeys. guildKeysMu guards it.
+var (
+	runtimeGuildKeys = make(map[string]string)
+	guildKeysMu      sync.RWMutex
+)
 
 func main() {
+	prompt := flag.String("prompt", "", "generate a single image for this prompt and exit without connecting to Discord")
+	out := flag.String("out", "", "with -prompt, save the image to this file instead of printing its URL")
+	flag.StringVar(&replayDir, "replay", "", "replay the session recorded in this recordDir offline, printing what the bot does, and exit")
+	flag.StringVar(&configPath, "config", "", "config file to load (default: the first of "+strings.Join(configFiles, ", ")+" in the working directory)")
+	flag.Parse()
+
+	var config Config
 	err := loadConfig(&config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if replayDir != "" {
+		// OpenAI answers from the recording, and nothing is saved
+		config.HTTPReplayDir = filepath.Join(replayDir, recordedHTTPDir)
+		config.HTTPRecordDir, config.RecordDir, config.HistoryDB, config.SpendFile = "", "", "", ""
+	}
+	currentConfig.Store(&config)
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setupOpenAI(); err != nil {
+		log.Fatal(err)
+	}
+	generationSlots.resize(cfg().MaxConcurrent)
+	mediaSlots.resize(cfg().MediaConcurrency)
+
+	if *prompt != "" {
+		if err := generateOnce(*prompt, *out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if replayDir != "" {
+		if err := replayEvents(replayDir, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := loadMaintenance(); err != nil {
+		log.Fatal(err)
+	}
+	if err := openHistory(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadSpend(); err != nil {
+		log.Fatal(err)
+	}
 
-	discord, err := discordgo.New("Bot " + config.DiscordToken)
+	discord, err := discordgo.New("Bot " + cfg().DiscordToken)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	discord.AddHandler(onMessageHandler)
-	discord.AddHandler(onEmojiAddHandler)
+	discord.AddHandler(live(recorded(eventMessageCreate, onMessageHandler)))
+	discord.AddHandler(live(recorded(eventReactionAdd, onEmojiAddHandler)))
+	discord.AddHandler(live(recorded(eventReactionRemove, onEmojiRemoveHandler)))
+	if cfg().EnableSlashCommands {
+		discord.AddHandler(live(recorded(eventInteractionCreate, onInteractionHandler)))
+	}
+
+	health := startHealthServer(discord)
 
 	err = discord.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer discord.Close()
+	if cfg().EnableSlashCommands {
+		if err := registerCommands(liveSession{discord}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ready.Store(true)
+	stopJanitor := startJanitor(liveSession{discord})
+	stopPush := startMetricsPush()
 
 	fmt.Println("DISC-E is listening. Press CTRL-C to exit")
 
@@ -70,213 +261,1069 @@ func main() {
 	case <-sc:
 		fmt.Println("\nExiting...")
 	}
+	ready.Store(false)
+	stopJanitor()
+	stopPendingDeletes()
+	shutdown(liveSession{discord})
+	// the last push counts the requests shutdown waited for
+	stopPush()
+	stopHealthServer(health)
+	if history != nil {
+		history.Close()
+	}
 }
 
-func loadConfig(config *Config) error {
-	jsonFile, err := os.Open("config.json")
-	if err != nil {
-		return err
+func onEmojiAddHandler(s session, r *discordgo.MessageReactionAdd) {
+	if !allowedChannel(s, r.GuildID, r.ChannelID) {
+		return
 	}
 
-	jsonBytes, err := ioutil.ReadAll(jsonFile)
-	if err != nil {
-		return err
+	// cancelling shouldn't wait out the debounce
+	if isCancelEmoji(r.Emoji.Name) {
+		if runningRequests.cancel(r.MessageID, r.UserID) {
+			slog.Info("Requester cancelled a request", "message_id", r.MessageID, "author_id", r.UserID)
+		}
+		return
 	}
 
-	if err = json.Unmarshal(jsonBytes, &config); err != nil {
-		return err
+	if cfg().ReactionDebounceMillis > 0 && r.UserID != s.botID() {
+		wait := time.Duration(cfg().ReactionDebounceMillis) * time.Millisecond
+		reactionDebouncer.schedule(reactionKey(r.MessageReaction), wait, func() {
+			handleReactionAdd(s, r)
+		})
+		return
 	}
+	handleReactionAdd(s, r)
+}
 
-	return nil
+// onEmojiRemoveHandler calls off a debounced reaction that was removed
+// before it settled.
+func onEmojiRemoveHandler(s session, r *discordgo.MessageReactionRemove) {
+	reactionDebouncer.cancel(reactionKey(r.MessageReaction))
+
+	// taking back a 🔁 also stops the re-roll it started, but the bot
+	// clearing its own reactions means nothing
+	if r.UserID == s.botID() || r.Emoji.APIName() != statusEmojis().Retry {
+		return
+	}
+	if runningRequests.cancel(r.MessageID, r.UserID) {
+		slog.Info("Requester withdrew a re-roll", "message_id", r.MessageID, "author_id", r.UserID)
+	}
 }
 
-func onEmojiAddHandler(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+// upscaleEmoji on a result re-rolls it at a bigger size.
+const upscaleEmoji = "🔍"
+
+func handleReactionAdd(s session, r *discordgo.MessageReactionAdd) {
 	// Get original message
 	m, err := s.ChannelMessage(r.ChannelID, r.MessageID)
 	if err != nil {
-		fmt.Printf("[%s] Error on getting message %v\n", r.MessageID, err)
+		slog.Error("Error on getting message", "message_id", r.MessageID, "channel_id", r.ChannelID, "error", err)
+		return
+	}
+
+	// Ignore user messages and reactions from the bot. Discord often leaves
+	// ReferencedMessage out, and results in a thread of their own aren't
+	// replies at all, so findRequestMessage decides what each one belongs to.
+	if m.Author.ID != s.botID() || r.MessageReaction.UserID == s.botID() {
 		return
 	}
 
-	// Ignore user messages, reactions from the bot, and messages with no replies
-	if m.Author.ID != s.State.User.ID || r.MessageReaction.UserID == s.State.User.ID || m.ReferencedMessage == nil {
+	if cfg().AutoPinThreshold > 0 && m.ChannelID == cfg().AutoPinChannelID {
+		handleAutoPin(s, m)
+	}
+
+	if cfg().DownvoteThreshold > 0 && r.Emoji.Name == downvoteEmoji() {
+		handleDownvote(s, m, &r.Emoji)
 		return
 	}
 
-	if r.Emoji.Name != "🔁" {
+	if isDeleteEmoji(r.Emoji.Name) {
+		handleDelete(s, r, m)
 		return
 	}
 
-	// Find the original message requesting the image
-	m = m.ReferencedMessage
-	content := strings.ToLower(m.Content)
-	for {
-		hasNullReply := m.ReferencedMessage == nil
-		fromBot := m.Author.ID == s.State.User.ID
-		hasCorrectFormat := len(content) >= 7 && content[:7] == "/dalle "
-
-		if fromBot {
-			if hasNullReply {
-				// Ignore bot messages with no replies
-				return
-			} else {
-				// Bot message has a reply, keep searching
-				m = m.ReferencedMessage
-				content = strings.ToLower(m.Content)
-				continue
-			}
-		} else {
-			if hasCorrectFormat {
-				// Message from the user in the correct format, we found our message
-				break
-			} else {
-				// Irrelevant user message, ignore
-				return
-			}
+	if cfg().EnablePromptCopy && r.Emoji.Name == "📋" {
+		if req := findRequestMessage(s, m); req != nil {
+			sendPromptCopy(s, r, req)
 		}
+		return
+	}
+
+	if cfg().EnableFavorites && r.Emoji.Name == "⭐" {
+		sendFavorite(s, r, m)
+		return
 	}
 
-	prompt := strings.TrimSpace(content[7:])
-	guild, _ := s.Guild(m.GuildID)
-	channel, _ := s.Channel(m.ChannelID)
+	// 🔍 re-rolls at the next size up, and 🎯 keeping the composition
+	upscale := r.Emoji.Name == upscaleEmoji
+	composition := r.Emoji.Name == compositionEmoji
+	if r.Emoji.APIName() != statusEmojis().Retry && !upscale && !composition {
+		return
+	}
+	// several images together have no one composition to keep
+	if composition && resultImageCount(m) > 1 {
+		s.ChannelMessageSendReply(r.ChannelID, tr(locale(), "reroll.severalImages", compositionEmoji), m.Reference())
+		return
+	}
+	if !rerollsInFlight.acquire(r.MessageID, 1) {
+		slog.Info("Already re-rolling message, ignoring reaction", "message_id", r.MessageID, "author_id", r.UserID)
+		return
+	}
+	defer rerollsInFlight.release(r.MessageID)
+
+	// Find the original message requesting the image
+	reroll := m
+	m = findRequestMessage(s, m)
+	if m == nil {
+		return
+	}
+
+	// a batch's results can't be matched back to their line
+	if len(batchCommands(m.Content)) > 1 {
+		return
+	}
+	command, _ := parseCommand(m.Content)
+	prompt := normalizePrompt(strings.ToLower(command))
+	_, prompt = parseTargetChannel(prompt)
+	prompt = sanitizePrompt(prompt, m.Mentions)
+	spoiler, prompt := parseSpoiler(prompt)
+	edit := false
+	if rest, ok := strings.CutPrefix(prompt, "edit "); ok && len(m.Attachments) > 0 {
+		edit, prompt = true, rest
+	}
+	model, autoModel, prompt := requestModel(r.GuildID, prompt)
+	size, prompt := parseSize(prompt)
+	count, prompt := parseCount(prompt, countLimit())
+	quality, style, prompt := parseStyle(prompt, model)
+	seed, prompt := parseSeed(prompt)
+	prompt = applyNegatives(prompt)
 
-	if prompt == "help" {
+	variation := prompt == "" && len(m.Attachments) > 0 && !edit
+	if (prompt == "" && !variation) || prompt == "help" || count == 0 {
+		return
+	}
+	if checkPromptLength(promptLocale(prompt, locale()), r.GuildID, model, prompt) != nil || blockedWord(prompt) != "" {
 		return
 	}
 
-	fmt.Printf("[%v] Sending variation for prompt: %s\n", r.MessageID, prompt)
-	setStatus(s, r.ChannelID, r.MessageID, "🤖")
+	if !hasCredentials(m.GuildID) {
+		slog.Warn("No OpenAI key configured", "message_id", r.MessageID, "guild_id", m.GuildID)
+		return
+	}
+	// a re-roll makes as many images as the reply it's on holds, so one of
+	// several sent apart is re-rolled on its own, even when the bot has
+	// restarted since
+	shown := resultImageCount(reroll)
+	single := isSingleImage(reroll.ID) || (shown == 1 && count > 1)
+	if single {
+		count = 1
+	} else if shown > 1 {
+		count = min(count, shown)
+	}
 
+	requestID := uuid.NewString()
 	imgReq := ImageRequest{
-		ID:       m.ID,
-		Prompt:   prompt,
-		AuthorID: m.Author.ID,
-		Guild:    guild,
-		Channel:  channel,
+		RequestID: requestID,
+		ID:        m.ID,
+		Prompt:    prompt,
+		AuthorID:  m.Author.ID,
+		Model:     model,
+		AutoModel: autoModel,
+		Size:      resolveSize(model, size),
+		N:         count,
+		Quality:   quality,
+		Style:     style,
+		Seed:      seed,
+		Spoiler:   spoiler,
+		Locale:    promptLocale(prompt, locale()),
+		GuildID:   m.GuildID,
+		// the result goes where it was re-rolled, which is the request's
+		// thread when it started one
+		ChannelID: r.ChannelID,
+		// every re-roll of a message is a new generation
+		IdempotencyKey: requestID,
 	}
+	ctx := newRequestContext(context.Background(), &imgReq)
 
-	imgURL, err := fetchImage(&imgReq)
-	if err != nil {
-		fmt.Printf("[%s] Error on getting message %v\n", r.MessageID, err)
+	// the old result may predate uploads and link to an image that's gone
+	go rehostExpiredImage(ctx, s, reroll)
+
+	if variation {
+		source, err := variationSource(ctx, m)
+		if err != nil {
+			logger(ctx).Error("Error on loading attached image", "error", err)
+			return
+		}
+		imgReq.Model, imgReq.AutoModel = variationModel, false
+		imgReq.Size = resolveSize(variationModel, size)
+		imgReq.Source = source
+	}
+	if edit {
+		source, mask, err := editSources(ctx, m)
+		if err != nil {
+			logger(ctx).Error("Error on loading attached images", "error", err)
+			return
+		}
+		imgReq.Model, imgReq.AutoModel = variationModel, false
+		imgReq.Size = resolveSize(variationModel, size)
+		imgReq.Source, imgReq.Mask = source, mask
+	}
+	if composition {
+		if err := keepComposition(ctx, &imgReq, reroll, size); err != nil {
+			logger(ctx).Error("Error on loading result image", "error", err)
+			setStatus(s, r.ChannelID, r.MessageID, "⚠️")
+			return
+		}
+	}
+	imgReq.N, imgReq.CountAsked = clampCount(imgReq.Model, imgReq.N)
+	if upscale {
+		bigger, ok := largerSize(imgReq.Model, imgReq.Size)
+		if !ok {
+			setStatus(s, r.ChannelID, r.MessageID, "⚠️")
+			return
+		}
+		imgReq.Size = bigger
+	}
+
+	if !allowedMember(s, r.GuildID, r.Member, r.UserID) {
+		setStatus(s, r.ChannelID, r.MessageID, "🔒")
 		return
 	}
-	reply, err := s.ChannelMessageSendReply(channel.ID, imgURL, m.Reference())
-	if err != nil {
-		swapStatus(s, r.ChannelID, r.MessageID, "🤖", "❌")
-		fmt.Printf("[%s] %v\n", imgReq.ID, err)
+
+	if _, ok := inMaintenance(r.UserID); ok {
+		setStatus(s, r.ChannelID, r.MessageID, "🔧")
 		return
 	}
-	swapStatus(s, r.ChannelID, r.MessageID, "🤖", "✅")
-	setStatus(s, reply.ChannelID, reply.ID, "🔁")
 
-	fmt.Printf("[%s] Sent variation\n", imgReq.ID)
-}
+	if _, closed := outsideActiveHours(m.GuildID, r.UserID); closed {
+		setStatus(s, r.ChannelID, r.MessageID, "🌙")
+		return
+	}
 
-func onMessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	content := strings.ToLower(m.Content)
-	if len(content) <= 7 || content[:7] != "/dalle " || m.Author.ID == s.State.User.ID {
+	if ok, _ := userLimiter.allow(r.UserID, imgReq.count(), cfg().MaxRequestsPerMinute); !ok {
+		setStatus(s, r.ChannelID, r.MessageID, "⏳")
 		return
 	}
 
-	prompt := strings.TrimSpace(content[7:])
-	guild, _ := s.Guild(m.GuildID)
-	channel, _ := s.Channel(m.ChannelID)
+	if !userInFlight.acquire(r.UserID, cfg().MaxInFlightPerUser) {
+		setStatus(s, r.ChannelID, r.MessageID, "⏳")
+		return
+	}
+	defer userInFlight.release(r.UserID)
 
-	imgReq := ImageRequest{
-		ID:       m.ID,
-		Prompt:   prompt,
-		AuthorID: m.Message.Author.ID,
-		Guild:    guild,
-		Channel:  channel,
+	if !dailyQuota.take(r.UserID, imgReq.count(), cfg().DailyQuota) {
+		setStatus(s, r.ChannelID, r.MessageID, "🚫")
+		return
 	}
 
-	// display help message if relevant
-	if prompt == "help" {
-		s.ChannelMessageSend(imgReq.Channel.ID, "Type `/dalle` with some words to get an image! (`/dalle help` to display this message)\n🔁 = Click this to try again for a better picture\n🤖 = AI is working on it\n✅ = Done! I've sent your nightmare fuel\n❌ = It didn't work for some reason")
+	if !activeRequests.start(r.ChannelID, r.MessageID) {
 		return
 	}
+	defer activeRequests.done(r.ChannelID, r.MessageID)
 
-	// update status to show that AI is working on the request
-	err := s.MessageReactionAdd(imgReq.Channel.ID, imgReq.ID, "🤖")
-	if err != nil {
-		fmt.Printf("[%s] %s", imgReq.ID, err)
+	// whoever re-rolled can stop it with ⏹️ or by taking back their 🔁, even
+	// while it waits for a slot
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer runningRequests.add(r.MessageID, r.UserID, cancel)()
+
+	if !waitForSlot(ctx, s, r.ChannelID, r.MessageID) {
 		return
 	}
+	releaseSlot := generationSlots.releaser()
+	defer releaseSlot()
+	activeRequests.running(r.ChannelID, r.MessageID)
+
+	logger(ctx).Info("Sending variation", "prompt", prompt)
+	setStatus(s, r.ChannelID, r.MessageID, statusEmojis().Working)
 
-	// if SpecialUser is set, send them their special reply
-	if config.SpecialUser != "" && config.SpecialUser == imgReq.AuthorID {
-		_, err := s.ChannelMessageSendReply(channel.ID, config.SpeicalReply, m.Reference())
+	succeeded := false
+	requestsTotal.Inc()
+	rerollsTotal.Inc()
+	defer func() { recordOutcome(succeeded) }()
+
+	if cfg().ModerationEnabled && prompt != "" {
+		flagged, err := moderatePrompt(ctx, prompt)
 		if err != nil {
-			fmt.Printf("[%s] %v\n", imgReq.ID, err)
-			swapStatus(s, imgReq.Channel.ID, imgReq.ID, "🤖", "❌")
+			logger(ctx).Error("Error on moderating prompt", "error", err)
+		} else if flagged != nil && cfg().AutoSpoilerModerated {
+			logger(ctx).Info("Prompt flagged, hiding it behind a spoiler", "categories", flagged)
+			imgReq.Spoiler = true
+		} else if flagged != nil {
+			logger(ctx).Info("Prompt flagged", "categories", flagged)
+			swapStatus(s, r.ChannelID, r.MessageID, statusEmojis().Working, "🚫")
 			return
 		}
 	}
 
-	// http request to AI backend
-	imgURL, err := fetchImage(&imgReq)
+	downgrade := applyCostDowngrade(&imgReq)
+
+	stopTyping := startTyping(ctx, s, imgReq.ChannelID)
+	images, err := generateImage(ctx, &imgReq)
+	stopTyping()
 	if err != nil {
-		fmt.Printf("[%s] %s\n", imgReq.ID, err)
-		swapStatus(s, imgReq.Channel.ID, imgReq.ID, "🤖", "❌")
+		failStatus(ctx, s, r.ChannelID, r.MessageID)
+		logger(ctx).Error("Error on getting image", "error", err)
 		return
 	}
+	// the image is paid for whether or not the reply goes through
+	recordSpend(&imgReq)
 
-	// send to channel
-	reply, err := s.ChannelMessageSendReply(channel.ID, imgURL, m.Reference())
+	imgURLs := urlsOf(images)
+	if !enterMediaStage(ctx, releaseSlot) {
+		failStatus(ctx, s, r.ChannelID, r.MessageID)
+		return
+	}
+	defer mediaSlots.release()
+	imgs, err := loadImages(ctx, images)
 	if err != nil {
-		fmt.Printf("[%s] %v\n", imgReq.ID, err)
-		swapStatus(s, imgReq.Channel.ID, imgReq.ID, "🤖", "❌")
+		failStatus(ctx, s, r.ChannelID, r.MessageID)
+		logger(ctx).Error("Error on downloading image", "error", err)
+		return
+	}
+	imgs, sensitive := blurSensitive(ctx, imgs)
+
+	caption := strings.TrimSpace(withRevisedPrompt(sensitiveNote(imgReq.Locale, sensitive)+downgrade+fallbackNote(&imgReq)+countNote(&imgReq)+autoModelNote(&imgReq)+settingsFooter(&imgReq)+costNote(&imgReq), images))
+	// replies can't cross channels, so a re-roll in a thread answers the
+	// image it was asked on instead
+	reference := m.Reference()
+	if m.ChannelID != imgReq.ChannelID {
+		reference = reroll.Reference()
+	}
+	if imgReq.Spoiler {
+		imgs = spoilered(imgs)
+	}
+	imgs = withAltText(imgs, images, imgReq.Prompt)
+	if ctx.Err() != nil {
+		failStatus(ctx, s, r.ChannelID, r.MessageID)
+		return
+	}
+	replies, err := sendResults(ctx, s, &imgReq, imgReq.ChannelID, caption, imgs, reference)
+	if err != nil && len(replies) == 0 {
+		failStatus(ctx, s, r.ChannelID, r.MessageID)
+		logSendError(ctx, err)
 		return
+	} else if err != nil {
+		logSendError(ctx, err)
+	}
+	succeeded = true
+	completeStatus(s, r.ChannelID, r.MessageID)
+	for _, reply := range replies {
+		// an image re-rolled on its own can go on being re-rolled that way
+		if single {
+			rememberSingleImage(reply.ID)
+		}
+		if reply.WebhookID == "" {
+			setStatus(s, reply.ChannelID, reply.ID, statusEmojis().Retry)
+		}
 	}
+	postResult(&imgReq, resultURL(replies[0], imgURLs[0]))
+
+	logf(ctx, "Sent variation")
+}
 
-	swapStatus(s, imgReq.Channel.ID, imgReq.ID, "🤖", "✅")
-	setStatus(s, reply.ChannelID, reply.ID, "🔁")
-	fmt.Printf("[%s] Successfully sent message to channel\n", imgReq.ID)
+// specialReply returns the reply configured for userID in SpecialReplies,
+// falling back to the single SpecialUser/SpecialReply pair.
+func specialReply(userID string) (string, bool) {
+	if reply, ok := cfg().SpecialReplies[userID]; ok {
+		return reply, true
+	}
+	if cfg().SpecialUser != "" && cfg().SpecialUser == userID {
+		return cfg().SpecialReply, true
+	}
+	return "", false
 }
 
-func fetchImage(imgReq *ImageRequest) (string, error) {
-	fmt.Printf("[%s] Fetching images for prompt %s\n", imgReq.ID, imgReq.Prompt)
+const defaultMaxReplyDepth = 10
 
-	// Create http request
-	url := "https://api.openai.com/v1/images/generations"
-	jsonStr := fmt.Sprintf(`{"prompt": "%s", "n": 1, "size": "512x512"}`, imgReq.Prompt)
-	jsonBytes := []byte(jsonStr)
+func maxReplyDepth() int {
+	if cfg().MaxReplyDepth > 0 {
+		return cfg().MaxReplyDepth
+	}
+	return defaultMaxReplyDepth
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return "", err
+// findRequestMessage walks the reply chain up from a bot image to the user's
+// command message that requested it, returning nil if there isn't one. Replies
+// Discord didn't include are fetched, at most MaxReplyDepth steps up.
+func findRequestMessage(s session, m *discordgo.Message) *discordgo.Message {
+	for depth := 0; depth < maxReplyDepth(); depth++ {
+		parent, err := referencedMessage(s, m)
+		if err != nil {
+			slog.Error("Error on getting replied-to message", "message_id", m.ID, "channel_id", m.ChannelID, "error", err)
+			return nil
+		}
+		if parent == nil {
+			// a result in a thread of its own continues from the thread's
+			// starter, otherwise the chain ended without reaching a request
+			if parent = threadStarter(s, m); parent == nil {
+				return nil
+			}
+		}
+		m = parent
+
+		if m.Author.ID == s.botID() {
+			// Bot message, keep searching
+			continue
+		}
+		if _, hasCorrectFormat := parseCommand(m.Content); hasCorrectFormat {
+			// Message from the user in the correct format, we found our message
+			return m
+		}
+		// Irrelevant user message, ignore
+		return nil
+	}
+	slog.Warn("Gave up walking a long reply chain", "message_id", m.ID, "channel_id", m.ChannelID, "max_depth", maxReplyDepth())
+	return nil
+}
+
+// referencedMessage returns the message m replies to, fetching it when
+// Discord only sent the reference, or nil when m isn't a reply.
+func referencedMessage(s session, m *discordgo.Message) (*discordgo.Message, error) {
+	if m.ReferencedMessage != nil {
+		return m.ReferencedMessage, nil
+	}
+	ref := m.MessageReference
+	if ref == nil || ref.MessageID == "" {
+		return nil, nil
+	}
+	channelID := ref.ChannelID
+	if channelID == "" {
+		channelID = m.ChannelID
+	}
+	return s.ChannelMessage(channelID, ref.MessageID)
+}
+
+func onMessageHandler(s session, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.botID() || cfg().DisableTextCommands {
+		return
+	}
+	command, ok := parseCommand(m.Content)
+	if !ok {
+		return
+	}
+
+	prompt := normalizePrompt(strings.ToLower(command))
+
+	// admin commands sent over DM
+	if m.GuildID == "" && strings.HasPrefix(prompt, "setkey") {
+		setGuildKey(s, m)
+		return
+	}
+	if prompt == "reload" {
+		reloadConfig(s, m)
+		return
+	}
+
+	if !allowedChannel(s, m.GuildID, m.ChannelID) {
+		return
+	}
+	if !allowedMember(s, m.GuildID, m.Member, m.Author.ID) {
+		setStatus(s, m.ChannelID, m.ID, "🔒")
+		return
+	}
+
+	if strings.HasPrefix(prompt, "maintenance") {
+		setMaintenance(s, m)
+		return
+	}
+	if isModelCommand(prompt, m.Author.ID) {
+		setGuildModel(s, m, prompt)
+		return
+	}
+	if reply, ok := styleCommand(context.Background(), locale(), m.Author.ID, prompt); ok {
+		sendReplyChunked(s, m.ChannelID, reply, nil, m.Reference())
+		return
+	}
+
+	if message, ok := inMaintenance(m.Author.ID); ok {
+		setStatus(s, m.ChannelID, m.ID, "🔧")
+		sendReplyChunked(s, m.ChannelID, message, nil, m.Reference())
+		return
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.OpenAIKey))
+	// several command lines in one message are a batch
+	if commands := batchCommands(m.Content); len(commands) > 1 {
+		handleBatch(s, m, commands)
+		return
+	}
+	handlePrompt(s, m, prompt, 0)
+}
 
-	// Make Request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// handlePrompt generates the images for a normalized prompt from m. line is
+// the prompt's place in a batch counting from 1, or 0 when it's the whole
+// message.
+func handlePrompt(s session, m *discordgo.MessageCreate, prompt string, line int) {
+	// preview shows admins the request without sending it
+	preview := false
+	if rest, ok := strings.CutPrefix(prompt, "preview "); ok {
+		preview, prompt = true, rest
+	}
+	// results can be sent to another channel with --to #channel
+	targetID, prompt := parseTargetChannel(prompt)
+	// mentions and custom emoji mean nothing to OpenAI
+	prompt = sanitizePrompt(prompt, m.Mentions)
+	// replies can be in the language the prompt is written in
+	promptLocale := promptLocale(prompt, locale())
+	// saved styles are filled in before the settings are read from it
+	prompt, err := expandStyles(context.Background(), promptLocale, m.Author.ID, prompt)
 	if err != nil {
-		return "", err
+		sendReplyChunked(s, m.ChannelID, err.Error(), nil, m.Reference())
+		return
+	}
+	// a leading spoiler hides the result
+	spoiler, prompt := parseSpoiler(prompt)
+	// "edit" with an image and a mask attached redraws the masked area
+	edit := false
+	if rest, ok := strings.CutPrefix(prompt, "edit "); ok && len(m.Attachments) > 0 {
+		edit, prompt = true, rest
+	}
+	// model:<name> anywhere picks the model, which otherwise may be picked
+	// for the prompt
+	model, autoModel, prompt := requestModel(m.GuildID, prompt)
+	// an optional leading 256, 512 or 1024 picks the size
+	size, prompt := parseSize(prompt)
+	// then an optional count asks for several images
+	count, prompt := parseCount(prompt, countLimit())
+	// dall-e-3 also takes a quality and style
+	quality, style, prompt := parseStyle(prompt, model)
+	// a seed can go anywhere
+	seed, prompt := parseSeed(prompt)
+	// and anything after a | is what to leave out
+	prompt = applyNegatives(prompt)
+
+	imgReq := ImageRequest{
+		RequestID: uuid.NewString(),
+		ID:        m.ID,
+		Prompt:    prompt,
+		AuthorID:  m.Message.Author.ID,
+		Model:     model,
+		AutoModel: autoModel,
+		Size:      resolveSize(model, size),
+		N:         count,
+		Quality:   quality,
+		Style:     style,
+		Seed:      seed,
+		Spoiler:   spoiler,
+		Locale:    promptLocale,
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		Batch:     line > 0,
+		// Discord delivers a message once, so its ID names the generation
+		IdempotencyKey: m.ID,
+	}
+	if line > 0 {
+		imgReq.IdempotencyKey = fmt.Sprintf("%s-%d", m.ID, line)
+	}
+	ctx := newRequestContext(context.Background(), &imgReq)
+
+	// redo runs the requester's last prompt again with its settings
+	if prompt == "redo" {
+		previous, ok := recentRequests.last(imgReq.AuthorID)
+		if !ok {
+			s.ChannelMessageSendReply(imgReq.ChannelID, tr(imgReq.Locale, "refuse.noRedo"), m.Reference())
+			return
+		}
+		imgReq.redo(previous)
+		count, prompt = imgReq.count(), imgReq.Prompt
+	}
+
+	if preview {
+		sendReplyChunked(s, imgReq.ChannelID, previewReply(&imgReq), nil, m.Reference())
+		return
+	}
+
+	// an image attached without a prompt asks for variations of it
+	variation := prompt == "" && len(m.Attachments) > 0 && !edit
+	if variation || edit {
+		imgReq.Model, imgReq.AutoModel = variationModel, false
+		imgReq.Size = resolveSize(variationModel, size)
+	}
+
+	// an empty prompt can offer the requester's recent ones instead
+	if prompt == "" && !variation && offerRecent(ctx, s, &imgReq, m.Reference()) {
+		return
+	}
+	// display help message if relevant, including when the prompt is empty
+	if (prompt == "" && !variation) || prompt == "help" {
+		sendReplyChunked(s, imgReq.ChannelID, helpText(imgReq.Locale), nil, nil)
+		return
+	}
+
+	if imgReq.Prompt == "stats --chart" {
+		chart, reply := statsChart(ctx, imgReq.AuthorID)
+		var imgs []*imageFile
+		if chart != nil {
+			imgs = append(imgs, chart)
+		}
+		if _, err := sendReplyChunked(s, imgReq.ChannelID, reply, imgs, m.Reference()); err != nil {
+			logger(ctx).Error("Error sending stats chart", "error", err)
+		}
+		return
+	}
+	if count, ok := parseCollage(imgReq.Prompt); ok {
+		sheet, reply := collage(ctx, imgReq.AuthorID, count)
+		var imgs []*imageFile
+		if sheet != nil {
+			imgs = append(imgs, sheet)
+		}
+		if _, err := sendReplyChunked(s, imgReq.ChannelID, reply, imgs, m.Reference()); err != nil {
+			logger(ctx).Error("Error sending collage", "error", err)
+		}
+		return
+	}
+	if reply, ok := promptReply(ctx, &imgReq); ok {
+		sendReplyChunked(s, imgReq.ChannelID, reply, nil, m.Reference())
+		return
+	}
+
+	if count == 0 {
+		s.ChannelMessageSendReply(imgReq.ChannelID, tr(imgReq.Locale, "refuse.count", maxImages(imgReq.Model)), m.Reference())
+		return
+	}
+	imgReq.N, imgReq.CountAsked = clampCount(imgReq.Model, imgReq.N)
+
+	if refused := generationRefusal(ctx, &imgReq); refused != nil {
+		if refused.status != "" {
+			setStatus(s, imgReq.ChannelID, imgReq.ID, refused.status)
+		}
+		if refused.wait > 0 {
+			sendLimitNotice(s, m, refused)
+		} else if !refused.quiet {
+			sendReplyChunked(s, imgReq.ChannelID, refused.reason, nil, m.Reference())
+		}
+		return
+	}
+
+	if targetID != "" {
+		if err := checkTargetChannel(s, m, targetID); err != nil {
+			sendReplyChunked(s, imgReq.ChannelID, err.Error(), nil, m.Reference())
+			return
+		}
+	}
+
+	if variation {
+		source, err := variationSource(ctx, m.Message)
+		if err != nil {
+			sendReplyChunked(s, imgReq.ChannelID, err.Error(), nil, m.Reference())
+			return
+		}
+		imgReq.Source = source
+	}
+	if edit {
+		source, mask, err := editSources(ctx, m.Message)
+		if err != nil {
+			sendReplyChunked(s, imgReq.ChannelID, err.Error(), nil, m.Reference())
+			return
+		}
+		imgReq.Source, imgReq.Mask = source, mask
+	}
+
+	if !userInFlight.acquire(imgReq.AuthorID, cfg().MaxInFlightPerUser) {
+		setStatus(s, imgReq.ChannelID, imgReq.ID, "⏳")
+		s.ChannelMessageSendReply(imgReq.ChannelID, tr(imgReq.Locale, "refuse.inFlight", cfg().MaxInFlightPerUser), m.Reference())
+		return
+	}
+	defer userInFlight.release(imgReq.AuthorID)
+
+	// failed requests still count against the quota
+	if !dailyQuota.take(imgReq.AuthorID, imgReq.count(), cfg().DailyQuota) {
+		setStatus(s, imgReq.ChannelID, imgReq.ID, "🚫")
+		reset := quotaReset().Round(time.Minute)
+		s.ChannelMessageSendReply(imgReq.ChannelID, tr(imgReq.Locale, "refuse.quotaIn", cfg().DailyQuota, int(reset.Hours()), int(reset.Minutes())%60), m.Reference())
+		return
+	}
+
+	// the bot is shutting down, don't start anything new
+	if !activeRequests.start(imgReq.ChannelID, imgReq.ID) {
+		return
+	}
+	defer activeRequests.done(imgReq.ChannelID, imgReq.ID)
+
+	// the requester can stop it with ⏹️, even while it waits for a slot
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer runningRequests.add(imgReq.ID, imgReq.AuthorID, cancel)()
+
+	// only MaxConcurrent generations run at once, the rest wait their turn
+	if !waitForSlot(ctx, s, imgReq.ChannelID, imgReq.ID) {
+		return
 	}
+	releaseSlot := generationSlots.releaser()
+	defer releaseSlot()
+	activeRequests.running(imgReq.ChannelID, imgReq.ID)
 
-	// Extract URL from response
-	b, err := ioutil.ReadAll(resp.Body)
+	// update status to show that AI is working on the request, which is
+	// worth answering without
+	err = s.MessageReactionAdd(imgReq.ChannelID, imgReq.ID, statusEmojis().Working)
 	if err != nil {
-		return "", err
+		reactionFailed(ctx, s, imgReq.ChannelID, err)
+	}
+
+	// from here every outcome goes in the history and the metrics
+	var results []string
+	succeeded := false
+	requestsTotal.Inc()
+	defer func() {
+		recordHistory(ctx, &imgReq, results, succeeded)
+		recordOutcome(succeeded)
+	}()
+
+	// special users get their special reply first
+	if reply, ok := specialReply(imgReq.AuthorID); ok {
+		_, err := sendReplyChunked(s, imgReq.ChannelID, reply, nil, m.Reference())
+		if err != nil {
+			logger(ctx).Error("Error on sending special reply", "error", err)
+			failRequest(ctx, s, &imgReq)
+			return
+		}
+	}
+
+	// a moderation outage doesn't stop generation, OpenAI still applies its
+	// own policy to the image request
+	if cfg().ModerationEnabled && imgReq.Prompt != "" {
+		flagged, err := moderatePrompt(ctx, imgReq.Prompt)
+		if err != nil {
+			logger(ctx).Error("Error on moderating prompt", "error", err)
+		} else if flagged != nil && cfg().AutoSpoilerModerated {
+			logger(ctx).Info("Prompt flagged, hiding it behind a spoiler", "categories", flagged)
+			imgReq.Spoiler = true
+		} else if flagged != nil {
+			logger(ctx).Info("Prompt flagged", "categories", flagged)
+			swapStatus(s, imgReq.ChannelID, imgReq.ID, statusEmojis().Working, "🚫")
+			sendReplyChunked(s, imgReq.ChannelID, tr(imgReq.Locale, "refuse.flagged", strings.Join(flagged, ", ")), nil, m.Reference())
+			return
+		}
+	}
+
+	// http request to AI backend
+	downgrade := applyCostDowngrade(&imgReq)
+	// identical prompts from a moment ago reuse those images
+	key := cacheKey(&imgReq)
+	images, imgs, cached := resultCache.get(key)
+	if cached {
+		logf(ctx, "Reusing cached images")
+	} else {
+		stopTyping := startTyping(ctx, s, imgReq.ChannelID)
+		images, err = generateImage(ctx, &imgReq)
+		stopTyping()
+		if err != nil {
+			logger(ctx).Error("Error on getting image", "error", err)
+			failRequest(ctx, s, &imgReq)
+			if reason := errorReason(imgReq.Locale, err); reason != "" {
+				if reply := sendErrorReply(ctx, s, m.Message, withRequestID(ctx, reason)); reply != nil {
+					scheduleDelete(s, reply.ChannelID, reply.ID)
+				}
+			}
+			return
+		}
+		// the image is paid for whether or not the reply goes through
+		recordSpend(&imgReq)
 	}
-	resp.Body.Close()
 
-	var r ImageResponse
-	err = json.Unmarshal(b, &r)
+	if !enterMediaStage(ctx, releaseSlot) {
+		failRequest(ctx, s, &imgReq)
+		return
+	}
+	defer mediaSlots.release()
+	if !cached {
+		imgs, err = loadImages(ctx, images)
+		if err != nil {
+			logger(ctx).Error("Error on downloading image", "error", err)
+			failRequest(ctx, s, &imgReq)
+			if reason := errorReason(imgReq.Locale, err); reason != "" {
+				if reply := sendErrorReply(ctx, s, m.Message, withRequestID(ctx, reason)); reply != nil {
+					scheduleDelete(s, reply.ChannelID, reply.ID)
+				}
+			}
+			return
+		}
+		resultCache.put(key, images, imgs)
+	}
+	imgURLs := urlsOf(images)
+	if imgReq.Spoiler {
+		imgs = spoilered(imgs)
+	}
+	imgs, sensitive := blurSensitive(ctx, imgs)
+	imgs = withAltText(imgs, images, imgReq.Prompt)
+
+	// send to channel, or to the --to channel with a link back to the command
+	caption := sensitiveNote(imgReq.Locale, sensitive) + downgrade + fallbackNote(&imgReq) + countNote(&imgReq) + autoModelNote(&imgReq) + settingsFooter(&imgReq)
+	if !cached {
+		caption += costNote(&imgReq)
+	}
+	caption = strings.TrimSpace(withRevisedPrompt(caption, images))
+	// a request cancelled since its images came back isn't answered
+	if ctx.Err() != nil {
+		failRequest(ctx, s, &imgReq)
+		return
+	}
+	var replies []*discordgo.Message
+	if targetID != "" {
+		caption += "\n" + tr(imgReq.Locale, "reply.askedIn", imgReq.AuthorID, messageLink(m.GuildID, m.ChannelID, m.ID))
+		var reply *discordgo.Message
+		if reply, err = sendReply(ctx, s, targetID, strings.TrimSpace(caption), imgs, nil); err == nil {
+			replies = []*discordgo.Message{reply}
+		}
+	} else if threadID := promptThread(ctx, s, m.Message, imgReq.Prompt); threadID != "" {
+		replies, err = sendResults(ctx, s, &imgReq, threadID, caption, imgs, nil)
+	} else {
+		replies, err = sendResults(ctx, s, &imgReq, imgReq.ChannelID, caption, imgs, m.Reference())
+	}
 	if err != nil {
-		return "", err
+		logSendError(ctx, err)
+		// images already sent in replies of their own still made it
+		if len(replies) == 0 {
+			failRequest(ctx, s, &imgReq)
+			return
+		}
+	}
+
+	for i, reply := range replies {
+		urls := imgURLs
+		if len(replies) > 1 {
+			urls = imgURLs[i : i+1]
+		}
+		results = append(results, resultURLs(reply, urls)...)
+	}
+	succeeded = true
+	recentRequests.add(imgReq.AuthorID, &imgReq)
+	completeStatus(s, imgReq.ChannelID, imgReq.ID)
+	// re-rolls walk the reply chain, which a redirected or webhook result
+	// doesn't have, and can't tell which line of a batch a result is for
+	for _, reply := range replies {
+		if targetID == "" && reply.WebhookID == "" && !imgReq.Batch {
+			setStatus(s, reply.ChannelID, reply.ID, statusEmojis().Retry)
+		}
+	}
+	postResult(&imgReq, resultURL(replies[0], imgURLs[0]))
+	logf(ctx, "Successfully sent message to channel")
+}
+
+// count is how many images imgReq asks for.
+func (imgReq *ImageRequest) count() int {
+	if imgReq.N < 1 {
+		return 1
+	}
+	return imgReq.N
+}
+
+// options are the generation settings for imgReq.
+func (imgReq *ImageRequest) options() imagegen.Options {
+	opts := imagegen.Options{
+		Model:          imgReq.Model,
+		Size:           imgReq.Size,
+		N:              imgReq.count(),
+		ResponseFormat: cfg().ResponseFormat,
+		IdempotencyKey: imgReq.IdempotencyKey,
+	}
+	// variations and edits switch to a model without them
+	if takesStyle(imgReq.Model) {
+		opts.Quality, opts.Style = imgReq.Quality, imgReq.Style
+	}
+	if cfg().SupportsSeed {
+		opts.Seed = imgReq.Seed
+	}
+	opts.Strength = imgReq.Strength
+	return opts
+}
+
+// fetchImage fetches imgReq's images from the main API, or from the first
+// provider in ProviderFallbackOrder that makes them when it's set.
+func fetchImage(ctx context.Context, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	defer observeFetch(time.Now())
+	if len(cfg().ProviderFallbackOrder) > 0 {
+		return fetchFromProviders(ctx, imgReq)
+	}
+	return fetchFromOpenAI(ctx, imgReq)
+}
+
+// fetchFromOpenAI fetches imgReq's images from the main API, taking a key
+// that turns out to be out of quota out of the rotation.
+func fetchFromOpenAI(ctx context.Context, imgReq *ImageRequest) (images []imagegen.Image, err error) {
+	ctx, used := withUsedKey(ctx)
+	defer func() { used.retireIfExhausted(ctx, err) }()
+	return requestImages(ctx, generator(), imgReq)
+}
+
+// requestImages asks gen for imgReq's images.
+func requestImages(ctx context.Context, gen imagegen.Generator, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	if imgReq.Strength != nil {
+		logger(ctx).Info("Fetching a redraw of a result", "prompt", imgReq.Prompt, "strength", *imgReq.Strength)
+		return gen.Edit(ctx, imgReq.Source, nil, styledPrompt(imgReq.GuildID, imgReq.Prompt), imgReq.options())
+	}
+	if imgReq.Mask != nil {
+		logger(ctx).Info("Fetching edits of an attached image", "prompt", imgReq.Prompt)
+		return gen.Edit(ctx, imgReq.Source, imgReq.Mask, styledPrompt(imgReq.GuildID, imgReq.Prompt), imgReq.options())
+	}
+	if imgReq.Source != nil {
+		logf(ctx, "Fetching variations of an attached image")
+		return gen.Vary(ctx, imgReq.Source, imgReq.options())
+	}
+	logger(ctx).Info("Fetching images", "prompt", imgReq.Prompt, "model", imgReq.Model, "size", imgReq.Size, "n", imgReq.count())
+	return gen.Generate(ctx, styledPrompt(imgReq.GuildID, imgReq.Prompt), imgReq.options())
+}
+
+// newGenerator builds the OpenAI generator from the config, sending requests
+// through client with each guild's credentials.
+func newGenerator(client *http.Client) imagegen.Generator {
+	return &imagegen.OpenAI{
+		BaseURL: apiBaseURL(),
+		Client:  client,
+		Authorize: func(req *http.Request) {
+			authorize(req, guildIDFrom(req.Context()))
+		},
+		Timeout:    requestTimeout(),
+		MaxRetries: cfg().MaxRetries,
+		Limiter:    apiLimiter(),
+		Logf:       logf,
+	}
+}
+
+// requestTimeout is how long a single call to OpenAI may take.
+func requestTimeout() time.Duration {
+	if cfg().RequestTimeoutSeconds > 0 {
+		return time.Duration(cfg().RequestTimeoutSeconds) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// openAIKeyFor returns the OpenAI key to bill a guild's requests to, taking
+// the next global key when the guild hasn't configured its own.
+func openAIKeyFor(guildID string) string {
+	if key := guildKey(guildID); key != "" {
+		return key
+	}
+	return apiKeys.pick()
+}
+
+// guildKey returns the key a guild has configured for itself, if any.
+func guildKey(guildID string) string {
+	guildKeysMu.RLock()
+	key := runtimeGuildKeys[guildID]
+	guildKeysMu.RUnlock()
+	if key != "" {
+		return key
+	}
+	return cfg().GuildKeys[guildID]
+}
+
+// setGuildKey handles the DM-only admin command "setkey <guildID> <key>".
+// Keys set this way are kept in memory until the bot restarts.
+func setGuildKey(s session, m *discordgo.MessageCreate) {
+	if !isAdmin(m.Author.ID) {
+		s.ChannelMessageSend(m.ChannelID, tr(locale(), "admin.setkey"))
+		return
+	}
+
+	// use the original content, keys are case sensitive
+	command, _ := parseCommand(m.Content)
+	args := strings.Fields(command)
+	if len(args) != 3 {
+		s.ChannelMessageSend(m.ChannelID, tr(locale(), "admin.setkeyUsage", commandPrefix()))
+		return
+	}
+	guildID, key := args[1], args[2]
+
+	guildKeysMu.Lock()
+	runtimeGuildKeys[guildID] = key
+	guildKeysMu.Unlock()
+
+	slog.Info("Admin set a guild's OpenAI key", "message_id", m.ID, "author_id", m.Author.ID, "guild_id", guildID)
+	s.ChannelMessageSend(m.ChannelID, tr(locale(), "admin.keySet", guildID))
+}
+
+func isAdmin(userID string) bool {
+	for _, id := range cfg().Admins {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// settingsFooter describes the resolved settings a request was generated
+// with, or returns an empty string when the footer is disabled.
+func settingsFooter(imgReq *ImageRequest) string {
+	if !cfg().ShowSettingsFooter {
+		return ""
+	}
+	settings := []string{"model " + imgReq.Model, "size " + imgReq.Size}
+	if takesStyle(imgReq.Model) {
+		if imgReq.Quality != "" {
+			settings = append(settings, "quality "+imgReq.Quality)
+		}
+		if imgReq.Style != "" {
+			settings = append(settings, "style "+imgReq.Style)
+		}
+	}
+	if seed := imgReq.options().Seed; seed != nil {
+		settings = append(settings, fmt.Sprintf("seed %d", *seed))
+	}
+	return "\n*" + tr(imgReq.Locale, "reply.settings", strings.Join(settings, " · ")) + "*"
+}
+
+// sendReply uploads images as a reply to a message (or as a plain message
+// when reference is nil). With HonorSlowMode set, a piece of the reply that's
+// rejected by the channel's slow-mode is sent once more after waiting it out,
+// without sending the pieces before it again.
+func sendReply(ctx context.Context, s session, channelID string, content string, imgs []*imageFile, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	if !cfg().HonorSlowMode {
+		return sendReplyChunked(s, channelID, content, imgs, reference)
 	}
+	return sendChunks(s, channelID, content, imgs, reference, func(err error) bool {
+		return waitSlowMode(ctx, s, channelID, err)
+	})
+}
 
-	imgURL := r.Data[0]["url"]
+// waitSlowMode waits out channelID's slow-mode interval when err is a
+// rejection by it, reporting whether the message should be sent again.
+func waitSlowMode(ctx context.Context, s session, channelID string, err error) bool {
+	if !isSlowModeError(err) {
+		return false
+	}
+	channel, chErr := s.Channel(channelID)
+	if chErr != nil {
+		return false
+	}
 
-	return imgURL, nil
+	wait := time.Duration(channel.RateLimitPerUser) * time.Second
+	logger(ctx).Info("Channel is in slow-mode, retrying reply", "wait", wait)
+	select {
+	case <-time.After(wait):
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
-func swapStatus(s *discordgo.Session, channelID string, messageID string, oldEmoji string, newEmoji string) error {
+// resultURL prefers the uploaded attachment's URL, which outlives OpenAI's.
+func resultURL(reply *discordgo.Message, imgURL string) string {
+	if len(reply.Attachments) > 0 {
+		return reply.Attachments[0].URL
+	}
+	return imgURL
+}
+
+// resultURLs is resultURL for every image in a reply.
+func resultURLs(reply *discordgo.Message, imgURLs []string) []string {
+	if len(reply.Attachments) == 0 {
+		return imgURLs
+	}
+	urls := make([]string, 0, len(reply.Attachments))
+	for _, a := range reply.Attachments {
+		urls = append(urls, a.URL)
+	}
+	return urls
+}
+
+func isSlowModeError(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeThisActionCannotBePerformedDueToSlowmodeRateLimit
+}
+
+func swapStatus(s session, channelID string, messageID string, oldEmoji string, newEmoji string) error {
 	err := s.MessageReactionRemove(channelID, messageID, oldEmoji, "@me")
 	if err != nil {
 		return err
@@ -288,7 +1335,20 @@ func swapStatus(s *discordgo.Session, channelID string, messageID string, oldEmo
 	return nil
 }
 
-func setStatus(s *discordgo.Session, channelID string, messageID string, emoji string) error {
+// completeStatus marks a request as done, either by swapping 🤖 for ✅ or, with
+// ClearStatusOnComplete, by removing 🤖 and letting the reply speak for itself.
+// A request the janitor already marked overdue keeps its ❌.
+func completeStatus(s session, channelID string, messageID string) error {
+	if activeRequests.isOverdue(channelID, messageID) {
+		return nil
+	}
+	if cfg().ClearStatusOnComplete {
+		return s.MessageReactionRemove(channelID, messageID, statusEmojis().Working, "@me")
+	}
+	return swapStatus(s, channelID, messageID, statusEmojis().Working, statusEmojis().Done)
+}
+
+func setStatus(s session, channelID string, messageID string, emoji string) error {
 	err := s.MessageReactionAdd(channelID, messageID, emoji)
 	if err != nil {
 		return err
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPromptSendsImage(t *testing.T) {
+	d, api := setupBot(t, Config{}, "happy")
+	m := d.post("happy", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox"}) {
+		t.Fatalf("generated %q, want just the prompt", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	reply := sent[0]
+	if reply.ChannelID != "happy" || reply.MessageReference == nil || reply.MessageReference.MessageID != m.ID {
+		t.Errorf("reply went to %s replying to %+v, want a reply to %s in happy", reply.ChannelID, reply.MessageReference, m.ID)
+	}
+	if len(reply.Files) != 1 || len(reply.Files[0]) == 0 {
+		t.Errorf("reply has %d files, want the image", len(reply.Files))
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+		t.Errorf("request shows %q, want 🤖 swapped for ✅", got)
+	}
+	if got := d.reactionsOn(reply.ID); !reflect.DeepEqual(got, []string{"🔁"}) {
+		t.Errorf("result shows %q, want 🔁", got)
+	}
+}
+
+func TestPromptRejected(t *testing.T) {
+	d, api := setupBot(t, Config{}, "rejected")
+	api.fail(http.StatusBadRequest, `{"error": {"message": "Your request was rejected by our safety system.", "type": "invalid_request_error", "code": "content_policy_violation"}}`)
+	m := d.post("rejected", "user", "/dalle something forbidden")
+
+	onMessageHandler(d, m)
+
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want the error reply", len(sent))
+	}
+	if want := "Your prompt was rejected: Your request was rejected by our safety system."; !strings.Contains(sent[0].Content, want) {
+		t.Errorf("error reply is %q, want it to contain %q", sent[0].Content, want)
+	}
+	if len(sent[0].Files) != 0 {
+		t.Errorf("error reply has %d files, want none", len(sent[0].Files))
+	}
+}
+
+func TestPromptOpenAIDown(t *testing.T) {
+	d, api := setupBot(t, Config{}, "down")
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+	m := d.post("down", "user", "/dalle a lighthouse")
+
+	onMessageHandler(d, m)
+
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, "OpenAI couldn't make that image: <html>Bad Gateway</html>") {
+		t.Errorf("sent %+v, want one reply explaining OpenAI failed", sent)
+	}
+}
+
+func TestPromptReplyFails(t *testing.T) {
+	d, api := setupBot(t, Config{}, "unsendable")
+	d.failSends = errors.New("500 Internal Server Error")
+	m := d.post("unsendable", "user", "/dalle a teapot")
+
+	onMessageHandler(d, m)
+
+	if len(api.generated()) != 1 {
+		t.Errorf("generated %d times, want once", len(api.generated()))
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+}
+
+func TestReactionRerolls(t *testing.T) {
+	d, api := setupBot(t, Config{}, "reroll")
+	m := d.post("reroll", "user", "/dalle a blue whale")
+	onMessageHandler(d, m)
+	first := d.sentMessages()[0]
+
+	onEmojiAddHandler(d, d.react("reroll", first.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 2 || got[1] != "a blue whale" {
+		t.Fatalf("generated %q, want the prompt twice", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the re-roll too", len(sent))
+	}
+	if ref := sent[1].MessageReference; ref == nil || ref.MessageID != m.ID {
+		t.Errorf("re-roll replies to %+v, want the request %s", ref, m.ID)
+	}
+}
+
+// replyChain sends n bot messages to channelID, each replying to the last,
+// starting with one replying to messageID, and returns the last.
+func replyChain(t *testing.T, d *fakeDiscord, channelID string, messageID string, n int) *sentMessage {
+	t.Helper()
+	var last *sentMessage
+	for i := 0; i < n; i++ {
+		var err error
+		last, err = d.send(channelID, "still here", &discordgo.MessageReference{MessageID: messageID, ChannelID: channelID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		messageID = last.ID
+	}
+	return last
+}
+
+func TestRerollReplyDepthCap(t *testing.T) {
+	logged := captureLogs(t)
+	d, api := setupBot(t, Config{}, "deep")
+	onMessageHandler(d, d.post("deep", "user", "/dalle a blue whale"))
+	deepest := replyChain(t, d, "deep", d.sentMessages()[0].ID, defaultMaxReplyDepth)
+
+	onEmojiAddHandler(d, d.react("deep", deepest.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want no re-roll past the depth cap", got)
+	}
+	if len(logged("Gave up walking a long reply chain")) != 1 {
+		t.Error("giving up on the reply chain wasn't logged")
+	}
+	if got := d.reactionsOn(deepest.ID); len(got) != 0 {
+		t.Errorf("reacted %q to the deepest reply, want no status left on it", got)
+	}
+
+	useConfig(t, Config{BaseURL: api.URL, MaxReplyDepth: defaultMaxReplyDepth + 1})
+	onEmojiAddHandler(d, d.react("deep", deepest.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %q, want a re-roll within a raised cap", got)
+	}
+}
+
+func TestRerollBrokenReplyChain(t *testing.T) {
+	logged := captureLogs(t)
+	d, api := setupBot(t, Config{}, "broken")
+	broken := replyChain(t, d, "broken", "deleted", 3)
+
+	onEmojiAddHandler(d, d.react("broken", broken.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing for a broken chain", got)
+	}
+	if len(logged("Error on getting replied-to message")) != 1 {
+		t.Error("the broken reply chain wasn't logged")
+	}
+	if got := d.reactionsOn(broken.ID); len(got) != 0 {
+		t.Errorf("reacted %q to the broken reply, want no status left on it", got)
+	}
+}
+
+func TestThreadPerPrompt(t *testing.T) {
+	d, api := setupBot(t, Config{CreateThreadPerPrompt: true}, "threads")
+	m := d.post("threads", "user", "/dalle a lighthouse at night")
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	result := sent[0]
+	if result.ChannelID != m.ID {
+		t.Fatalf("result went to %s, want the request's thread %s", result.ChannelID, m.ID)
+	}
+
+	// results in their own thread aren't replies, so a re-roll has to find
+	// the request through the thread's starter
+	onEmojiAddHandler(d, d.react(result.ChannelID, result.ID, "user", "🔁"))
+
+	if got := api.generated(); len(got) != 2 || got[1] != "a lighthouse at night" {
+		t.Fatalf("generated %q, want the prompt again", got)
+	}
+	sent = d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the re-roll too", len(sent))
+	}
+	if sent[1].ChannelID != m.ID {
+		t.Errorf("re-roll went to %s, want the thread %s", sent[1].ChannelID, m.ID)
+	}
+	if ref := sent[1].MessageReference; ref == nil || ref.MessageID != result.ID {
+		t.Errorf("re-roll replies to %+v, want the result it was asked on", ref)
+	}
+}
+
+func TestReplyInExistingThread(t *testing.T) {
+	d, _ := setupBot(t, Config{CreateThreadPerPrompt: true}, "parent")
+	d.channels["thread"] = &discordgo.Channel{ID: "thread", GuildID: testGuildID, ParentID: "parent", Type: discordgo.ChannelTypeGuildPublicThread}
+	m := d.post("thread", "user", "/dalle a paper boat")
+
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].ChannelID != "thread" {
+		t.Fatalf("sent %+v, want one reply in the thread", sent)
+	}
+	if ref := sent[0].MessageReference; ref == nil || ref.MessageID != m.ID {
+		t.Errorf("reply replies to %+v, want the request", ref)
+	}
+}
+
+func TestChannelCooldown(t *testing.T) {
+	d, api := setupBot(t, Config{ChannelCooldownSeconds: 60}, "slow")
+	d.addChannel("other")
+
+	onMessageHandler(d, d.post("slow", "user", "/dalle a first dog"))
+	second := d.post("slow", "someone", "/dalle a second dog")
+	onMessageHandler(d, second)
+	onMessageHandler(d, d.post("other", "someone", "/dalle a third dog"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a first dog", "a third dog"}) {
+		t.Errorf("generated %q, want the second request in the channel held back", got)
+	}
+	if got := d.reactionsOn(second.ID); !reflect.DeepEqual(got, []string{"⏳"}) {
+		t.Errorf("held back request shows %q, want ⏳", got)
+	}
+	if got := len(d.sentMessages()); got != 2 {
+		t.Errorf("sent %d messages, want only the two results", got)
+	}
+}
+
+func TestFallbackOnlyForRejections(t *testing.T) {
+	d, api := setupBot(t, Config{FallbackModels: []string{"dall-e-3"}}, "fallback")
+	api.fail(http.StatusBadRequest, `{"error": {"message": "Invalid size.", "type": "invalid_request_error", "code": "invalid_value"}}`)
+	onMessageHandler(d, d.post("fallback", "user", "/dalle a bad request"))
+	if got := len(api.generated()); got != 1 {
+		t.Errorf("a bad request was sent %d times, want no fallback", got)
+	}
+
+	api.fail(http.StatusBadRequest, `{"error": {"message": "Rejected.", "type": "invalid_request_error", "code": "content_policy_violation"}}`)
+	onMessageHandler(d, d.post("fallback", "user", "/dalle a rejected prompt"))
+	if got := len(api.generated()); got != 3 {
+		t.Errorf("a rejected prompt was sent %d times, want it tried on the fallback", got-1)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	useConfig(t, Config{})
+	if got := httpClient().Timeout; got != defaultRequestTimeout {
+		t.Errorf("client timeout is %v, want the %v default", got, defaultRequestTimeout)
+	}
+	useConfig(t, Config{RequestTimeoutSeconds: 5})
+	if got := httpClient().Timeout; got != 5*time.Second {
+		t.Errorf("client timeout is %v, want 5s", got)
+	}
+}
+
+func TestCancelWhileQueued(t *testing.T) {
+	d, api := setupBot(t, Config{MaxConcurrent: 1}, "queued")
+	holdSlots(t)
+
+	m := d.post("queued", "user", "/dalle a long wait")
+	done := make(chan struct{})
+	go func() {
+		onMessageHandler(d, m)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); !reflect.DeepEqual(d.reactionsOn(m.ID), []string{"⌛"}); {
+		if time.Now().After(deadline) {
+			t.Fatalf("request shows %q, want it queued with ⌛", d.reactionsOn(m.ID))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	onEmojiAddHandler(d, d.react("queued", m.ID, "user", "⏹️"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request is still waiting for a slot")
+	}
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing", got)
+	}
+}
+
+func TestEmptyPromptShowsHelp(t *testing.T) {
+	for _, content := range []string{"/dalle", "/dalle ", "/dalle   "} {
+		d, api := setupBot(t, Config{}, "empty")
+		onMessageHandler(d, d.post("empty", "user", content))
+
+		if got := api.generated(); len(got) != 0 {
+			t.Errorf("%q generated %q, want nothing sent to OpenAI", content, got)
+		}
+		sent := d.sentMessages()
+		if len(sent) != 1 || sent[0].Content != helpText("en") {
+			t.Errorf("%q sent %+v, want the help message", content, sent)
+		}
+	}
+}
+
+func TestPromptSendsSeveralImages(t *testing.T) {
+	d, api := setupBot(t, Config{MaxImages: 4}, "several")
+	onMessageHandler(d, d.post("several", "user", "/dalle 3 a sunset"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a sunset"}) {
+		t.Fatalf("generated %q, want one request for the prompt", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 3 {
+		t.Fatalf("sent %+v, want one reply with all 3 images", sent)
+	}
+}
+
+func TestPromptCountZeroRefused(t *testing.T) {
+	d, api := setupBot(t, Config{MaxImages: 4}, "none")
+	onMessageHandler(d, d.post("none", "user", "/dalle 0 a sunset"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing for 0 images", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != "Ask for between 1 and 4 images." {
+		t.Errorf("sent %+v, want the count refused", sent)
+	}
+}
+
+func TestNegativesSentToOpenAI(t *testing.T) {
+	d, api := setupBot(t, Config{}, "negatives")
+
+	onMessageHandler(d, d.post("negatives", "user", "/dalle a forest | no people, no buildings"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a forest, without: people, buildings"}) {
+		t.Errorf("generated %q, want the negatives folded into the prompt", got)
+	}
+}
+
+func TestStyleSentOnlyForDallE3(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-3"}, "styled")
+	onMessageHandler(d, d.post("styled", "user", "/dalle hd natural a cabin"))
+
+	useConfig(t, Config{BaseURL: api.URL, Model: "dall-e-2", DefaultQuality: "hd"})
+	onMessageHandler(d, d.post("styled", "user", "/dalle hd natural a cabin"))
+
+	got := api.generations()
+	if len(got) != 2 {
+		t.Fatalf("asked for %d generations, want 2", len(got))
+	}
+	if g := got[0]; g.Prompt != "a cabin" || g.Quality == nil || *g.Quality != "hd" || g.Style == nil || *g.Style != "natural" {
+		t.Errorf("dall-e-3 was asked for %+v, want an hd, natural cabin", g)
+	}
+	if g := got[1]; g.Prompt != "hd natural a cabin" || g.Quality != nil || g.Style != nil {
+		t.Errorf("dall-e-2 was asked for %+v, want the whole prompt and no quality or style", g)
+	}
+}
+
+func TestSeedSentWhenSupported(t *testing.T) {
+	d, api := setupBot(t, Config{SupportsSeed: true}, "seeded")
+	onMessageHandler(d, d.post("seeded", "user", "/dalle a red fox seed:42"))
+
+	useConfig(t, Config{BaseURL: api.URL})
+	onMessageHandler(d, d.post("seeded", "user", "/dalle a red fox seed:42"))
+
+	got := api.generations()
+	if len(got) != 2 {
+		t.Fatalf("asked for %d generations, want 2", len(got))
+	}
+	if g := got[0]; g.Prompt != "a red fox" || g.Seed == nil || *g.Seed != 42 {
+		t.Errorf("backend taking seeds was asked for %+v, want seed 42", g)
+	}
+	if g := got[1]; g.Prompt != "a red fox" || g.Seed != nil {
+		t.Errorf("backend without seeds was asked for %+v, want no seed", g)
+	}
+}
+
+func TestBase64ResponseUploaded(t *testing.T) {
+	d, api := setupBot(t, Config{ResponseFormat: "b64_json"}, "inline")
+	m := d.post("inline", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if got := api.generations(); len(got) != 1 || got[0].ResponseFormat != "b64_json" {
+		t.Fatalf("asked for %+v, want b64_json sent", got)
+	}
+	// the fake sends no link in this mode, so the image can only have come
+	// from the response
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %d messages, want one with the decoded image", len(sent))
+	}
+	if !bytes.Equal(sent[0].Files[0], testPNG()) {
+		t.Errorf("uploaded %d bytes, want the PNG from the response", len(sent[0].Files[0]))
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+		t.Errorf("request shows %q, want ✅", got)
+	}
+}
+
+func TestRevisedPromptInReply(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-3", ShowRevisedPrompt: true}, "revised")
+	api.revise("A small red fox curled up in fresh snow, in soft morning light.")
+
+	onMessageHandler(d, d.post("revised", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if !strings.HasPrefix(sent[0].Content, "*A small red fox curled up in fresh snow, in soft morning light.*") {
+		t.Errorf("sent %q, want the revised prompt captioning the image", sent[0].Content)
+	}
+}
+
+func TestFallbackMakesImage(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-3", FallbackModels: []string{"dall-e-2"}}, "fallback")
+	api.failModel("dall-e-3", http.StatusBadRequest, rejectedPrompt)
+
+	onMessageHandler(d, d.post("fallback", "user", "/dalle a rejected prompt"))
+
+	var models []string
+	for _, g := range api.generations() {
+		models = append(models, g.Model)
+	}
+	if !reflect.DeepEqual(models, []string{"dall-e-3", "dall-e-2"}) {
+		t.Errorf("tried %q, want dall-e-3 then its fallback", models)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %+v, want the fallback's image", sent)
+	}
+	if !strings.Contains(sent[0].Content, "dall-e-3 couldn't make this one, so dall-e-2 did.") {
+		t.Errorf("reply %q doesn't say which model made the image", sent[0].Content)
+	}
+}
+
+func TestFallbacksAllFail(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-3", FallbackModels: []string{"dall-e-2"}}, "fallback")
+	api.fail(http.StatusBadRequest, rejectedPrompt)
+	m := d.post("fallback", "user", "/dalle a rejected prompt")
+
+	onMessageHandler(d, m)
+
+	if got := len(api.generated()); got != 2 {
+		t.Errorf("tried %d models, want the primary and its fallback", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 0 || !strings.Contains(sent[0].Content, rejectedReason) {
+		t.Errorf("sent %+v, want just the rejection", sent)
+	}
+}
+
+func TestSpoilerPrompt(t *testing.T) {
+	for _, content := range []string{"/dalle spoiler a red fox", "/dalle ⚠️ a red fox"} {
+		d, api := setupBot(t, Config{}, "spoiler")
+
+		onMessageHandler(d, d.post("spoiler", "user", content))
+
+		if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox"}) {
+			t.Errorf("%q generated %q, want the token taken off", content, got)
+		}
+		sent := d.sentMessages()
+		if len(sent) != 1 || len(sent[0].FileNames) != 1 || !strings.HasPrefix(sent[0].FileNames[0], "SPOILER_") {
+			t.Errorf("%q sent %+v, want the image uploaded as a spoiler", content, sent)
+		}
+	}
+}
+
+func TestReactionUpscales(t *testing.T) {
+	d, api := setupBot(t, Config{}, "upscale")
+	onMessageHandler(d, d.post("upscale", "user", "/dalle 512 a blue whale"))
+
+	onEmojiAddHandler(d, d.react("upscale", d.sentMessages()[0].ID, "user", "🔍"))
+
+	got := api.generations()
+	if len(got) != 2 {
+		t.Fatalf("asked for %d generations, want the upscale too", len(got))
+	}
+	if got[0].Size != "512x512" || got[1].Size != "1024x1024" || got[1].Prompt != "a blue whale" {
+		t.Errorf("asked for %s then %s %q, want the same prompt at 1024x1024", got[0].Size, got[1].Size, got[1].Prompt)
+	}
+}
+
+func TestUpscaleAtLargestSize(t *testing.T) {
+	d, api := setupBot(t, Config{}, "upscale")
+	onMessageHandler(d, d.post("upscale", "user", "/dalle 1024 a blue whale"))
+	first := d.sentMessages()[0]
+
+	onEmojiAddHandler(d, d.react("upscale", first.ID, "user", "🔍"))
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want no upscale past the largest size", got)
+	}
+	if got := d.reactionsOn(first.ID); !reflect.DeepEqual(got, []string{"🔁", "⚠️"}) {
+		t.Errorf("result shows %q, want a warning added", got)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultMaintenanceFile = "maintenance.json"
+
+// maintenanceState is persisted to MaintenanceFile so the mode survives
+// restarts.
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+var (
+	maintenanceMu sync.RWMutex
+	maintenance   maintenanceState
+)
+
+func maintenanceFile() string {
+	if cfg().MaintenanceFile != "" {
+		return cfg().MaintenanceFile
+	}
+	return defaultMaintenanceFile
+}
+
+// loadMaintenance restores the maintenance mode saved by a previous run.
+func loadMaintenance() error {
+	b, err := ioutil.ReadFile(maintenanceFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return json.Unmarshal(b, &maintenance)
+}
+
+// inMaintenance returns the maintenance message when the bot is in
+// maintenance mode for userID. Admins are exempt so they can test.
+func inMaintenance(userID string) (string, bool) {
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+
+	if !maintenance.Enabled || isAdmin(userID) {
+		return "", false
+	}
+	return maintenance.Message, true
+}
+
+// setMaintenance handles the "maintenance on [message]" and
+// "maintenance off" commands.
+func setMaintenance(s session, m *discordgo.MessageCreate) {
+	if !isAdmin(m.Author.ID) {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.maintenance"), m.Reference())
+		return
+	}
+
+	// use the original content to keep the message's case
+	command, _ := parseCommand(m.Content)
+	args := strings.Fields(command)
+	if len(args) < 2 || (strings.ToLower(args[1]) != "on" && strings.ToLower(args[1]) != "off") {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.maintUsage", commandPrefix()), m.Reference())
+		return
+	}
+
+	state := maintenanceState{Enabled: strings.ToLower(args[1]) == "on"}
+	if state.Enabled {
+		state.Message = strings.Join(args[2:], " ")
+		if state.Message == "" {
+			state.Message = tr(locale(), "admin.maintMessage")
+		}
+	}
+
+	maintenanceMu.Lock()
+	maintenance = state
+	b, err := json.Marshal(state)
+	if err == nil {
+		err = ioutil.WriteFile(maintenanceFile(), b, 0644)
+	}
+	maintenanceMu.Unlock()
+
+	if err != nil {
+		slog.Error("Error saving maintenance mode", "message_id", m.ID, "error", err)
+	}
+
+	if state.Enabled {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.maintOn"), m.Reference())
+	} else {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.maintOff"), m.Reference())
+	}
+}
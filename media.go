@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+const (
+	defaultMaxDownloadBytes = 20 << 20
+	defaultDownloadTimeout  = 60 * time.Second
+)
+
+// maxDownloadBytes caps how much of a generated image is downloaded.
+func maxDownloadBytes() int {
+	if cfg().MaxDownloadBytes > 0 {
+		return cfg().MaxDownloadBytes
+	}
+	return defaultMaxDownloadBytes
+}
+
+func downloadTimeout() time.Duration {
+	if cfg().DownloadTimeoutSeconds > 0 {
+		return time.Duration(cfg().DownloadTimeoutSeconds) * time.Second
+	}
+	return defaultDownloadTimeout
+}
+
+// downloadClient fetches images from OpenAI's and Discord's CDNs. It's kept
+// apart from httpClient, whose credentials, timeout and recording are only
+// meant for the API.
+var downloadClient = &http.Client{Timeout: defaultDownloadTimeout}
+
+// imageExtensions maps the image types we upload to their file extensions.
+var imageExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+// imageFile is a downloaded image ready to be uploaded to Discord.
+type imageFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// Description is the image's alt text, if it has one.
+	Description string
+}
+
+var (
+	// errImageTooLarge is returned for a download over its size limit.
+	errImageTooLarge = errors.New("image too large")
+	// errNotAnImage is returned for a download that isn't one of the image
+	// types in imageExtensions.
+	errNotAnImage = errors.New("not an image")
+)
+
+// downloadImage fetches a generated image so it can be uploaded as an
+// attachment rather than linked, since OpenAI's URLs expire after an hour.
+// The format is sniffed from the bytes instead of trusting the URL. It gives
+// up after DownloadTimeoutSeconds or MaxDownloadBytes.
+func downloadImage(ctx context.Context, imgURL string) (*imageFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout())
+	defer cancel()
+	return downloadLimited(ctx, imgURL, maxDownloadBytes())
+}
+
+// downloadLimited is downloadImage stopping at max bytes, so a huge file
+// can't use up memory. Responses that say they aren't an image aren't read.
+func downloadLimited(ctx context.Context, imgURL string, max int) (*imageFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", imgURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloads().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading image: %s", resp.Status)
+	}
+	// fall back to what the server says, minus any parameters
+	declaredType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if !downloadableType(declaredType) {
+		return nil, fmt.Errorf("downloading image: content type %q: %w", declaredType, errNotAnImage)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, fmt.Errorf("downloading image: larger than %d bytes: %w", max, errImageTooLarge)
+	}
+	return newImageFile(data, declaredType)
+}
+
+// downloadableType reports whether a download declared as contentType may
+// be an image. Servers that don't say, only say it's bytes or name an image
+// type of their own, like image/jpg, are given the benefit of the doubt until
+// the bytes are sniffed.
+func downloadableType(contentType string) bool {
+	if _, ok := imageExtensions[contentType]; ok || strings.HasPrefix(contentType, "image/") {
+		return true
+	}
+	return contentType == "" || contentType == "application/octet-stream"
+}
+
+// newImageFile wraps image bytes, sniffing their format and trusting
+// declaredType only when sniffing doesn't recognise it.
+func newImageFile(data []byte, declaredType string) (*imageFile, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		contentType = declaredType
+		if ext, ok = imageExtensions[contentType]; !ok {
+			return nil, fmt.Errorf("downloading image: unexpected content type %q: %w", contentType, errNotAnImage)
+		}
+	}
+
+	return &imageFile{
+		Name:        "disc-e." + ext,
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
+
+// downloadImages downloads each of imgURLs and runs it through the
+// post-processing pipeline, by default converting it to OutputFormat,
+// numbering the file names when there is more than one so they stay
+// distinct in the message. Stages that fail are skipped.
+func downloadImages(ctx context.Context, imgURLs []string) ([]*imageFile, error) {
+	images := make([]imagegen.Image, 0, len(imgURLs))
+	for _, imgURL := range imgURLs {
+		images = append(images, imagegen.Image{URL: imgURL})
+	}
+	return loadImages(ctx, images)
+}
+
+// loadImages is downloadImages for generated images, using the bytes of
+// those that came back as base64 instead of downloading them.
+func loadImages(ctx context.Context, images []imagegen.Image) ([]*imageFile, error) {
+	imgs := make([]*imageFile, 0, len(images))
+	for i, image := range images {
+		var img *imageFile
+		var err error
+		if len(image.Data) > 0 {
+			img, err = newImageFile(image.Data, "")
+		} else {
+			img, err = downloadImage(ctx, image.URL)
+		}
+		if err != nil {
+			return nil, err
+		}
+		postProcess(ctx, img)
+		if len(images) > 1 {
+			img.Name = fmt.Sprintf("disc-e-%d.%s", i+1, imageExtensions[img.ContentType])
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// spoilerPrefix in an attachment's name makes Discord blur it until clicked.
+const spoilerPrefix = "SPOILER_"
+
+// spoilerName returns name marked as a spoiler.
+func spoilerName(name string) string {
+	if strings.HasPrefix(name, spoilerPrefix) {
+		return name
+	}
+	return spoilerPrefix + name
+}
+
+// spoilered returns copies of imgs that upload as spoilers, leaving imgs
+// themselves alone since they may be cached.
+func spoilered(imgs []*imageFile) []*imageFile {
+	hidden := make([]*imageFile, 0, len(imgs))
+	for _, img := range imgs {
+		copied := *img
+		copied.Name = spoilerName(img.Name)
+		hidden = append(hidden, &copied)
+	}
+	return hidden
+}
+
+// discordFile wraps the image for a message upload. Each call returns a
+// fresh reader so a message can be re-sent.
+func (f *imageFile) discordFile() *discordgo.File {
+	return &discordgo.File{
+		Name:        f.Name,
+		ContentType: f.ContentType,
+		Reader:      bytes.NewReader(f.Data),
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+// serving returns the URL of a server answering every request with body as
+// contentType.
+func serving(t *testing.T, contentType string, body []byte) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestDownloadSkipsOpenAIClient(t *testing.T) {
+	// replaying answers OpenAI calls from recordings, which downloads from
+	// a CDN don't have
+	useConfig(t, Config{HTTPReplayDir: t.TempDir()})
+
+	img, err := downloadImage(context.Background(), serving(t, "image/png", testPNG())+"/0.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/png" || img.Name != "disc-e.png" {
+		t.Errorf("downloaded %s as %s, want a PNG", img.Name, img.ContentType)
+	}
+}
+
+func TestDownloadDetectsFormat(t *testing.T) {
+	useConfig(t, Config{})
+	decoded, _, err := image.Decode(bytes.NewReader(testPNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var photo, webp bytes.Buffer
+	if err := jpeg.Encode(&photo, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := nativewebp.Encode(&webp, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, contentType string
+		body              []byte
+		want, wantName    string
+	}{
+		{"png", "image/png", testPNG(), "image/png", "disc-e.png"},
+		{"jpeg", "image/jpeg", photo.Bytes(), "image/jpeg", "disc-e.jpg"},
+		{"webp", "image/webp", webp.Bytes(), "image/webp", "disc-e.webp"},
+		{"jpeg sent as png", "image/png", photo.Bytes(), "image/jpeg", "disc-e.jpg"},
+		{"webp sent as png", "image/png", webp.Bytes(), "image/webp", "disc-e.webp"},
+		{"undeclared jpeg", "", photo.Bytes(), "image/jpeg", "disc-e.jpg"},
+		{"jpeg sent as image/jpg", "image/jpg", photo.Bytes(), "image/jpeg", "disc-e.jpg"},
+		{"webp sent as bytes", "application/octet-stream", webp.Bytes(), "image/webp", "disc-e.webp"},
+	} {
+		img, err := downloadImage(context.Background(), serving(t, tt.contentType, tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if img.ContentType != tt.want || img.Name != tt.wantName {
+			t.Errorf("%s: downloaded %s as %s, want %s as %s", tt.name, img.Name, img.ContentType, tt.wantName, tt.want)
+		}
+	}
+}
+
+func TestLoadImagesNamesFormats(t *testing.T) {
+	useConfig(t, Config{})
+	decoded, _, err := image.Decode(bytes.NewReader(testPNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var photo, webp bytes.Buffer
+	jpeg.Encode(&photo, decoded, nil)
+	nativewebp.Encode(&webp, decoded, nil)
+
+	imgs, err := loadImages(context.Background(), []imagegen.Image{{Data: photo.Bytes()}, {Data: webp.Bytes()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, img := range imgs {
+		names = append(names, img.Name)
+	}
+	if want := []string{"disc-e-1.jpg", "disc-e-2.webp"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("named %q, want %q", names, want)
+	}
+}
+
+func TestDownloadTooLarge(t *testing.T) {
+	useConfig(t, Config{MaxDownloadBytes: 10})
+
+	_, err := downloadImage(context.Background(), serving(t, "image/png", testPNG()))
+
+	if !errors.Is(err, errImageTooLarge) {
+		t.Errorf("got %v, want errImageTooLarge", err)
+	}
+}
+
+func TestDownloadNotAnImage(t *testing.T) {
+	useConfig(t, Config{})
+
+	for _, tt := range []struct {
+		name, contentType string
+		body              []byte
+	}{
+		{"html page", "text/html; charset=utf-8", []byte("<html>Access denied</html>")},
+		{"image bytes sent as html", "text/html", testPNG()},
+		{"undeclared text", "application/octet-stream", []byte("not an image at all")},
+	} {
+		_, err := downloadImage(context.Background(), serving(t, tt.contentType, tt.body))
+		if !errors.Is(err, errNotAnImage) {
+			t.Errorf("%s: got %v, want errNotAnImage", tt.name, err)
+		}
+	}
+}
+
+func TestDownloadFailureExplained(t *testing.T) {
+	d, api := setupBot(t, Config{MaxDownloadBytes: 10}, "huge")
+	m := d.post("huge", "user", "/dalle a very detailed map")
+
+	onMessageHandler(d, m)
+
+	if len(api.generated()) != 1 {
+		t.Fatalf("generated %q, want the prompt once", api.generated())
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"❌"}) {
+		t.Errorf("request shows %q, want ❌", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, tr("en", "error.imageTooLarge")) {
+		t.Errorf("sent %+v, want a reply saying the image was too big", sent)
+	}
+}
+
+func TestSpoilerName(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"disc-e.png", "SPOILER_disc-e.png"},
+		{"disc-e-2.webp", "SPOILER_disc-e-2.webp"},
+		{"SPOILER_disc-e.png", "SPOILER_disc-e.png"},
+	} {
+		if got := spoilerName(tt.name); got != tt.want {
+			t.Errorf("spoilerName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpoileredLeavesOriginals(t *testing.T) {
+	imgs := []*imageFile{{Name: "disc-e-1.png"}, {Name: "disc-e-2.png"}}
+
+	hidden := spoilered(imgs)
+
+	if hidden[0].Name != "SPOILER_disc-e-1.png" || hidden[1].Name != "SPOILER_disc-e-2.png" {
+		t.Errorf("spoilered names %q and %q, want both prefixed", hidden[0].Name, hidden[1].Name)
+	}
+	if imgs[0].Name != "disc-e-1.png" || imgs[1].Name != "disc-e-2.png" {
+		t.Errorf("originals renamed to %q and %q, want them left alone", imgs[0].Name, imgs[1].Name)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "disce_requests_total",
+		Help: "Image requests that started generating.",
+	})
+	requestsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "disce_requests_succeeded_total",
+		Help: "Image requests whose images were sent.",
+	})
+	requestsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "disce_requests_failed_total",
+		Help: "Image requests that ended without sending an image.",
+	})
+	rerollsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "disce_rerolls_total",
+		Help: "Requests re-rolled with 🔁.",
+	})
+	fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "disce_fetch_image_seconds",
+		Help:    "Time taken by OpenAI to generate images.",
+		Buckets: []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90},
+	})
+	generationSlotsInUse = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "disce_generation_slots_in_use",
+		Help: "Requests holding a generation slot.",
+	}, func() float64 { return float64(generationSlots.inUse()) })
+	mediaSlotsInUse = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "disce_media_slots_in_use",
+		Help: "Requests downloading or uploading their images.",
+	}, func() float64 { return float64(mediaSlots.inUse()) })
+)
+
+// recordOutcome counts a finished request as a success or a failure.
+func recordOutcome(succeeded bool) {
+	if succeeded {
+		requestsSucceeded.Inc()
+	} else {
+		requestsFailed.Inc()
+	}
+}
+
+// observeFetch records how long a call to OpenAI took.
+func observeFetch(start time.Time) {
+	fetchDuration.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricValue reads a counter's or gauge's value, or a histogram's sample
+// count.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Histogram != nil {
+		return float64(out.Histogram.GetSampleCount())
+	}
+	if out.Gauge != nil {
+		return out.Gauge.GetValue()
+	}
+	return out.Counter.GetValue()
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	d, api := setupBot(t, Config{}, "metrics")
+	metrics := map[string]prometheus.Metric{
+		"requests":  requestsTotal,
+		"succeeded": requestsSucceeded,
+		"failed":    requestsFailed,
+		"rerolls":   rerollsTotal,
+		"fetches":   fetchDuration,
+	}
+	before := make(map[string]float64)
+	for name, m := range metrics {
+		before[name] = metricValue(t, m)
+	}
+
+	onMessageHandler(d, d.post("metrics", "user", "/dalle a red fox"))
+	onEmojiAddHandler(d, d.react("metrics", d.sentMessages()[0].ID, "user", "🔁"))
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+	onMessageHandler(d, d.post("metrics", "user", "/dalle a lighthouse"))
+
+	for name, want := range map[string]float64{"requests": 3, "succeeded": 2, "failed": 1, "rerolls": 1, "fetches": 3} {
+		if got := metricValue(t, metrics[name]) - before[name]; got != want {
+			t.Errorf("%s went up by %v, want %v", name, got, want)
+		}
+	}
+}
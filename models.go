@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const defaultModel = "dall-e-2"
+
+// modelSizes lists the sizes each supported model can generate, the first
+// being the one used when a request doesn't ask for a size.
+var modelSizes = map[string][]string{
+	"dall-e-2": {"512x512", "256x256", "1024x1024"},
+	"dall-e-3": {"1024x1024", "1792x1024", "1024x1792"},
+}
+
+// modelMaxImages is how many images each model can return per request.
+var modelMaxImages = map[string]int{
+	"dall-e-2": 10,
+	"dall-e-3": 1,
+}
+
+// maxImages returns how many images one request may ask model for, the
+// smallest of MaxImages, what the model supports and the MaxImages of the
+// providers it could be sent to.
+func maxImages(model string) int {
+	max := cfg().MaxImages
+	if limit, ok := modelMaxImages[model]; ok && max > limit {
+		max = limit
+	}
+	for _, name := range cfg().ProviderFallbackOrder {
+		if limit := cfg().Providers[name].MaxImages; limit > 0 && max > limit {
+			max = limit
+		}
+	}
+	if max < 1 {
+		return 1
+	}
+	return max
+}
+
+// countLimit is the largest count parseCount reads, so that a count over
+// maxImages can be told apart from a prompt starting with a number. Without
+// MaxImages prompts don't take a count.
+func countLimit() int {
+	if cfg().MaxImages > 1 {
+		return maxCountToken
+	}
+	return 1
+}
+
+// clampCount holds count to maxImages(model), returning how many were asked
+// for when it had to be lowered and 0 otherwise.
+func clampCount(model string, count int) (clamped int, asked int) {
+	if max := maxImages(model); count > max {
+		return max, count
+	}
+	return count, 0
+}
+
+// countNote tells the requester they got fewer images than they asked for.
+func countNote(imgReq *ImageRequest) string {
+	if imgReq.CountAsked == 0 {
+		return ""
+	}
+	return "\n*" + tr(imgReq.Locale, "reply.countClamped", imgReq.CountAsked, imgReq.count(), imgReq.Model) + "*"
+}
+
+// modelPromptLimits is the longest prompt, in characters, each model takes.
+var modelPromptLimits = map[string]int{
+	"dall-e-2": 1000,
+	"dall-e-3": 4000,
+}
+
+// checkPromptLength returns an error for the requester when prompt is too
+// long for model once PromptPrefix, PromptSuffix and guildID's suffix are
+// added. The limit it reports leaves room for them.
+func checkPromptLength(locale string, guildID string, model string, prompt string) error {
+	limit, ok := modelPromptLimits[model]
+	limit -= utf8.RuneCountInString(styledPrompt(guildID, prompt)) - utf8.RuneCountInString(prompt)
+	if n := utf8.RuneCountInString(prompt); ok && n > limit {
+		return errors.New(tr(locale, "refuse.length", n, limit))
+	}
+	return nil
+}
+
+// currentModel returns the configured model.
+func currentModel() string {
+	return cfg().model()
+}
+
+func (c *Config) model() string {
+	if c.Model != "" {
+		return c.Model
+	}
+	return defaultModel
+}
+
+// validateModel checks the model of c and its dall-e-3 defaults.
+func validateModel(c *Config) error {
+	if _, ok := modelSizes[c.model()]; !ok {
+		return fmt.Errorf("unsupported model %q, use dall-e-2 or dall-e-3", c.Model)
+	}
+	if q := c.DefaultQuality; q != "" && qualityTokens[q] == "" {
+		return fmt.Errorf("unsupported defaultQuality %q, use standard or hd", q)
+	}
+	if st := c.DefaultStyle; st != "" && styleTokens[st] == "" {
+		return fmt.Errorf("unsupported defaultStyle %q, use vivid or natural", st)
+	}
+	for _, model := range c.FallbackModels {
+		if _, ok := modelSizes[model]; !ok {
+			return fmt.Errorf("unsupported fallback model %q, use dall-e-2 or dall-e-3", model)
+		}
+	}
+	return nil
+}
+
+// takesStyle reports whether model accepts a quality and style.
+func takesStyle(model string) bool {
+	return model == "dall-e-3"
+}
+
+// largerSize returns the smallest size model supports that's bigger than size
+// with the same shape, and false when size is already the largest.
+func largerSize(model string, size string) (string, bool) {
+	var w, h int
+	if _, err := fmt.Sscanf(size, "%dx%d", &w, &h); err != nil || w == 0 || h == 0 {
+		return "", false
+	}
+	best, bestW := "", 0
+	for _, s := range modelSizes[model] {
+		var sw, sh int
+		if _, err := fmt.Sscanf(s, "%dx%d", &sw, &sh); err != nil {
+			continue
+		}
+		if sw*h == sh*w && sw > w && (best == "" || sw < bestW) {
+			best, bestW = s, sw
+		}
+	}
+	return best, best != ""
+}
+
+// resolveSize returns size if model supports it, and otherwise the model's
+// default size, so asking dall-e-3 for 256x256 gets 1024x1024.
+func resolveSize(model string, size string) string {
+	sizes := modelSizes[model]
+	for _, s := range sizes {
+		if s == size {
+			return size
+		}
+	}
+	if len(sizes) == 0 {
+		return size
+	}
+	return sizes[0]
+}
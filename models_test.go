@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromptOverLimitRefused(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-2"}, "long")
+	m := d.post("long", "user", "/dalle "+strings.Repeat("a", 1001))
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated a %d character prompt, want it refused", len(got[0]))
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, "1001/1000") {
+		t.Errorf("sent %+v, want a reply giving the length and the limit", sent)
+	}
+}
+
+func TestPromptAtLimitSent(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-2"}, "atlimit")
+	prompt := strings.Repeat("é", 1000)
+
+	onMessageHandler(d, d.post("atlimit", "user", "/dalle "+prompt))
+
+	if got := api.generated(); len(got) != 1 || got[0] != prompt {
+		t.Errorf("generated %d prompts, want the 1000 character prompt sent", len(got))
+	}
+}
+
+func TestWhitespacePromptNotSent(t *testing.T) {
+	d, api := setupBot(t, Config{}, "blank")
+
+	onMessageHandler(d, d.post("blank", "user", "/dalle \t   "))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want a blank prompt never sent", got)
+	}
+}
+
+func TestPromptLimitPerModel(t *testing.T) {
+	for _, c := range []struct {
+		model  string
+		length int
+		ok     bool
+	}{
+		{"dall-e-2", 1000, true},
+		{"dall-e-2", 1001, false},
+		{"dall-e-3", 4000, true},
+		{"dall-e-3", 4001, false},
+		{"some-local-model", 10000, true},
+	} {
+		useConfig(t, Config{})
+		err := checkPromptLength("en", "", c.model, strings.Repeat("a", c.length))
+		if (err == nil) != c.ok {
+			t.Errorf("%d characters for %s gave %v, want ok %v", c.length, c.model, err, c.ok)
+		}
+	}
+}
+
+func TestPromptPrefixAndSuffix(t *testing.T) {
+	d, api := setupBot(t, Config{PromptPrefix: "in the style of a watercolor painting, ", PromptSuffix: ", soft light"}, "styled")
+
+	onMessageHandler(d, d.post("styled", "user", "/dalle a red fox"))
+
+	if got := api.generated(); len(got) != 1 || got[0] != "in the style of a watercolor painting, a red fox, soft light" {
+		t.Errorf("generated %q, want the prompt wrapped in the prefix and suffix", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].AltText) != 1 {
+		t.Fatalf("sent %+v, want one described image", sent)
+	}
+	if reply := sent[0].Content + sent[0].AltText[0]; strings.Contains(reply, "watercolor") || strings.Contains(reply, "soft light") {
+		t.Errorf("reply shows %q, want only the requester's prompt", reply)
+	}
+}
+
+func TestPromptLimitLeavesRoomForStyle(t *testing.T) {
+	d, api := setupBot(t, Config{Model: "dall-e-2", PromptPrefix: strings.Repeat("p", 60), PromptSuffix: strings.Repeat("s", 40)}, "roomy")
+
+	onMessageHandler(d, d.post("roomy", "user", "/dalle "+strings.Repeat("a", 901)))
+	onMessageHandler(d, d.post("roomy", "user", "/dalle "+strings.Repeat("b", 900)))
+
+	if got := api.generated(); len(got) != 1 || len(got[0]) != 1000 || !strings.Contains(got[0], "b") {
+		t.Errorf("generated %d prompts, want only the one fitting with the prefix and suffix", len(got))
+	}
+	sent := d.sentMessages()
+	if len(sent) != 2 || !strings.Contains(sent[0].Content, "901/900") {
+		t.Errorf("sent %+v, want the first refused giving the room left", sent)
+	}
+}
+
+func TestLargerSize(t *testing.T) {
+	for _, c := range []struct {
+		model, size, want string
+		ok                bool
+	}{
+		{"dall-e-2", "256x256", "512x512", true},
+		{"dall-e-2", "512x512", "1024x1024", true},
+		{"dall-e-2", "1024x1024", "", false},
+		{"dall-e-3", "1024x1024", "", false},
+		{"dall-e-3", "1792x1024", "", false},
+	} {
+		if got, ok := largerSize(c.model, c.size); got != c.want || ok != c.ok {
+			t.Errorf("largerSize(%q, %q) = %q, %v, want %q, %v", c.model, c.size, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestClampCount(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		config    Config
+		model     string
+		count     int
+		want, ask int
+	}{
+		{"under the limit", Config{MaxImages: 4}, "dall-e-2", 3, 3, 0},
+		{"over MaxImages", Config{MaxImages: 4}, "dall-e-2", 6, 4, 6},
+		{"over the model", Config{MaxImages: 4}, "dall-e-3", 3, 1, 3},
+		{"over a provider", Config{
+			MaxImages:             8,
+			Providers:             map[string]ProviderConfig{"backup": {BaseURL: "https://backup.example", MaxImages: 2}},
+			ProviderFallbackOrder: []string{mainProvider, "backup"},
+		}, "dall-e-2", 5, 2, 5},
+		{"provider left to the model", Config{
+			MaxImages:             8,
+			Providers:             map[string]ProviderConfig{"backup": {BaseURL: "https://backup.example"}},
+			ProviderFallbackOrder: []string{mainProvider, "backup"},
+		}, "dall-e-2", 5, 5, 0},
+	} {
+		useConfig(t, c.config)
+		if got, asked := clampCount(c.model, c.count); got != c.want || asked != c.ask {
+			t.Errorf("%s: clampCount(%q, %d) = %d, %d, want %d, %d", c.name, c.model, c.count, got, asked, c.want, c.ask)
+		}
+	}
+}
+
+func TestCountOverLimitClamped(t *testing.T) {
+	d, api := setupBot(t, Config{MaxImages: 4, Model: "dall-e-3"}, "clamped")
+
+	onMessageHandler(d, d.post("clamped", "user", "/dalle 4 a red fox"))
+
+	gens := api.generations()
+	if len(gens) != 1 || gens[0].Prompt != "a red fox" || gens[0].N > 1 {
+		t.Fatalf("generated %+v, want one image of the prompt without the count", gens)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %d messages, want the one image", len(sent))
+	}
+	if want := tr("en", "reply.countClamped", 4, 1, "dall-e-3"); !strings.Contains(sent[0].Content, want) {
+		t.Errorf("replied %q, want it to say %q", sent[0].Content, want)
+	}
+}
+
+func TestCountAtLimitNotNoted(t *testing.T) {
+	d, _ := setupBot(t, Config{MaxImages: 4, Model: "dall-e-2"}, "atcount")
+
+	onMessageHandler(d, d.post("atcount", "user", "/dalle 4 a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 4 {
+		t.Fatalf("sent %d messages, want the 4 images", len(sent))
+	}
+	if strings.Contains(sent[0].Content, "You asked for") {
+		t.Errorf("replied %q, want no note when nothing was lowered", sent[0].Content)
+	}
+}
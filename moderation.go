@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+// moderationResult is how the moderation endpoint rated one input.
+type moderationResult struct {
+	Flagged    bool            `json:"flagged"`
+	Categories map[string]bool `json:"categories"`
+	// CategoryScores are from 0 to 1, how likely the input is to be in
+	// each category.
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type moderationResponse struct {
+	Results []moderationResult `json:"results"`
+	Error   *imagegen.APIError `json:"error"`
+}
+
+// moderatePrompt checks prompt against OpenAI's moderation endpoint and
+// returns the categories it was flagged for, sorted, or nil when it's clean.
+func moderatePrompt(ctx context.Context, prompt string) ([]string, error) {
+	result, err := moderate(ctx, map[string]interface{}{"input": prompt})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Flagged {
+		return nil, nil
+	}
+	var flagged []string
+	for category, hit := range result.Categories {
+		if hit {
+			flagged = append(flagged, category)
+		}
+	}
+	sort.Strings(flagged)
+	// flagged without a category still has to be refused
+	if len(flagged) == 0 {
+		flagged = []string{"unspecified"}
+	}
+	return flagged, nil
+}
+
+// moderate sends body to OpenAI's moderation endpoint and returns how its
+// input was rated.
+func moderate(ctx context.Context, body map[string]interface{}) (*moderationResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout())
+	defer cancel()
+
+	url := apiBaseURL() + "/moderations"
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	authorize(req, guildIDFrom(ctx))
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var r moderationResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if r.Error != nil {
+		r.Error.StatusCode = resp.StatusCode
+		return nil, r.Error
+	}
+	if len(r.Results) == 0 {
+		return nil, errors.New("no result in the moderation response")
+	}
+	return &r.Results[0], nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModerationFlagged(t *testing.T) {
+	d, api := setupBot(t, Config{ModerationEnabled: true}, "moderated")
+	api.flag("violence", "harassment")
+	m := d.post("moderated", "user", "/dalle something nasty")
+
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want a flagged prompt refused", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"🚫"}) {
+		t.Errorf("request shows %q, want 🤖 swapped for 🚫", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, "harassment, violence") {
+		t.Errorf("sent %+v, want a reply naming the categories", sent)
+	}
+}
+
+func TestModerationClean(t *testing.T) {
+	d, api := setupBot(t, Config{ModerationEnabled: true}, "clean")
+	m := d.post("clean", "user", "/dalle a red fox")
+
+	onMessageHandler(d, m)
+
+	if !reflect.DeepEqual(api.moderations(), []string{"a red fox"}) {
+		t.Errorf("moderated %q, want the prompt checked", api.moderations())
+	}
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a red fox"}) {
+		t.Errorf("generated %q, want a clean prompt generated", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+		t.Errorf("request shows %q, want ✅", got)
+	}
+}
+
+func TestModerationOff(t *testing.T) {
+	d, api := setupBot(t, Config{}, "unmoderated")
+	api.flag("violence")
+
+	onMessageHandler(d, d.post("unmoderated", "user", "/dalle a red fox"))
+
+	if len(api.moderations()) != 0 || len(api.generated()) != 1 {
+		t.Errorf("moderated %q and generated %q, want moderation skipped", api.moderations(), api.generated())
+	}
+}
+
+func TestModerationAutoSpoiler(t *testing.T) {
+	d, api := setupBot(t, Config{ModerationEnabled: true, AutoSpoilerModerated: true}, "spoiled")
+	api.flag("violence")
+
+	onMessageHandler(d, d.post("spoiled", "user", "/dalle a sword fight"))
+
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"a sword fight"}) {
+		t.Errorf("generated %q, want a flagged prompt generated anyway", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].FileNames) != 1 || !strings.HasPrefix(sent[0].FileNames[0], "SPOILER_") {
+		t.Errorf("sent %+v, want the image uploaded as a spoiler", sent)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// isPermissionError reports whether err is Discord refusing an action the
+// bot lacks the permission for.
+func isPermissionError(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions
+}
+
+// reactionNotices remembers the channels already told the bot can't react
+// there, so each is told once per run.
+var reactionNotices sync.Map
+
+// reactionFailed handles a status reaction that couldn't be added. A
+// missing permission is explained in the channel once, since the requester
+// would otherwise see nothing until the result arrives.
+func reactionFailed(ctx context.Context, s session, channelID string, err error) {
+	if !isPermissionError(err) {
+		logger(ctx).Error("Error on adding status", "error", err)
+		return
+	}
+	logger(ctx).Warn("Can't add status reactions, the bot needs the Add Reactions and Read Message History permissions")
+	if _, told := reactionNotices.LoadOrStore(channelID, true); !told {
+		s.ChannelMessageSend(channelID, tr(localeFrom(ctx), "error.noReactions"))
+	}
+}
+
+// logSendError logs a reply that couldn't be sent, calling out a missing
+// permission, which only a server admin can fix.
+func logSendError(ctx context.Context, err error) {
+	if isPermissionError(err) {
+		logger(ctx).Warn("Can't send the reply, the bot needs the Send Messages and Attach Files permissions", "error", err)
+		return
+	}
+	logger(ctx).Error("Error on sending reply", "error", err)
+}
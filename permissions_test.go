@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// missingPermissions is Discord refusing an action the bot lacks the
+// permission for.
+var missingPermissions = &discordgo.RESTError{Response: &http.Response{Status: "403 Forbidden", StatusCode: http.StatusForbidden}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeMissingPermissions}}
+
+func TestIsPermissionError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{missingPermissions, true},
+		{&discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusNotFound}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownMessage}}, false},
+		{&discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusBadGateway}}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if got := isPermissionError(c.err); got != c.want {
+			t.Errorf("isPermissionError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestReactionPermissionMissing(t *testing.T) {
+	logged := captureLogs(t)
+	d, api := setupBot(t, Config{}, "noreactions")
+	d.failReactions = missingPermissions
+	t.Cleanup(func() { reactionNotices.Delete("noreactions") })
+
+	onMessageHandler(d, d.post("noreactions", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("noreactions", "user", "/dalle a blue fox"))
+
+	if got := api.generated(); len(got) != 2 {
+		t.Fatalf("generated %q, want both requests carried on without their reactions", got)
+	}
+	var notices, results int
+	for _, m := range d.sentMessages() {
+		if m.Content == tr("en", "error.noReactions") {
+			notices++
+		} else if len(m.Files) == 1 {
+			results++
+		}
+	}
+	if notices != 1 || results != 2 {
+		t.Errorf("sent %d notices and %d results, want the channel told once and both images sent", notices, results)
+	}
+	if got := logged("Can't add status reactions, the bot needs the Add Reactions and Read Message History permissions"); len(got) != 2 {
+		t.Errorf("warned %d times, want once for each request", len(got))
+	}
+}
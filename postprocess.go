@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// postProcessor is one stage of the post-processing pipeline, changing an
+// image about to be uploaded in place.
+type postProcessor func(ctx context.Context, img *imageFile) error
+
+// postProcessors are the stages PostProcess can name.
+var postProcessors = map[string]postProcessor{
+	"transcode": transcodeStage,
+	"retro":     retroStage,
+	"watermark": watermarkStage,
+}
+
+// defaultPostProcess is the pipeline without PostProcess set, only
+// converting to OutputFormat as before there was a pipeline.
+var defaultPostProcess = []string{"transcode"}
+
+// postProcessStages returns the names of the stages guildID's images go
+// through, in order.
+func postProcessStages(guildID string) []string {
+	if stages, ok := cfg().GuildPostProcess[guildID]; ok {
+		return stages
+	}
+	if cfg().PostProcess != nil {
+		return cfg().PostProcess
+	}
+	return defaultPostProcess
+}
+
+// postProcess runs img through the pipeline of the guild in ctx. A stage
+// that fails is logged and skipped, leaving img as the stage before left it.
+func postProcess(ctx context.Context, img *imageFile) {
+	for _, name := range postProcessStages(guildIDFrom(ctx)) {
+		stage, ok := postProcessors[name]
+		if !ok {
+			continue
+		}
+		before := *img
+		if err := stage(ctx, img); err != nil {
+			logger(ctx).Error("Error on post-processing image", "stage", name, "error", err)
+			*img = before
+		}
+	}
+}
+
+// validatePostProcess checks the stage names in the pipelines.
+func validatePostProcess(c *Config) error {
+	var unknown []string
+	check := func(stages []string) {
+		for _, name := range stages {
+			if _, ok := postProcessors[name]; !ok {
+				unknown = append(unknown, name)
+			}
+			if name == "watermark" && c.WatermarkText == "" {
+				unknown = append(unknown, "watermark (without watermarkText)")
+			}
+		}
+	}
+	check(c.PostProcess)
+	for _, stages := range c.GuildPostProcess {
+		check(stages)
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unsupported postProcess stages %s, use transcode, retro or watermark", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// transcodeStage converts the image to OutputFormat.
+func transcodeStage(ctx context.Context, img *imageFile) error {
+	converted, err := reencode(img)
+	if err != nil {
+		return err
+	}
+	*img = *converted
+	return nil
+}
+
+// retroLevels is how many shades of each colour the retro stage keeps.
+const retroLevels = 4
+
+// retroStage posterizes the image to a few shades of each colour, for an old
+// computer look. It comes out as a PNG.
+func retroStage(ctx context.Context, img *imageFile) error {
+	return redraw(img, func(dst *image.RGBA) {
+		step := 255 / (retroLevels - 1)
+		for i := 0; i+3 < len(dst.Pix); i += 4 {
+			for c := 0; c < 3; c++ {
+				v := int(dst.Pix[i+c])
+				dst.Pix[i+c] = uint8((v + step/2) / step * step)
+			}
+		}
+	})
+}
+
+// watermarkStage writes WatermarkText in the image's bottom right corner,
+// on a dark band so it shows on any image. It comes out as a PNG.
+func watermarkStage(ctx context.Context, img *imageFile) error {
+	text := cfg().WatermarkText
+	if text == "" {
+		return nil
+	}
+	return redraw(img, func(dst *image.RGBA) {
+		face := basicfont.Face7x13
+		d := &font.Drawer{Dst: dst, Src: image.NewUniform(color.White), Face: face}
+		width := d.MeasureString(text).Ceil()
+		height := face.Metrics().Height.Ceil()
+		const pad = 4
+		bounds := dst.Bounds()
+		band := image.Rect(bounds.Max.X-width-2*pad, bounds.Max.Y-height-2*pad, bounds.Max.X, bounds.Max.Y)
+		draw.Draw(dst, band, image.NewUniform(color.RGBA{0, 0, 0, 0x99}), image.Point{}, draw.Over)
+		d.Dot = fixed.P(band.Min.X+pad, band.Max.Y-pad-face.Metrics().Descent.Ceil())
+		d.DrawString(text)
+	})
+}
+
+// redraw decodes img, lets change draw over it and encodes the result back
+// into img as a PNG.
+func redraw(img *imageFile, change func(dst *image.RGBA)) error {
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return err
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	change(dst)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return err
+	}
+	img.Name = strings.TrimSuffix(img.Name, "."+imageExtensions[img.ContentType]) + ".png"
+	img.Data, img.ContentType = buf.Bytes(), "image/png"
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// guildContext is a request context in guildID.
+func guildContext(guildID string) context.Context {
+	return newRequestContext(context.Background(), &ImageRequest{GuildID: guildID})
+}
+
+func TestPostProcessStages(t *testing.T) {
+	useConfig(t, Config{
+		PostProcess:      []string{"watermark", "transcode"},
+		GuildPostProcess: map[string][]string{"plain": {}},
+		WatermarkText:    "disc-e",
+	})
+
+	if got, want := postProcessStages("guild"), []string{"watermark", "transcode"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stages = %q, want %q", got, want)
+	}
+	if got := postProcessStages("plain"); len(got) != 0 {
+		t.Errorf("stages for a guild with none = %q, want none", got)
+	}
+
+	useConfig(t, Config{})
+	if got := postProcessStages("guild"); !reflect.DeepEqual(got, defaultPostProcess) {
+		t.Errorf("default stages = %q, want %q", got, defaultPostProcess)
+	}
+}
+
+func TestPostProcessTranscodes(t *testing.T) {
+	useConfig(t, Config{OutputFormat: "jpeg"})
+	img := photoPNG(t)
+
+	postProcess(guildContext("guild"), img)
+
+	if img.ContentType != "image/jpeg" || img.Name != "disc-e.jpg" {
+		t.Errorf("post-processed to %s named %q, want a JPEG by default", img.ContentType, img.Name)
+	}
+}
+
+func TestPostProcessPerGuild(t *testing.T) {
+	useConfig(t, Config{OutputFormat: "jpeg", GuildPostProcess: map[string][]string{"raw": {}}})
+	img := photoPNG(t)
+	data := img.Data
+
+	postProcess(guildContext("raw"), img)
+
+	if img.ContentType != "image/png" || !bytes.Equal(img.Data, data) {
+		t.Error("changed an image for a guild with no stages")
+	}
+}
+
+func TestRetroStage(t *testing.T) {
+	img := photoPNG(t)
+
+	if err := retroStage(context.Background(), img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shades := make(map[uint32]bool)
+	b := decoded.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := decoded.At(x, y).RGBA()
+			shades[r>>8] = true
+		}
+	}
+	if len(shades) > retroLevels {
+		t.Errorf("%d shades of red left, want at most %d", len(shades), retroLevels)
+	}
+}
+
+func TestWatermarkStage(t *testing.T) {
+	useConfig(t, Config{WatermarkText: "disc-e"})
+	img := photoPNG(t)
+	original, _, _ := image.Decode(bytes.NewReader(img.Data))
+
+	if err := watermarkStage(context.Background(), img); err != nil {
+		t.Fatal(err)
+	}
+	marked, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := marked.Bounds()
+	if marked.At(b.Max.X-2, b.Max.Y-2) == original.At(b.Max.X-2, b.Max.Y-2) {
+		t.Error("bottom right corner unchanged, want the watermark there")
+	}
+	if marked.At(2, 2) != original.At(2, 2) {
+		t.Error("top left corner changed, want only the corner marked")
+	}
+}
+
+func TestFailedStageSkipped(t *testing.T) {
+	useConfig(t, Config{PostProcess: []string{"retro"}})
+	img := &imageFile{Name: "disc-e.png", ContentType: "image/png", Data: []byte("not a png")}
+	logged := captureLogs(t)
+
+	postProcess(guildContext("guild"), img)
+
+	if string(img.Data) != "not a png" || img.Name != "disc-e.png" {
+		t.Errorf("image is now %+v, want it left as it was", img)
+	}
+	if records := logged("Error on post-processing image"); len(records) != 1 || records[0].fields["stage"] != "retro" {
+		t.Errorf("logged %+v, want the failed stage named", records)
+	}
+}
+
+func TestValidatePostProcess(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		ok     bool
+	}{
+		{Config{}, true},
+		{Config{PostProcess: []string{"retro", "transcode"}}, true},
+		{Config{PostProcess: []string{"watermark"}, WatermarkText: "disc-e"}, true},
+		{Config{PostProcess: []string{"watermark"}}, false},
+		{Config{PostProcess: []string{"sepia"}}, false},
+		{Config{GuildPostProcess: map[string][]string{"guild": {"blur"}}}, false},
+	} {
+		if err := validatePostProcess(&c.config); (err == nil) != c.ok {
+			t.Errorf("validatePostProcess(%+v) = %v, want ok %v", c.config, err, c.ok)
+		}
+	}
+}
+
+func TestWatermarkUploaded(t *testing.T) {
+	d, _ := setupBot(t, Config{PostProcess: []string{"watermark"}, WatermarkText: "disc-e"}, "watermarked")
+
+	onMessageHandler(d, d.post("watermarked", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %+v, want the image", sent)
+	}
+	if bytes.Equal(sent[0].Files[0], testPNG()) {
+		t.Error("uploaded the image as generated, want it watermarked")
+	}
+}
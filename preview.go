@@ -0,0 +1,21 @@
+package main
+
+import "github.com/mdesson/disc-e/imagegen"
+
+// previewReply shows the body that would be sent to OpenAI for imgReq, after
+// the prompt prefix, suffix and negatives are applied, without generating
+// anything. It's admin-only since the body shows the configured model and
+// prompt styling.
+func previewReply(imgReq *ImageRequest) string {
+	if !isAdmin(imgReq.AuthorID) {
+		return tr(imgReq.Locale, "admin.preview")
+	}
+	if imgReq.Prompt == "" {
+		return tr(imgReq.Locale, "refuse.empty")
+	}
+	body, err := imagegen.GenerationBody(styledPrompt(imgReq.GuildID, imgReq.Prompt), imgReq.options())
+	if err != nil {
+		return err.Error()
+	}
+	return "```json\n" + string(body) + "\n```"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewShowsBody(t *testing.T) {
+	d, api := setupBot(t, Config{Admins: []string{"admin"}, ModerationEnabled: true, PromptPrefix: "watercolor, "}, "preview")
+
+	onMessageHandler(d, d.post("preview", "admin", "/dalle preview 1024 a forest | no people"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing for a preview", got)
+	}
+	if got := api.moderations(); len(got) != 0 {
+		t.Errorf("moderated %q, want no call to OpenAI for a preview", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want the preview", len(sent))
+	}
+	body, ok := strings.CutPrefix(sent[0].Content, "```json\n")
+	body, ok2 := strings.CutSuffix(body, "\n```")
+	if !ok || !ok2 {
+		t.Fatalf("preview is %q, want a JSON code block", sent[0].Content)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("preview body %q: %v", body, err)
+	}
+	want := map[string]interface{}{"model": "dall-e-2", "prompt": "watercolor, a forest, without: people", "n": 1.0, "size": "1024x1024"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("preview body is %v, want %v", got, want)
+	}
+}
+
+func TestPreviewNeedsAdmin(t *testing.T) {
+	d, api := setupBot(t, Config{Admins: []string{"admin"}}, "preview")
+
+	onMessageHandler(d, d.post("preview", "user", "/dalle preview a forest"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing for a preview", got)
+	}
+	if sent := d.sentMessages(); len(sent) != 1 || sent[0].Content != "Only bot admins can preview requests." {
+		t.Errorf("sent %+v, want the preview refused", sent)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizePrompt cleans up text pasted from elsewhere before it is used as a
+// prompt. NFKC composes decomposed accents and folds fullwidth forms to their
+// plain equivalents, invisible format characters such as zero-width spaces
+// and joiners are dropped, and any other control characters become spaces.
+func normalizePrompt(prompt string) string {
+	prompt = norm.NFKC.String(prompt)
+
+	prompt = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.Is(unicode.Cf, r):
+			return -1
+		case unicode.IsControl(r):
+			return ' '
+		}
+		return r
+	}, prompt)
+
+	return strings.TrimSpace(prompt)
+}
+
+var (
+	userMention  = regexp.MustCompile(`<@!?(\d+)>`)
+	otherMention = regexp.MustCompile(`<(?:@&|#)\d+>`)
+	customEmoji  = regexp.MustCompile(`<a?:\w+:\d+>`)
+)
+
+// sanitizePrompt swaps user mentions for the names of the users in mentions,
+// dropping those it can't name, and removes role and channel mentions and
+// custom emoji, then collapses the whitespace left behind. Unicode emoji are
+// kept, they're just text.
+func sanitizePrompt(prompt string, mentions []*discordgo.User) string {
+	prompt = userMention.ReplaceAllStringFunc(prompt, func(mention string) string {
+		id := userMention.FindStringSubmatch(mention)[1]
+		for _, u := range mentions {
+			if u.ID == id {
+				return u.Username
+			}
+		}
+		return ""
+	})
+	prompt = otherMention.ReplaceAllString(prompt, "")
+	prompt = customEmoji.ReplaceAllString(prompt, "")
+	return strings.Join(strings.Fields(prompt), " ")
+}
+
+const defaultCommandPrefix = "/dalle"
+
+func commandPrefix() string {
+	if cfg().CommandPrefix != "" {
+		return cfg().CommandPrefix
+	}
+	return defaultCommandPrefix
+}
+
+// parseCommand reports whether content is a bot command and returns the text
+// after the prefix with its case kept. The prefix matches case-insensitively
+// and must be the whole message or be followed by whitespace, so "/dalle2"
+// isn't a command.
+func parseCommand(content string) (prompt string, ok bool) {
+	content = strings.TrimSpace(content)
+	prefix := commandPrefix()
+	if len(content) < len(prefix) || !strings.EqualFold(content[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	rest := content[len(prefix):]
+	if rest != "" && strings.TrimLeftFunc(rest, unicode.IsSpace) == rest {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+var targetChannelFlag = regexp.MustCompile(`^--to\s+<#(\d+)>\s*`)
+
+// parseTargetChannel splits a leading "--to #channel" off a prompt, returning
+// the mentioned channel ID, or an empty string when there is none.
+func parseTargetChannel(prompt string) (channelID string, rest string) {
+	match := targetChannelFlag.FindStringSubmatch(prompt)
+	if match == nil {
+		return "", prompt
+	}
+	return match[1], prompt[len(match[0]):]
+}
+
+// sizeTokens maps the size a user can put before their prompt to the size
+// OpenAI expects. Whether the model supports it is checked by resolveSize.
+var sizeTokens = map[string]string{
+	"256":  "256x256",
+	"512":  "512x512",
+	"1024": "1024x1024",
+	"wide": "1792x1024",
+	"tall": "1024x1792",
+}
+
+// parseSize splits a leading size token such as "1024" off a prompt. Prompts
+// without one are left as they are and get an empty size, as do unknown
+// sizes, which are treated as part of the prompt.
+func parseSize(prompt string) (size string, rest string) {
+	fields := strings.SplitN(prompt, " ", 2)
+	size, ok := sizeTokens[fields[0]]
+	if !ok {
+		return "", prompt
+	}
+	if len(fields) == 1 {
+		return size, ""
+	}
+	return size, strings.TrimSpace(fields[1])
+}
+
+// maxCountToken is the largest leading number read as an image count, so a
+// prompt like "1984 in crayon" keeps its year.
+const maxCountToken = 10
+
+// parseCount splits a leading image count such as "4" off a prompt, clamped
+// to max. Prompts without one get a count of 1, and so does everything when
+// max is 1 or less, as the number is then part of the prompt. An explicit 0
+// is returned as 0 for the caller to reject.
+func parseCount(prompt string, max int) (count int, rest string) {
+	if max <= 1 {
+		return 1, prompt
+	}
+	fields := strings.SplitN(prompt, " ", 2)
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n < 0 || n > maxCountToken || fields[0] != strconv.Itoa(n) {
+		return 1, prompt
+	}
+	if n > max {
+		n = max
+	}
+	if len(fields) == 1 {
+		return n, ""
+	}
+	return n, strings.TrimSpace(fields[1])
+}
+
+var qualityTokens = map[string]string{"standard": "standard", "hd": "hd"}
+
+var styleTokens = map[string]string{"vivid": "vivid", "natural": "natural"}
+
+// parseStyle splits a leading quality ("hd", "standard") and style ("vivid",
+// "natural"), in either order, off a prompt for models that take them. Those
+// not given fall back to DefaultQuality and DefaultStyle. For other models
+// both are empty and the words are left in the prompt.
+func parseStyle(prompt string, model string) (quality string, style string, rest string) {
+	if !takesStyle(model) {
+		return "", "", prompt
+	}
+	rest = prompt
+	for {
+		fields := strings.SplitN(rest, " ", 2)
+		if q, ok := qualityTokens[fields[0]]; ok && quality == "" {
+			quality = q
+		} else if st, ok := styleTokens[fields[0]]; ok && style == "" {
+			style = st
+		} else {
+			break
+		}
+		if len(fields) == 1 {
+			rest = ""
+			break
+		}
+		rest = strings.TrimSpace(fields[1])
+	}
+	if quality == "" {
+		quality = cfg().DefaultQuality
+	}
+	if style == "" {
+		style = cfg().DefaultStyle
+	}
+	return quality, style, rest
+}
+
+var seedToken = regexp.MustCompile(`(?:^|\s)seed:(\d+)(?:\s|$)`)
+
+// parseSeed takes a "seed:12345" token out of anywhere in a prompt. Prompts
+// without one get a nil seed, and so do malformed or out of range seeds,
+// which are left in the prompt.
+func parseSeed(prompt string) (seed *int64, rest string) {
+	loc := seedToken.FindStringSubmatchIndex(prompt)
+	if loc == nil {
+		return nil, prompt
+	}
+	n, err := strconv.ParseInt(prompt[loc[2]:loc[3]], 10, 64)
+	if err != nil {
+		return nil, prompt
+	}
+	return &n, strings.Join(strings.Fields(prompt[:loc[0]]+" "+prompt[loc[1]:]), " ")
+}
+
+// styledPrompt wraps a requester's prompt in PromptPrefix and PromptSuffix,
+// then adds guildID's entry in GuildPromptSuffixes, which are sent to OpenAI
+// but never shown back. It's the prompt as OpenAI gets it.
+func styledPrompt(guildID string, prompt string) string {
+	return cfg().PromptPrefix + prompt + cfg().PromptSuffix + cfg().GuildPromptSuffixes[guildID]
+}
+
+// parseSpoiler takes a leading "spoiler" or ⚠️ off a prompt, which asks for
+// its images to be hidden behind a spoiler.
+func parseSpoiler(prompt string) (spoiler bool, rest string) {
+	for _, token := range []string{"spoiler", "⚠️", "⚠"} {
+		if rest, ok := strings.CutPrefix(prompt, token); ok && (rest == "" || strings.HasPrefix(rest, " ")) {
+			return true, strings.TrimSpace(rest)
+		}
+	}
+	return false, prompt
+}
+
+// applyNegatives rewrites "a forest | no people, no buildings" as "a forest,
+// without: people, buildings", since OpenAI has no negative prompt field.
+// Every "|" after the first also starts more negatives, and a leading "no"
+// on each one is dropped.
+func applyNegatives(prompt string) string {
+	parts := strings.Split(prompt, "|")
+	positive := strings.TrimSpace(parts[0])
+
+	var negatives []string
+	for _, part := range parts[1:] {
+		for _, neg := range strings.Split(part, ",") {
+			neg = strings.TrimSpace(neg)
+			neg = strings.TrimSpace(strings.TrimPrefix(neg, "no "))
+			if neg != "" && neg != "no" {
+				negatives = append(negatives, neg)
+			}
+		}
+	}
+	if positive == "" || len(negatives) == 0 {
+		return positive
+	}
+	return positive + ", without: " + strings.Join(negatives, ", ")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		prompt, size, rest string
+	}{
+		{"1024 a fox", "1024x1024", "a fox"},
+		{"256 a fox", "256x256", "a fox"},
+		{"a 512 pixel wide fox", "", "a 512 pixel wide fox"},
+		{"a red fox", "", "a red fox"},
+		{"2048 a fox", "", "2048 a fox"},
+		{"512", "512x512", ""},
+	} {
+		size, rest := parseSize(tt.prompt)
+		if size != tt.size || rest != tt.rest {
+			t.Errorf("parseSize(%q) = %q, %q, want %q, %q", tt.prompt, size, rest, tt.size, tt.rest)
+		}
+	}
+}
+
+func TestPromptWithoutSizeGetsDefault(t *testing.T) {
+	if got := resolveSize("dall-e-2", ""); got != "512x512" {
+		t.Errorf("default size is %q, want 512x512", got)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, content, prompt string
+		ok                      bool
+	}{
+		{"", "/dalle a fox", "a fox", true},
+		{"", "  /dalle   a fox  ", "a fox", true},
+		{"", "/DALLE a fox", "a fox", true},
+		{"", "/dalle", "", true},
+		{"", "/dalle   ", "", true},
+		{"", "/dallea fox", "", false},
+		{"", "a fox", "", false},
+		{"", "/dal", "", false},
+		{"!draw", "!draw a fox", "a fox", true},
+		{"!draw", "!draw", "", true},
+		{"!draw", "/dalle a fox", "", false},
+	} {
+		useConfig(t, Config{CommandPrefix: tt.prefix})
+		prompt, ok := parseCommand(tt.content)
+		if prompt != tt.prompt || ok != tt.ok {
+			t.Errorf("with prefix %q, parseCommand(%q) = %q, %v, want %q, %v", tt.prefix, tt.content, prompt, ok, tt.prompt, tt.ok)
+		}
+	}
+}
+
+func TestNormalizePrompt(t *testing.T) {
+	for _, tt := range []struct {
+		prompt, want string
+	}{
+		// decomposed accents compose, fullwidth forms and ligatures fold
+		{"cafe\u0301 au lait", "caf\u00e9 au lait"},
+		{"\uff46\uff55\uff4c\uff4c fox", "full fox"},
+		{"\ufb01ne \ufb02owers", "fine flowers"},
+		// zero-width and other invisible characters are dropped
+		{"a red\u200b fox", "a red fox"},
+		{"a\u200dfamily\ufeff portrait", "afamily portrait"},
+		{"a\u200e fox\u2060", "a fox"},
+		{"a \U0001f98a with \U0001f468\u200d\U0001f469\u200d\U0001f467", "a \U0001f98a with \U0001f468\U0001f469\U0001f467"},
+		// control characters become spaces, trimmed at the ends
+		{"a red\tfox\non a hill", "a red fox on a hill"},
+		{"a fox\x00in\x1bthe snow", "a fox in the snow"},
+		{"\u200b  a fox \r\n", "a fox"},
+	} {
+		if got := normalizePrompt(tt.prompt); got != tt.want {
+			t.Errorf("normalizePrompt(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizedPromptsMatch(t *testing.T) {
+	// pasted and typed versions of the same prompt are one prompt, so
+	// they're counted and cached the same
+	typed := normalizePrompt("cr\u00e8me br\u00fbl\u00e9e")
+	pasted := normalizePrompt("cre\u0300me\u200b bru\u0302le\u0301e")
+	if pasted != typed {
+		t.Errorf("pasted prompt normalizes to %q, want %q", pasted, typed)
+	}
+	if n := len([]rune(pasted)); n != 12 {
+		t.Errorf("pasted prompt is %d runes, want 12", n)
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	for _, tt := range []struct {
+		prompt string
+		max    int
+		count  int
+		rest   string
+	}{
+		{"4 a sunset", 10, 4, "a sunset"},
+		{"a sunset", 10, 1, "a sunset"},
+		{"8 a sunset", 4, 4, "a sunset"},
+		{"0 a sunset", 10, 0, "a sunset"},
+		{"4 a sunset", 1, 1, "4 a sunset"},
+		{"1984 in crayon", 10, 1, "1984 in crayon"},
+		{"04 a sunset", 10, 1, "04 a sunset"},
+		{"-2 a sunset", 10, 1, "-2 a sunset"},
+		{"3", 10, 3, ""},
+	} {
+		count, rest := parseCount(tt.prompt, tt.max)
+		if count != tt.count || rest != tt.rest {
+			t.Errorf("parseCount(%q, %d) = %d, %q, want %d, %q", tt.prompt, tt.max, count, rest, tt.count, tt.rest)
+		}
+	}
+}
+
+func TestApplyNegatives(t *testing.T) {
+	for _, tt := range []struct {
+		prompt, want string
+	}{
+		{"a forest", "a forest"},
+		{"a forest | no people, no buildings", "a forest, without: people, buildings"},
+		{"  a forest  |  people  ", "a forest, without: people"},
+		{"a forest | no people | cars, no", "a forest, without: people, cars"},
+		{"a forest |", "a forest"},
+		{"a forest || ,", "a forest"},
+		{"| people", ""},
+	} {
+		if got := applyNegatives(tt.prompt); got != tt.want {
+			t.Errorf("applyNegatives(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	useConfig(t, Config{DefaultStyle: "vivid"})
+	for _, tt := range []struct {
+		prompt, model        string
+		quality, style, rest string
+	}{
+		{"hd natural a cabin", "dall-e-3", "hd", "natural", "a cabin"},
+		{"natural hd a cabin", "dall-e-3", "hd", "natural", "a cabin"},
+		{"standard a cabin", "dall-e-3", "standard", "vivid", "a cabin"},
+		{"a cabin in hd", "dall-e-3", "", "vivid", "a cabin in hd"},
+		{"hd hd a cabin", "dall-e-3", "hd", "vivid", "hd a cabin"},
+		{"hd", "dall-e-3", "hd", "vivid", ""},
+		{"hd natural a cabin", "dall-e-2", "", "", "hd natural a cabin"},
+	} {
+		quality, style, rest := parseStyle(tt.prompt, tt.model)
+		if quality != tt.quality || style != tt.style || rest != tt.rest {
+			t.Errorf("parseStyle(%q, %q) = %q, %q, %q, want %q, %q, %q", tt.prompt, tt.model, quality, style, rest, tt.quality, tt.style, tt.rest)
+		}
+	}
+}
+
+func TestSanitizePrompt(t *testing.T) {
+	mentions := []*discordgo.User{{ID: "123", Username: "alice"}}
+	for _, tt := range []struct {
+		prompt, want string
+	}{
+		{"a portrait of <@123> as a knight", "a portrait of alice as a knight"},
+		{"a portrait of <@!123>", "a portrait of alice"},
+		{"<@456>  a stranger", "a stranger"},
+		{"a party <:partyparrot:789> in <#42> for <@&7>", "a party in for"},
+		{"a dancing <a:blob:789> blob", "a dancing blob"},
+		{"a 🦊 in the 🌧️", "a 🦊 in the 🌧️"},
+	} {
+		if got := sanitizePrompt(tt.prompt, mentions); got != tt.want {
+			t.Errorf("sanitizePrompt(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestParseSeed(t *testing.T) {
+	for _, tt := range []struct {
+		prompt string
+		seed   int64
+		ok     bool
+		rest   string
+	}{
+		{"a red fox seed:12345", 12345, true, "a red fox"},
+		{"seed:7 a red fox", 7, true, "a red fox"},
+		{"a seed:0 red fox", 0, true, "a red fox"},
+		{"a red fox", 0, false, "a red fox"},
+		{"a red fox seed:abc", 0, false, "a red fox seed:abc"},
+		{"a red fox seed:-3", 0, false, "a red fox seed:-3"},
+		{"a red fox seed:99999999999999999999", 0, false, "a red fox seed:99999999999999999999"},
+		{"a red fox myseed:12", 0, false, "a red fox myseed:12"},
+	} {
+		seed, rest := parseSeed(tt.prompt)
+		if (seed != nil) != tt.ok || (seed != nil && *seed != tt.seed) || rest != tt.rest {
+			t.Errorf("parseSeed(%q) = %v, %q, want %d (%v), %q", tt.prompt, seed, rest, tt.seed, tt.ok, tt.rest)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdesson/disc-e/imagegen"
+)
+
+// mainProvider is the name ProviderFallbackOrder knows the API set by
+// baseURL and the OpenAI keys as.
+const mainProvider = "openai"
+
+// A provider that fails providerBreakerFailures times in a row is skipped
+// for providerBreakerCooldown.
+const (
+	providerBreakerFailures = 3
+	providerBreakerCooldown = time.Minute
+)
+
+// ProviderConfig is an OpenAI-compatible image API to fall back on.
+type ProviderConfig struct {
+	// BaseURL is the root of the provider's API.
+	BaseURL string `json:"baseURL"`
+	// APIKey, when set, is sent as a bearer token.
+	APIKey string `json:"apiKey"`
+	// MaxConcurrent limits how many generations it runs at once, with
+	// requests over the limit trying the next provider. 0 means no limit.
+	MaxConcurrent int `json:"maxConcurrent"`
+	// MaxImages caps how many images a request may ask for while it's in
+	// the fallback order, for backends with a smaller batch size. 0 leaves
+	// it to the model.
+	MaxImages int `json:"maxImages"`
+}
+
+// validateProviders checks that every provider in the fallback order is
+// configured with a usable base URL.
+func validateProviders(c *Config) error {
+	var problems []error
+	for _, name := range c.ProviderFallbackOrder {
+		if _, ok := c.Providers[name]; !ok && name != mainProvider {
+			problems = append(problems, fmt.Errorf("providerFallbackOrder names %q, which isn't %s or in providers", name, mainProvider))
+		}
+	}
+	for name, p := range c.Providers {
+		if u, err := url.Parse(p.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("provider %q needs an http or https baseURL", name))
+		}
+		if p.MaxConcurrent < 0 || p.MaxImages < 0 {
+			problems = append(problems, fmt.Errorf("provider %q maxConcurrent and maxImages can't be negative", name))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// provider is one of the image APIs in ProviderFallbackOrder, with the
+// generations it has running and its circuit breaker.
+type provider struct {
+	name          string
+	generator     imagegen.Generator
+	maxConcurrent int
+
+	mu      sync.Mutex
+	running int
+	// failures counts the failures in a row, and openUntil is when a
+	// provider that failed too often is tried again
+	failures  int
+	openUntil time.Time
+}
+
+// newProviders builds the providers in ProviderFallbackOrder, sending
+// requests through client.
+func newProviders(client *http.Client) map[string]*provider {
+	providers := make(map[string]*provider)
+	for _, name := range cfg().ProviderFallbackOrder {
+		if name == mainProvider {
+			providers[name] = &provider{name: name}
+			continue
+		}
+		p := cfg().Providers[name]
+		key := p.APIKey
+		providers[name] = &provider{
+			name: name,
+			generator: &imagegen.OpenAI{
+				BaseURL: strings.TrimSuffix(p.BaseURL, "/"),
+				Client:  client,
+				Authorize: func(req *http.Request) {
+					if key != "" {
+						req.Header.Set("Authorization", "Bearer "+key)
+					}
+				},
+				Timeout:    requestTimeout(),
+				MaxRetries: cfg().MaxRetries,
+				Logf:       logf,
+			},
+			maxConcurrent: p.MaxConcurrent,
+		}
+	}
+	return providers
+}
+
+// acquire reserves a generation on p, returning false when its breaker is
+// open or it's running its limit already. force skips the breaker.
+func (p *provider) acquire(force bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !force && now().Before(p.openUntil) {
+		return false
+	}
+	if p.maxConcurrent > 0 && p.running >= p.maxConcurrent {
+		return false
+	}
+	p.running++
+	return true
+}
+
+// release frees the generation acquire reserved, counting err against p
+// when it says p is down.
+func (p *provider) release(ctx context.Context, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	if err == nil || !providerDown(err) {
+		p.failures = 0
+		return
+	}
+	p.failures++
+	if p.failures >= providerBreakerFailures {
+		logger(ctx).Warn("Provider keeps failing, skipping it for a while", "provider", p.name, "cooldown", providerBreakerCooldown)
+		p.failures = 0
+		p.openUntil = now().Add(providerBreakerCooldown)
+	}
+}
+
+// fetch asks p for imgReq's images.
+func (p *provider) fetch(ctx context.Context, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	if p.name == mainProvider {
+		return fetchFromOpenAI(ctx, imgReq)
+	}
+	return requestImages(ctx, p.generator, imgReq)
+}
+
+// providerDown reports whether err means the provider failed rather than
+// the request: it couldn't be reached or kept answering 429 or 5xx.
+func providerDown(err error) bool {
+	var apiErr *imagegen.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return !errors.Is(err, imagegen.ErrEmptyResult) && !errors.Is(err, context.Canceled)
+}
+
+// fetchFromProviders tries each provider in ProviderFallbackOrder in turn
+// until one makes imgReq's images, skipping those whose breaker is open or
+// that are busy. When every one is skipped the first is tried anyway, so
+// the request fails with its own error. imgReq.Provider is set when the
+// images didn't come from the first.
+func fetchFromProviders(ctx context.Context, imgReq *ImageRequest) ([]imagegen.Image, error) {
+	order := cfg().ProviderFallbackOrder
+	providers := openAI.Load().providers
+	var err error
+	tried := false
+	for n, name := range order {
+		p := providers[name]
+		if !p.acquire(false) {
+			logger(ctx).Info("Skipping unavailable provider", "provider", name)
+			continue
+		}
+		tried = true
+		var images []imagegen.Image
+		images, err = p.fetch(ctx, imgReq)
+		p.release(ctx, err)
+		if err == nil {
+			if n > 0 {
+				imgReq.Provider = name
+			}
+			return images, nil
+		}
+		if ctx.Err() != nil || !(providerDown(err) || fallbackWorthy(err)) {
+			return nil, err
+		}
+		logger(ctx).Warn("Provider failed, trying the next", "provider", name, "error", err)
+	}
+	if !tried {
+		p := providers[order[0]]
+		p.acquire(true)
+		images, err := p.fetch(ctx, imgReq)
+		p.release(ctx, err)
+		return images, err
+	}
+	return nil, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withBackup runs the bot with a backup provider behind the main API,
+// returning the backup's fake.
+func withBackup(t *testing.T, config Config, channelID string) (*fakeDiscord, *fakeOpenAI, *fakeOpenAI) {
+	t.Helper()
+	backup := newFakeOpenAI(t)
+	config.Providers = map[string]ProviderConfig{"backup": {BaseURL: backup.URL, APIKey: "sk-backup"}}
+	config.ProviderFallbackOrder = []string{mainProvider, "backup"}
+	d, api := setupBot(t, config, channelID)
+	return d, api, backup
+}
+
+func TestProviderFallback(t *testing.T) {
+	d, api, backup := withBackup(t, Config{}, "providers")
+	api.fail(http.StatusServiceUnavailable, `{"error": {"message": "overloaded"}}`)
+
+	onMessageHandler(d, d.post("providers", "user", "/dalle a red fox"))
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("main API got %q, want the one attempt", got)
+	}
+	if got := backup.generated(); len(got) != 1 || got[0] != "a red fox" {
+		t.Errorf("backup got %q, want the prompt after the main API failed", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %d messages, want the backup's image", len(sent))
+	}
+	if want := tr("en", "reply.provider", "backup"); !strings.Contains(sent[0].Content, want) {
+		t.Errorf("replied %q, want it to say %q", sent[0].Content, want)
+	}
+}
+
+func TestProviderFallbackSkipsRequestErrors(t *testing.T) {
+	d, api, backup := withBackup(t, Config{}, "badrequest")
+	api.fail(http.StatusBadRequest, `{"error": {"message": "bad size", "code": "invalid_size"}}`)
+
+	onMessageHandler(d, d.post("badrequest", "user", "/dalle a red fox"))
+
+	if got := backup.generated(); len(got) != 0 {
+		t.Errorf("backup got %q, want a bad request not passed on", got)
+	}
+}
+
+func TestProviderBreaker(t *testing.T) {
+	advance := fakeClock(t)
+	d, api, backup := withBackup(t, Config{}, "breaker")
+	api.fail(http.StatusInternalServerError, `{"error": {"message": "down"}}`)
+
+	for i := 0; i < providerBreakerFailures+1; i++ {
+		onMessageHandler(d, d.post("breaker", "user", "/dalle a red fox"))
+	}
+	if got := len(api.generated()); got != providerBreakerFailures {
+		t.Errorf("main API got %d requests, want %d before it's skipped", got, providerBreakerFailures)
+	}
+	if got := len(backup.generated()); got != providerBreakerFailures+1 {
+		t.Errorf("backup got %d requests, want every one", got)
+	}
+
+	advance(providerBreakerCooldown + time.Second)
+	onMessageHandler(d, d.post("breaker", "user", "/dalle a red fox"))
+	if got := len(api.generated()); got != providerBreakerFailures+1 {
+		t.Errorf("main API got %d requests, want it tried again after the cooldown", got)
+	}
+}
+
+func TestProviderAllSkipped(t *testing.T) {
+	d, api, backup := withBackup(t, Config{}, "skipped")
+	for _, p := range openAI.Load().providers {
+		p.openUntil = now().Add(time.Hour)
+	}
+
+	onMessageHandler(d, d.post("skipped", "user", "/dalle a red fox"))
+
+	if got := len(api.generated()); got != 1 {
+		t.Errorf("main API got %d requests, want it tried anyway", got)
+	}
+	if got := len(backup.generated()); got != 0 {
+		t.Errorf("backup got %d requests, want only the first provider tried", got)
+	}
+}
+
+func TestProviderBusy(t *testing.T) {
+	useConfig(t, Config{Providers: map[string]ProviderConfig{"backup": {BaseURL: "http://backup", MaxConcurrent: 1}}, ProviderFallbackOrder: []string{"backup"}})
+	p := openAI.Load().providers["backup"]
+
+	if !p.acquire(false) {
+		t.Fatal("first generation refused, want it let through")
+	}
+	if p.acquire(false) {
+		t.Error("second generation let through, want it over maxConcurrent")
+	}
+	p.release(context.Background(), nil)
+	if !p.acquire(false) {
+		t.Error("generation refused after one finished, want it let through")
+	}
+}
+
+func TestValidateProviders(t *testing.T) {
+	for _, c := range []struct {
+		config Config
+		want   string
+	}{
+		{Config{ProviderFallbackOrder: []string{mainProvider}}, ""},
+		{Config{ProviderFallbackOrder: []string{mainProvider, "backup"}}, `"backup"`},
+		{Config{Providers: map[string]ProviderConfig{"backup": {BaseURL: "backup.example"}}}, "baseURL"},
+		{Config{Providers: map[string]ProviderConfig{"backup": {BaseURL: "https://backup.example", MaxConcurrent: -1}}}, "maxConcurrent"},
+	} {
+		err := validateProviders(&c.config)
+		if c.want == "" && err != nil {
+			t.Errorf("validateProviders(%+v) = %v, want it valid", c.config, err)
+		}
+		if c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("validateProviders(%+v) = %v, want it to mention %s", c.config, err, c.want)
+		}
+	}
+}
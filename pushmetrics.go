@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const defaultPushInterval = 15 * time.Second
+
+// pushJob is the job the metrics are pushed under.
+const pushJob = "disce"
+
+func pushInterval() time.Duration {
+	if cfg().PushIntervalSeconds > 0 {
+		return time.Duration(cfg().PushIntervalSeconds) * time.Second
+	}
+	return defaultPushInterval
+}
+
+// validatePushgateway checks the Pushgateway the metrics are pushed to.
+func validatePushgateway(c *Config) error {
+	if c.PushgatewayURL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.PushgatewayURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("pushgatewayURL %q must be an http or https URL", c.PushgatewayURL)
+	}
+	return nil
+}
+
+// startMetricsPush pushes the metrics served on /metrics to PushgatewayURL
+// every PushIntervalSeconds, for deployments that can't be scraped, until
+// the returned function is called, which pushes them a last time. A failed
+// push is logged and the next one tried as usual. Without a Pushgateway
+// nothing is pushed.
+func startMetricsPush() (stop func()) {
+	if cfg().PushgatewayURL == "" {
+		return func() {}
+	}
+	pusher := push.New(cfg().PushgatewayURL, pushJob).
+		Gatherer(prometheus.DefaultGatherer).
+		Client(&http.Client{Timeout: 10 * time.Second})
+	pushMetrics := func() {
+		if err := pusher.Push(); err != nil {
+			slog.Error("Error pushing metrics", "error", err)
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(pushInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pushMetrics()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		pushMetrics()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushgateway is a fake Pushgateway answering every push with status,
+// returning the bodies pushed so far.
+func pushgateway(t *testing.T, status int) (url string, pushed func() []string) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/"+pushJob {
+			t.Errorf("got %s %s, want a push for the %s job", r.Method, r.URL.Path, pushJob)
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+func TestMetricsPush(t *testing.T) {
+	url, pushed := pushgateway(t, http.StatusOK)
+	useConfig(t, Config{PushgatewayURL: url, PushIntervalSeconds: 1})
+
+	stop := startMetricsPush()
+	deadline := time.Now().Add(3 * time.Second)
+	for len(pushed()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pushed()) == 0 {
+		t.Fatal("nothing pushed, want a push every interval")
+	}
+	before := len(pushed())
+	stop()
+
+	bodies := pushed()
+	if len(bodies) != before+1 {
+		t.Errorf("pushed %d times on stopping, want a last push", len(bodies)-before)
+	}
+	// pushes are protobuf, which keeps the metric names as they are
+	if !strings.Contains(bodies[len(bodies)-1], "disce_requests_total") {
+		t.Error("last push is missing disce_requests_total, want the registered metrics")
+	}
+}
+
+func TestMetricsPushFailureLogged(t *testing.T) {
+	logged := captureLogs(t)
+	url, pushed := pushgateway(t, http.StatusInternalServerError)
+	useConfig(t, Config{PushgatewayURL: url})
+
+	startMetricsPush()()
+
+	if len(pushed()) != 1 {
+		t.Errorf("pushed %d times, want the last push tried", len(pushed()))
+	}
+	if len(logged("Error pushing metrics")) != 1 {
+		t.Error("failed push wasn't logged")
+	}
+}
+
+func TestMetricsPushOff(t *testing.T) {
+	useConfig(t, Config{})
+
+	// nothing to push to, so stopping does nothing
+	startMetricsPush()()
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// The QueuePolicy values: requests waiting for a generation slot get one in
+// the order they arrived, or the servers with requests waiting take turns.
+const (
+	queueFIFO = "fifo"
+	queueFair = "fair"
+)
+
+// semaphore limits how many requests hold a slot at once. A limit of zero
+// doesn't limit anything, though the slots in use are still counted.
+// Requests that have to wait are queued by key and handed slots as they come
+// free, taking turns between the keys with requests waiting and first come
+// first served within each key.
+type semaphore struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+	// queues are the requests waiting by key, and turns the keys with
+	// requests waiting in the order they get their next slot. served is
+	// how many slots the key at the front has had this turn.
+	queues map[string][]*slotWaiter
+	turns  []string
+	served int
+	// weight, when set, is how many slots in a row a key gets on its turn,
+	// 1 otherwise.
+	weight func(key string) int
+	// moved is closed, and replaced, whenever a request joins the line, gets
+	// a slot or gives up, so the others can tell they've moved.
+	moved chan struct{}
+}
+
+// slotWaiter is a request waiting for a slot, whose ready is closed once
+// it's been handed one.
+type slotWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// generationSlots is sized by main from MaxConcurrent, and again when the
+// config is reloaded. With the fair QueuePolicy its waiting requests are
+// keyed by guild, so one busy server can't keep the others waiting.
+var generationSlots = newWeightedSemaphore(0, guildQueueWeight)
+
+// mediaSlots are held while a request downloads its images and uploads them
+// to Discord, sized from MediaConcurrency.
+var mediaSlots = newSemaphore(0)
+
+func newSemaphore(n int) *semaphore {
+	return newWeightedSemaphore(n, nil)
+}
+
+// newWeightedSemaphore is newSemaphore giving each key weight slots in a row
+// on its turn.
+func newWeightedSemaphore(n int, weight func(key string) int) *semaphore {
+	sem := &semaphore{queues: make(map[string][]*slotWaiter), weight: weight, moved: make(chan struct{})}
+	sem.resize(n)
+	return sem
+}
+
+// resize changes the limit. Slots taken over a lowered limit are kept until
+// they're released; an increase lets waiting requests through right away.
+func (sem *semaphore) resize(n int) {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	sem.limit = n
+	sem.grant()
+}
+
+// free reports whether a slot is free. sem.mu must be held.
+func (sem *semaphore) free() bool {
+	return sem.limit == 0 || sem.used < sem.limit
+}
+
+// grant hands the free slots to the waiting requests, the key at the front
+// of turns going to the back once it's had its weight of them. sem.mu must
+// be held.
+func (sem *semaphore) grant() {
+	if sem.free() && len(sem.turns) > 0 {
+		defer sem.advance()
+	}
+	for sem.free() && len(sem.turns) > 0 {
+		key := sem.turns[0]
+		queue := sem.queues[key]
+		w := queue[0]
+		sem.used++
+		w.granted = true
+		close(w.ready)
+
+		sem.served++
+		weight := 1
+		if sem.weight != nil {
+			weight = max(sem.weight(key), 1)
+		}
+		switch {
+		case len(queue) == 1:
+			delete(sem.queues, key)
+			sem.turns, sem.served = sem.turns[1:], 0
+		case sem.served >= weight:
+			sem.queues[key] = queue[1:]
+			sem.turns, sem.served = append(sem.turns[1:], key), 0
+		default:
+			sem.queues[key] = queue[1:]
+		}
+	}
+}
+
+// advance tells the waiting requests the line has moved. sem.mu must be
+// held.
+func (sem *semaphore) advance() {
+	close(sem.moved)
+	sem.moved = make(chan struct{})
+}
+
+// position is how many slots will have been handed out once w gets one,
+// counting its own, or 0 when it isn't waiting. It plays grant forward over
+// the queues as they are. sem.mu must be held.
+func (sem *semaphore) position(w *slotWaiter) int {
+	turns := append([]string(nil), sem.turns...)
+	next := make(map[string]int, len(turns))
+	served := sem.served
+	for position := 1; len(turns) > 0; position++ {
+		key := turns[0]
+		queue := sem.queues[key]
+		if queue[next[key]] == w {
+			return position
+		}
+		next[key]++
+		served++
+		weight := 1
+		if sem.weight != nil {
+			weight = max(sem.weight(key), 1)
+		}
+		switch {
+		case next[key] == len(queue):
+			turns, served = turns[1:], 0
+		case served >= weight:
+			turns, served = append(turns[1:], key), 0
+		}
+	}
+	return 0
+}
+
+// tryAcquire takes a slot if one is free and nobody is waiting for one.
+func (sem *semaphore) tryAcquire() bool {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if !sem.free() || len(sem.turns) > 0 {
+		return false
+	}
+	sem.used++
+	return true
+}
+
+// acquire waits for a slot, returning false if ctx is done first.
+func (sem *semaphore) acquire(ctx context.Context) bool {
+	return sem.acquireFor(ctx, "")
+}
+
+// acquireFor waits for a slot in key's queue, returning false if ctx is done
+// first.
+func (sem *semaphore) acquireFor(ctx context.Context, key string) bool {
+	return sem.acquireReporting(ctx, key, nil)
+}
+
+// acquireReporting is acquireFor calling report with the request's place in
+// line when it has to wait and each time it moves. report runs on the
+// waiting goroutine, so it holds up nobody else.
+func (sem *semaphore) acquireReporting(ctx context.Context, key string, report func(position int)) bool {
+	sem.mu.Lock()
+	if sem.free() && len(sem.turns) == 0 {
+		sem.used++
+		sem.mu.Unlock()
+		return true
+	}
+	w := &slotWaiter{ready: make(chan struct{})}
+	if _, ok := sem.queues[key]; !ok {
+		sem.turns = append(sem.turns, key)
+	}
+	sem.queues[key] = append(sem.queues[key], w)
+	// taking turns, a new key can go ahead of those already waiting
+	sem.advance()
+
+	reported := 0
+	for {
+		position, moved := 0, sem.moved
+		if report != nil {
+			position = sem.position(w)
+		}
+		sem.mu.Unlock()
+		if position > 0 && position != reported {
+			report(position)
+			reported = position
+		}
+
+		select {
+		case <-w.ready:
+			return true
+		case <-ctx.Done():
+		case <-moved:
+			sem.mu.Lock()
+			continue
+		}
+		break
+	}
+
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if w.granted {
+		// the slot came too late, so it goes to the next in line
+		sem.used--
+		sem.grant()
+		return false
+	}
+	sem.leave(key, w)
+	sem.advance()
+	return false
+}
+
+// leave takes w out of key's queue. sem.mu must be held.
+func (sem *semaphore) leave(key string, w *slotWaiter) {
+	queue := sem.queues[key]
+	for i, waiting := range queue {
+		if waiting == w {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		sem.queues[key] = queue
+		return
+	}
+	delete(sem.queues, key)
+	for i, k := range sem.turns {
+		if k == key {
+			if i == 0 {
+				sem.served = 0
+			}
+			sem.turns = append(sem.turns[:i:i], sem.turns[i+1:]...)
+			break
+		}
+	}
+}
+
+func (sem *semaphore) release() {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	sem.used--
+	sem.grant()
+}
+
+// releaser returns a release for a slot already taken that only releases it
+// the first time, so it can be both deferred and called once it's done with.
+func (sem *semaphore) releaser() (release func()) {
+	var once sync.Once
+	return func() { once.Do(sem.release) }
+}
+
+// inUse is how many slots are taken.
+func (sem *semaphore) inUse() int {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	return sem.used
+}
+
+// size is the limit, zero when there isn't one.
+func (sem *semaphore) size() int {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	return sem.limit
+}
+
+// waitLine tracks who is waiting for a generation slot, in the order they
+// started waiting, for the queue command.
+type waitLine struct {
+	mu      sync.Mutex
+	waiting []*string
+}
+
+var generationLine = &waitLine{}
+
+// join adds userID to the end of the line, returning the function that
+// takes them out of it.
+func (l *waitLine) join(userID string) (leave func()) {
+	entry := &userID
+	l.mu.Lock()
+	l.waiting = append(l.waiting, entry)
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, e := range l.waiting {
+			if e == entry {
+				l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// positions returns how many are waiting and where userID's requests are in
+// the line, counting from 1.
+func (l *waitLine) positions(userID string) (waiting int, mine []int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, e := range l.waiting {
+		if *e == userID {
+			mine = append(mine, i+1)
+		}
+	}
+	return len(l.waiting), mine
+}
+
+// queueSlot takes a generation slot, waiting in line for one when they're
+// all busy and calling report, when it's set, with the request's place in
+// line as it moves. It returns false if ctx is done or shutdown begins
+// first.
+func queueSlot(ctx context.Context, report func(position int)) bool {
+	if generationSlots.tryAcquire() {
+		return true
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(activeRequests.draining, cancel)()
+
+	defer generationLine.join(userIDFrom(ctx))()
+	return generationSlots.acquireReporting(ctx, queueKey(ctx), report)
+}
+
+// queueKey is the queue a request waits for a generation slot in: its
+// guild's with the fair QueuePolicy, else the same one as everyone's.
+func queueKey(ctx context.Context) string {
+	if cfg().QueuePolicy == queueFair {
+		return guildIDFrom(ctx)
+	}
+	return ""
+}
+
+// guildQueueWeight is how many generation slots in a row guildID gets on its
+// turn with the fair QueuePolicy.
+func guildQueueWeight(guildID string) int {
+	if w := cfg().GuildQueueWeights[guildID]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// validateQueuePolicy checks how waiting requests are handed generation
+// slots.
+func validateQueuePolicy(c *Config) error {
+	if p := c.QueuePolicy; p != "" && p != queueFIFO && p != queueFair {
+		return fmt.Errorf("unsupported queuePolicy %q, use %s or %s", p, queueFIFO, queueFair)
+	}
+	for guildID, w := range c.GuildQueueWeights {
+		if w < 0 {
+			return fmt.Errorf("guildQueueWeights for %s can't be negative", guildID)
+		}
+	}
+	return nil
+}
+
+// queueReply describes how busy the bot is for the queue command, and where
+// userID's requests are if they're waiting.
+func queueReply(locale string, userID string) string {
+	waiting, mine := generationLine.positions(userID)
+	reply := tr(locale, "reply.queue", generationSlots.inUse(), waiting)
+	if len(mine) > 0 {
+		reply += " " + tr(locale, "reply.queuePos", mine[0])
+	}
+	return reply
+}
+
+// waitForSlot takes a generation slot, showing ⌛ on the message while the
+// request is queued behind others, and the error status if shutdown ends its
+// wait. With ShowQueuePosition a reply says where it is in line until then.
+// The caller must release the slot.
+func waitForSlot(ctx context.Context, s session, channelID string, messageID string) bool {
+	if generationSlots.tryAcquire() {
+		return true
+	}
+
+	logger(ctx).Info("Queued, all generation slots are busy", "slots", generationSlots.size())
+	setStatus(s, channelID, messageID, "⌛")
+	var report func(int)
+	if cfg().ShowQueuePosition {
+		notice := &queueNotice{s: s, channelID: channelID, messageID: messageID, locale: localeFrom(ctx)}
+		defer notice.remove()
+		report = notice.show
+	}
+	ok := queueSlot(ctx, report)
+	s.MessageReactionRemove(channelID, messageID, "⌛", "@me")
+	if !ok && activeRequests.closingDown() {
+		setStatus(s, channelID, messageID, statusEmojis().Error)
+	}
+	return ok
+}
+
+// queueNotice is the reply telling a queued request where it is in line.
+type queueNotice struct {
+	s         session
+	channelID string
+	messageID string
+	locale    string
+	// reply is the notice once it's been sent.
+	reply *discordgo.Message
+}
+
+// show sends the notice for position, or edits the one already sent.
+func (n *queueNotice) show(position int) {
+	content := tr(n.locale, "reply.queuePos", position)
+	if n.reply == nil {
+		reply, err := n.s.ChannelMessageSendReply(n.channelID, content, &discordgo.MessageReference{MessageID: n.messageID, ChannelID: n.channelID})
+		if err != nil {
+			slog.Warn("Error sending queue position", "message_id", n.messageID, "error", err)
+			return
+		}
+		n.reply = reply
+		return
+	}
+	if _, err := n.s.ChannelMessageEditComplex(discordgo.NewMessageEdit(n.channelID, n.reply.ID).SetContent(content)); err != nil {
+		slog.Warn("Error updating queue position", "message_id", n.messageID, "error", err)
+	}
+}
+
+// remove deletes the notice once the wait is over.
+func (n *queueNotice) remove() {
+	if n.reply == nil {
+		return
+	}
+	if err := n.s.ChannelMessageDelete(n.channelID, n.reply.ID); err != nil {
+		slog.Warn("Error deleting queue position", "message_id", n.messageID, "error", err)
+	}
+}
+
+// enterMediaStage hands a request's generation slot back through
+// releaseGeneration and takes a media slot for downloading and uploading its
+// images, so a backlog of uploads doesn't keep the next generations waiting.
+// It returns false if ctx is done first. The caller must release the media
+// slot.
+func enterMediaStage(ctx context.Context, releaseGeneration func()) bool {
+	releaseGeneration()
+	return mediaSlots.acquire(ctx)
+}
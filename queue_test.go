@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSemaphoreResize(t *testing.T) {
+	sem := newSemaphore(1)
+	if !sem.tryAcquire() || sem.tryAcquire() {
+		t.Fatal("a semaphore of 1 should hand out exactly one slot")
+	}
+
+	got := make(chan bool)
+	go func() { got <- sem.acquire(context.Background()) }()
+	select {
+	case <-got:
+		t.Fatal("acquired a slot while the only one was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// growing the limit lets the waiter in without a release
+	sem.resize(2)
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Fatal("acquire failed after the limit grew")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked after the limit grew")
+	}
+
+	// shrinking keeps the slots already taken until they're released
+	sem.resize(1)
+	if sem.inUse() != 2 || sem.tryAcquire() {
+		t.Fatalf("in use %d after shrinking, want both slots kept and none free", sem.inUse())
+	}
+	sem.release()
+	if sem.tryAcquire() {
+		t.Fatal("one release under a lowered limit freed a slot too soon")
+	}
+	sem.release()
+	if !sem.tryAcquire() {
+		t.Fatal("no slot free once back under the limit")
+	}
+}
+
+func TestSemaphoreUnlimited(t *testing.T) {
+	sem := newSemaphore(0)
+	for i := 0; i < 100; i++ {
+		if !sem.tryAcquire() {
+			t.Fatalf("slot %d refused without a limit", i)
+		}
+	}
+	if sem.inUse() != 100 {
+		t.Errorf("in use %d, want 100", sem.inUse())
+	}
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	sem := newSemaphore(1)
+	sem.tryAcquire()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sem.acquire(ctx) {
+		t.Error("acquired a slot with a cancelled context")
+	}
+}
+
+func TestQueueKeepsEveryRequest(t *testing.T) {
+	d, api := setupBot(t, Config{MaxConcurrent: 2}, "crowded")
+	oldSlots := generationSlots.size()
+	generationSlots.resize(2)
+	t.Cleanup(func() { generationSlots.resize(oldSlots) })
+	release := api.hang()
+
+	const jobs = 6
+	var wg sync.WaitGroup
+	var requests []*discordgo.MessageCreate
+	for i := 0; i < jobs; i++ {
+		m := d.post("crowded", fmt.Sprintf("user%d", i), fmt.Sprintf("/dalle fox number %d", i))
+		requests = append(requests, m)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			onMessageHandler(d, m)
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if waiting, _ := generationLine.positions(""); waiting == jobs-2 && len(api.generated()) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d generating, want 2 running and the rest queued", len(api.generated()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	wg.Wait()
+
+	if got := api.generated(); len(got) != jobs {
+		t.Errorf("generated %d prompts, want all %d", len(got), jobs)
+	}
+	if n := api.mostConcurrent(); n > 2 {
+		t.Errorf("%d generations ran at once, want at most 2", n)
+	}
+	for _, m := range requests {
+		if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+			t.Errorf("request %s shows %q, want ✅", m.ID, got)
+		}
+	}
+}
+
+func TestWaitLinePositions(t *testing.T) {
+	line := &waitLine{}
+	leaveAlice := line.join("alice")
+	line.join("bob")
+	line.join("alice")
+
+	if waiting, mine := line.positions("alice"); waiting != 3 || !reflect.DeepEqual(mine, []int{1, 3}) {
+		t.Errorf("alice sees %d waiting at %v, want 3 at [1 3]", waiting, mine)
+	}
+	leaveAlice()
+	if waiting, mine := line.positions("bob"); waiting != 2 || !reflect.DeepEqual(mine, []int{1}) {
+		t.Errorf("bob sees %d waiting at %v, want 2 at [1] once alice's first leaves", waiting, mine)
+	}
+	if _, mine := line.positions("carol"); len(mine) != 0 {
+		t.Errorf("carol is at %v, want carol nowhere in line", mine)
+	}
+}
+
+func TestQueueCommand(t *testing.T) {
+	d, _ := setupBot(t, Config{MaxConcurrent: 1}, "busy")
+	oldSlots := generationSlots.size()
+	generationSlots.resize(1)
+	t.Cleanup(func() { generationSlots.resize(oldSlots) })
+	if !generationSlots.tryAcquire() {
+		t.Fatal("no free slot to hold")
+	}
+
+	var wg sync.WaitGroup
+	for i, user := range []string{"alice", "bob", "alice"} {
+		m := d.post("busy", user, fmt.Sprintf("/dalle fox number %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			onMessageHandler(d, m)
+		}()
+		// one at a time, so the line is in posting order
+		for deadline := time.Now().Add(time.Second); ; {
+			if waiting, _ := generationLine.positions(""); waiting == i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("request %d never joined the line", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	onMessageHandler(d, d.post("busy", "bob", "/dalle queue"))
+	onMessageHandler(d, d.post("busy", "carol", "/dalle queue"))
+
+	generationSlots.release()
+	wg.Wait()
+
+	var replies []string
+	for _, m := range d.sentMessages() {
+		if strings.HasPrefix(m.Content, "In progress") {
+			replies = append(replies, m.Content)
+		}
+	}
+	want := []string{
+		"In progress: 1, waiting: 3. You're number 2 in line.",
+		"In progress: 1, waiting: 3.",
+	}
+	if !reflect.DeepEqual(replies, want) {
+		t.Errorf("queue replies %q, want %q", replies, want)
+	}
+}
+
+func TestMediaStageFreesGenerationSlot(t *testing.T) {
+	d, api := setupBot(t, Config{MaxConcurrent: 1, MediaConcurrency: 1}, "uploads")
+	oldSlots, oldMedia := generationSlots.size(), mediaSlots.size()
+	generationSlots.resize(1)
+	mediaSlots.resize(1)
+	t.Cleanup(func() {
+		generationSlots.resize(oldSlots)
+		mediaSlots.resize(oldMedia)
+	})
+	// a slow upload holds the only media slot
+	if !mediaSlots.tryAcquire() {
+		t.Fatal("no free media slot to hold")
+	}
+
+	var wg sync.WaitGroup
+	var requests []*discordgo.MessageCreate
+	for i := 0; i < 2; i++ {
+		m := d.post("uploads", "user", fmt.Sprintf("/dalle fox number %d", i))
+		requests = append(requests, m)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			onMessageHandler(d, m)
+		}()
+	}
+	// both generate even though there's only one generation slot, since
+	// each gives it up while waiting to upload
+	for deadline := time.Now().Add(time.Second); len(api.generated()) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d generated, want both while the first waits for a media slot", len(api.generated()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for deadline := time.Now().Add(time.Second); generationSlots.inUse() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d generation slots in use, want none while waiting to upload", generationSlots.inUse())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := metricValue(t, mediaSlotsInUse); got != 1 {
+		t.Errorf("media slots in use reads %v, want the held one", got)
+	}
+	if got := metricValue(t, generationSlotsInUse); got != 0 {
+		t.Errorf("generation slots in use reads %v, want 0", got)
+	}
+
+	mediaSlots.release()
+	wg.Wait()
+	for _, m := range requests {
+		if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"✅"}) {
+			t.Errorf("request %s shows %q, want ✅", m.ID, got)
+		}
+	}
+}
+
+// servedOrder queues a request for each of keys in turn on a semaphore of
+// one slot already taken, then releases the slot over and over, returning
+// the order the requests got it in by their key and number.
+func servedOrder(t *testing.T, sem *semaphore, keys []string) []string {
+	t.Helper()
+	if !sem.tryAcquire() {
+		t.Fatal("no slot to hold")
+	}
+	got := make(chan string, len(keys))
+	for n, key := range keys {
+		name := fmt.Sprintf("%s%d", key, n+1)
+		go func() {
+			if sem.acquireFor(context.Background(), key) {
+				got <- name
+			}
+		}()
+		// wait for it to be in line before the next joins
+		for deadline := time.Now().Add(time.Second); ; {
+			sem.mu.Lock()
+			waiting := 0
+			for _, q := range sem.queues {
+				waiting += len(q)
+			}
+			sem.mu.Unlock()
+			if waiting == n+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("request %s never queued", name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var order []string
+	for range keys {
+		sem.release()
+		select {
+		case name := <-got:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("nobody got the released slot after %q", order)
+		}
+	}
+	return order
+}
+
+func TestSemaphoreFirstComeFirstServed(t *testing.T) {
+	keys := []string{"", "", "", ""}
+	got := servedOrder(t, newSemaphore(1), keys)
+	if want := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("served %q, want %q", got, want)
+	}
+}
+
+func TestSemaphoreFairBetweenGuilds(t *testing.T) {
+	// the busy guild floods the queue before the quiet one asks once
+	keys := []string{"busy", "busy", "busy", "busy", "quiet", "quiet"}
+	got := servedOrder(t, newSemaphore(1), keys)
+	if want := []string{"busy1", "quiet5", "busy2", "quiet6", "busy3", "busy4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("served %q, want the guilds taking turns %q", got, want)
+	}
+}
+
+func TestSemaphoreWeightedGuilds(t *testing.T) {
+	weights := map[string]int{"big": 2}
+	sem := newWeightedSemaphore(1, func(key string) int { return weights[key] })
+	keys := []string{"big", "big", "big", "big", "small", "small"}
+	got := servedOrder(t, sem, keys)
+	if want := []string{"big1", "big2", "small5", "big3", "big4", "small6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("served %q, want two in a row for the heavier guild %q", got, want)
+	}
+}
+
+func TestSemaphoreCancelledWaiterLeavesLine(t *testing.T) {
+	sem := newSemaphore(1)
+	sem.tryAcquire()
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan bool)
+	go func() { gaveUp <- sem.acquireFor(ctx, "gone") }()
+	for deadline := time.Now().Add(time.Second); ; {
+		sem.mu.Lock()
+		queued := len(sem.turns)
+		sem.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("waiter never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if <-gaveUp {
+		t.Fatal("acquired a slot with a cancelled context")
+	}
+
+	sem.release()
+	if !sem.tryAcquire() {
+		t.Error("slot not free after the only waiter gave up")
+	}
+}
+
+func TestQueueKey(t *testing.T) {
+	imgReq := &ImageRequest{GuildID: "guild"}
+	ctx := newRequestContext(context.Background(), imgReq)
+
+	useConfig(t, Config{})
+	if got := queueKey(ctx); got != "" {
+		t.Errorf("queue key is %q by default, want everyone in one line", got)
+	}
+	useConfig(t, Config{QueuePolicy: queueFair})
+	if got := queueKey(ctx); got != "guild" {
+		t.Errorf("queue key is %q when fair, want the guild", got)
+	}
+}
+
+func TestSemaphoreReportsPosition(t *testing.T) {
+	sem := newSemaphore(1)
+	sem.tryAcquire()
+	var mu sync.Mutex
+	latest := make(map[string]int)
+	got := make(chan string, 3)
+	for n, key := range []string{"busy", "busy", "quiet"} {
+		name := fmt.Sprintf("%s%d", key, n+1)
+		go func() {
+			report := func(position int) {
+				mu.Lock()
+				latest[name] = position
+				mu.Unlock()
+			}
+			if sem.acquireReporting(context.Background(), key, report) {
+				got <- name
+			}
+		}()
+		for deadline := time.Now().Add(time.Second); ; {
+			mu.Lock()
+			_, queued := latest[name]
+			mu.Unlock()
+			if queued {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never reported a position", name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// positions says where everyone is once the reports settle
+	positions := func(want map[string]int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); ; {
+			mu.Lock()
+			same := reflect.DeepEqual(latest, want)
+			mu.Unlock()
+			if same {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("reported %v, want %v", latest, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the quiet key's turn comes before the busy one's second
+	positions(map[string]int{"busy1": 1, "busy2": 3, "quiet3": 2})
+	sem.release()
+	if name := <-got; name != "busy1" {
+		t.Fatalf("%s got the slot, want busy1", name)
+	}
+	// the first's last report stays as it was
+	positions(map[string]int{"busy1": 1, "busy2": 2, "quiet3": 1})
+	sem.release()
+	if name := <-got; name != "quiet3" {
+		t.Fatalf("%s got the slot, want quiet3", name)
+	}
+	positions(map[string]int{"busy1": 1, "busy2": 1, "quiet3": 1})
+	sem.release()
+	<-got
+}
+
+func TestQueuePositionShown(t *testing.T) {
+	d, _ := setupBot(t, Config{MaxConcurrent: 1, ShowQueuePosition: true}, "inline")
+	oldSlots := generationSlots.size()
+	generationSlots.resize(1)
+	t.Cleanup(func() { generationSlots.resize(oldSlots) })
+	if !generationSlots.tryAcquire() {
+		t.Fatal("no free slot to hold")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		m := d.post("inline", "user", fmt.Sprintf("/dalle fox number %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			onMessageHandler(d, m)
+		}()
+		for deadline := time.Now().Add(time.Second); ; {
+			if len(d.sentMessages()) == i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("request %d never said where it is in line", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	notices := d.sentMessages()
+	for i, notice := range notices {
+		if want := tr("en", "reply.queuePos", i+1); notice.Content != want {
+			t.Errorf("notice %d says %q, want %q", i, notice.Content, want)
+		}
+	}
+
+	generationSlots.release()
+	wg.Wait()
+
+	var edited []string
+	for _, e := range d.editedMessages() {
+		if e.ID == notices[1].ID && e.Content != nil {
+			edited = append(edited, *e.Content)
+		}
+	}
+	if want := []string{tr("en", "reply.queuePos", 1)}; !reflect.DeepEqual(edited, want) {
+		t.Errorf("second notice edited to %q, want %q once the first moved on", edited, want)
+	}
+	deleted := make(map[string]bool)
+	for _, id := range d.deletedMessages() {
+		deleted[id] = true
+	}
+	if !deleted[notices[0].ID] || !deleted[notices[1].ID] {
+		t.Errorf("deleted %q, want both notices gone once their turn came", d.deletedMessages())
+	}
+}
+
+func TestQueuePositionOff(t *testing.T) {
+	d, _ := setupBot(t, Config{MaxConcurrent: 1}, "quietline")
+	oldSlots := generationSlots.size()
+	generationSlots.resize(1)
+	t.Cleanup(func() { generationSlots.resize(oldSlots) })
+	if !generationSlots.tryAcquire() {
+		t.Fatal("no free slot to hold")
+	}
+
+	m := d.post("quietline", "user", "/dalle a red fox")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		onMessageHandler(d, m)
+	}()
+	for deadline := time.Now().Add(time.Second); ; {
+		if waiting, _ := generationLine.positions(""); waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("request never joined the line")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	generationSlots.release()
+	<-done
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Errorf("sent %+v, want only the image", sent)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// quotaTracker counts the images each user has asked for on the current UTC
+// day.
+type quotaTracker struct {
+	mu     sync.Mutex
+	day    string
+	byUser map[string]int
+}
+
+var dailyQuota = &quotaTracker{byUser: make(map[string]int)}
+
+// take reserves n images of userID's limit for today, returning false when
+// that would go over it. A limit of 0 means no quota.
+func (q *quotaTracker) take(userID string, n int, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day := now().UTC().Format("2006-01-02")
+	if day != q.day {
+		q.day = day
+		q.byUser = make(map[string]int)
+	}
+
+	if q.byUser[userID]+n > limit {
+		return false
+	}
+	q.byUser[userID] += n
+	return true
+}
+
+// quotaReset is how long until the daily quotas reset at midnight UTC.
+func quotaReset() time.Duration {
+	t := now().UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(t)
+}
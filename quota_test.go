@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuotaReached(t *testing.T) {
+	fakeClock(t)
+	oldQuota := dailyQuota
+	dailyQuota = &quotaTracker{byUser: make(map[string]int)}
+	t.Cleanup(func() { dailyQuota = oldQuota })
+	d, api := setupBot(t, Config{DailyQuota: 2}, "quota")
+
+	onMessageHandler(d, d.post("quota", "user", "/dalle a red fox"))
+	onMessageHandler(d, d.post("quota", "user", "/dalle a blue whale"))
+	m := d.post("quota", "user", "/dalle one too many")
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %q, want only the first 2 prompts", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"🚫"}) {
+		t.Errorf("request shows %q, want 🚫", got)
+	}
+	sent := d.sentMessages()
+	if want := "You've used your 2 images for today, the quota resets at midnight UTC (in 12h 0m)."; sent[len(sent)-1].Content != want {
+		t.Errorf("last reply is %q, want %q", sent[len(sent)-1].Content, want)
+	}
+}
+
+func TestQuotaResetsAtMidnight(t *testing.T) {
+	advance := fakeClock(t)
+	q := &quotaTracker{byUser: make(map[string]int)}
+
+	if !q.take("user", 3, 3) {
+		t.Fatal("3 images refused with a quota of 3")
+	}
+	advance(12*time.Hour - time.Second)
+	if q.take("user", 1, 3) {
+		t.Fatal("image allowed at 23:59:59 after using the quota")
+	}
+	if got := quotaReset(); got != time.Second {
+		t.Errorf("quota resets in %v, want 1s", got)
+	}
+	advance(time.Second)
+	if !q.take("user", 3, 3) {
+		t.Error("quota still used up after midnight UTC")
+	}
+}
+
+func TestQuotaCountsImages(t *testing.T) {
+	fakeClock(t)
+	q := &quotaTracker{byUser: make(map[string]int)}
+
+	if q.take("user", 4, 3) {
+		t.Error("4 images allowed with a quota of 3")
+	}
+	if !q.take("user", 3, 3) {
+		t.Error("a refused request used up some of the quota")
+	}
+}
+
+func TestQuotaUnlimited(t *testing.T) {
+	fakeClock(t)
+	q := &quotaTracker{byUser: make(map[string]int)}
+	for i := 0; i < 100; i++ {
+		if !q.take("user", 10, 0) {
+			t.Fatalf("request %d refused without a quota", i+1)
+		}
+	}
+	if len(q.byUser) != 0 {
+		t.Errorf("tracked %v without a quota, want nothing counted", q.byUser)
+	}
+}
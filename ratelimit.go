@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// bucket is one user's token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-user token bucket limiter. Each user may burst up to
+// the per-minute limit, with tokens refilling continuously over the minute.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var userLimiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+// allow takes a token for each of the n images userID asked for, returning
+// false and how long until there are enough when the user is out. A request
+// never needs more than a full bucket, so asking for more images than the
+// per-minute limit waits for the bucket to fill rather than failing forever.
+// A perMinute of 0 means no limit.
+func (l *rateLimiter) allow(userID string, n, perMinute int) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(perMinute)
+	rate := capacity / time.Minute.Seconds()
+	t := now()
+
+	b, ok := l.buckets[userID]
+	if !ok {
+		b = &bucket{tokens: capacity, last: t}
+		l.buckets[userID] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+t.Sub(b.last).Seconds()*rate)
+	b.last = t
+
+	cost := math.Min(capacity, float64(max(n, 1)))
+	if b.tokens < cost {
+		wait := time.Duration((cost - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens -= cost
+	return true, 0
+}
+
+// prune forgets users whose buckets have refilled, since a full bucket is
+// the same as none and the map would otherwise keep everyone who ever asked.
+func (l *rateLimiter) prune(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := now()
+	for userID, b := range l.buckets {
+		if perMinute <= 0 || t.Sub(b.last) >= time.Minute {
+			delete(l.buckets, userID)
+		}
+	}
+}
+
+// limitNotice is the reply telling a user how long to wait, and when that
+// wait is over.
+type limitNotice struct {
+	channelID string
+	messageID string
+	until     time.Time
+}
+
+// limitNotices are the replies to users over their limit that are still
+// counting down, by user, so one asking again and again gets the one reply
+// updated instead of a new one each time.
+var (
+	limitNoticesMu sync.Mutex
+	limitNotices   = make(map[string]limitNotice)
+)
+
+// sendLimitNotice tells the author of m how long to wait before asking
+// again. While an earlier notice in the channel is still counting down it's
+// edited with the new wait instead, or left alone with QuietRateLimit.
+func sendLimitNotice(s session, m *discordgo.MessageCreate, refused *refusal) {
+	userID := m.Author.ID
+	limitNoticesMu.Lock()
+	notice, ok := limitNotices[userID]
+	limitNoticesMu.Unlock()
+
+	if ok && notice.channelID == m.ChannelID && now().Before(notice.until) {
+		if cfg().QuietRateLimit {
+			return
+		}
+		_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{ID: notice.messageID, Channel: notice.channelID, Content: &refused.reason})
+		if err == nil {
+			rememberLimitNotice(userID, notice.channelID, notice.messageID, refused.wait)
+			return
+		}
+		// it was likely deleted, so a new one takes its place
+		slog.Warn("Error on updating rate limit notice", "message_id", notice.messageID, "channel_id", notice.channelID, "error", err)
+	}
+
+	reply, err := sendReplyChunked(s, m.ChannelID, refused.reason, nil, m.Reference())
+	if err != nil {
+		slog.Error("Error on sending rate limit notice", "message_id", m.ID, "channel_id", m.ChannelID, "error", err)
+		return
+	}
+	rememberLimitNotice(userID, reply.ChannelID, reply.ID, refused.wait)
+}
+
+// rememberLimitNotice keeps userID's notice until wait is over, forgetting
+// any others whose wait is.
+func rememberLimitNotice(userID string, channelID string, messageID string, wait time.Duration) {
+	limitNoticesMu.Lock()
+	defer limitNoticesMu.Unlock()
+	t := now()
+	for id, notice := range limitNotices {
+		if !t.Before(notice.until) {
+			delete(limitNotices, id)
+		}
+	}
+	limitNotices[userID] = limitNotice{channelID: channelID, messageID: messageID, until: t.Add(wait)}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRateLimitRefills(t *testing.T) {
+	advance := fakeClock(t)
+	l := &rateLimiter{buckets: make(map[string]*bucket)}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("user", 1, 3); !ok {
+			t.Fatalf("request %d refused, want the first 3 allowed", i+1)
+		}
+	}
+	ok, wait := l.allow("user", 1, 3)
+	if ok || wait != 20*time.Second {
+		t.Fatalf("4th request allowed %v waiting %v, want it refused for 20s", ok, wait)
+	}
+
+	advance(20 * time.Second)
+	if ok, _ := l.allow("user", 1, 3); !ok {
+		t.Errorf("request after 20s refused, want one token refilled")
+	}
+	if ok, _ := l.allow("other", 1, 3); !ok {
+		t.Errorf("another user refused, want each user limited on their own")
+	}
+}
+
+func TestRateLimitCountsImages(t *testing.T) {
+	fakeClock(t)
+	l := &rateLimiter{buckets: make(map[string]*bucket)}
+
+	if ok, _ := l.allow("user", 4, 5); !ok {
+		t.Fatalf("4 images refused, want them allowed with 5 a minute")
+	}
+	if ok, wait := l.allow("user", 2, 5); ok || wait != 12*time.Second {
+		t.Errorf("2 more images allowed %v waiting %v, want them refused for 12s", ok, wait)
+	}
+	if ok, _ := l.allow("user", 1, 5); !ok {
+		t.Errorf("the last image refused, want it allowed")
+	}
+	// asking for more than the limit waits for a full bucket
+	if ok, wait := l.allow("greedy", 10, 5); !ok || wait != 0 {
+		t.Errorf("10 images with 5 a minute allowed %v waiting %v, want a full bucket to be enough", ok, wait)
+	}
+}
+
+func TestRateLimitPrunesIdleUsers(t *testing.T) {
+	advance := fakeClock(t)
+	l := &rateLimiter{buckets: make(map[string]*bucket)}
+	l.allow("idle", 1, 5)
+	advance(30 * time.Second)
+	l.allow("busy", 1, 5)
+	advance(30 * time.Second)
+
+	l.prune(5)
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Errorf("idle user still tracked, want their full bucket dropped")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Errorf("busy user dropped, want them tracked until their bucket refills")
+	}
+}
+
+// useLimiter starts everyone's rate limits and notices afresh, on a fake
+// clock, for the rest of the test.
+func useLimiter(t *testing.T) (advance func(time.Duration)) {
+	advance = fakeClock(t)
+	oldLimiter := userLimiter
+	userLimiter = &rateLimiter{buckets: make(map[string]*bucket)}
+	limitNoticesMu.Lock()
+	oldNotices := limitNotices
+	limitNotices = make(map[string]limitNotice)
+	limitNoticesMu.Unlock()
+	t.Cleanup(func() {
+		userLimiter = oldLimiter
+		limitNoticesMu.Lock()
+		limitNotices = oldNotices
+		limitNoticesMu.Unlock()
+	})
+	return advance
+}
+
+func TestRateLimitRefusesPrompt(t *testing.T) {
+	useLimiter(t)
+	d, api := setupBot(t, Config{MaxRequestsPerMinute: 2}, "limited")
+
+	for i := 0; i < 2; i++ {
+		onMessageHandler(d, d.post("limited", "user", fmt.Sprintf("/dalle fox number %d", i+1)))
+	}
+	m := d.post("limited", "user", "/dalle one fox too many")
+	onMessageHandler(d, m)
+
+	if got := api.generated(); len(got) != 2 {
+		t.Fatalf("generated %q, want only the first 2 prompts", got)
+	}
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"⏳"}) {
+		t.Errorf("request shows %q, want ⏳", got)
+	}
+	sent := d.sentMessages()
+	if last := sent[len(sent)-1]; last.MessageReference == nil || last.MessageReference.MessageID != m.ID || !strings.Contains(last.Content, "30") {
+		t.Errorf("last reply is %+v, want one telling the user to wait 30 seconds", last)
+	}
+}
+
+func TestRateLimitNoticeUpdated(t *testing.T) {
+	advance := useLimiter(t)
+	d, _ := setupBot(t, Config{MaxRequestsPerMinute: 1}, "impatient")
+	onMessageHandler(d, d.post("impatient", "user", "/dalle a red fox"))
+
+	first := d.post("impatient", "user", "/dalle another fox")
+	onMessageHandler(d, first)
+	advance(10 * time.Second)
+	second := d.post("impatient", "user", "/dalle one more fox")
+	onMessageHandler(d, second)
+
+	sent := d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the image and a single notice", len(sent))
+	}
+	notice := sent[1]
+	if notice.MessageReference == nil || notice.MessageReference.MessageID != first.ID {
+		t.Errorf("notice replies to %+v, want the first request over the limit", notice.MessageReference)
+	}
+	if want := tr("en", "refuse.tooFast", 50); notice.Content != want {
+		t.Errorf("notice says %q, want it updated to %q", notice.Content, want)
+	}
+	for _, m := range []*discordgo.MessageCreate{first, second} {
+		if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"⏳"}) {
+			t.Errorf("request %s shows %q, want ⏳", m.ID, got)
+		}
+	}
+
+	// once the wait is over a new refusal gets a notice of its own
+	advance(50 * time.Second)
+	onMessageHandler(d, d.post("impatient", "user", "/dalle a fox at last"))
+	onMessageHandler(d, d.post("impatient", "user", "/dalle a fox too soon"))
+	if sent := d.sentMessages(); len(sent) != 4 {
+		t.Errorf("sent %d messages, want a new notice after the old one ran out", len(sent))
+	}
+}
+
+func TestQuietRateLimit(t *testing.T) {
+	useLimiter(t)
+	d, _ := setupBot(t, Config{MaxRequestsPerMinute: 1, QuietRateLimit: true}, "quietlimit")
+	onMessageHandler(d, d.post("quietlimit", "user", "/dalle a red fox"))
+
+	onMessageHandler(d, d.post("quietlimit", "user", "/dalle another fox"))
+	last := d.post("quietlimit", "user", "/dalle one more fox")
+	onMessageHandler(d, last)
+
+	if sent := d.sentMessages(); len(sent) != 2 {
+		t.Errorf("sent %d messages, want the image and one notice", len(sent))
+	}
+	if edits := d.editedMessages(); len(edits) != 0 {
+		t.Errorf("made %d edits, want the notice left alone", len(edits))
+	}
+	if got := d.reactionsOn(last.ID); !reflect.DeepEqual(got, []string{"⏳"}) {
+		t.Errorf("request shows %q, want only ⏳", got)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "sync"
+
+// recentPromptsPerUser is how many of a user's prompts are kept for redo.
+const recentPromptsPerUser = 5
+
+// promptRing holds a user's last few requests, overwriting the oldest.
+type promptRing struct {
+	entries [recentPromptsPerUser]ImageRequest
+	next    int
+	count   int
+}
+
+// recentPrompts keeps each user's recent requests in memory so the redo
+// command can run the last one again.
+type recentPrompts struct {
+	mu     sync.Mutex
+	byUser map[string]*promptRing
+}
+
+var recentRequests = &recentPrompts{byUser: make(map[string]*promptRing)}
+
+// add records a finished request of userID's. Variations and edits aren't
+// kept, their images can't be redone from a prompt.
+func (r *recentPrompts) add(userID string, imgReq *ImageRequest) {
+	if imgReq.Source != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ring, ok := r.byUser[userID]
+	if !ok {
+		ring = &promptRing{}
+		r.byUser[userID] = ring
+	}
+	ring.entries[ring.next] = ImageRequest{
+		Prompt:  imgReq.Prompt,
+		Model:   imgReq.Model,
+		Size:    imgReq.Size,
+		Quality: imgReq.Quality,
+		Style:   imgReq.Style,
+		Seed:    imgReq.Seed,
+		Spoiler: imgReq.Spoiler,
+		N:       imgReq.N,
+	}
+	ring.next = (ring.next + 1) % recentPromptsPerUser
+	if ring.count < recentPromptsPerUser {
+		ring.count++
+	}
+}
+
+// last returns userID's most recent request, and false when they haven't
+// made one since the bot started.
+func (r *recentPrompts) last(userID string) (ImageRequest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ring, ok := r.byUser[userID]
+	if !ok || ring.count == 0 {
+		return ImageRequest{}, false
+	}
+	return ring.entries[(ring.next+recentPromptsPerUser-1)%recentPromptsPerUser], true
+}
+
+// redo sets imgReq up to run previous again with the same settings.
+func (imgReq *ImageRequest) redo(previous ImageRequest) {
+	imgReq.Prompt = previous.Prompt
+	imgReq.Model, imgReq.Size = previous.Model, previous.Size
+	imgReq.Quality, imgReq.Style = previous.Quality, previous.Style
+	imgReq.Seed, imgReq.Spoiler, imgReq.N = previous.Seed, previous.Spoiler, previous.N
+	imgReq.Redo = true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// useRecent starts everyone's recent prompts from nothing for the rest of
+// the test.
+func useRecent(t *testing.T) {
+	old := recentRequests
+	recentRequests = &recentPrompts{byUser: make(map[string]*promptRing)}
+	t.Cleanup(func() { recentRequests = old })
+}
+
+func TestRedoRunsLastPrompt(t *testing.T) {
+	useRecent(t)
+	d, api := setupBot(t, Config{CacheSize: 10}, "redo")
+	onMessageHandler(d, d.post("redo", "user", "/dalle 1024 a red fox"))
+	onMessageHandler(d, d.post("redo", "someone", "/dalle a blue whale"))
+
+	onMessageHandler(d, d.post("redo", "user", "/dalle redo"))
+
+	got := api.generations()
+	if len(got) != 3 {
+		t.Fatalf("asked for %d generations, want the redo generated afresh", len(got))
+	}
+	if redo := got[2]; redo.Prompt != "a red fox" || redo.Size != "1024x1024" {
+		t.Errorf("redo asked for %q at %s, want the requester's last prompt at 1024x1024", redo.Prompt, redo.Size)
+	}
+	if sent := d.sentMessages(); len(sent) != 3 || len(sent[2].Files) != 1 {
+		t.Errorf("sent %+v, want the redo's image", sent)
+	}
+}
+
+func TestRedoWithoutHistory(t *testing.T) {
+	useRecent(t)
+	d, api := setupBot(t, Config{}, "redo")
+
+	onMessageHandler(d, d.post("redo", "user", "/dalle redo"))
+
+	if got := api.generated(); len(got) != 0 {
+		t.Errorf("generated %q, want nothing to redo", got)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != "You haven't asked me for anything yet, so there's nothing to redo." {
+		t.Errorf("sent %+v, want the redo refused", sent)
+	}
+}
+
+func TestRecentPromptsKeepNewest(t *testing.T) {
+	recent := &recentPrompts{byUser: make(map[string]*promptRing)}
+	for i := 1; i <= recentPromptsPerUser+2; i++ {
+		recent.add("user", &ImageRequest{Prompt: fmt.Sprintf("prompt %d", i)})
+	}
+	recent.add("user", &ImageRequest{Prompt: "a variation", Source: testPNG()})
+
+	last, ok := recent.last("user")
+	if !ok || last.Prompt != fmt.Sprintf("prompt %d", recentPromptsPerUser+2) {
+		t.Errorf("last(user) = %q, %v, want the newest prompt", last.Prompt, ok)
+	}
+	ring := recent.byUser["user"]
+	if ring.count != recentPromptsPerUser {
+		t.Errorf("kept %d prompts, want at most %d", ring.count, recentPromptsPerUser)
+	}
+	if _, ok := recent.last("someone"); ok {
+		t.Error("someone with no prompts has a last one")
+	}
+
+	var redo ImageRequest
+	redo.redo(ImageRequest{Prompt: "a red fox", Model: "dall-e-3", Size: "1792x1024", Quality: "hd", N: 1})
+	if want := (ImageRequest{Prompt: "a red fox", Model: "dall-e-3", Size: "1792x1024", Quality: "hd", N: 1, Redo: true}); !reflect.DeepEqual(redo, want) {
+		t.Errorf("redo set up %+v, want %+v", redo, want)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The files of a session recording in RecordDir: the Discord events the bot
+// got, one JSON object a line, and the HTTP exchanges it made, as for
+// HTTPRecordDir.
+const (
+	recordedEventsFile = "events.jsonl"
+	recordedHTTPDir    = "http"
+)
+
+// Event types in a recording.
+const (
+	eventMessageCreate     = "messageCreate"
+	eventReactionAdd       = "reactionAdd"
+	eventReactionRemove    = "reactionRemove"
+	eventInteractionCreate = "interactionCreate"
+)
+
+// recordedEvent is one line of a recording.
+type recordedEvent struct {
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+	// BotID is who the bot was, so a replay ignores its own messages too.
+	BotID string          `json:"botID"`
+	Event json.RawMessage `json:"event"`
+}
+
+// eventLogMu keeps lines from handlers running at once whole.
+var eventLogMu sync.Mutex
+
+// recorded returns handler writing each event to the RecordDir recording,
+// when there is one, before handling it.
+func recorded[T any](eventType string, handler func(session, T)) func(session, T) {
+	return func(s session, event T) {
+		if dir := cfg().RecordDir; dir != "" {
+			if err := recordEvent(dir, eventType, s.botID(), event); err != nil {
+				slog.Error("Error recording event", "type", eventType, "error", err)
+			}
+		}
+		handler(s, event)
+	}
+}
+
+// recordEvent adds event to the recording in dir.
+func recordEvent(dir string, eventType string, botID string, event interface{}) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(recordedEvent{Type: eventType, At: now(), BotID: botID, Event: raw})
+	if err != nil {
+		return err
+	}
+
+	eventLogMu.Lock()
+	defer eventLogMu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, recordedEventsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const redirectPermissions = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+
+// checkTargetChannel makes sure a result can be redirected to channelID: it
+// must be in the requester's guild, be a channel the bot may make images in,
+// and both they and the bot must be able to post there.
+func checkTargetChannel(s session, m *discordgo.MessageCreate, channelID string) error {
+	channel, err := s.Channel(channelID)
+	if err != nil || channel.GuildID != m.GuildID {
+		return errors.New(tr(locale(), "error.toGuild"))
+	}
+	if !allowedChannel(s, m.GuildID, channelID) {
+		return errors.New(tr(locale(), "refuse.here"))
+	}
+
+	for _, userID := range []string{m.Author.ID, s.botID()} {
+		perms, err := s.UserChannelPermissions(userID, channelID)
+		if err != nil || perms&redirectPermissions != redirectPermissions {
+			return errors.New(tr(locale(), "error.toPerms", channelID))
+		}
+	}
+	return nil
+}
+
+// messageLink returns a jump link to a message, which Discord addresses
+// under @me when it's in a DM.
+func messageLink(guildID string, channelID string, messageID string) string {
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// refinePrefix starts the custom IDs of the Refine button and its modal.
+// The rest is the command's options, so the refined prompt is drawn the same
+// way: "refine:<size>:<quality>:<style>:<spoiler>".
+const refinePrefix = "refine:"
+
+// refinePromptInput is the custom ID of the modal's prompt field.
+const refinePromptInput = "prompt"
+
+// refineTTL is how long a response's prompt is kept for its Refine button.
+const refineTTL = 24 * time.Hour
+
+// commandOptions are a /dalle command's options as they were given.
+type commandOptions struct {
+	prompt  string
+	size    string
+	quality string
+	style   string
+	spoiler bool
+}
+
+// refineID is the custom ID that carries opts, but not the prompt, which
+// could be too long for one.
+func refineID(opts commandOptions) string {
+	spoiler := ""
+	if opts.spoiler {
+		spoiler = "1"
+	}
+	return refinePrefix + strings.Join([]string{opts.size, opts.quality, opts.style, spoiler}, ":")
+}
+
+// parseRefineID reads the options back out of a refineID. ok is false for
+// any other custom ID.
+func parseRefineID(customID string) (opts commandOptions, ok bool) {
+	rest, ok := strings.CutPrefix(customID, refinePrefix)
+	if !ok {
+		return opts, false
+	}
+	fields := strings.Split(rest, ":")
+	if len(fields) != 4 {
+		return opts, false
+	}
+	return commandOptions{size: fields[0], quality: fields[1], style: fields[2], spoiler: fields[3] == "1"}, true
+}
+
+// refineButton is the row under a /dalle response to refine its prompt.
+func refineButton(locale string, opts commandOptions) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    tr(locale, "refine.button"),
+			Style:    discordgo.SecondaryButton,
+			CustomID: refineID(opts),
+			Emoji:    discordgo.ComponentEmoji{Name: "✏️"},
+		},
+	}}}
+}
+
+// refinable is the prompt a /dalle response was drawn from, by whom and
+// when.
+type refinable struct {
+	authorID string
+	prompt   string
+	at       time.Time
+}
+
+// refinePrompts are the prompts of /dalle responses, by message ID, to fill
+// in their Refine modal.
+var (
+	refinePromptsMu sync.Mutex
+	refinePrompts   = make(map[string]refinable)
+)
+
+func rememberRefinable(messageID string, authorID string, prompt string) {
+	refinePromptsMu.Lock()
+	defer refinePromptsMu.Unlock()
+	refinePrompts[messageID] = refinable{authorID: authorID, prompt: prompt, at: now()}
+}
+
+func refinableFor(messageID string) (refinable, bool) {
+	refinePromptsMu.Lock()
+	defer refinePromptsMu.Unlock()
+	r, ok := refinePrompts[messageID]
+	return r, ok
+}
+
+// pruneRefinable forgets the prompts older than refineTTL.
+func pruneRefinable() {
+	refinePromptsMu.Lock()
+	defer refinePromptsMu.Unlock()
+	t := now()
+	for id, r := range refinePrompts {
+		if t.Sub(r.at) >= refineTTL {
+			delete(refinePrompts, id)
+		}
+	}
+}
+
+// interactionAuthor is who sent an interaction, in a server or a DM.
+func interactionAuthor(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// respondPrivately answers an interaction with content only its sender sees.
+func respondPrivately(s session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		slog.Error("Error on answering interaction", "interaction_id", i.ID, "error", err)
+	}
+}
+
+// openRefine answers a click on a response's Refine button with a modal
+// holding its prompt to edit. Only the requester can refine it.
+func openRefine(s session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	locale := localeFor(i.Locale)
+	r, ok := refinableFor(i.Message.ID)
+	if !ok {
+		respondPrivately(s, i, tr(locale, "refine.expired"))
+		return
+	}
+	if r.authorID != interactionAuthor(i).ID {
+		respondPrivately(s, i, tr(locale, "refine.notYours"))
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: customID,
+			Title:    tr(locale, "refine.title"),
+			Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:  refinePromptInput,
+					Label:     tr(locale, "refine.label"),
+					Style:     discordgo.TextInputParagraph,
+					Value:     r.prompt,
+					Required:  true,
+					MaxLength: 4000,
+				},
+			}}},
+		},
+	})
+	if err != nil {
+		slog.Error("Error on opening refine modal", "interaction_id", i.ID, "error", err)
+	}
+}
+
+// submitRefine generates the prompt edited in a Refine modal, with the
+// options of the response it came from, as a new response of its own.
+func submitRefine(s session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	opts, ok := parseRefineID(data.CustomID)
+	if !ok {
+		return
+	}
+	for _, row := range data.Components {
+		row, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if input, ok := c.(*discordgo.TextInput); ok && input.CustomID == refinePromptInput {
+				opts.prompt = input.Value
+			}
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		slog.Error("Error on acknowledging interaction", "interaction_id", i.ID, "error", err)
+		return
+	}
+	answerCommand(s, i, opts)
+}
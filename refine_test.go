@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// useRefinable gives a test its own remembered prompts.
+func useRefinable(t *testing.T) {
+	refinePromptsMu.Lock()
+	old := refinePrompts
+	refinePrompts = make(map[string]refinable)
+	refinePromptsMu.Unlock()
+	t.Cleanup(func() {
+		refinePromptsMu.Lock()
+		refinePrompts = old
+		refinePromptsMu.Unlock()
+	})
+}
+
+// refineButtonOn returns the Refine button on a response, or nil.
+func refineButtonOn(m *sentMessage) *discordgo.Button {
+	for _, c := range m.Components {
+		row, ok := c.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if b, ok := c.(*discordgo.Button); ok {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func TestRefineID(t *testing.T) {
+	opts := commandOptions{size: "wide", quality: "hd", style: "natural", spoiler: true}
+
+	got, ok := parseRefineID(refineID(opts))
+	if !ok || got != opts {
+		t.Errorf("parseRefineID(refineID(%+v)) = %+v, %v", opts, got, ok)
+	}
+	for _, id := range []string{"vote:up", "refine:wide", ""} {
+		if _, ok := parseRefineID(id); ok {
+			t.Errorf("parseRefineID(%q) ok, want it refused", id)
+		}
+	}
+}
+
+func TestRefine(t *testing.T) {
+	useRefinable(t)
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "refine")
+	i := d.command("refine", "user", "A red fox")
+	onInteractionHandler(d, i)
+
+	response := d.response(i)
+	button := refineButtonOn(response)
+	if button == nil {
+		t.Fatalf("response %+v has no Refine button", response)
+	}
+
+	click := d.click(response.Message, "user", button.CustomID)
+	onInteractionHandler(d, click)
+	modal := d.modal(click)
+	if modal == nil {
+		t.Fatal("clicking Refine didn't open a modal")
+	}
+	input := modal.Components[0].(discordgo.ActionsRow).Components[0].(discordgo.TextInput)
+	if input.Value != "A red fox" {
+		t.Errorf("modal filled in with %q, want the prompt as it was typed", input.Value)
+	}
+
+	submit := d.submit(response.Message, "user", modal.CustomID, map[string]string{refinePromptInput: "a red fox in the snow"})
+	onInteractionHandler(d, submit)
+
+	if got, want := api.generated(), []string{"a red fox", "a red fox in the snow"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q", got, want)
+	}
+	refined := d.response(submit)
+	if refined == nil || len(refined.Files) != 1 || refineButtonOn(refined) == nil {
+		t.Errorf("refined response %+v, want a new image that can be refined again", refined)
+	}
+	if len(d.response(i).Files) != 1 {
+		t.Error("the first response lost its image, want it kept")
+	}
+}
+
+func TestRefineKeepsOptions(t *testing.T) {
+	useRefinable(t)
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "refineopts")
+	response := &discordgo.Message{ID: "earlier", ChannelID: "refineopts"}
+	rememberRefinable(response.ID, "user", "a red fox")
+
+	customID := refineID(commandOptions{size: "256", spoiler: true})
+	submit := d.submit(response, "user", customID, map[string]string{refinePromptInput: "a blue fox"})
+	onInteractionHandler(d, submit)
+
+	gens := api.generations()
+	if len(gens) != 1 || gens[0].Size != "256x256" {
+		t.Fatalf("generations %+v, want one at the size the response had", gens)
+	}
+	if names := d.response(submit).FileNames; len(names) != 1 || names[0] != "SPOILER_disc-e.png" {
+		t.Errorf("uploaded %q, want it kept behind a spoiler", names)
+	}
+}
+
+func TestRefineNotYours(t *testing.T) {
+	useRefinable(t)
+	d, _ := setupBot(t, Config{EnableSlashCommands: true}, "refineother")
+	i := d.command("refineother", "user", "a red fox")
+	onInteractionHandler(d, i)
+	response := d.response(i)
+
+	click := d.click(response.Message, "someone", refineButtonOn(response).CustomID)
+	onInteractionHandler(d, click)
+
+	if d.modal(click) != nil {
+		t.Error("opened a modal for someone else's image")
+	}
+	answer := d.response(click)
+	if answer == nil || answer.Content != tr("en", "refine.notYours") || answer.Flags != discordgo.MessageFlagsEphemeral {
+		t.Errorf("answered %+v, want a private refusal", answer)
+	}
+}
+
+func TestRefineExpired(t *testing.T) {
+	useRefinable(t)
+	advance := fakeClock(t)
+	d, _ := setupBot(t, Config{EnableSlashCommands: true}, "refineold")
+	i := d.command("refineold", "user", "a red fox")
+	onInteractionHandler(d, i)
+	response := d.response(i)
+
+	advance(refineTTL + time.Minute)
+	pruneRefinable()
+	click := d.click(response.Message, "user", refineButtonOn(response).CustomID)
+	onInteractionHandler(d, click)
+
+	if answer := d.response(click); answer == nil || answer.Content != tr("en", "refine.expired") {
+		t.Errorf("answered %+v, want the image too old to refine", answer)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// refusal is why a request won't be generated: the status to show on a
+// request message, if any, and the reason to give the requester. A quiet
+// refusal only shows its status when it has a message to show it on.
+type refusal struct {
+	status string
+	reason string
+	quiet  bool
+	// wait, when set, is how long until the user may ask again
+	wait time.Duration
+}
+
+// generationRefusal runs the checks every generation must pass, whether it
+// was asked for in a message or with /dalle, returning nil when it may go
+// ahead.
+func generationRefusal(ctx context.Context, imgReq *ImageRequest) *refusal {
+	if err := checkPromptLength(imgReq.Locale, imgReq.GuildID, imgReq.Model, imgReq.Prompt); err != nil {
+		return &refusal{reason: err.Error()}
+	}
+	if word := blockedWord(imgReq.Prompt); word != "" {
+		logger(ctx).Info("Prompt blocked", "word", word)
+		return &refusal{status: "🚫", reason: tr(imgReq.Locale, "refuse.blocked")}
+	}
+	// servers without a key of their own can't generate anything
+	if !hasCredentials(imgReq.GuildID) {
+		return &refusal{reason: tr(imgReq.Locale, "refuse.nokey")}
+	}
+	// outside the guild's active hours nothing is generated
+	if hours, closed := outsideActiveHours(imgReq.GuildID, imgReq.AuthorID); closed {
+		return &refusal{status: "🌙", reason: tr(imgReq.Locale, "refuse.hours", hours)}
+	}
+	if ok, wait := userLimiter.allow(imgReq.AuthorID, imgReq.count(), cfg().MaxRequestsPerMinute); !ok {
+		return &refusal{status: "⏳", reason: tr(imgReq.Locale, "refuse.tooFast", int(math.Ceil(wait.Seconds()))), wait: wait}
+	}
+	// back-to-back images in a channel are ignored during its cooldown
+	if !channelCooldown.allow(imgReq.ChannelID, time.Duration(cfg().ChannelCooldownSeconds)*time.Second) {
+		return &refusal{status: "⏳", reason: tr(imgReq.Locale, "refuse.cooldown"), quiet: true}
+	}
+	return nil
+}
+
+// promptReply answers the prompts that ask about the bot rather than for an
+// image: help, stats, cost and queue. ok is false for any other prompt.
+func promptReply(ctx context.Context, imgReq *ImageRequest) (reply string, ok bool) {
+	switch imgReq.Prompt {
+	case "help":
+		return helpText(imgReq.Locale), true
+	case "stats":
+		return statsReply(ctx, imgReq.AuthorID), true
+	case "cost":
+		return costReply(imgReq.Locale, imgReq.AuthorID), true
+	case "queue":
+		return queueReply(imgReq.Locale, imgReq.AuthorID), true
+	}
+	return "", false
+}
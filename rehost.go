@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// linkedImage returns the image URL an old reply linked to, from before
+// images were uploaded as attachments, or "" if m isn't such a reply.
+func linkedImage(m *discordgo.Message) string {
+	if len(m.Attachments) > 0 {
+		return ""
+	}
+	fields := strings.Fields(m.Content)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "https://") {
+		return ""
+	}
+	return fields[0]
+}
+
+// urlExpired reports whether imgURL no longer serves its image. OpenAI's
+// URLs answer 403 once their signature runs out.
+func urlExpired(ctx context.Context, imgURL string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", imgURL, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := downloads().Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound, nil
+}
+
+// embeddedCopy returns the URL of Discord's own copy of the image embedded in
+// m, which outlives the link it was embedded from.
+func embeddedCopy(m *discordgo.Message) string {
+	for _, embed := range m.Embeds {
+		if embed.Image != nil && embed.Image.ProxyURL != "" {
+			return embed.Image.ProxyURL
+		}
+		if embed.Thumbnail != nil && embed.Thumbnail.ProxyURL != "" {
+			return embed.Thumbnail.ProxyURL
+		}
+	}
+	return ""
+}
+
+// rehostExpiredImage edits an old reply whose image link has expired to
+// upload the image instead, so the result stays viewable. It does nothing for
+// replies that already have attachments.
+func rehostExpiredImage(ctx context.Context, s session, m *discordgo.Message) {
+	imgURL := linkedImage(m)
+	if imgURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout())
+	defer cancel()
+
+	expired, err := urlExpired(ctx, imgURL)
+	if err != nil {
+		logger(ctx).Error("Error on checking image link", "reply_id", m.ID, "error", err)
+		return
+	}
+	if !expired {
+		return
+	}
+
+	proxyURL := embeddedCopy(m)
+	if proxyURL == "" {
+		logger(ctx).Warn("Image link expired with no copy to re-host", "reply_id", m.ID)
+		return
+	}
+	img, err := downloadImage(ctx, proxyURL)
+	if err != nil {
+		logger(ctx).Error("Error on downloading embedded image", "reply_id", m.ID, "error", err)
+		return
+	}
+
+	content := strings.TrimSpace(strings.TrimPrefix(m.Content, imgURL))
+	_, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      m.ID,
+		Channel: m.ChannelID,
+		Content: &content,
+		Embeds:  []*discordgo.MessageEmbed{},
+		Files:   []*discordgo.File{img.discordFile()},
+	})
+	if err != nil {
+		logger(ctx).Error("Error on re-hosting image", "reply_id", m.ID, "error", err)
+		return
+	}
+	logger(ctx).Info("Re-hosted expired image", "reply_id", m.ID)
+}
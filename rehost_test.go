@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// linkedReply is an old bot reply linking imgURL, with Discord's copy of the
+// image embedded.
+func linkedReply(t *testing.T, d *fakeDiscord, channelID string, imgURL string) *discordgo.Message {
+	sent, err := d.send(channelID, imgURL+" a red fox", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := sent.Message
+	m.Embeds = []*discordgo.MessageEmbed{{Image: &discordgo.MessageEmbedImage{URL: imgURL, ProxyURL: serving(t, "image/png", testPNG()) + "/proxy.png"}}}
+	return m
+}
+
+// linkAnswering is an https image link whose every request gets status,
+// which the download client is made to trust for the rest of the test.
+func linkAnswering(t *testing.T, status int) string {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	oldClient := downloadClient
+	downloadClient = server.Client()
+	t.Cleanup(func() { downloadClient = oldClient })
+	return server.URL + "/img.png"
+}
+
+func TestRehostExpiredImage(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "old")
+	m := linkedReply(t, d, "old", linkAnswering(t, http.StatusForbidden))
+
+	rehostExpiredImage(context.Background(), d, m)
+
+	edits := d.editedMessages()
+	if len(edits) != 1 {
+		t.Fatalf("made %d edits, want the expired reply edited", len(edits))
+	}
+	edit := edits[0]
+	if edit.ID != m.ID || len(edit.Files) != 1 || edit.Files[0].ContentType != "image/png" {
+		t.Errorf("edited %s with %d files, want %s given the image", edit.ID, len(edit.Files), m.ID)
+	}
+	if edit.Content == nil || *edit.Content != "a red fox" || edit.Embeds == nil || len(edit.Embeds) != 0 {
+		t.Errorf("edit leaves content %v and embeds %v, want the link and its embed removed", edit.Content, edit.Embeds)
+	}
+}
+
+func TestRehostLiveImageLeftAlone(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "live")
+	m := linkedReply(t, d, "live", linkAnswering(t, http.StatusOK))
+
+	rehostExpiredImage(context.Background(), d, m)
+
+	if edits := d.editedMessages(); len(edits) != 0 {
+		t.Errorf("made %d edits, want a working link left alone", len(edits))
+	}
+}
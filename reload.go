@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reloadConfig handles the admin command "reload", loading the config again
+// and swapping it in if it's valid. The OpenAI client and generator are built
+// again, so the base URL, retries, timeout and rate limit change with it, and
+// MaxConcurrent and MediaConcurrency resize their slots. Settings only read
+// at startup, such as the Discord token, slash commands, logging, health
+// port, history database, maintenance and spend files, still need a restart.
+func reloadConfig(s session, m *discordgo.MessageCreate) {
+	if !isAdmin(m.Author.ID) {
+		s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.reload"), m.Reference())
+		return
+	}
+
+	var config Config
+	err := loadConfig(&config)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		slog.Error("Error on reloading config", "message_id", m.ID, "author_id", m.Author.ID, "error", err)
+		sendReplyChunked(s, m.ChannelID, tr(locale(), "admin.reloadFailed", err), nil, m.Reference())
+		return
+	}
+
+	old := currentConfig.Swap(&config)
+	if err := setupOpenAI(); err != nil {
+		currentConfig.Store(old)
+		slog.Error("Error on reloading config", "message_id", m.ID, "author_id", m.Author.ID, "error", err)
+		sendReplyChunked(s, m.ChannelID, tr(locale(), "admin.reloadFailed", err), nil, m.Reference())
+		return
+	}
+	generationSlots.resize(cfg().MaxConcurrent)
+	mediaSlots.resize(cfg().MediaConcurrency)
+	slog.Info("Admin reloaded the config", "message_id", m.ID, "author_id", m.Author.ID)
+	s.ChannelMessageSendReply(m.ChannelID, tr(locale(), "admin.reloaded"), m.Reference())
+}
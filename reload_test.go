@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadRebuildsClients(t *testing.T) {
+	d, _ := setupBot(t, Config{Admins: []string{"admin"}, RequestTimeoutSeconds: 5, MaxConcurrent: 1}, "reload")
+	oldSlots := generationSlots.size()
+	t.Cleanup(func() { generationSlots.resize(oldSlots) })
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"discordToken": "token", "openAIKey": "sk-test", "admins": ["admin"], "requestTimeoutSeconds": 30, "maxConcurrent": 3, "baseURL": "https://proxy.example.com/v1"}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = oldPath })
+
+	reloadConfig(d, d.post("reload", "admin", "/dalle reload"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || !strings.HasPrefix(sent[0].Content, "Config reloaded.") {
+		t.Fatalf("sent %+v, want the reload confirmed", sent)
+	}
+	if got := httpClient().Timeout.Seconds(); got != 30 {
+		t.Errorf("client timeout is %vs after reload, want 30s", got)
+	}
+	if got := generationSlots.size(); got != 3 {
+		t.Errorf("%d generation slots after reload, want 3", got)
+	}
+	if got := apiBaseURL(); got != "https://proxy.example.com/v1" {
+		t.Errorf("base URL is %q after reload, want the proxy", got)
+	}
+}
+
+func TestReloadNeedsAdmin(t *testing.T) {
+	d, _ := setupBot(t, Config{Admins: []string{"admin"}, SpecialUser: "friend", SpecialReply: "hi"}, "reload")
+	before := cfg()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"discordToken": "token", "specialUser": "friend", "specialReply": "bye"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = oldPath })
+
+	onMessageHandler(d, d.post("reload", "user", "/dalle reload"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].Content != "Only bot admins can reload the config." {
+		t.Fatalf("sent %+v, want the reload refused", sent)
+	}
+	if cfg() != before {
+		t.Errorf("config is %+v, want a non-admin's reload to leave it alone", cfg())
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// replayDir is the session recording being replayed with -replay, if any.
+var replayDir string
+
+// maxReplayGap is the longest a replay waits between two events, keeping
+// the timing of ones close together without waiting out long quiet spells.
+const maxReplayGap = 10 * time.Second
+
+// replaySession stands in for Discord while a recording is replayed,
+// writing what the bot does to out instead of doing it.
+type replaySession struct {
+	out io.Writer
+	id  string
+
+	mu     sync.Mutex
+	nextID int
+	// guilds are the guild of each channel seen in the recording
+	guilds map[string]string
+	// members are the members seen in the recording, by guild and user ID
+	members map[string]*discordgo.Member
+	// messages are those seen in the recording and those the bot sent
+	messages map[string]*discordgo.Message
+	// responses are the bot's responses to interactions, by token
+	responses map[string]*discordgo.Message
+}
+
+func newReplaySession(out io.Writer, botID string) *replaySession {
+	return &replaySession{
+		out:       out,
+		id:        botID,
+		guilds:    make(map[string]string),
+		members:   make(map[string]*discordgo.Member),
+		messages:  make(map[string]*discordgo.Message),
+		responses: make(map[string]*discordgo.Message),
+	}
+}
+
+// say writes one thing the bot did.
+func (s *replaySession) say(format string, a ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, format+"\n", a...)
+}
+
+// message makes up a message the bot sent to channelID.
+func (s *replaySession) message(channelID string, content string) *discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	m := &discordgo.Message{
+		ID:        "replay-" + strconv.Itoa(s.nextID),
+		ChannelID: channelID,
+		GuildID:   s.guilds[channelID],
+		Content:   content,
+		Author:    &discordgo.User{ID: s.id, Bot: true},
+		Timestamp: now(),
+	}
+	s.messages[m.ID] = m
+	return m
+}
+
+// seen notes the channel, member and message of an event about to be
+// handled, to answer the bot's questions about them.
+func (s *replaySession) seen(channelID string, guildID string, member *discordgo.Member, userID string, message *discordgo.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guilds[channelID] = guildID
+	if member != nil {
+		if member.User == nil {
+			member.User = &discordgo.User{ID: userID}
+		}
+		s.members[guildID+"/"+userID] = member
+	}
+	if message != nil {
+		s.messages[message.ID] = message
+	}
+}
+
+func (s *replaySession) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	return commands, nil
+}
+
+func (s *replaySession) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return s.cachedChannel(channelID)
+}
+
+func (s *replaySession) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.messages[messageID]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("message %s wasn't in the recording", messageID)
+}
+
+func (s *replaySession) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	s.say("delete %s in %s", messageID, channelID)
+	return nil
+}
+
+func (s *replaySession) ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	content := ""
+	if m.Content != nil {
+		content = *m.Content
+	}
+	s.say("edit %s in %s: %s", m.ID, m.Channel, content)
+	return &discordgo.Message{ID: m.ID, ChannelID: m.Channel, Content: content}, nil
+}
+
+func (s *replaySession) ChannelMessagePin(channelID, messageID string, options ...discordgo.RequestOption) error {
+	s.say("pin %s in %s", messageID, channelID)
+	return nil
+}
+
+func (s *replaySession) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (s *replaySession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	m := s.message(channelID, data.Content)
+	s.say("send %s to %s: %s", m.ID, channelID, data.Content)
+	return m, nil
+}
+
+func (s *replaySession) ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content, Reference: reference})
+}
+
+func (s *replaySession) ChannelMessageUnpin(channelID, messageID string, options ...discordgo.RequestOption) error {
+	s.say("unpin %s in %s", messageID, channelID)
+	return nil
+}
+
+func (s *replaySession) ChannelMessagesPinned(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	return nil, nil
+}
+
+func (s *replaySession) ChannelTyping(channelID string, options ...discordgo.RequestOption) error {
+	return nil
+}
+
+func (s *replaySession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	m := s.message(interaction.ChannelID, data.Content)
+	s.say("follow up %s on interaction %s: %s", m.ID, interaction.ID, data.Content)
+	return m, nil
+}
+
+func (s *replaySession) GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	return s.cachedMember(guildID, userID)
+}
+
+func (s *replaySession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	content := ""
+	if resp.Data != nil {
+		content = resp.Data.Content
+	}
+	m := s.message(interaction.ChannelID, content)
+	s.mu.Lock()
+	s.responses[interaction.Token] = m
+	s.mu.Unlock()
+	s.say("respond to interaction %s with type %d: %s", interaction.ID, resp.Type, content)
+	return nil
+}
+
+func (s *replaySession) InteractionResponse(interaction *discordgo.Interaction, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.responses[interaction.Token]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("no response to interaction %s", interaction.ID)
+}
+
+func (s *replaySession) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	s.say("delete the response to interaction %s", interaction.ID)
+	return nil
+}
+
+func (s *replaySession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	m, err := s.InteractionResponse(interaction)
+	if err != nil {
+		return nil, err
+	}
+	if newresp.Content != nil {
+		s.mu.Lock()
+		m.Content = *newresp.Content
+		s.mu.Unlock()
+		s.say("edit the response to interaction %s: %s", interaction.ID, *newresp.Content)
+	}
+	return m, nil
+}
+
+func (s *replaySession) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	s.say("react %s on %s in %s", emojiID, messageID, channelID)
+	return nil
+}
+
+func (s *replaySession) MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error {
+	s.say("remove %s's %s from %s in %s", userID, emojiID, messageID, channelID)
+	return nil
+}
+
+func (s *replaySession) MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string, options ...discordgo.RequestOption) ([]*discordgo.User, error) {
+	return nil, nil
+}
+
+func (s *replaySession) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	m := s.message(channelID, "")
+	s.say("start thread %s on %s in %s: %s", m.ID, messageID, channelID, name)
+	s.seen(m.ID, s.guildOf(channelID), nil, "", nil)
+	return &discordgo.Channel{ID: m.ID, GuildID: s.guildOf(channelID), Type: discordgo.ChannelTypeGuildPublicThread, Name: name}, nil
+}
+
+func (s *replaySession) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (s *replaySession) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	return discordgo.PermissionAll, nil
+}
+
+func (s *replaySession) WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.WebhookThreadExecute(webhookID, token, wait, "", data)
+}
+
+func (s *replaySession) WebhookThreadExecute(webhookID, token string, wait bool, threadID string, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	m := s.message(threadID, data.Content)
+	s.say("post %s through webhook %s: %s", m.ID, webhookID, data.Content)
+	return m, nil
+}
+
+func (s *replaySession) WebhookWithToken(webhookID, token string, options ...discordgo.RequestOption) (*discordgo.Webhook, error) {
+	return &discordgo.Webhook{ID: webhookID, Token: token}, nil
+}
+
+func (s *replaySession) botID() string {
+	return s.id
+}
+
+func (s *replaySession) guildOf(channelID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.guilds[channelID]
+}
+
+func (s *replaySession) cachedChannel(channelID string) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guildID, ok := s.guilds[channelID]
+	if !ok {
+		return nil, fmt.Errorf("channel %s wasn't in the recording", channelID)
+	}
+	if guildID == "" {
+		return &discordgo.Channel{ID: channelID, Type: discordgo.ChannelTypeDM}, nil
+	}
+	return &discordgo.Channel{ID: channelID, GuildID: guildID, Type: discordgo.ChannelTypeGuildText}, nil
+}
+
+func (s *replaySession) cachedMember(guildID string, userID string) (*discordgo.Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.members[guildID+"/"+userID]; ok {
+		return m, nil
+	}
+	return &discordgo.Member{GuildID: guildID, User: &discordgo.User{ID: userID}}, nil
+}
+
+func (s *replaySession) requestMultipart(method string, endpoint string, contentType string, body []byte) ([]byte, error) {
+	var payload struct {
+		Content string `json:"content"`
+	}
+	files, err := readMultipart(contentType, body, &payload)
+	if err != nil {
+		return nil, err
+	}
+	m := s.message("", payload.Content)
+	s.say("upload %s with %d files to %s %s: %s", m.ID, len(files), method, endpoint, payload.Content)
+	return json.Marshal(m)
+}
+
+// readMultipart decodes a discordgo multipart body's payload_json into
+// payload and returns the files after it.
+func readMultipart(contentType string, body []byte, payload interface{}) ([][]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var files [][]byte
+	for {
+		part, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "payload_json" {
+			if err := json.Unmarshal(b, payload); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		files = append(files, b)
+	}
+}
+
+// replayEvents feeds the events recorded in dir through the handlers, as
+// when they first came in, against a replaySession writing what the bot
+// does to out.
+func replayEvents(dir string, out io.Writer) error {
+	f, err := os.Open(filepath.Join(dir, recordedEventsFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var s *replaySession
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	// messages with long prompts and their embeds make long lines
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var event recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s line %d: %w", recordedEventsFile, line, err)
+		}
+		if s == nil {
+			s = newReplaySession(out, event.BotID)
+		} else if gap := event.At.Sub(last); gap > 0 {
+			time.Sleep(min(gap, maxReplayGap))
+		}
+		last = event.At
+
+		s.say("%s (%s)", event.Type, event.At.Format(time.RFC3339))
+		if err := replayEvent(s, event); err != nil {
+			return fmt.Errorf("%s line %d: %w", recordedEventsFile, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// replayEvent handles one recorded event.
+func replayEvent(s *replaySession, event recordedEvent) error {
+	switch event.Type {
+	case eventMessageCreate:
+		var m discordgo.MessageCreate
+		if err := json.Unmarshal(event.Event, &m); err != nil {
+			return err
+		}
+		s.seen(m.ChannelID, m.GuildID, m.Member, m.Author.ID, m.Message)
+		onMessageHandler(s, &m)
+	case eventReactionAdd:
+		var r discordgo.MessageReactionAdd
+		if err := json.Unmarshal(event.Event, &r); err != nil {
+			return err
+		}
+		s.seen(r.ChannelID, r.GuildID, r.Member, r.UserID, nil)
+		onEmojiAddHandler(s, &r)
+	case eventReactionRemove:
+		var r discordgo.MessageReactionRemove
+		if err := json.Unmarshal(event.Event, &r); err != nil {
+			return err
+		}
+		s.seen(r.ChannelID, r.GuildID, nil, r.UserID, nil)
+		onEmojiRemoveHandler(s, &r)
+	case eventInteractionCreate:
+		var i discordgo.InteractionCreate
+		if err := json.Unmarshal(event.Event, &i); err != nil {
+			return err
+		}
+		s.seen(i.ChannelID, i.GuildID, i.Member, interactionAuthor(&i).ID, i.Message)
+		onInteractionHandler(s, &i)
+	default:
+		return fmt.Errorf("unknown event type %q", event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useReplay replays the session recorded in dir for the rest of the test,
+// with OpenAI at baseURL as when it was recorded.
+func useReplay(t *testing.T, dir string, baseURL string) {
+	old := replayDir
+	replayDir = dir
+	t.Cleanup(func() { replayDir = old })
+	useConfig(t, Config{BaseURL: baseURL, HTTPReplayDir: filepath.Join(dir, recordedHTTPDir)})
+}
+
+func TestRecordAndReplaySession(t *testing.T) {
+	dir := t.TempDir()
+	d, api := setupBot(t, Config{RecordDir: dir}, "recorded")
+	handle := recorded(eventMessageCreate, onMessageHandler)
+	handle(d, d.post("recorded", "user", "/dalle a recorded fox"))
+	if sent := d.sentMessages(); len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %+v while recording, want the image", sent)
+	}
+
+	events, err := os.ReadFile(filepath.Join(dir, recordedEventsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(events)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "a recorded fox") {
+		t.Errorf("recorded %q, want the one message", lines)
+	}
+
+	useReplay(t, dir, api.URL)
+	var out bytes.Buffer
+	if err := replayEvents(dir, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := api.generated(); len(got) != 1 {
+		t.Errorf("generated %q, want the replay answered from the recording", got)
+	}
+	for _, want := range []string{"messageCreate", "upload replay-1 with 1 files", "react ✅"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("replay printed %q, want %q in it", out.String(), want)
+		}
+	}
+}
+
+func TestReplayUnknownEvent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, recordedEventsFile), []byte(`{"type":"guildCreate","event":{}}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := replayEvents(dir, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("replayEvents() = %v, want the bad line named", err)
+	}
+}
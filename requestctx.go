@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	messageIDKey
+	userIDKey
+	guildIDKey
+	channelIDKey
+	localeKey
+	usedKeyKey
+)
+
+// newRequestContext returns a context carrying the metadata of imgReq, to be
+// passed down through everything that handles the request.
+func newRequestContext(parent context.Context, imgReq *ImageRequest) context.Context {
+	ctx := context.WithValue(parent, requestIDKey, imgReq.RequestID)
+	ctx = context.WithValue(ctx, messageIDKey, imgReq.ID)
+	ctx = context.WithValue(ctx, userIDKey, imgReq.AuthorID)
+	ctx = context.WithValue(ctx, channelIDKey, imgReq.ChannelID)
+	ctx = context.WithValue(ctx, localeKey, imgReq.Locale)
+	return context.WithValue(ctx, guildIDKey, imgReq.GuildID)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func messageIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(messageIDKey).(string)
+	return id
+}
+
+func userIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey).(string)
+	return id
+}
+
+func guildIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(guildIDKey).(string)
+	return id
+}
+
+func channelIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(channelIDKey).(string)
+	return id
+}
+
+// localeFrom returns the locale to answer the request carried by ctx in.
+func localeFrom(ctx context.Context) string {
+	if l, _ := ctx.Value(localeKey).(string); l != "" {
+		return l
+	}
+	return locale()
+}
+
+// logger returns the default logger with the request fields carried by ctx.
+func logger(ctx context.Context) *slog.Logger {
+	return slog.Default().With("request_id", requestIDFrom(ctx), "message_id", messageIDFrom(ctx), "author_id", userIDFrom(ctx), "channel_id", channelIDFrom(ctx))
+}
+
+// logf logs an info message with the request fields carried by ctx.
+func logf(ctx context.Context, format string, a ...interface{}) {
+	logger(ctx).Info(fmt.Sprintf(format, a...))
+}
+
+// withRequestID adds the request ID carried by ctx to an error reply, so the
+// requester can quote it when reporting the problem.
+func withRequestID(ctx context.Context, reply string) string {
+	return tr(localeFrom(ctx), "error.request", reply, requestIDFrom(ctx))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// imageModerationModel is the moderation model that takes images.
+	imageModerationModel = "omni-moderation-latest"
+	// defaultSensitiveThreshold is the moderation score a result is
+	// blurred from when SensitiveThreshold isn't set.
+	defaultSensitiveThreshold = 0.5
+	// blurSide is the longest side an image is shrunk to before blurring,
+	// which makes the blur strong and cheap at once.
+	blurSide = 64
+	// blurSigma is the Gaussian's standard deviation at blurSide.
+	blurSigma = 3.0
+)
+
+func sensitiveThreshold() float64 {
+	if cfg().SensitiveThreshold > 0 {
+		return cfg().SensitiveThreshold
+	}
+	return defaultSensitiveThreshold
+}
+
+// validateSensitiveThreshold checks the score results are blurred from.
+func validateSensitiveThreshold(c *Config) error {
+	if t := c.SensitiveThreshold; t < 0 || t > 1 {
+		return fmt.Errorf("sensitiveThreshold %g must be between 0 and 1, with 0 meaning %g", t, defaultSensitiveThreshold)
+	}
+	return nil
+}
+
+// sensitiveScore is the highest moderation score img gets in any category.
+func sensitiveScore(ctx context.Context, img *imageFile) (float64, error) {
+	dataURL := "data:" + img.ContentType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+	result, err := moderate(ctx, map[string]interface{}{
+		"model": imageModerationModel,
+		"input": []map[string]interface{}{{"type": "image_url", "image_url": map[string]string{"url": dataURL}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	score := 0.0
+	for _, s := range result.CategoryScores {
+		score = max(score, s)
+	}
+	return score, nil
+}
+
+// blurred returns img with a heavy Gaussian blur, as a PNG under the same
+// name. It's shrunk to blurSide, blurred there and scaled back up.
+func blurred(img *imageFile) (*imageFile, error) {
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w >= h {
+		w, h = blurSide, max(1, h*blurSide/w)
+	} else {
+		w, h = max(1, w*blurSide/h), blurSide
+	}
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Bounds(), src, bounds, draw.Src, nil)
+	gaussianBlur(small, blurSigma)
+
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.BiLinear.Scale(out, out.Bounds(), small, small.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(img.Name, "."+imageExtensions[img.ContentType]) + ".png"
+	return &imageFile{Name: name, ContentType: "image/png", Data: buf.Bytes(), Description: img.Description}, nil
+}
+
+// gaussianBlur blurs img in place with a Gaussian of sigma pixels, across
+// then down, clamping at the edges.
+func gaussianBlur(img *image.RGBA, sigma float64) {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	total := 0.0
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		total += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= total
+	}
+
+	b := img.Bounds()
+	pass := func(dx, dy int) {
+		src := append([]uint8(nil), img.Pix...)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var sum [4]float64
+				for i, k := range kernel {
+					sx := min(max(x+(i-radius)*dx, b.Min.X), b.Max.X-1)
+					sy := min(max(y+(i-radius)*dy, b.Min.Y), b.Max.Y-1)
+					offset := img.PixOffset(sx, sy)
+					for c := range sum {
+						sum[c] += k * float64(src[offset+c])
+					}
+				}
+				offset := img.PixOffset(x, y)
+				for c := range sum {
+					img.Pix[offset+c] = uint8(math.Round(sum[c]))
+				}
+			}
+		}
+	}
+	pass(1, 0)
+	pass(0, 1)
+}
+
+// blurSensitive returns imgs with the ones moderation scores at
+// SensitiveThreshold or over blurred and marked as spoilers, and how many
+// that was. An image that can't be scored or blurred is sent as it is. imgs
+// themselves are left alone since they may be cached.
+func blurSensitive(ctx context.Context, imgs []*imageFile) ([]*imageFile, int) {
+	if !cfg().BlurSensitiveResults {
+		return imgs, 0
+	}
+	out := make([]*imageFile, 0, len(imgs))
+	count := 0
+	for _, img := range imgs {
+		score, err := sensitiveScore(ctx, img)
+		if err != nil {
+			logger(ctx).Warn("Error scoring image, sending it as it is", "image", img.Name, "error", err)
+			out = append(out, img)
+			continue
+		}
+		if score < sensitiveThreshold() {
+			out = append(out, img)
+			continue
+		}
+		hidden, err := blurred(img)
+		if err != nil {
+			logger(ctx).Warn("Error blurring image, sending it as it is", "image", img.Name, "error", err)
+			out = append(out, img)
+			continue
+		}
+		logger(ctx).Info("Blurred a sensitive image", "image", img.Name, "score", score)
+		hidden.Name = spoilerName(hidden.Name)
+		out = append(out, hidden)
+		count++
+	}
+	return out, count
+}
+
+// sensitiveNote warns that some of the images were blurred, when count of
+// them were.
+func sensitiveNote(locale string, count int) string {
+	if count == 0 {
+		return ""
+	}
+	return "\n*" + tr(locale, "reply.sensitive") + "*"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGaussianBlur(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 21, 21))
+	img.Set(10, 10, color.RGBA{255, 255, 255, 255})
+
+	gaussianBlur(img, 2)
+
+	centre, near, far := img.RGBAAt(10, 10), img.RGBAAt(12, 10), img.RGBAAt(0, 0)
+	if centre.R == 255 || centre.R == 0 {
+		t.Errorf("centre is %v after blurring, want the bright pixel spread out", centre)
+	}
+	if near.R == 0 || near.R >= centre.R {
+		t.Errorf("two pixels over is %v, want dimmer than the centre %v but lit", near, centre)
+	}
+	if far.R != 0 {
+		t.Errorf("corner is %v, want it out of reach", far)
+	}
+	total := 0
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 21; x++ {
+			total += int(img.RGBAAt(x, y).R)
+		}
+	}
+	if total < 200 || total > 270 {
+		t.Errorf("brightness adds up to %d, want about the 255 there was, less what rounds away", total)
+	}
+}
+
+func TestBlurred(t *testing.T) {
+	// a checkerboard, so blurring shows
+	src := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if (x+y)%2 == 0 {
+				src.Set(x, y, color.RGBA{255, 255, 255, 255})
+			} else {
+				src.Set(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	got, err := blurred(&imageFile{Name: "0.png", ContentType: "image/png", Data: buf.Bytes(), Description: "a board"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "0.png" || got.ContentType != "image/png" || got.Description != "a board" {
+		t.Errorf("blurred to %s as %s described %q, want the name, type and alt text kept", got.Name, got.ContentType, got.Description)
+	}
+	img, err := png.Decode(bytes.NewReader(got.Data))
+	if err != nil {
+		t.Fatalf("blurred image isn't a PNG: %v", err)
+	}
+	if img.Bounds() != src.Bounds() {
+		t.Errorf("blurred image is %v, want %v", img.Bounds(), src.Bounds())
+	}
+	r, _, _, _ := img.At(16, 8).RGBA()
+	if grey := r >> 8; grey < 64 || grey > 192 {
+		t.Errorf("middle of the board is %d after blurring, want about grey", grey)
+	}
+
+	if _, err := blurred(&imageFile{Name: "0.png", ContentType: "image/png", Data: []byte("not an image")}); err == nil {
+		t.Error("blurred an image that doesn't decode, want an error")
+	}
+}
+
+func TestSensitiveResultBlurred(t *testing.T) {
+	d, api := setupBot(t, Config{BlurSensitiveResults: true}, "sensitive")
+	api.scoreImages(0.9, false)
+
+	onMessageHandler(d, d.post("sensitive", "user", "/dalle a red fox"))
+
+	scored := api.scoredImages()
+	if len(scored) != 1 || !strings.HasPrefix(scored[0], "data:image/png;base64,") {
+		t.Fatalf("scored %d images, want the result sent as a data URL", len(scored))
+	}
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].Files) != 1 {
+		t.Fatalf("sent %d messages, want the one result", len(sent))
+	}
+	if name := sent[0].FileNames[0]; !strings.HasPrefix(name, spoilerPrefix) {
+		t.Errorf("attached %s, want it hidden as a spoiler", name)
+	}
+	if reflect.DeepEqual(sent[0].Files[0], testPNG()) {
+		t.Error("attached the result as it was, want it blurred")
+	}
+	if want := tr("en", "reply.sensitive"); !strings.Contains(sent[0].Content, want) {
+		t.Errorf("replied %q, want it to say %q", sent[0].Content, want)
+	}
+}
+
+func TestResultNotBlurred(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config Config
+		score  float64
+		fail   bool
+		scored int
+	}{
+		{"under the threshold", Config{BlurSensitiveResults: true}, 0.3, false, 1},
+		{"under a raised threshold", Config{BlurSensitiveResults: true, SensitiveThreshold: 0.95}, 0.9, false, 1},
+		{"scoring failed", Config{BlurSensitiveResults: true}, 0, true, 1},
+		{"off", Config{}, 0.9, false, 0},
+	} {
+		d, api := setupBot(t, c.config, "insensitive")
+		api.scoreImages(c.score, c.fail)
+
+		onMessageHandler(d, d.post("insensitive", "user", "/dalle a red fox"))
+
+		if got := len(api.scoredImages()); got != c.scored {
+			t.Errorf("%s: scored %d images, want %d", c.name, got, c.scored)
+		}
+		sent := d.sentMessages()
+		if len(sent) != 1 || len(sent[0].Files) != 1 {
+			t.Fatalf("%s: sent %d messages, want the one result", c.name, len(sent))
+		}
+		if name := sent[0].FileNames[0]; strings.HasPrefix(name, spoilerPrefix) {
+			t.Errorf("%s: attached %s, want it shown as it is", c.name, name)
+		}
+		if strings.Contains(sent[0].Content, tr("en", "reply.sensitive")) {
+			t.Errorf("%s: replied %q, want no warning", c.name, sent[0].Content)
+		}
+	}
+}
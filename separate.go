@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// singleImageTTL is how long a reply sent on its own is remembered, after
+// which a 🔁 on it re-rolls the whole request again.
+const singleImageTTL = 24 * time.Hour
+
+// singleImages are the results sent one image to a reply with
+// SeparateMessagesPerImage, by message ID, with when they were sent.
+var (
+	singleImagesMu sync.Mutex
+	singleImages   = make(map[string]time.Time)
+)
+
+// isSingleImage reports whether messageID is one image of a request sent
+// in a reply of its own.
+func isSingleImage(messageID string) bool {
+	singleImagesMu.Lock()
+	defer singleImagesMu.Unlock()
+	_, ok := singleImages[messageID]
+	return ok
+}
+
+func rememberSingleImage(messageID string) {
+	singleImagesMu.Lock()
+	defer singleImagesMu.Unlock()
+	singleImages[messageID] = now()
+}
+
+// resultImageCount is how many generated images reply holds, leaving out
+// thumbnails, or 0 when they're only linked and it can't tell.
+func resultImageCount(reply *discordgo.Message) int {
+	if len(reply.Attachments) == 0 {
+		count := 0
+		for _, e := range reply.Embeds {
+			if e.Image != nil {
+				count++
+			}
+		}
+		return count
+	}
+	count := 0
+	for _, a := range reply.Attachments {
+		if !strings.HasPrefix(strings.TrimPrefix(a.Filename, spoilerPrefix), "thumb-") {
+			count++
+		}
+	}
+	return count
+}
+
+// pruneSingleImages forgets the replies older than singleImageTTL.
+func pruneSingleImages() {
+	singleImagesMu.Lock()
+	defer singleImagesMu.Unlock()
+	t := now()
+	for id, sent := range singleImages {
+		if t.Sub(sent) >= singleImageTTL {
+			delete(singleImages, id)
+		}
+	}
+}
+
+// sendResults sends a request's images to channelID, together in one reply
+// or, with SeparateMessagesPerImage and more than one image, each in its
+// own with the caption on the first. A failure after the first reply still
+// returns the ones that were sent.
+func sendResults(ctx context.Context, s session, imgReq *ImageRequest, channelID string, content string, imgs []*imageFile, reference *discordgo.MessageReference) ([]*discordgo.Message, error) {
+	if !cfg().SeparateMessagesPerImage || len(imgs) < 2 {
+		reply, err := sendResult(ctx, s, imgReq, channelID, content, imgs, reference)
+		if err != nil {
+			return nil, err
+		}
+		return []*discordgo.Message{reply}, nil
+	}
+
+	replies := make([]*discordgo.Message, 0, len(imgs))
+	for i, img := range imgs {
+		caption := ""
+		if i == 0 {
+			caption = content
+		}
+		reply, err := sendResult(ctx, s, imgReq, channelID, caption, []*imageFile{img}, reference)
+		if err != nil {
+			return replies, err
+		}
+		rememberSingleImage(reply.ID)
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
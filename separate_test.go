@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// useSingleImages forgets the images sent apart for the rest of the test.
+func useSingleImages(t *testing.T) {
+	singleImagesMu.Lock()
+	old := singleImages
+	singleImages = make(map[string]time.Time)
+	singleImagesMu.Unlock()
+	t.Cleanup(func() {
+		singleImagesMu.Lock()
+		singleImages = old
+		singleImagesMu.Unlock()
+	})
+}
+
+func TestSeparateMessagesPerImage(t *testing.T) {
+	useSingleImages(t)
+	d, api := setupBot(t, Config{MaxImages: 4, SeparateMessagesPerImage: true, ShowRevisedPrompt: true}, "apart")
+	api.revise("A sunset over the sea.")
+	m := d.post("apart", "user", "/dalle 3 a sunset")
+
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 3 {
+		t.Fatalf("sent %d messages, want one for each of the 3 images", len(sent))
+	}
+	for i, reply := range sent {
+		if len(reply.Files) != 1 || reply.MessageReference == nil || reply.MessageReference.MessageID != m.ID {
+			t.Errorf("reply %d has %d images replying to %+v, want one image replying to the request", i, len(reply.Files), reply.MessageReference)
+		}
+		if got := d.reactionsOn(reply.ID); !reflect.DeepEqual(got, []string{"🔁"}) {
+			t.Errorf("reply %d shows %q, want its own 🔁", i, got)
+		}
+		if hasCaption := reply.Content != ""; hasCaption != (i == 0) {
+			t.Errorf("reply %d says %q, want the caption only on the first", i, reply.Content)
+		}
+	}
+
+	// each one re-rolls on its own, and so does what it's re-rolled into
+	onEmojiAddHandler(d, d.react("apart", sent[1].ID, "user", "🔁"))
+	sent = d.sentMessages()
+	if len(sent) != 4 || len(sent[3].Files) != 1 {
+		t.Fatalf("sent %d messages, want one more with the re-rolled image", len(sent))
+	}
+	onEmojiAddHandler(d, d.react("apart", sent[3].ID, "user", "🔁"))
+
+	var counts []int
+	for _, g := range api.generations() {
+		counts = append(counts, g.N)
+	}
+	if !reflect.DeepEqual(counts, []int{3, 1, 1}) {
+		t.Errorf("asked for %v images, want 3 then 1 for each re-roll", counts)
+	}
+}
+
+func TestPruneSingleImages(t *testing.T) {
+	useSingleImages(t)
+	advance := fakeClock(t)
+	rememberSingleImage("old")
+	advance(singleImageTTL / 2)
+	rememberSingleImage("new")
+	advance(singleImageTTL / 2)
+
+	pruneSingleImages()
+
+	if isSingleImage("old") || !isSingleImage("new") {
+		t.Errorf("old kept %v, new kept %v, want only the one sent within %v", isSingleImage("old"), isSingleImage("new"), singleImageTTL)
+	}
+}
+
+// withAttachments gives result attachments named names, as Discord would
+// show them once uploaded.
+func withAttachments(d *fakeDiscord, result *sentMessage, names ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result.Attachments = nil
+	for _, name := range names {
+		result.Attachments = append(result.Attachments, &discordgo.MessageAttachment{Filename: name, URL: "https://cdn.example/" + name})
+	}
+}
+
+func TestResultImageCount(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		reply *discordgo.Message
+		want  int
+	}{
+		{"linked", &discordgo.Message{Content: "https://cdn.example/a.png"}, 0},
+		{"embedded", &discordgo.Message{Embeds: []*discordgo.MessageEmbed{{Image: &discordgo.MessageEmbedImage{URL: "https://cdn.example/a.png"}}}}, 1},
+		{"attached", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{{Filename: "a.png"}, {Filename: "b.png"}}}, 2},
+		{"with thumbnails", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{
+			{Filename: "a.png"}, {Filename: "b.png"}, {Filename: "thumb-a.jpg"}, {Filename: "thumb-b.jpg"},
+		}}, 2},
+		{"spoilered", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{{Filename: "SPOILER_a.png"}, {Filename: "SPOILER_thumb-a.jpg"}}}, 1},
+	} {
+		if got := resultImageCount(c.reply); got != c.want {
+			t.Errorf("%s: resultImageCount = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRerollMatchesReplyImages(t *testing.T) {
+	useSingleImages(t)
+	d, api := setupBot(t, Config{MaxImages: 4}, "rerollset")
+	onMessageHandler(d, d.post("rerollset", "user", "/dalle 4 a sunset"))
+	result := d.sentMessages()[0]
+
+	// a reply that lost an image, or was sent apart before a restart
+	withAttachments(d, result, "a.png", "b.png", "c.png", "thumb-a.jpg", "thumb-b.jpg", "thumb-c.jpg")
+	onEmojiAddHandler(d, d.react("rerollset", result.ID, "user", "🔁"))
+	withAttachments(d, result, "a.png")
+	onEmojiAddHandler(d, d.react("rerollset", result.ID, "someone", "🔁"))
+
+	var counts []int
+	for _, g := range api.generations() {
+		if g.Prompt != "a sunset" {
+			t.Errorf("re-rolled %q, want the request's prompt", g.Prompt)
+		}
+		counts = append(counts, g.N)
+	}
+	if !reflect.DeepEqual(counts, []int{4, 3, 1}) {
+		t.Errorf("asked for %v images, want as many as the reply held each time", counts)
+	}
+	sent := d.sentMessages()
+	if last := sent[len(sent)-1]; !isSingleImage(last.ID) {
+		t.Errorf("reply %s to the lone image isn't kept as one image, want it to go on re-rolling alone", last.ID)
+	}
+}
+
+func TestKeepCompositionOfSeveralRefused(t *testing.T) {
+	d, api := setupBot(t, Config{MaxImages: 4}, "severalkept")
+	onMessageHandler(d, d.post("severalkept", "user", "/dalle 2 a sunset"))
+	result := d.sentMessages()[0]
+	withAttachments(d, result, "a.png", "b.png")
+
+	onEmojiAddHandler(d, d.react("severalkept", result.ID, "user", compositionEmoji))
+
+	if forms := api.varied(); len(forms) != 0 {
+		t.Errorf("sent %+v, want nothing redrawn", forms)
+	}
+	sent := d.sentMessages()
+	if len(sent) != 2 || sent[1].Content != tr("en", "reroll.severalImages", compositionEmoji) {
+		t.Errorf("sent %+v, want a reply asking for a single image", sent)
+	}
+}
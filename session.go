@@ -0,0 +1,72 @@
+package main
+
+import "github.com/bwmarrin/discordgo"
+
+// session is what the bot asks of Discord. liveSession provides it from a
+// discordgo session, and tests stand in for Discord with their own.
+type session interface {
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessagePin(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageUnpin(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessagesPinned(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelTyping(channelID string, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponse(interaction *discordgo.Interaction, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error
+	MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string, options ...discordgo.RequestOption) ([]*discordgo.User, error)
+	MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error)
+	WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	WebhookThreadExecute(webhookID, token string, wait bool, threadID string, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	WebhookWithToken(webhookID, token string, options ...discordgo.RequestOption) (*discordgo.Webhook, error)
+
+	// botID is the bot's own user ID.
+	botID() string
+	// cachedChannel and cachedMember look in the state cache, without
+	// asking Discord.
+	cachedChannel(channelID string) (*discordgo.Channel, error)
+	cachedMember(guildID string, userID string) (*discordgo.Member, error)
+	// requestMultipart sends a multipart body discordgo has no call for.
+	requestMultipart(method string, endpoint string, contentType string, body []byte) ([]byte, error)
+}
+
+// liveSession is a session talking to Discord.
+type liveSession struct {
+	*discordgo.Session
+}
+
+func (s liveSession) botID() string {
+	return s.State.User.ID
+}
+
+func (s liveSession) cachedChannel(channelID string) (*discordgo.Channel, error) {
+	return s.State.Channel(channelID)
+}
+
+func (s liveSession) cachedMember(guildID string, userID string) (*discordgo.Member, error) {
+	return s.State.Member(guildID, userID)
+}
+
+func (s liveSession) requestMultipart(method string, endpoint string, contentType string, body []byte) ([]byte, error) {
+	return s.RequestWithLockedBucket(method, endpoint, contentType, body, s.Ratelimiter.LockBucket(endpoint), 0)
+}
+
+// live adapts an event handler taking a session to one discordgo can call.
+func live[T any](handler func(session, T)) func(*discordgo.Session, T) {
+	return func(s *discordgo.Session, event T) {
+		handler(liveSession{s}, event)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sendPromptCopy DMs the user who reacted with 📋 the prompt and settings used
+// for an image, so they can copy and tweak it. If their DMs are closed they
+// get a mention in the channel instead.
+func sendPromptCopy(s session, r *discordgo.MessageReactionAdd, req *discordgo.Message) {
+	// keep the prompt as it was typed
+	command, _ := parseCommand(req.Content)
+	prompt := normalizePrompt(command)
+	size, _ := parseSize(strings.ToLower(prompt))
+	size = resolveSize(modelFor(r.GuildID), size)
+	// referenced messages don't carry their guild ID
+	details := tr(locale(), "reply.prompt", messageLink(r.GuildID, req.ChannelID, req.ID), prompt, modelFor(r.GuildID), size)
+
+	dm, err := s.UserChannelCreate(r.UserID)
+	if err == nil {
+		_, err = sendReplyChunked(s, dm.ID, details, nil, nil)
+	}
+	if err == nil {
+		return
+	}
+
+	slog.Error("Error on sending prompt copy", "message_id", r.MessageID, "user_id", r.UserID, "error", err)
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeCannotSendMessagesToThisUser {
+		s.ChannelMessageSend(r.ChannelID, tr(locale(), "reply.promptDM", r.UserID, strings.ReplaceAll(prompt, "`", "'")))
+	}
+}
+
+// imageURLs returns the images shown on a bot reply: its attachments, or
+// Discord's copy of the image an older reply linked to.
+func imageURLs(m *discordgo.Message) []string {
+	var urls []string
+	for _, a := range m.Attachments {
+		urls = append(urls, a.URL)
+	}
+	if len(urls) == 0 {
+		if proxyURL := embeddedCopy(m); proxyURL != "" {
+			urls = append(urls, proxyURL)
+		}
+	}
+	return urls
+}
+
+// sendFavorite DMs the user who reacted with ⭐ a copy of the image, uploaded
+// so it won't expire, captioned with its prompt. If their DMs are closed the
+// image gets a ⚠️ instead.
+func sendFavorite(s session, r *discordgo.MessageReactionAdd, m *discordgo.Message) {
+	ctx := context.Background()
+	urls := imageURLs(m)
+	if len(urls) == 0 {
+		return
+	}
+	imgs, err := downloadImages(ctx, urls)
+	if err != nil {
+		slog.Error("Error on downloading favorite", "message_id", m.ID, "user_id", r.UserID, "error", err)
+		return
+	}
+
+	caption := tr(locale(), "reply.saved", messageLink(r.GuildID, m.ChannelID, m.ID))
+	if req := findRequestMessage(s, m); req != nil {
+		command, _ := parseCommand(req.Content)
+		caption = fmt.Sprintf("%s\n```\n%s\n```", caption, normalizePrompt(command))
+	}
+	files := make([]*discordgo.File, 0, len(imgs))
+	for _, img := range imgs {
+		files = append(files, img.discordFile())
+	}
+
+	dm, err := s.UserChannelCreate(r.UserID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{Content: caption, Files: files})
+	}
+	if err == nil {
+		return
+	}
+
+	slog.Error("Error on sending favorite", "message_id", r.MessageID, "user_id", r.UserID, "error", err)
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeCannotSendMessagesToThisUser {
+		setStatus(s, r.ChannelID, r.MessageID, "⚠️")
+	}
+}
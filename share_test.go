@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// favoriteable sends "/dalle a red fox" to channelID and returns the bot's
+// reply, given an attached image.
+func favoriteable(t *testing.T, d *fakeDiscord, channelID string) *sentMessage {
+	t.Helper()
+	onMessageHandler(d, d.post(channelID, "user", "/dalle a red fox"))
+	reply := d.sentMessages()[0]
+	reply.Attachments = []*discordgo.MessageAttachment{{URL: serving(t, "image/png", testPNG()) + "/fox.png"}}
+	return reply
+}
+
+func TestFavoriteSentToDMs(t *testing.T) {
+	d, _ := setupBot(t, Config{EnableFavorites: true}, "faves")
+	reply := favoriteable(t, d, "faves")
+
+	onEmojiAddHandler(d, d.react("faves", reply.ID, "fan", "⭐"))
+
+	sent := d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the favorite DMed", len(sent))
+	}
+	dm := sent[1]
+	if dm.ChannelID != "dm-fan" || len(dm.Files) != 1 {
+		t.Errorf("sent %d files to %s, want the image DMed to the fan", len(dm.Files), dm.ChannelID)
+	}
+	if !strings.Contains(dm.Content, "a red fox") {
+		t.Errorf("favorite captioned %q, want the prompt in it", dm.Content)
+	}
+}
+
+func TestFavoriteDMsClosed(t *testing.T) {
+	d, _ := setupBot(t, Config{EnableFavorites: true}, "closed")
+	reply := favoriteable(t, d, "closed")
+	d.closeDMs("fan")
+
+	onEmojiAddHandler(d, d.react("closed", reply.ID, "fan", "⭐"))
+
+	if sent := d.sentMessages(); len(sent) != 1 {
+		t.Errorf("sent %d messages, want nothing more", len(sent))
+	}
+	if got := d.reactionsOn(reply.ID); !reflect.DeepEqual(got, []string{"🔁", "⚠️"}) {
+		t.Errorf("reacted %q to the image, want a warning", got)
+	}
+}
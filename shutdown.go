@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultShutdownGrace = 30 * time.Second
+
+// statusMessage is a message showing 🤖 while its request runs.
+type statusMessage struct {
+	ChannelID string
+	MessageID string
+}
+
+// requestTracker keeps track of the generations in progress so shutdown can
+// wait for them to finish.
+type requestTracker struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing bool
+	active  map[statusMessage]int
+	// started is when the first request on each message got a generation
+	// slot, and overdue is which of them the janitor has already marked
+	// failed. Time spent queued doesn't count.
+	started map[statusMessage]time.Time
+	overdue map[statusMessage]bool
+	// draining is done once shutdown begins, which stops requests still
+	// waiting for a slot.
+	draining    context.Context
+	stopWaiting context.CancelFunc
+}
+
+var activeRequests = newRequestTracker()
+
+func newRequestTracker() *requestTracker {
+	draining, stopWaiting := context.WithCancel(context.Background())
+	return &requestTracker{
+		active:      make(map[statusMessage]int),
+		started:     make(map[statusMessage]time.Time),
+		overdue:     make(map[statusMessage]bool),
+		draining:    draining,
+		stopWaiting: stopWaiting,
+	}
+}
+
+// start records a request whose status is shown on messageID. It returns
+// false once shutdown has begun, otherwise the caller must call done when the
+// request finishes.
+func (t *requestTracker) start(channelID string, messageID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing {
+		return false
+	}
+	t.active[statusMessage{channelID, messageID}]++
+	t.wg.Add(1)
+	return true
+}
+
+// running starts the overdue clock for a request recorded by start, once it
+// has a generation slot and is actually generating.
+func (t *requestTracker) running(channelID string, messageID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := statusMessage{channelID, messageID}
+	if _, ok := t.started[key]; !ok && t.active[key] > 0 {
+		t.started[key] = now()
+	}
+}
+
+// done marks a request recorded by start as finished.
+func (t *requestTracker) done(channelID string, messageID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := statusMessage{channelID, messageID}
+	t.active[key]--
+	if t.active[key] <= 0 {
+		delete(t.active, key)
+		delete(t.started, key)
+		delete(t.overdue, key)
+	}
+	t.wg.Done()
+}
+
+// drain stops new requests from starting, gives up on those still waiting
+// for a slot, and waits up to grace for the running ones. It returns the
+// status messages of any still running.
+func (t *requestTracker) drain(grace time.Duration) []statusMessage {
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+	t.stopWaiting()
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(grace):
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	remaining := make([]statusMessage, 0, len(t.active))
+	for msg := range t.active {
+		remaining = append(remaining, msg)
+	}
+	return remaining
+}
+
+// count is how many requests are running.
+func (t *requestTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, running := range t.active {
+		n += running
+	}
+	return n
+}
+
+// takeOverdue returns the status messages whose requests have been running
+// longer than max since getting a slot, each only once.
+func (t *requestTracker) takeOverdue(max time.Duration) []statusMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var overdue []statusMessage
+	for msg, started := range t.started {
+		if !t.overdue[msg] && now().Sub(started) > max {
+			t.overdue[msg] = true
+			overdue = append(overdue, msg)
+		}
+	}
+	return overdue
+}
+
+// closingDown reports whether shutdown has begun.
+func (t *requestTracker) closingDown() bool {
+	return t.draining.Err() != nil
+}
+
+// isOverdue reports whether the janitor has marked the request on messageID
+// as failed.
+func (t *requestTracker) isOverdue(channelID string, messageID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.overdue[statusMessage{channelID, messageID}]
+}
+
+func shutdownGrace() time.Duration {
+	if cfg().ShutdownGraceSeconds > 0 {
+		return time.Duration(cfg().ShutdownGraceSeconds) * time.Second
+	}
+	return defaultShutdownGrace
+}
+
+// shutdown waits for running requests before the session is closed, marking
+// any that don't finish in time as failed so they aren't left on 🤖.
+func shutdown(s session) {
+	remaining := activeRequests.drain(shutdownGrace())
+	if len(remaining) == 0 {
+		return
+	}
+
+	slog.Warn("Gave up waiting for requests", "remaining", len(remaining))
+	for _, msg := range remaining {
+		if err := swapStatus(s, msg.ChannelID, msg.MessageID, statusEmojis().Working, statusEmojis().Error); err != nil {
+			slog.Error("Error on marking request as failed", "message_id", msg.MessageID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverdueCountsFromSlot(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	oldNow := now
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = oldNow })
+
+	tracker := &requestTracker{
+		active:  make(map[statusMessage]int),
+		started: make(map[statusMessage]time.Time),
+		overdue: make(map[statusMessage]bool),
+	}
+	tracker.start("channel", "queued")
+	tracker.start("channel", "running")
+	tracker.running("channel", "running")
+
+	clock = clock.Add(time.Hour)
+	overdue := tracker.takeOverdue(10 * time.Minute)
+	if len(overdue) != 1 || overdue[0].MessageID != "running" {
+		t.Fatalf("overdue %v, want only the request that got a slot", overdue)
+	}
+
+	// the queued one's clock starts once it gets its slot
+	tracker.running("channel", "queued")
+	clock = clock.Add(5 * time.Minute)
+	if overdue := tracker.takeOverdue(10 * time.Minute); len(overdue) != 0 {
+		t.Errorf("overdue %v, want none five minutes after getting a slot", overdue)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+)
+
+// spendTotals is the bot's running estimated spend across every guild,
+// persisted to SpendFile when one is set so the all-time total survives
+// restarts.
+type spendTotals struct {
+	Day     string  `json:"day"`
+	Today   float64 `json:"today"`
+	AllTime float64 `json:"allTime"`
+	Images  int     `json:"images"`
+}
+
+var (
+	spendMu sync.Mutex
+	spend   spendTotals
+)
+
+// loadSpend restores the totals saved by a previous run.
+func loadSpend() error {
+	if cfg().SpendFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(cfg().SpendFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	spendMu.Lock()
+	defer spendMu.Unlock()
+	return json.Unmarshal(b, &spend)
+}
+
+// rolloverSpend starts a new day's total once the UTC date changes. The
+// caller must hold spendMu.
+func rolloverSpend() {
+	day := now().UTC().Format("2006-01-02")
+	if day != spend.Day {
+		spend.Day = day
+		spend.Today = 0
+	}
+}
+
+// addSpend adds n images costing usd to the running totals, saving them when
+// SpendFile is set.
+func addSpend(usd float64, n int) {
+	spendMu.Lock()
+	defer spendMu.Unlock()
+
+	rolloverSpend()
+	spend.Today += usd
+	spend.AllTime += usd
+	spend.Images += n
+	if cfg().SpendFile == "" {
+		return
+	}
+	b, err := json.Marshal(spend)
+	if err == nil {
+		err = ioutil.WriteFile(cfg().SpendFile, b, 0644)
+	}
+	if err != nil {
+		slog.Error("Error saving spend", "error", err)
+	}
+}
+
+// spendSoFar returns the running totals.
+func spendSoFar() spendTotals {
+	spendMu.Lock()
+	defer spendMu.Unlock()
+	rolloverSpend()
+	return spend
+}
+
+// costReply describes the running spend for the admin cost command.
+func costReply(locale string, userID string) string {
+	if !isAdmin(userID) {
+		return tr(locale, "admin.cost")
+	}
+	totals := spendSoFar()
+	return tr(locale, "admin.spend", formatCost(totals.Today), formatCost(totals.AllTime), totals.Images)
+}
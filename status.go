@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StatusEmojis are the reactions that show a request's progress. Custom
+// server emojis are given as name:id.
+type StatusEmojis struct {
+	Working string `json:"working"`
+	Done    string `json:"done"`
+	Error   string `json:"error"`
+	Retry   string `json:"retry"`
+}
+
+var defaultStatusEmojis = StatusEmojis{
+	Working: "🤖",
+	Done:    "✅",
+	Error:   "❌",
+	Retry:   "🔁",
+}
+
+// statusEmojis returns the configured status emojis, or the defaults when
+// none are set.
+func statusEmojis() StatusEmojis {
+	if cfg().StatusEmojis != nil {
+		return *cfg().StatusEmojis
+	}
+	return defaultStatusEmojis
+}
+
+// validateStatusEmojis checks that a set configured in c has every emoji.
+func validateStatusEmojis(c *Config) error {
+	e := c.StatusEmojis
+	if e == nil {
+		return nil
+	}
+	var missing []string
+	for _, emoji := range []struct{ name, value string }{
+		{"working", e.Working},
+		{"done", e.Done},
+		{"error", e.Error},
+		{"retry", e.Retry},
+	} {
+		if strings.TrimSpace(emoji.value) == "" {
+			missing = append(missing, "statusEmojis."+emoji.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s must be set", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// emojiText formats a reaction emoji for use in a message, where custom
+// emojis are written <:name:id>.
+func emojiText(emoji string) string {
+	if strings.Contains(emoji, ":") {
+		return "<:" + emoji + ">"
+	}
+	return emoji
+}
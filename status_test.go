@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCustomStatusEmojis(t *testing.T) {
+	emojis := &StatusEmojis{Working: "⚙️", Done: "done:456", Error: "👎", Retry: "🔄"}
+	d, api := setupBot(t, Config{StatusEmojis: emojis}, "custom")
+
+	m := d.post("custom", "user", "/dalle a red fox")
+	onMessageHandler(d, m)
+	result := d.sentMessages()[0]
+	if got := d.reactionsOn(m.ID); !reflect.DeepEqual(got, []string{"done:456"}) {
+		t.Errorf("request shows %q, want the custom done emoji", got)
+	}
+	if got := d.reactionsOn(result.ID); !reflect.DeepEqual(got, []string{"🔄"}) {
+		t.Errorf("result shows %q, want the custom retry emoji", got)
+	}
+
+	// the default 🔁 means nothing once retry is replaced
+	onEmojiAddHandler(d, d.react("custom", result.ID, "user", "🔁"))
+	retry := d.react("custom", result.ID, "user", "")
+	retry.Emoji = discordgo.Emoji{Name: "🔄"}
+	onEmojiAddHandler(d, retry)
+	if got := api.generated(); len(got) != 2 {
+		t.Errorf("generated %q, want only the custom retry emoji to re-roll", got)
+	}
+
+	api.fail(http.StatusBadGateway, "<html>Bad Gateway</html>")
+	failed := d.post("custom", "user", "/dalle a lighthouse")
+	onMessageHandler(d, failed)
+	if got := d.reactionsOn(failed.ID); !reflect.DeepEqual(got, []string{"👎"}) {
+		t.Errorf("failed request shows %q, want the custom error emoji", got)
+	}
+}
+
+func TestValidateStatusEmojis(t *testing.T) {
+	for _, c := range []struct {
+		emojis *StatusEmojis
+		ok     bool
+	}{
+		{nil, true},
+		{&StatusEmojis{Working: "⚙️", Done: "👍", Error: "👎", Retry: "🔄"}, true},
+		{&StatusEmojis{Working: "⚙️", Done: "👍", Error: "👎"}, false},
+		{&StatusEmojis{Working: " ", Done: "👍", Error: "👎", Retry: "🔄"}, false},
+	} {
+		if err := validateStatusEmojis(&Config{StatusEmojis: c.emojis}); (err == nil) != c.ok {
+			t.Errorf("validateStatusEmojis(%+v) = %v, want ok %v", c.emojis, err, c.ok)
+		}
+	}
+}
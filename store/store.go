@@ -0,0 +1,233 @@
+// Package store keeps a history of image requests, and the styles users
+// saved, in a SQLite database.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// pure Go driver, so the bot still cross-compiles without cgo
+	_ "modernc.org/sqlite"
+)
+
+// Record is one image request and how it turned out.
+type Record struct {
+	ID        int64
+	AuthorID  string
+	GuildID   string
+	ChannelID string
+	Prompt    string
+	// URLs are where the resulting images can be found, empty on failure.
+	URLs      []string
+	Success   bool
+	CreatedAt time.Time
+}
+
+// Store is a request history backed by SQLite. It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS requests (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id  TEXT NOT NULL,
+	guild_id   TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	prompt     TEXT NOT NULL,
+	urls       TEXT NOT NULL,
+	success    INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_author ON requests (author_id, created_at);
+CREATE TABLE IF NOT EXISTS styles (
+	author_id TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	text      TEXT NOT NULL,
+	PRIMARY KEY (author_id, name)
+);
+`
+
+// Open opens the database at path, creating it and its tables if needed.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add saves r, filling in its ID, and CreatedAt when it's zero.
+func (s *Store) Add(ctx context.Context, r *Record) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	urls, err := json.Marshal(r.URLs)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO requests (author_id, guild_id, channel_id, prompt, urls, success, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.AuthorID, r.GuildID, r.ChannelID, r.Prompt, string(urls), r.Success, r.CreatedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// ByAuthor returns up to limit of authorID's requests, newest first.
+func (s *Store) ByAuthor(ctx context.Context, authorID string, limit int) ([]Record, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, author_id, guild_id, channel_id, prompt, urls, success, created_at FROM requests WHERE author_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		authorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var r Record
+		var urls string
+		var created int64
+		if err := rows.Scan(&r.ID, &r.AuthorID, &r.GuildID, &r.ChannelID, &r.Prompt, &urls, &r.Success, &created); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(urls), &r.URLs); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = time.Unix(0, created)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// UserStats sums up one user's requests.
+type UserStats struct {
+	Total     int
+	Succeeded int
+	// LastPrompt is the prompt of their most recent request.
+	LastPrompt string
+}
+
+// Stats returns authorID's totals, all zero when they have no history.
+func (s *Store) Stats(ctx context.Context, authorID string) (UserStats, error) {
+	var stats UserStats
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(success), 0) FROM requests WHERE author_id = ?`,
+		authorID).Scan(&stats.Total, &stats.Succeeded)
+	if err != nil || stats.Total == 0 {
+		return stats, err
+	}
+
+	err = s.db.QueryRowContext(ctx,
+		`SELECT prompt FROM requests WHERE author_id = ? ORDER BY created_at DESC, id DESC LIMIT 1`,
+		authorID).Scan(&stats.LastPrompt)
+	return stats, err
+}
+
+// DayCount is how many requests were made on one day.
+type DayCount struct {
+	// Day is midnight at the start of the day, in the location asked for.
+	Day   time.Time
+	Count int
+}
+
+// Daily returns how many requests authorID made on each of the days days up
+// to and including the one now falls on, oldest first, with days counted in
+// now's location. Days without any are included with a count of zero.
+func (s *Store) Daily(ctx context.Context, authorID string, days int, now time.Time) ([]DayCount, error) {
+	loc := now.Location()
+	y, m, d := now.Date()
+	first := time.Date(y, m, d-days+1, 0, 0, 0, 0, loc)
+	counts := make([]DayCount, days)
+	index := make(map[time.Time]int, days)
+	for i := range counts {
+		counts[i].Day = time.Date(y, m, d-days+1+i, 0, 0, 0, 0, loc)
+		index[counts[i].Day] = i
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT created_at FROM requests WHERE author_id = ? AND created_at >= ?`,
+		authorID, first.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var created int64
+		if err := rows.Scan(&created); err != nil {
+			return nil, err
+		}
+		y, m, d := time.Unix(0, created).In(loc).Date()
+		// anything after today is left out
+		if i, ok := index[time.Date(y, m, d, 0, 0, 0, 0, loc)]; ok {
+			counts[i].Count++
+		}
+	}
+	return counts, rows.Err()
+}
+
+// Style is a snippet a user saved to add to their prompts by name.
+type Style struct {
+	Name string
+	Text string
+}
+
+// SaveStyle saves authorID's style name as text, replacing any they had by
+// that name.
+func (s *Store) SaveStyle(ctx context.Context, authorID string, name string, text string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO styles (author_id, name, text) VALUES (?, ?, ?) ON CONFLICT (author_id, name) DO UPDATE SET text = excluded.text`,
+		authorID, name, text)
+	return err
+}
+
+// Styles returns authorID's styles, sorted by name.
+func (s *Store) Styles(ctx context.Context, authorID string) ([]Style, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT name, text FROM styles WHERE author_id = ? ORDER BY name`,
+		authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var styles []Style
+	for rows.Next() {
+		var st Style
+		if err := rows.Scan(&st.Name, &st.Text); err != nil {
+			return nil, err
+		}
+		styles = append(styles, st)
+	}
+	return styles, rows.Err()
+}
+
+// DeleteStyle deletes authorID's style name, reporting whether they had one.
+func (s *Store) DeleteStyle(ctx context.Context, authorID string, name string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM styles WHERE author_id = ? AND name = ?`,
+		authorID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
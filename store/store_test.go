@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAddAndQuery(t *testing.T) {
+	s := openTemp(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	records := []*Record{
+		{AuthorID: "user", GuildID: "guild", ChannelID: "channel", Prompt: "a red fox", URLs: []string{"https://cdn/fox.png"}, Success: true, CreatedAt: start},
+		{AuthorID: "user", GuildID: "guild", ChannelID: "channel", Prompt: "something forbidden", CreatedAt: start.Add(time.Minute)},
+		{AuthorID: "someone", GuildID: "guild", ChannelID: "channel", Prompt: "a blue whale", URLs: []string{"https://cdn/whale.png"}, Success: true, CreatedAt: start.Add(2 * time.Minute)},
+	}
+	for _, r := range records {
+		if err := s.Add(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if r.ID == 0 {
+			t.Errorf("record %q wasn't given an ID", r.Prompt)
+		}
+	}
+
+	got, err := s.ByAuthor(ctx, "user", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want the user's 2", len(got))
+	}
+	if got[0].Prompt != "something forbidden" || got[0].Success || len(got[0].URLs) != 0 {
+		t.Errorf("newest record is %+v, want the failure", got[0])
+	}
+	if got[1].Prompt != "a red fox" || !got[1].Success || !reflect.DeepEqual(got[1].URLs, []string{"https://cdn/fox.png"}) {
+		t.Errorf("oldest record is %+v, want the success with its URL", got[1])
+	}
+	if !got[1].CreatedAt.Equal(start) {
+		t.Errorf("created at %v, want %v", got[1].CreatedAt, start)
+	}
+
+	if got, err := s.ByAuthor(ctx, "user", 1); err != nil || len(got) != 1 {
+		t.Errorf("with a limit of 1 got %d records, %v", len(got), err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := openTemp(t)
+	ctx := context.Background()
+
+	stats, err := s.Stats(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (UserStats{}) {
+		t.Errorf("stats without history are %+v, want zero", stats)
+	}
+
+	s.Add(ctx, &Record{AuthorID: "user", Prompt: "a red fox", Success: true})
+	s.Add(ctx, &Record{AuthorID: "user", Prompt: "something forbidden"})
+
+	stats, err = s.Stats(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (UserStats{Total: 2, Succeeded: 1, LastPrompt: "something forbidden"}); stats != want {
+		t.Errorf("stats are %+v, want %+v", stats, want)
+	}
+}
+
+func TestDaily(t *testing.T) {
+	s := openTemp(t)
+	ctx := context.Background()
+	now := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	for _, at := range []time.Time{
+		now.AddDate(0, 0, -5),
+		now.Add(-10 * time.Hour),
+		now.Add(-time.Hour),
+		now.Add(-48 * time.Hour),
+		now.Add(-48 * time.Hour),
+	} {
+		s.Add(ctx, &Record{AuthorID: "user", Prompt: "a red fox", Success: true, CreatedAt: at})
+	}
+	s.Add(ctx, &Record{AuthorID: "someone", Prompt: "a blue whale", CreatedAt: now})
+
+	got, err := s.Daily(ctx, "user", 3, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DayCount{
+		{Day: time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC), Count: 2},
+		{Day: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), Count: 1},
+		{Day: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Daily() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStyles(t *testing.T) {
+	s := openTemp(t)
+	ctx := context.Background()
+
+	for _, st := range [][3]string{
+		{"user", "noir", "black and white"},
+		{"user", "comic", "comic book style"},
+		{"someone", "noir", "someone else's noir"},
+		{"user", "noir", "black and white, film noir, high contrast"},
+	} {
+		if err := s.SaveStyle(ctx, st[0], st[1], st[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.Styles(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Style{{"comic", "comic book style"}, {"noir", "black and white, film noir, high contrast"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("styles are %+v, want %+v with the resave replacing the first noir", got, want)
+	}
+
+	if deleted, err := s.DeleteStyle(ctx, "user", "noir"); err != nil || !deleted {
+		t.Errorf("deleting noir returned %v, %v, want it deleted", deleted, err)
+	}
+	if deleted, err := s.DeleteStyle(ctx, "user", "noir"); err != nil || deleted {
+		t.Errorf("deleting noir again returned %v, %v, want nothing deleted", deleted, err)
+	}
+	if got, err := s.Styles(ctx, "someone"); err != nil || len(got) != 1 {
+		t.Errorf("someone's styles are %+v, %v, want theirs kept", got, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// styleName is what a saved style can be called.
+var styleName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// styleToken is a saved style used in a prompt, like "@noir".
+var styleToken = regexp.MustCompile(`(?:^|\s)@([a-z0-9_-]+)`)
+
+// maxStyleLength keeps a saved style from taking up most of a prompt.
+const maxStyleLength = 300
+
+// styleCommand answers the saved style commands: "savestyle <name> <text>",
+// "styles" and "delstyle <name>". ok is false for any other prompt. Styles
+// live in the history database, so they're off without one.
+func styleCommand(ctx context.Context, locale string, userID string, prompt string) (reply string, ok bool) {
+	fields := strings.Fields(prompt)
+	if len(fields) == 0 {
+		return "", false
+	}
+	switch fields[0] {
+	case "savestyle", "styles", "delstyle":
+	default:
+		return "", false
+	}
+	if history == nil {
+		return tr(locale, "styles.off"), true
+	}
+
+	switch fields[0] {
+	case "savestyle":
+		if len(fields) < 3 || !styleName.MatchString(fields[1]) {
+			return tr(locale, "styles.usage", commandPrefix()), true
+		}
+		text := strings.Trim(strings.Join(fields[2:], " "), `"“”'`)
+		if text == "" || len(text) > maxStyleLength {
+			return tr(locale, "styles.usage", commandPrefix()), true
+		}
+		if err := history.SaveStyle(ctx, userID, fields[1], text); err != nil {
+			logger(ctx).Error("Error saving style", "style", fields[1], "error", err)
+			return tr(locale, "styles.error"), true
+		}
+		return tr(locale, "styles.saved", fields[1]), true
+
+	case "delstyle":
+		if len(fields) != 2 {
+			return tr(locale, "styles.usage", commandPrefix()), true
+		}
+		deleted, err := history.DeleteStyle(ctx, userID, fields[1])
+		if err != nil {
+			logger(ctx).Error("Error deleting style", "style", fields[1], "error", err)
+			return tr(locale, "styles.error"), true
+		}
+		if !deleted {
+			return tr(locale, "styles.unknown", fields[1]), true
+		}
+		return tr(locale, "styles.deleted", fields[1]), true
+	}
+
+	styles, err := history.Styles(ctx, userID)
+	if err != nil {
+		logger(ctx).Error("Error getting styles", "error", err)
+		return tr(locale, "styles.error"), true
+	}
+	if len(styles) == 0 {
+		return tr(locale, "styles.none", commandPrefix()), true
+	}
+	lines := make([]string, 0, len(styles))
+	for _, st := range styles {
+		lines = append(lines, "@"+st.Name+": "+st.Text)
+	}
+	return tr(locale, "styles.list", strings.Join(lines, "\n")), true
+}
+
+// expandStyles takes each @name token out of prompt and adds userID's saved
+// style by that name to the end of it. Its errors are meant for the
+// requester. Without a history database prompt is left as it is.
+func expandStyles(ctx context.Context, locale string, userID string, prompt string) (string, error) {
+	if history == nil || !styleToken.MatchString(prompt) {
+		return prompt, nil
+	}
+	styles, err := history.Styles(ctx, userID)
+	if err != nil {
+		logger(ctx).Error("Error getting styles", "error", err)
+		return "", errors.New(tr(locale, "styles.error"))
+	}
+	saved := make(map[string]string, len(styles))
+	for _, st := range styles {
+		saved[st.Name] = st.Text
+	}
+
+	var suffixes []string
+	for _, match := range styleToken.FindAllStringSubmatch(prompt, -1) {
+		text, ok := saved[match[1]]
+		if !ok {
+			return "", errors.New(tr(locale, "styles.unknown", match[1]))
+		}
+		suffixes = append(suffixes, text)
+	}
+	rest := strings.Join(strings.Fields(styleToken.ReplaceAllString(prompt, " ")), " ")
+	// the styles go with what to draw, ahead of anything to leave out
+	positive, negatives, found := strings.Cut(rest, "|")
+	parts := suffixes
+	if positive = strings.TrimSpace(positive); positive != "" {
+		parts = append([]string{positive}, suffixes...)
+	}
+	expanded := strings.Join(parts, ", ")
+	if found {
+		expanded += " |" + negatives
+	}
+	return expanded, nil
+}
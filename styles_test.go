@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestExpandStyles(t *testing.T) {
+	s := useHistory(t)
+	useConfig(t, Config{})
+	ctx := context.Background()
+	s.SaveStyle(ctx, "user", "noir", "black and white, film noir")
+	s.SaveStyle(ctx, "user", "wide-angle", "wide angle lens")
+
+	for _, c := range []struct {
+		prompt string
+		want   string
+		err    string
+	}{
+		{"a city street", "a city street", ""},
+		{"@noir a city street", "a city street, black and white, film noir", ""},
+		{"a city street @noir @wide-angle", "a city street, black and white, film noir, wide angle lens", ""},
+		{"@noir", "black and white, film noir", ""},
+		{"@noir a forest | no people", "a forest, black and white, film noir | no people", ""},
+		{"mail me@example.com a letter", "mail me@example.com a letter", ""},
+		{"@pastel a city street", "", tr("en", "styles.unknown", "pastel")},
+	} {
+		got, err := expandStyles(ctx, "en", "user", c.prompt)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("expandStyles(%q) returned %v, want %q", c.prompt, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("expandStyles(%q) = %q, %v, want %q", c.prompt, got, err, c.want)
+		}
+	}
+}
+
+func TestStyleCommands(t *testing.T) {
+	useHistory(t)
+	d, api := setupBot(t, Config{}, "styled")
+
+	onMessageHandler(d, d.post("styled", "user", `/dalle savestyle noir "black and white, film noir, high contrast"`))
+	onMessageHandler(d, d.post("styled", "user", "/dalle styles"))
+	onMessageHandler(d, d.post("styled", "user", "/dalle @noir a city street"))
+	onMessageHandler(d, d.post("styled", "someone", "/dalle @noir a city street"))
+	onMessageHandler(d, d.post("styled", "user", "/dalle delstyle noir"))
+	onMessageHandler(d, d.post("styled", "user", "/dalle styles"))
+
+	if got, want := api.generated(), []string{"a city street, black and white, film noir, high contrast"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q with only the saver's style filled in", got, want)
+	}
+	var replies []string
+	for _, m := range d.sentMessages() {
+		if len(m.Files) == 0 {
+			replies = append(replies, m.Content)
+		}
+	}
+	want := []string{
+		tr("en", "styles.saved", "noir"),
+		tr("en", "styles.list", "@noir: black and white, film noir, high contrast"),
+		tr("en", "styles.unknown", "noir"),
+		tr("en", "styles.deleted", "noir"),
+		tr("en", "styles.none", "/dalle"),
+	}
+	if !reflect.DeepEqual(replies, want) {
+		t.Errorf("replied %q, want %q", replies, want)
+	}
+}
+
+func TestSlashCommandStyles(t *testing.T) {
+	s := useHistory(t)
+	d, api := setupBot(t, Config{EnableSlashCommands: true}, "slashstyled")
+	s.SaveStyle(context.Background(), "user", "noir", "black and white")
+
+	onInteractionHandler(d, d.command("slashstyled", "user", "@noir a forest | no people"))
+
+	if got, want := api.generated(), []string{"a forest, black and white, without: people"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q", got, want)
+	}
+}
+
+func TestStylesOff(t *testing.T) {
+	d, api := setupBot(t, Config{}, "unstyled")
+
+	onMessageHandler(d, d.post("unstyled", "user", "/dalle savestyle noir black and white"))
+	onMessageHandler(d, d.post("unstyled", "user", "/dalle @noir a city street"))
+
+	sent := d.sentMessages()
+	if len(sent) == 0 || sent[0].Content != tr("en", "styles.off") {
+		t.Errorf("sent %d messages, want saving refused without a history", len(sent))
+	}
+	if got := api.generated(); !reflect.DeepEqual(got, []string{"@noir a city street"}) {
+		t.Errorf("generated %q, want the prompt as it was", got)
+	}
+}
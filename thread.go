@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxThreadName is the longest name Discord gives a thread.
+const maxThreadName = 100
+
+// threadArchiveMinutes is how long a prompt's thread stays open without
+// activity.
+const threadArchiveMinutes = 1440
+
+// channelInfo looks up a channel, from the state cache when it's there.
+func channelInfo(s session, channelID string) (*discordgo.Channel, error) {
+	if ch, err := s.cachedChannel(channelID); err == nil {
+		return ch, nil
+	}
+	return s.Channel(channelID)
+}
+
+// parentChannel returns the channel a thread belongs to, or channelID itself
+// when it isn't a thread, so the channel allowlist covers a channel's threads.
+func parentChannel(s session, channelID string) string {
+	ch, err := channelInfo(s, channelID)
+	if err != nil || !ch.IsThread() {
+		return channelID
+	}
+	return ch.ParentID
+}
+
+// threadStarter returns the message a thread was started from when m is in
+// such a thread, or nil. A thread started from a message shares its ID.
+func threadStarter(s session, m *discordgo.Message) *discordgo.Message {
+	ch, err := channelInfo(s, m.ChannelID)
+	if err != nil || !ch.IsThread() {
+		return nil
+	}
+	starter, err := s.ChannelMessage(ch.ParentID, ch.ID)
+	if err != nil {
+		return nil
+	}
+	return starter
+}
+
+// promptThread starts a thread on m for its results when
+// CreateThreadPerPrompt is set, returning the thread's ID, or "" to reply in
+// m's channel as usual.
+func promptThread(ctx context.Context, s session, m *discordgo.Message, prompt string) string {
+	if !cfg().CreateThreadPerPrompt || m.GuildID == "" {
+		return ""
+	}
+	if ch, err := channelInfo(s, m.ChannelID); err != nil || ch.IsThread() {
+		return ""
+	}
+
+	name := []rune(prompt)
+	if len(name) > maxThreadName {
+		name = append(name[:maxThreadName-1], '…')
+	}
+	if len(name) == 0 {
+		name = []rune("variations")
+	}
+	thread, err := s.MessageThreadStart(m.ChannelID, m.ID, string(name), threadArchiveMinutes)
+	if err != nil {
+		logger(ctx).Error("Error on starting thread", "error", err)
+		return ""
+	}
+	return thread.ID
+}
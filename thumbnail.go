@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/image/draw"
+)
+
+// thumbnailQuality is the JPEG quality thumbnails are encoded at. They're
+// only a preview, so it's on the low side.
+const thumbnailQuality = 75
+
+// thumbnail returns img scaled down so neither side is over size pixels, as
+// a JPEG to show inline ahead of the full image. ok is false when img can't
+// be decoded or is already no bigger than that.
+func thumbnail(img *imageFile, size int) (thumb *imageFile, ok bool) {
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, false
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return nil, false
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, false
+	}
+	name := strings.TrimSuffix(img.Name, "."+imageExtensions[img.ContentType])
+	return &imageFile{
+		Name:        "thumb-" + name + ".jpg",
+		ContentType: "image/jpeg",
+		Data:        buf.Bytes(),
+		Description: img.Description,
+	}, true
+}
+
+// withThumbnails adds a ThumbnailSize thumbnail of each of imgs to the
+// upload, returning the files to send along with the embeds that show the
+// thumbnails, so the full images are there to open without having to load
+// first. Images that can't be thumbnailed go without.
+func withThumbnails(imgs []*imageFile) ([]*imageFile, []*discordgo.MessageEmbed) {
+	size := cfg().ThumbnailSize
+	if size <= 0 {
+		return imgs, nil
+	}
+	files := append([]*imageFile(nil), imgs...)
+	var embeds []*discordgo.MessageEmbed
+	for _, img := range imgs {
+		thumb, ok := thumbnail(img, size)
+		if !ok {
+			continue
+		}
+		files = append(files, thumb)
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			Image: &discordgo.MessageEmbedImage{URL: fmt.Sprintf("attachment://%s", thumb.Name)},
+		})
+	}
+	return files, embeds
+}
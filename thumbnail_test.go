@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	useConfig(t, Config{})
+	img := photoPNG(t)
+
+	thumb, ok := thumbnail(img, 64)
+	if !ok {
+		t.Fatal("thumbnail() not ok, want a 256px image scaled down")
+	}
+	if thumb.Name != "thumb-disc-e.jpg" || thumb.ContentType != "image/jpeg" {
+		t.Errorf("thumbnail named %q as %q, want thumb-disc-e.jpg as image/jpeg", thumb.Name, thumb.ContentType)
+	}
+	decoded, _, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Width != 64 || decoded.Height != 64 {
+		t.Errorf("thumbnail is %dx%d, want 64x64", decoded.Width, decoded.Height)
+	}
+
+	if _, ok := thumbnail(img, 256); ok {
+		t.Error("thumbnail() ok for an image already that small, want it left out")
+	}
+	if _, ok := thumbnail(&imageFile{Name: "disc-e.png", ContentType: "image/png", Data: []byte("not a png")}, 64); ok {
+		t.Error("thumbnail() ok for bytes that don't decode, want it skipped")
+	}
+}
+
+func TestThumbnailKeepsAspect(t *testing.T) {
+	img := &imageFile{Name: "disc-e.png", ContentType: "image/png", Data: pngOfSize(t, 200, 100)}
+
+	thumb, ok := thumbnail(img, 50)
+	if !ok {
+		t.Fatal("thumbnail() not ok")
+	}
+	decoded, _, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Width != 50 || decoded.Height != 25 {
+		t.Errorf("thumbnail is %dx%d, want 50x25", decoded.Width, decoded.Height)
+	}
+}
+
+func TestThumbnailUploaded(t *testing.T) {
+	d, _ := setupBot(t, Config{ThumbnailSize: 8}, "thumbs")
+
+	onMessageHandler(d, d.post("thumbs", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if want := []string{"disc-e.png", "thumb-disc-e.jpg"}; !reflect.DeepEqual(sent[0].FileNames, want) {
+		t.Errorf("uploaded %q, want %q", sent[0].FileNames, want)
+	}
+	if len(sent[0].Embeds) != 1 || sent[0].Embeds[0].Image == nil || sent[0].Embeds[0].Image.URL != "attachment://thumb-disc-e.jpg" {
+		t.Errorf("embeds %+v, want the thumbnail shown inline", sent[0].Embeds)
+	}
+}
+
+func TestSlashCommandThumbnail(t *testing.T) {
+	d, _ := setupBot(t, Config{EnableSlashCommands: true, ThumbnailSize: 8}, "slashthumbs")
+
+	i := d.command("slashthumbs", "user", "a red fox")
+	onInteractionHandler(d, i)
+
+	response := d.response(i)
+	if response == nil || len(response.Embeds) != 1 || response.Embeds[0].Image.URL != "attachment://thumb-disc-e.jpg" {
+		t.Fatalf("response %+v, want the thumbnail shown inline", response)
+	}
+}
+
+func TestThumbnailsOff(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "nothumbs")
+
+	onMessageHandler(d, d.post("nothumbs", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || len(sent[0].FileNames) != 1 || len(sent[0].Embeds) != 0 {
+		t.Errorf("sent %+v, want only the image", sent)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// typingRefresh is how often the typing indicator is renewed, just under the
+// ten seconds Discord shows it for.
+const typingRefresh = 8 * time.Second
+
+// startTyping shows the bot typing in channelID until the returned function
+// is called. Calling it more than once is fine.
+func startTyping(ctx context.Context, s session, channelID string) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingRefresh)
+		defer ticker.Stop()
+		for {
+			if err := s.ChannelTyping(channelID); err != nil {
+				logger(ctx).Error("Error on showing typing", "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
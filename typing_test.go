@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTypingShownWhileGenerating(t *testing.T) {
+	d, api := setupBot(t, Config{}, "typing")
+	release := api.hang()
+
+	done := make(chan struct{})
+	go func() {
+		onMessageHandler(d, d.post("typing", "user", "/dalle a slow fox"))
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); d.typingIn("typing") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("never showed typing while the image was generating")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	<-done
+}
+
+func TestTypingStops(t *testing.T) {
+	d := newFakeDiscord()
+	stop := startTyping(context.Background(), d, "stopped")
+	for deadline := time.Now().Add(time.Second); d.typingIn("stopped") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("never showed typing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	stop()
+	shown := d.typingIn("stopped")
+	time.Sleep(20 * time.Millisecond)
+	if got := d.typingIn("stopped"); got != shown {
+		t.Errorf("typing shown %d times after stopping, want it to stay at %d", got, shown)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/png"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// variationModel is the only model OpenAI makes variations and edits with.
+const variationModel = "dall-e-2"
+
+// maxVariationBytes is the largest image the variations and edits endpoints
+// accept.
+const maxVariationBytes = 4 << 20
+
+const defaultAttachmentTimeout = 15 * time.Second
+
+// maxAttachmentBytes is the largest attachment downloaded, MaxAttachmentBytes
+// when it's set lower than what the endpoints accept.
+func maxAttachmentBytes() int {
+	if max := cfg().MaxAttachmentBytes; max > 0 && max < maxVariationBytes {
+		return max
+	}
+	return maxVariationBytes
+}
+
+func attachmentTimeout() time.Duration {
+	if cfg().AttachmentTimeoutSeconds > 0 {
+		return time.Duration(cfg().AttachmentTimeoutSeconds) * time.Second
+	}
+	return defaultAttachmentTimeout
+}
+
+// pngAttachment downloads an attachment that must be a PNG small enough for
+// variations and edits. Its errors are meant for the requester.
+func pngAttachment(ctx context.Context, a *discordgo.MessageAttachment) ([]byte, error) {
+	max := maxAttachmentBytes()
+	// to a tenth of a MB, for limits set below 1MB
+	tooBig := errors.New(tr(localeFrom(ctx), "error.tooBig", math.Round(float64(max)/(1<<20)*10)/10))
+	if a.Size > max {
+		return nil, tooBig
+	}
+	// Discord's type saves downloading what plainly isn't an image
+	if a.ContentType != "" && !strings.HasPrefix(a.ContentType, "image/") {
+		return nil, errors.New(tr(localeFrom(ctx), "error.onlyPNG"))
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, attachmentTimeout())
+	defer cancel()
+	img, err := downloadLimited(downloadCtx, a.URL, max)
+	switch {
+	case errors.Is(err, errImageTooLarge):
+		return nil, tooBig
+	case errors.Is(err, errNotAnImage):
+		return nil, errors.New(tr(localeFrom(ctx), "error.onlyPNG"))
+	case err != nil && errors.Is(downloadCtx.Err(), context.DeadlineExceeded):
+		logger(ctx).Warn("Attachment download timed out", "timeout", attachmentTimeout())
+		return nil, errors.New(tr(localeFrom(ctx), "error.slowAttachment"))
+	case err != nil:
+		logger(ctx).Error("Error on downloading attachment", "error", err)
+		return nil, errors.New(tr(localeFrom(ctx), "error.noPNG"))
+	}
+	if img.ContentType != "image/png" {
+		return nil, errors.New(tr(localeFrom(ctx), "error.onlyPNG"))
+	}
+	return img.Data, nil
+}
+
+// variationSource downloads the image attached to m for a variation.
+func variationSource(ctx context.Context, m *discordgo.Message) ([]byte, error) {
+	return pngAttachment(ctx, m.Attachments[0])
+}
+
+// editSources downloads the image and mask attached to m for an edit, in
+// that order, checking they are the same size. Its errors are meant for the
+// requester.
+func editSources(ctx context.Context, m *discordgo.Message) ([]byte, []byte, error) {
+	if len(m.Attachments) != 2 {
+		return nil, nil, errors.New(tr(localeFrom(ctx), "error.needMask"))
+	}
+
+	src, err := pngAttachment(ctx, m.Attachments[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	mask, err := pngAttachment(ctx, m.Attachments[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srcSize, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, nil, errors.New(tr(localeFrom(ctx), "error.badImage"))
+	}
+	maskSize, _, err := image.DecodeConfig(bytes.NewReader(mask))
+	if err != nil {
+		return nil, nil, errors.New(tr(localeFrom(ctx), "error.badMask"))
+	}
+	if srcSize.Width != maskSize.Width || srcSize.Height != maskSize.Height {
+		return nil, nil, errors.New(tr(localeFrom(ctx), "error.maskSize", maskSize.Width, maskSize.Height, srcSize.Width, srcSize.Height))
+	}
+	return src, mask, nil
+}
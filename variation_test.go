@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// pngOfSize is a blank PNG of the given size.
+func pngOfSize(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// attached is an attachment of data served as contentType.
+func attached(t *testing.T, contentType string, data []byte) *discordgo.MessageAttachment {
+	return &discordgo.MessageAttachment{URL: serving(t, contentType, data) + "/file", ContentType: contentType, Size: len(data)}
+}
+
+func TestEditSources(t *testing.T) {
+	useConfig(t, Config{})
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	square := pngOfSize(t, 8, 8)
+	broken := []byte("\x89PNG\r\n\x1a\nnot really")
+
+	for _, c := range []struct {
+		name        string
+		attachments []*discordgo.MessageAttachment
+		err         string
+	}{
+		{"image and mask", []*discordgo.MessageAttachment{attached(t, "image/png", square), attached(t, "image/png", pngOfSize(t, 8, 8))}, ""},
+		{"no mask", []*discordgo.MessageAttachment{attached(t, "image/png", square)}, tr("en", "error.needMask")},
+		{"jpeg image", []*discordgo.MessageAttachment{attached(t, "image/jpeg", photo.Bytes()), attached(t, "image/png", square)}, tr("en", "error.onlyPNG")},
+		{"jpeg mask", []*discordgo.MessageAttachment{attached(t, "image/png", square), attached(t, "image/jpeg", photo.Bytes())}, tr("en", "error.onlyPNG")},
+		{"broken image", []*discordgo.MessageAttachment{attached(t, "image/png", broken), attached(t, "image/png", square)}, tr("en", "error.badImage")},
+		{"broken mask", []*discordgo.MessageAttachment{attached(t, "image/png", square), attached(t, "image/png", broken)}, tr("en", "error.badMask")},
+		{"mask too small", []*discordgo.MessageAttachment{attached(t, "image/png", square), attached(t, "image/png", pngOfSize(t, 4, 8))}, tr("en", "error.maskSize", 4, 8, 8, 8)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			src, mask, err := editSources(context.Background(), &discordgo.Message{Attachments: c.attachments})
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(src, square) || len(mask) == 0 {
+				t.Errorf("got a %d byte image and %d byte mask, want both downloaded", len(src), len(mask))
+			}
+		})
+	}
+}
+
+func TestPNGAttachment(t *testing.T) {
+	useConfig(t, Config{MaxAttachmentBytes: 200 << 10, AttachmentTimeoutSeconds: 1})
+	square := pngOfSize(t, 8, 8)
+	huge := append(append([]byte(nil), square...), make([]byte, 300<<10)...)
+	tooBig := tr("en", "error.tooBig", 0.2)
+
+	// a server taking longer than the timeout to send anything
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	understated := attached(t, "image/png", huge)
+	understated.Size = len(square)
+	unlabelled := attached(t, "", []byte("<html>not an image</html>"))
+
+	for _, c := range []struct {
+		name       string
+		attachment *discordgo.MessageAttachment
+		err        string
+	}{
+		{"valid", attached(t, "image/png", square), ""},
+		{"oversized", attached(t, "image/png", huge), tooBig},
+		{"oversized download", understated, tooBig},
+		{"not an image", attached(t, "text/plain", []byte("hello")), tr("en", "error.onlyPNG")},
+		{"sniffed not an image", unlabelled, tr("en", "error.onlyPNG")},
+		{"slow", &discordgo.MessageAttachment{URL: slow.URL + "/file", ContentType: "image/png", Size: len(square)}, tr("en", "error.slowAttachment")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := pngAttachment(context.Background(), c.attachment)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, square) {
+				t.Errorf("got %d bytes, want the %d byte PNG", len(data), len(square))
+			}
+		})
+	}
+}
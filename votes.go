@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// downvoteEmoji returns the reaction that counts as a downvote.
+func downvoteEmoji() string {
+	if cfg().DownvoteEmoji != "" {
+		return cfg().DownvoteEmoji
+	}
+	return "👎"
+}
+
+// handleDownvote deletes a bot image once it has DownvoteThreshold downvotes
+// from distinct users. Counting the users Discord reports for the reaction,
+// rather than tallying events, keeps removed reactions from counting.
+func handleDownvote(s session, m *discordgo.Message, emoji *discordgo.Emoji) {
+	users, err := s.MessageReactions(m.ChannelID, m.ID, emoji.APIName(), 100, "", "")
+	if err != nil {
+		slog.Error("Error on getting downvotes", "message_id", m.ID, "error", err)
+		return
+	}
+
+	requesterID := ""
+	if cfg().IgnoreRequesterDownvotes {
+		if req := findRequestMessage(s, m); req != nil {
+			requesterID = req.Author.ID
+		}
+	}
+	votes := 0
+	for _, u := range users {
+		if u.ID == s.botID() || (cfg().IgnoreRequesterDownvotes && u.ID == requesterID) {
+			continue
+		}
+		votes++
+	}
+
+	if votes < cfg().DownvoteThreshold {
+		return
+	}
+
+	if err := s.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+		slog.Error("Error on deleting downvoted image", "message_id", m.ID, "error", err)
+		return
+	}
+	slog.Info("Deleted image after downvotes", "message_id", m.ID, "votes", votes)
+}
+
+// isDeleteEmoji reports whether a reaction is 🗑️, which clients send with or
+// without the emoji variation selector.
+func isDeleteEmoji(name string) bool {
+	return strings.TrimSuffix(name, "\ufe0f") == "🗑"
+}
+
+// handleDelete deletes a bot image when the user who reacted with 🗑️ asked
+// for it or can manage messages in the channel.
+func handleDelete(s session, r *discordgo.MessageReactionAdd, m *discordgo.Message) {
+	allowed := false
+	if req := findRequestMessage(s, m); req != nil && req.Author.ID == r.UserID {
+		allowed = true
+	} else if perms, err := s.UserChannelPermissions(r.UserID, m.ChannelID); err == nil && perms&discordgo.PermissionManageMessages != 0 {
+		allowed = true
+	}
+	if !allowed {
+		return
+	}
+
+	if err := s.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+		slog.Error("Error on deleting image", "message_id", m.ID, "error", err)
+		return
+	}
+	slog.Info("Deleted image on request", "message_id", m.ID, "user_id", r.UserID)
+}
+
+// maxPins is the number of pinned messages Discord allows per channel.
+const maxPins = 50
+
+// handleAutoPin pins a bot image in the AutoPinChannelID once it has
+// AutoPinThreshold reactions from users. When the channel is out of pins the
+// oldest bot pin is removed to make room.
+func handleAutoPin(s session, m *discordgo.Message) {
+	if m.Pinned {
+		return
+	}
+
+	count := 0
+	for _, r := range m.Reactions {
+		count += r.Count
+		if r.Me {
+			count--
+		}
+	}
+	if count < cfg().AutoPinThreshold {
+		return
+	}
+
+	pinned, err := s.ChannelMessagesPinned(m.ChannelID)
+	if err != nil {
+		slog.Error("Error on getting pinned messages", "message_id", m.ID, "error", err)
+		return
+	}
+
+	if len(pinned) >= maxPins {
+		// pins are listed newest first
+		for i := len(pinned) - 1; i >= 0; i-- {
+			if pinned[i].Author.ID != s.botID() {
+				continue
+			}
+			if err := s.ChannelMessageUnpin(m.ChannelID, pinned[i].ID); err != nil {
+				logPinError(m.ID, err)
+				return
+			}
+			break
+		}
+	}
+
+	if err := s.ChannelMessagePin(m.ChannelID, m.ID); err != nil {
+		logPinError(m.ID, err)
+		return
+	}
+	slog.Info("Pinned image", "message_id", m.ID, "reactions", count)
+}
+
+func logPinError(messageID string, err error) {
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions {
+		slog.Warn("Can't auto-pin, the bot needs the Manage Messages permission", "message_id", messageID)
+		return
+	}
+	slog.Error("Error on pinning image", "message_id", messageID, "error", err)
+}
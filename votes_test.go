@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDeleteByRequester(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "trash")
+	onMessageHandler(d, d.post("trash", "user", "/dalle a red fox"))
+	result := d.sentMessages()[0]
+	d.permissions["user"] = 0
+
+	onEmojiAddHandler(d, d.react("trash", result.ID, "user", "🗑️"))
+
+	if got := d.deletedMessages(); !reflect.DeepEqual(got, []string{result.ID}) {
+		t.Errorf("deleted %q, want the requester's image %s", got, result.ID)
+	}
+}
+
+func TestDeleteByModerator(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "modtrash")
+	onMessageHandler(d, d.post("modtrash", "user", "/dalle a red fox"))
+	result := d.sentMessages()[0]
+	d.permissions["mod"] = discordgo.PermissionManageMessages
+
+	onEmojiAddHandler(d, d.react("modtrash", result.ID, "mod", "🗑"))
+
+	if got := d.deletedMessages(); !reflect.DeepEqual(got, []string{result.ID}) {
+		t.Errorf("deleted %q, want a moderator allowed to delete %s", got, result.ID)
+	}
+}
+
+func TestDeleteByStrangerIgnored(t *testing.T) {
+	d, _ := setupBot(t, Config{}, "nottrash")
+	onMessageHandler(d, d.post("nottrash", "user", "/dalle a red fox"))
+	result := d.sentMessages()[0]
+	d.permissions["stranger"] = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+
+	onEmojiAddHandler(d, d.react("nottrash", result.ID, "stranger", "🗑️"))
+
+	if got := d.deletedMessages(); len(got) != 0 {
+		t.Errorf("deleted %q, want someone else's image left alone", got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// resultEvent is the JSON body POSTed to Config.ResultWebhookURL after each
+// completed generation.
+type resultEvent struct {
+	RequestID string    `json:"requestID"`
+	MessageID string    `json:"messageID"`
+	Prompt    string    `json:"prompt"`
+	UserID    string    `json:"userID"`
+	GuildID   string    `json:"guildID"`
+	ChannelID string    `json:"channelID"`
+	ResultURL string    `json:"resultURL"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// postResult sends a completed generation to the result webhook, if one is
+// configured. It returns immediately; failures are only logged.
+func postResult(imgReq *ImageRequest, imgURL string) {
+	if cfg().ResultWebhookURL == "" {
+		return
+	}
+
+	event := resultEvent{
+		RequestID: imgReq.RequestID,
+		MessageID: imgReq.ID,
+		Prompt:    imgReq.Prompt,
+		UserID:    imgReq.AuthorID,
+		GuildID:   imgReq.GuildID,
+		ChannelID: imgReq.ChannelID,
+		ResultURL: imgURL,
+		Timestamp: time.Now().UTC(),
+	}
+
+	go func() {
+		if err := sendResultEvent(&event); err != nil {
+			slog.Error("Error posting result webhook", "request_id", event.RequestID, "error", err)
+		}
+	}()
+}
+
+func sendResultEvent(event *resultEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", cfg().ResultWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// sign the body so receivers can check it came from us
+	if cfg().ResultWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg().ResultWebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Disc-E-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// webhookChannels caches the channel each posting webhook belongs to, since
+// a webhook can only post there and in that channel's threads.
+var (
+	webhookChannelsMu sync.Mutex
+	webhookChannels   = make(map[string]string)
+)
+
+// webhookChannel returns the channel the configured posting webhook belongs
+// to.
+func webhookChannel(s session) (string, error) {
+	webhookChannelsMu.Lock()
+	defer webhookChannelsMu.Unlock()
+
+	if channelID, ok := webhookChannels[cfg().WebhookID]; ok {
+		return channelID, nil
+	}
+	webhook, err := s.WebhookWithToken(cfg().WebhookID, cfg().WebhookToken)
+	if err != nil {
+		return "", err
+	}
+	webhookChannels[cfg().WebhookID] = webhook.ChannelID
+	return webhook.ChannelID, nil
+}
+
+// sendWebhookResult posts a result to channelID through the configured
+// webhook, under its username and avatar. It returns nil without an error
+// when the webhook isn't set up or can't post in channelID, so the caller
+// replies normally instead.
+//
+// Webhook posts can't be replies, so they mention the requester instead and
+// can't be re-rolled.
+func sendWebhookResult(s session, channelID string, content string, imgs []*imageFile, imgReq *ImageRequest) (*discordgo.Message, error) {
+	if cfg().WebhookID == "" || cfg().WebhookToken == "" {
+		return nil, nil
+	}
+	hookChannel, err := webhookChannel(s)
+	if err != nil {
+		return nil, err
+	}
+	threadID := ""
+	if channelID != hookChannel {
+		if parentChannel(s, channelID) != hookChannel {
+			return nil, nil
+		}
+		threadID = channelID
+	}
+
+	content += "\n" + tr(imgReq.Locale, "reply.madeFor", imgReq.AuthorID)
+	if utf8.RuneCountInString(content) > maxMessageLength {
+		return nil, nil
+	}
+	files := make([]*discordgo.File, 0, len(imgs))
+	for _, img := range imgs {
+		files = append(files, img.discordFile())
+	}
+	params := &discordgo.WebhookParams{
+		Content:   content,
+		Username:  cfg().WebhookUsername,
+		AvatarURL: cfg().WebhookAvatarURL,
+		Files:     files,
+		// the mention is a credit, not a ping
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if threadID != "" {
+		return s.WebhookThreadExecute(cfg().WebhookID, cfg().WebhookToken, true, threadID, params)
+	}
+	return s.WebhookExecute(cfg().WebhookID, cfg().WebhookToken, true, params)
+}
+
+// sendResult sends a result to channelID through the webhook when there's
+// one for that channel, and as a reply to reference otherwise or when the
+// webhook fails. Results sent through the webhook have a WebhookID.
+func sendResult(ctx context.Context, s session, imgReq *ImageRequest, channelID string, content string, imgs []*imageFile, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	reply, err := sendWebhookResult(s, channelID, content, imgs, imgReq)
+	if err != nil {
+		logger(ctx).Error("Error on posting through webhook", "error", err)
+	} else if reply != nil {
+		return reply, nil
+	}
+	return sendReply(ctx, s, channelID, content, imgs, reference)
+}
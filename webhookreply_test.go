@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// useWebhook sets up the posting webhook "hook" in channelID under the name
+// "Painter", forgetting which channel it posts in after the test.
+func useWebhook(t *testing.T, channelID string) (*fakeDiscord, *fakeOpenAI) {
+	t.Helper()
+	d, api := setupBot(t, Config{WebhookID: "hook", WebhookToken: "hook-token", WebhookUsername: "Painter"}, channelID)
+	d.addWebhook("hook", channelID)
+	t.Cleanup(func() {
+		webhookChannelsMu.Lock()
+		defer webhookChannelsMu.Unlock()
+		delete(webhookChannels, "hook")
+	})
+	return d, api
+}
+
+func TestResultThroughWebhook(t *testing.T) {
+	d, _ := useWebhook(t, "hooked")
+
+	onMessageHandler(d, d.post("hooked", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want the result", len(sent))
+	}
+	result := sent[0]
+	if result.WebhookID != "hook" || result.Author.Username != "Painter" || len(result.Files) != 1 {
+		t.Errorf("result posted by %+v through %q with %d files, want the image from Painter", result.Author, result.WebhookID, len(result.Files))
+	}
+	if !strings.Contains(result.Content, "Made for <@user>") {
+		t.Errorf("result says %q, want it to credit the requester", result.Content)
+	}
+	if got := d.reactionsOn(result.ID); len(got) != 0 {
+		t.Errorf("reacted %q to the webhook's post, want no 🔁 since it can't be re-rolled", got)
+	}
+}
+
+func TestResultThroughWebhookInThread(t *testing.T) {
+	d, _ := useWebhook(t, "hooked")
+	d.channels["thread"] = &discordgo.Channel{ID: "thread", GuildID: testGuildID, ParentID: "hooked", Type: discordgo.ChannelTypeGuildPublicThread}
+
+	onMessageHandler(d, d.post("thread", "user", "/dalle a red fox"))
+
+	sent := d.sentMessages()
+	if len(sent) != 1 || sent[0].WebhookID != "hook" || sent[0].ChannelID != "thread" {
+		t.Errorf("sent %+v, want the webhook to post in the thread", sent)
+	}
+}
+
+func TestWebhookFallsBackToReply(t *testing.T) {
+	d, _ := useWebhook(t, "hooked")
+	d.addChannel("elsewhere")
+	onMessageHandler(d, d.post("elsewhere", "user", "/dalle a red fox"))
+
+	d.failWebhooks = errors.New("webhook deleted")
+	m := d.post("hooked", "user", "/dalle a blue whale")
+	onMessageHandler(d, m)
+
+	sent := d.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want both results", len(sent))
+	}
+	for _, result := range sent {
+		if result.WebhookID != "" || len(result.Files) != 1 {
+			t.Errorf("sent %+v through %q, want a normal reply", result.Message, result.WebhookID)
+		}
+	}
+	if ref := sent[1].MessageReference; ref == nil || ref.MessageID != m.ID {
+		t.Errorf("fallback replies to %+v, want the request", ref)
+	}
+	if got := d.reactionsOn(sent[1].ID); !reflect.DeepEqual(got, []string{"🔁"}) {
+		t.Errorf("fallback reply shows %q, want 🔁", got)
+	}
+}